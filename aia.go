@@ -0,0 +1,153 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// maxAIADepth is the max number of issuers downloaded by CompleteChain
+const maxAIADepth = 8
+
+// ErrIssuerNotFound is issuer not found by AIA error
+var ErrIssuerNotFound = errors.New("selfca: the issuer is not found by AIA")
+
+// pkcs7ContentInfo is the PKCS #7 content info of certs-only .p7c file
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+// pkcs7SignedData is the PKCS #7 signed data carrying the certificates
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      asn1.RawValue
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+// CompleteChain completes the leaf first chain by downloading the missing issuers from
+// the AIA CA issuers URL of the last certificate, until the self-signed root or no URL,
+// the chain completed so far is returned with the error, client defaults to http.DefaultClient
+func CompleteChain(ctx context.Context, client *http.Client, chain []*x509.Certificate) ([]*x509.Certificate, error) {
+	if len(chain) == 0 {
+		return nil, ErrInvalidCertificate
+	}
+
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	chain = append([]*x509.Certificate{}, chain...)
+	for i := 0; i < maxAIADepth; i++ {
+		last := chain[len(chain)-1]
+		if bytes.Equal(last.RawIssuer, last.RawSubject) || len(last.IssuingCertificateURL) == 0 {
+			return chain, nil
+		}
+
+		issuer, err := fetchIssuer(ctx, client, last)
+		if err != nil {
+			return chain, err
+		}
+
+		chain = append(chain, issuer)
+	}
+
+	return chain, nil
+}
+
+// fetchIssuer downloads the issuer of certificate from its AIA CA issuers URLs, the first valid one is returned
+func fetchIssuer(ctx context.Context, client *http.Client, c *x509.Certificate) (*x509.Certificate, error) {
+	var errs []error
+	for _, url := range c.IssuingCertificateURL {
+		certificates, err := fetchCertificates(ctx, client, url)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("%s: %w", url, err))
+			continue
+		}
+		for _, v := range certificates {
+			if bytes.Equal(v.RawSubject, c.RawIssuer) && c.CheckSignatureFrom(v) == nil {
+				return v, nil
+			}
+		}
+		errs = append(errs, fmt.Errorf("%s: %w", url, ErrIssuerNotFound))
+	}
+
+	return nil, errors.Join(errs...)
+}
+
+// fetchCertificates downloads the certificates of DER, PEM or PKCS #7 form from url
+func fetchCertificates(ctx context.Context, client *http.Client, url string) ([]*x509.Certificate, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("selfca: aia returned %s", rsp.Status)
+	}
+
+	return parseCertificates(body)
+}
+
+// parseCertificates parses the certificates of DER, PEM or PKCS #7 certs-only form
+func parseCertificates(data []byte) ([]*x509.Certificate, error) {
+	if certificates, _, err := splitPEM(data); err == nil && len(certificates) > 0 {
+		return certificates, nil
+	}
+
+	if certificates, err := x509.ParseCertificates(data); err == nil {
+		return certificates, nil
+	}
+
+	var info pkcs7ContentInfo
+	_, err := asn1.Unmarshal(data, &info)
+	if err != nil {
+		return nil, ErrInvalidCertificate
+	}
+
+	var signed pkcs7SignedData
+	_, err = asn1.Unmarshal(info.Content.Bytes, &signed)
+	if err != nil || len(signed.Certificates.Bytes) == 0 {
+		return nil, ErrInvalidCertificate
+	}
+
+	return x509.ParseCertificates(signed.Certificates.Bytes)
+}
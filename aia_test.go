@@ -0,0 +1,157 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestCompleteChain(t *testing.T) {
+	root, err := Issue(Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	mux := http.NewServeMux()
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Intermediate CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Duration(365*24) * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		IssuingCertificateURL: []string{server.URL + "/missing.crt", server.URL + "/root.pem"},
+	}, root.Certificate, &key.PublicKey, root.Key)
+	assert.Nil(t, err)
+	intermediate, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	leafKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	der, err = x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber:          big.NewInt(2),
+		Subject:               pkix.Name{CommonName: "localhost"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(24 * time.Hour),
+		DNSNames:              []string{"localhost"},
+		IssuingCertificateURL: []string{server.URL + "/intermediate.der"},
+	}, intermediate, &leafKey.PublicKey, key)
+	assert.Nil(t, err)
+	leaf, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	mux.HandleFunc("/intermediate.der", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(intermediate.Raw)
+	})
+	mux.HandleFunc("/root.pem", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.DER}))
+	})
+
+	chain, err := CompleteChain(context.Background(), nil, []*x509.Certificate{leaf})
+	assert.Nil(t, err)
+	assert.Len(t, chain, 3)
+	assert.Equal(t, chain[1].Raw, intermediate.Raw)
+	assert.Equal(t, chain[2].Raw, root.DER)
+
+	chain, err = CompleteChain(context.Background(), nil, []*x509.Certificate{leaf, intermediate})
+	assert.Nil(t, err)
+	assert.Len(t, chain, 3)
+
+	chain, err = CompleteChain(context.Background(), nil, []*x509.Certificate{root.Certificate})
+	assert.Nil(t, err)
+	assert.Len(t, chain, 1)
+
+	// the downloaded certificate must sign the last one
+	mux.HandleFunc("/other.der", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write(leaf.Raw)
+	})
+	other := *leaf
+	other.IssuingCertificateURL = []string{server.URL + "/other.der"}
+	chain, err = CompleteChain(context.Background(), nil, []*x509.Certificate{&other})
+	assert.NotNil(t, err)
+	assert.Len(t, chain, 1)
+
+	_, err = CompleteChain(context.Background(), nil, nil)
+	assert.Equal(t, err, ErrInvalidCertificate)
+}
+
+func TestParseCertificates(t *testing.T) {
+	root, err := Issue(Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	contentInfo, err := asn1.Marshal(struct{ ContentType asn1.ObjectIdentifier }{
+		asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1},
+	})
+	assert.Nil(t, err)
+
+	signed, err := asn1.Marshal(pkcs7SignedData{
+		Version:          1,
+		DigestAlgorithms: asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+		ContentInfo:      asn1.RawValue{FullBytes: contentInfo},
+		Certificates:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: root.DER},
+		SignerInfos:      asn1.RawValue{Tag: asn1.TagSet, IsCompound: true},
+	})
+	assert.Nil(t, err)
+
+	p7c, err := asn1.Marshal(struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}{
+		ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2},
+		Content:     asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: signed},
+	})
+	assert.Nil(t, err)
+
+	certificates, err := parseCertificates(p7c)
+	assert.Nil(t, err)
+	assert.Len(t, certificates, 1)
+	assert.Equal(t, certificates[0].Raw, root.DER)
+
+	_, err = parseCertificates([]byte("invalid"))
+	assert.Equal(t, err, ErrInvalidCertificate)
+}
@@ -0,0 +1,210 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"math/big"
+	"time"
+)
+
+var (
+	// ErrCAExpired is expired ca certificate error
+	ErrCAExpired = errors.New("selfca: the ca certificate is expired")
+	// ErrCAClosed is closed ca error
+	ErrCAClosed = errors.New("selfca: the ca is closed")
+	// ErrPathLenExceeded is intermediate ca issued by the ca of zero path length error
+	ErrPathLenExceeded = errors.New("selfca: the ca path length is exceeded")
+)
+
+// FastValidity is the default validity of certificates issued by the fast ca
+const FastValidity = time.Hour
+
+// CA is the certificate authority for issuing certificates in memory
+type CA struct {
+	// Certificate is the ca certificate
+	Certificate *x509.Certificate
+	// Key is the ca private key
+	Key crypto.Signer
+	// Chain is the issuer certificates of intermediate ca, empty if root
+	Chain []*x509.Certificate
+	// KeyType is the key type of issued certificates without key type, size or key, RSA if empty
+	KeyType KeyType
+	// Validity is the validity of issued certificates without NotAfter, 24 hours if zero
+	Validity time.Duration
+}
+
+// NewEphemeralCA returns a ca which is never written to disk,
+// the ca and certificates issued by it vanish with the process
+func NewEphemeralCA() (*CA, error) {
+	return newEphemeralCA("Ephemeral Root CA", KeyTypeRSA)
+}
+
+// NewFastCA returns an ephemeral ca for test workloads, the ca and the certificates issued
+// by it have P-256 keys, and the certificates are valid for FastValidity by default,
+// so issuing takes about a millisecond instead of generating RSA keys
+func NewFastCA() (*CA, error) {
+	ca, err := newEphemeralCA("Fast Ephemeral Root CA", KeyTypeECDSA)
+	if err != nil {
+		return nil, err
+	}
+
+	ca.KeyType, ca.Validity = KeyTypeECDSA, FastValidity
+
+	return ca, nil
+}
+
+// newEphemeralCA returns a ca of key type which is never written to disk
+func newEphemeralCA(commonName string, keyType KeyType) (*CA, error) {
+	now := time.Now()
+	issuance, err := Issue(Certificate{
+		IsCA:       true,
+		CommonName: commonName,
+		KeyType:    keyType,
+		NotBefore:  now.Add(-time.Minute),
+		NotAfter:   now.Add(time.Duration(365*24) * time.Hour),
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{
+		Certificate: issuance.Certificate,
+		Key:         issuance.Key,
+	}, nil
+}
+
+// defaults returns the certificate config with the defaults of the ca applied
+func (ca *CA) defaults(c Certificate) Certificate {
+	if c.Key == nil && c.KeyType == "" && c.KeySize <= 0 {
+		c.KeyType = ca.KeyType
+	}
+
+	if c.NotBefore.IsZero() {
+		c.NotBefore = time.Now().Add(-time.Minute)
+	}
+
+	if c.NotAfter.IsZero() {
+		validity := ca.Validity
+		if validity <= 0 {
+			validity = 24 * time.Hour
+		}
+		c.NotAfter = c.NotBefore.Add(validity)
+	}
+
+	c.Parent = ca
+
+	return c
+}
+
+// Issue issues certificate signed by the ca, the intermediate ca if IsCA,
+// the validity defaults to Validity or 24 hours from now if not set
+func (ca *CA) Issue(c Certificate) (*Issuance, error) {
+	if ca.Key == nil {
+		return nil, ErrCAClosed
+	}
+
+	return Issue(ca.defaults(c))
+}
+
+// SignCSR signs the PEM or DER encoded certificate request by the ca like the package SignCSR,
+// the key stays with the requester, the validity defaults to Validity or 24 hours from now if not set
+func (ca *CA) SignCSR(csr []byte, c Certificate) (*Issuance, error) {
+	if ca.Key == nil {
+		return nil, ErrCAClosed
+	}
+
+	return SignCSR(csr, ca.defaults(c))
+}
+
+// CrossSign signs the other ca certificate by the ca, so that clients only trusting
+// the ca can chain to the other ca, the validity is clamped to the ca validity, and
+// the name constraints are kept as the other ca
+func (ca *CA) CrossSign(certificate *x509.Certificate) (*x509.Certificate, error) {
+	if ca.Key == nil {
+		return nil, ErrCAClosed
+	}
+
+	if time.Now().After(ca.Certificate.NotAfter) {
+		return nil, ErrCAExpired
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          serialNumber,
+		RawSubject:            certificate.RawSubject,
+		NotBefore:             certificate.NotBefore,
+		NotAfter:              certificate.NotAfter,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		MaxPathLen:            certificate.MaxPathLen,
+		MaxPathLenZero:        certificate.MaxPathLenZero,
+		SubjectKeyId:          certificate.SubjectKeyId,
+		AuthorityKeyId:        ca.Certificate.SubjectKeyId,
+		KeyUsage:              certificate.KeyUsage,
+		ExtKeyUsage:           certificate.ExtKeyUsage,
+
+		PermittedDNSDomainsCritical: certificate.PermittedDNSDomainsCritical,
+		PermittedDNSDomains:         certificate.PermittedDNSDomains,
+		ExcludedDNSDomains:          certificate.ExcludedDNSDomains,
+		PermittedIPRanges:           certificate.PermittedIPRanges,
+		ExcludedIPRanges:            certificate.ExcludedIPRanges,
+		PermittedEmailAddresses:     certificate.PermittedEmailAddresses,
+		ExcludedEmailAddresses:      certificate.ExcludedEmailAddresses,
+		PermittedURIDomains:         certificate.PermittedURIDomains,
+		ExcludedURIDomains:          certificate.ExcludedURIDomains,
+	}
+
+	if template.NotBefore.Before(ca.Certificate.NotBefore) {
+		template.NotBefore = ca.Certificate.NotBefore
+	}
+
+	if template.NotAfter.After(ca.Certificate.NotAfter) {
+		template.NotAfter = ca.Certificate.NotAfter
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, certificate.PublicKey, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// Close overwrites the ca key in memory and drops it, the ca can not issue after closed
+func (ca *CA) Close() {
+	ZeroKey(ca.Key)
+	ca.Key = nil
+}
+
+// CertPool returns a cert pool trusting the ca
+func (ca *CA) CertPool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+
+	return pool
+}
@@ -0,0 +1,398 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestNewEphemeralCA(t *testing.T) {
+	files, err := os.ReadDir(".")
+	assert.Nil(t, err)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+	assert.True(t, ca.Certificate.IsCA)
+
+	leaf, err := ca.Issue(Certificate{
+		Hosts: []string{"likexian.com", "127.0.0.1"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.Chain, []*x509.Certificate{ca.Certificate})
+	assert.True(t, leaf.Certificate.NotAfter.After(leaf.Certificate.NotBefore))
+
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{
+		DNSName: "likexian.com",
+		Roots:   ca.CertPool(),
+	})
+	assert.Nil(t, err)
+
+	other, err := NewEphemeralCA()
+	assert.Nil(t, err)
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{
+		DNSName: "likexian.com",
+		Roots:   other.CertPool(),
+	})
+	assert.NotNil(t, err)
+
+	after, err := os.ReadDir(".")
+	assert.Nil(t, err)
+	assert.Equal(t, len(after), len(files))
+}
+
+func TestNewFastCA(t *testing.T) {
+	ca, err := NewFastCA()
+	assert.Nil(t, err)
+	keyType, size := KeyTypeOf(ca.Key.Public())
+	assert.Equal(t, keyType, KeyTypeECDSA)
+	assert.Equal(t, size, 256)
+
+	leaf, err := ca.Issue(Certificate{
+		Hosts: []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+	keyType, size = KeyTypeOf(leaf.Key.Public())
+	assert.Equal(t, keyType, KeyTypeECDSA)
+	assert.Equal(t, size, 256)
+	assert.Equal(t, leaf.Certificate.NotAfter.Sub(leaf.Certificate.NotBefore), FastValidity)
+	assert.Equal(t, leaf.CertificateFile, "")
+
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{
+		DNSName: "likexian.com",
+		Roots:   ca.CertPool(),
+	})
+	assert.Nil(t, err)
+
+	leaf, err = ca.Issue(Certificate{
+		Hosts:   []string{"likexian.com"},
+		KeySize: 2048,
+	})
+	assert.Nil(t, err)
+	keyType, _ = KeyTypeOf(leaf.Key.Public())
+	assert.Equal(t, keyType, KeyTypeRSA)
+}
+
+func TestIssuanceTLSCertificate(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{
+		Hosts: []string{"127.0.0.1"},
+	})
+	assert.Nil(t, err)
+
+	certificate := leaf.TLSCertificate()
+	assert.Len(t, certificate.Certificate, 2)
+
+	listener, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		MinVersion:   tls.VersionTLS12,
+	})
+	assert.Nil(t, err)
+	defer listener.Close()
+
+	go func() {
+		conn, err := listener.Accept()
+		if err == nil {
+			_ = conn.(*tls.Conn).Handshake()
+			conn.Close()
+		}
+	}()
+
+	conn, err := tls.Dial("tcp", listener.Addr().String(), &tls.Config{
+		RootCAs:    ca.CertPool(),
+		MinVersion: tls.VersionTLS12,
+	})
+	assert.Nil(t, err)
+	assert.True(t, net.ParseIP("127.0.0.1").Equal(conn.ConnectionState().PeerCertificates[0].IPAddresses[0]))
+	conn.Close()
+}
+
+func TestCACrossSign(t *testing.T) {
+	old, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	cross, err := old.CrossSign(ca.Certificate)
+	assert.Nil(t, err)
+	assert.True(t, cross.IsCA)
+	assert.Equal(t, cross.RawSubject, ca.Certificate.RawSubject)
+	assert.Equal(t, cross.PublicKey, ca.Certificate.PublicKey)
+	assert.False(t, cross.NotAfter.After(old.Certificate.NotAfter))
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(cross)
+	_, err = issuance.Certificate.Verify(x509.VerifyOptions{
+		DNSName:       "likexian.com",
+		Roots:         old.CertPool(),
+		Intermediates: intermediates,
+	})
+	assert.Nil(t, err)
+
+	old.Certificate.NotAfter = time.Now().Add(-time.Hour)
+	_, err = old.CrossSign(ca.Certificate)
+	assert.Equal(t, err, ErrCAExpired)
+}
+
+func TestCACrossSignNameConstraints(t *testing.T) {
+	old, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	_, internal, err := net.ParseCIDR("10.0.0.0/8")
+	assert.Nil(t, err)
+
+	root, err := Issue(Certificate{
+		IsCA:                    true,
+		NotBefore:               time.Now(),
+		NotAfter:                time.Now().Add(time.Hour),
+		PermittedDNSDomains:     []string{"likexian.com"},
+		ExcludedDNSDomains:      []string{"internal.likexian.com"},
+		PermittedIPRanges:       []*net.IPNet{internal},
+		PermittedEmailAddresses: []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	cross, err := old.CrossSign(root.Certificate)
+	assert.Nil(t, err)
+	assert.True(t, cross.PermittedDNSDomainsCritical)
+	assert.Equal(t, cross.PermittedDNSDomains, []string{"likexian.com"})
+	assert.Equal(t, cross.ExcludedDNSDomains, []string{"internal.likexian.com"})
+	assert.Equal(t, cross.PermittedIPRanges[0].String(), "10.0.0.0/8")
+	assert.Equal(t, cross.PermittedEmailAddresses, []string{"likexian.com"})
+
+	issuance, err := root.CA().Issue(Certificate{Hosts: []string{"www.likexian.com"}})
+	assert.Nil(t, err)
+
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(cross)
+	_, err = issuance.Certificate.Verify(x509.VerifyOptions{
+		DNSName:       "www.likexian.com",
+		Roots:         old.CertPool(),
+		Intermediates: intermediates,
+	})
+	assert.Nil(t, err)
+}
+
+func TestCAIssueIntermediate(t *testing.T) {
+	root, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	intermediate, err := root.Issue(Certificate{IsCA: true})
+	assert.Nil(t, err)
+	assert.True(t, intermediate.Certificate.IsCA)
+	assert.Equal(t, intermediate.Certificate.Subject.CommonName, "Intermediate CA")
+	assert.Equal(t, intermediate.Chain, []*x509.Certificate{root.Certificate})
+	assert.Nil(t, intermediate.Certificate.CheckSignatureFrom(root.Certificate))
+
+	ca := intermediate.CA()
+	leaf, err := ca.Issue(Certificate{
+		Hosts: []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.Chain, []*x509.Certificate{intermediate.Certificate, root.Certificate})
+
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(intermediate.Certificate)
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{
+		DNSName:       "likexian.com",
+		Roots:         root.CertPool(),
+		Intermediates: intermediates,
+	})
+	assert.Nil(t, err)
+
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{
+		DNSName: "likexian.com",
+		Roots:   root.CertPool(),
+	})
+	assert.NotNil(t, err)
+
+	leaf, err = Issue(Certificate{
+		Hosts:     []string{"likexian.com"},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+		Parent:    ca,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, leaf.Chain, 2)
+
+	root.Close()
+	_, err = Issue(Certificate{IsCA: true, Parent: root})
+	assert.Equal(t, err, ErrCAClosed)
+}
+
+func TestCAIssuePathLen(t *testing.T) {
+	root, err := Issue(Certificate{
+		IsCA:       true,
+		NotBefore:  time.Now(),
+		NotAfter:   time.Now().Add(time.Hour),
+		MaxPathLen: 1,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, root.Certificate.MaxPathLen, 1)
+
+	intermediate, err := root.CA().Issue(Certificate{IsCA: true, MaxPathLenZero: true})
+	assert.Nil(t, err)
+	assert.Equal(t, intermediate.Certificate.MaxPathLen, 0)
+	assert.True(t, intermediate.Certificate.MaxPathLenZero)
+
+	_, err = intermediate.CA().Issue(Certificate{IsCA: true})
+	assert.Equal(t, err, ErrPathLenExceeded)
+
+	leaf, err := intermediate.CA().Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+	assert.Len(t, leaf.Chain, 2)
+
+	unlimited, err := root.CA().Issue(Certificate{IsCA: true, MaxPathLen: -1})
+	assert.Nil(t, err)
+	assert.Equal(t, unlimited.Certificate.MaxPathLen, -1)
+}
+
+func TestCASignCSR(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	csr, key, err := GenerateCSR(Certificate{
+		KeyType: KeyTypeECDSA,
+		Hosts:   []string{"likexian.com", "127.0.0.1"},
+	})
+	assert.Nil(t, err)
+
+	issuance, err := ca.SignCSR(csr, Certificate{})
+	assert.Nil(t, err)
+	assert.Nil(t, issuance.Key)
+	assert.Equal(t, issuance.Chain, []*x509.Certificate{ca.Certificate})
+	assert.Equal(t, issuance.Certificate.PublicKey, key.Public())
+	assert.Equal(t, issuance.Certificate.NotAfter.Sub(issuance.Certificate.NotBefore), 24*time.Hour)
+
+	_, err = issuance.Certificate.Verify(x509.VerifyOptions{
+		DNSName: "likexian.com",
+		Roots:   ca.CertPool(),
+	})
+	assert.Nil(t, err)
+
+	_, err = ca.SignCSR([]byte("invalid"), Certificate{})
+	assert.NotNil(t, err)
+
+	ca.Close()
+	_, err = ca.SignCSR(csr, Certificate{})
+	assert.Equal(t, err, ErrCAClosed)
+}
+
+func BenchmarkCAIssue(b *testing.B) {
+	ca, err := NewEphemeralCA()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		_, err := ca.Issue(Certificate{
+			Hosts: []string{"likexian.com"},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkFastCAIssue(b *testing.B) {
+	ca, err := NewFastCA()
+	if err != nil {
+		b.Fatal(err)
+	}
+
+	for i := 0; i < b.N; i++ {
+		_, err := ca.Issue(Certificate{
+			Hosts: []string{"likexian.com"},
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestCAIssueURLs(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	c := Certificate{
+		Hosts:                 []string{"likexian.com"},
+		OCSPServer:            []string{"http://127.0.0.1:8080"},
+		IssuingCertificateURL: []string{"http://pki.likexian.com/ca.crt"},
+		CRLDistributionPoints: []string{"http://pki.likexian.com/ca.crl"},
+	}
+
+	issuance, err := ca.Issue(c)
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.Certificate.OCSPServer, c.OCSPServer)
+	assert.Equal(t, issuance.Certificate.IssuingCertificateURL, c.IssuingCertificateURL)
+	assert.Equal(t, issuance.Certificate.CRLDistributionPoints, c.CRLDistributionPoints)
+
+	csr, _, err := GenerateCSR(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	issuance, err = ca.SignCSR(csr, c)
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.Certificate.OCSPServer, c.OCSPServer)
+	assert.Equal(t, issuance.Certificate.CRLDistributionPoints, c.CRLDistributionPoints)
+}
+
+func TestCAIssueURIsAndEmails(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	hosts := []string{"spiffe://likexian.com/ns/default/sa/web", "i@likexian.com", "likexian.com"}
+	issuance, err := ca.Issue(Certificate{Hosts: hosts})
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.Certificate.Subject.CommonName, "i@likexian.com")
+	assert.Len(t, issuance.Certificate.URIs, 1)
+	assert.Equal(t, issuance.Certificate.URIs[0].String(), hosts[0])
+	assert.Equal(t, issuance.Certificate.EmailAddresses, []string{"i@likexian.com"})
+	assert.Equal(t, issuance.Certificate.DNSNames, []string{"likexian.com"})
+
+	csr, _, err := GenerateCSR(Certificate{Hosts: hosts[:1]})
+	assert.Nil(t, err)
+
+	request, err := ParseCSR(csr)
+	assert.Nil(t, err)
+	assert.Equal(t, request.Subject.CommonName, "")
+	assert.Len(t, request.URIs, 1)
+
+	issuance, err = ca.SignCSR(csr, Certificate{})
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.Certificate.URIs[0].String(), hosts[0])
+
+	_, err = ca.Issue(Certificate{Hosts: []string{"spiffe://"}})
+	assert.True(t, errors.Is(err, ErrInvalidURI))
+	assert.True(t, IsURI(hosts[0]))
+	assert.False(t, IsURI(hosts[1]))
+}
@@ -0,0 +1,181 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io"
+)
+
+// SortChain sorts the certificates into a leaf first chain with duplicates removed,
+// returns the chain and the certificates unrelated to the chain
+func SortChain(certificates []*x509.Certificate) (chain, unrelated []*x509.Certificate) {
+	var unique []*x509.Certificate
+	for _, v := range certificates {
+		if !containsCertificate(unique, v) {
+			unique = append(unique, v)
+		}
+	}
+
+	for _, v := range unique {
+		if isIssuerOf(unique, v) {
+			continue
+		}
+		c := buildChain(unique, v)
+		if len(c) > len(chain) || (len(c) == len(chain) && !v.IsCA && chain[0].IsCA) {
+			chain = c
+		}
+	}
+
+	for _, v := range unique {
+		if !containsCertificate(chain, v) {
+			unrelated = append(unrelated, v)
+		}
+	}
+
+	return chain, unrelated
+}
+
+// ChainPEM returns the PEM encoded certificates in order, the self-signed root
+// following the first certificate is skipped unless withRoot
+func ChainPEM(certificates []*x509.Certificate, withRoot bool) []byte {
+	var buf bytes.Buffer
+	for i, v := range certificates {
+		if i > 0 && !withRoot && bytes.Equal(v.RawIssuer, v.RawSubject) {
+			continue
+		}
+		_ = pem.Encode(&buf, &pem.Block{Type: "CERTIFICATE", Bytes: v.Raw})
+	}
+
+	return buf.Bytes()
+}
+
+// WriteChain writes the certificate followed by its chain to name.fullchain.pem,
+// for the servers like nginx and haproxy, the root is only included if withRoot
+func WriteChain(name string, certificates []*x509.Certificate, withRoot bool) error {
+	if len(certificates) == 0 {
+		return ErrInvalidCertificate
+	}
+
+	return writeFile(fmt.Sprintf("%s.fullchain.pem", name), func(w io.Writer) error {
+		_, err := w.Write(ChainPEM(certificates, withRoot))
+		return err
+	}, 0644)
+}
+
+// WriteChain writes the certificate and chain to name.fullchain.pem like WriteChain, and records the file path
+func (i *Issuance) WriteChain(name string, withRoot bool) error {
+	err := WriteChain(name, append([]*x509.Certificate{i.Certificate}, i.Chain...), withRoot)
+	if err != nil {
+		return err
+	}
+
+	i.FullChainFile = fmt.Sprintf("%s.fullchain.pem", name)
+
+	return nil
+}
+
+// WriteBundle writes the certificate, its chain and then the key to name.pem, the single file
+// needed by HAProxy and some load balancers, the root is not included
+func WriteBundle(name string, certificates []*x509.Certificate, key crypto.Signer) error {
+	if len(certificates) == 0 || key == nil {
+		return ErrInvalidCertificate
+	}
+
+	block, err := MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	defer clear(block.Bytes)
+
+	return writeFile(fmt.Sprintf("%s.pem", name), func(w io.Writer) error {
+		_, err := w.Write(ChainPEM(certificates, false))
+		if err != nil {
+			return err
+		}
+		return pem.Encode(w, block)
+	}, 0600)
+}
+
+// WriteBundle writes the certificate, chain and key to name.pem like WriteBundle, and records the file path
+func (i *Issuance) WriteBundle(name string) error {
+	err := WriteBundle(name, append([]*x509.Certificate{i.Certificate}, i.Chain...), i.Key)
+	if err != nil {
+		return err
+	}
+
+	i.BundleFile = fmt.Sprintf("%s.pem", name)
+
+	return nil
+}
+
+// buildChain returns the chain from leaf to the root, as far as found
+func buildChain(certificates []*x509.Certificate, leaf *x509.Certificate) []*x509.Certificate {
+	chain := []*x509.Certificate{leaf}
+	for {
+		child := chain[len(chain)-1]
+		parent := findIssuer(certificates, child)
+		if parent == nil || containsCertificate(chain, parent) {
+			return chain
+		}
+		chain = append(chain, parent)
+	}
+}
+
+// findIssuer returns the issuer of certificate, nil if not found
+func findIssuer(certificates []*x509.Certificate, c *x509.Certificate) *x509.Certificate {
+	for _, v := range certificates {
+		if v == c || !bytes.Equal(c.RawIssuer, v.RawSubject) {
+			continue
+		}
+		if c.CheckSignatureFrom(v) == nil {
+			return v
+		}
+	}
+
+	return nil
+}
+
+// isIssuerOf returns if certificate issued any other certificates
+func isIssuerOf(certificates []*x509.Certificate, c *x509.Certificate) bool {
+	for _, v := range certificates {
+		if v != c && findIssuer([]*x509.Certificate{c}, v) == c {
+			return true
+		}
+	}
+
+	return false
+}
+
+// containsCertificate returns if certificates contains c
+func containsCertificate(certificates []*x509.Certificate, c *x509.Certificate) bool {
+	for _, v := range certificates {
+		if v.Equal(c) {
+			return true
+		}
+	}
+
+	return false
+}
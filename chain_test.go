@@ -0,0 +1,165 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestSortChain(t *testing.T) {
+	root, err := Issue(Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Intermediate CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Duration(365*24) * time.Hour),
+		KeyUsage:              x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, root.Certificate, &key.PublicKey, root.Key)
+	assert.Nil(t, err)
+
+	intermediate, err := x509.ParseCertificate(der)
+	assert.Nil(t, err)
+
+	leaf, err := Issue(Certificate{
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Duration(365*24) * time.Hour),
+		Hosts:         []string{"likexian.com"},
+		CAKey:         key,
+		CACertificate: intermediate,
+	})
+	assert.Nil(t, err)
+
+	other, err := Issue(Certificate{
+		IsCA:       true,
+		CommonName: "Other CA",
+		NotBefore:  time.Now(),
+		NotAfter:   time.Now().Add(time.Duration(365*24) * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	chain, unrelated := SortChain([]*x509.Certificate{
+		root.Certificate, other.Certificate, intermediate, leaf.Certificate, intermediate,
+	})
+	assert.Equal(t, chain, []*x509.Certificate{leaf.Certificate, intermediate, root.Certificate})
+	assert.Equal(t, unrelated, []*x509.Certificate{other.Certificate})
+
+	chain, unrelated = SortChain([]*x509.Certificate{intermediate, leaf.Certificate})
+	assert.Equal(t, chain, []*x509.Certificate{leaf.Certificate, intermediate})
+	assert.Len(t, unrelated, 0)
+
+	chain, unrelated = SortChain([]*x509.Certificate{root.Certificate})
+	assert.Equal(t, chain, []*x509.Certificate{root.Certificate})
+	assert.Len(t, unrelated, 0)
+
+	chain, unrelated = SortChain(nil)
+	assert.Len(t, chain, 0)
+	assert.Len(t, unrelated, 0)
+}
+
+func TestWriteChain(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	chain := []*x509.Certificate{issuance.Certificate, ca.Certificate}
+	assert.Equal(t, ChainPEM(chain, false), issuance.PEM)
+	assert.Equal(t, ChainPEM(chain, true), append(append([]byte{}, issuance.PEM...),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate.Raw})...))
+
+	// the self-signed certificate alone is kept
+	assert.Equal(t, ChainPEM(chain[1:], false),
+		pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate.Raw}))
+
+	dir := t.TempDir()
+	err = WriteChain(dir+"/likexian.com", nil, false)
+	assert.Equal(t, err, ErrInvalidCertificate)
+
+	err = issuance.WriteChain("not-exists/likexian.com", true)
+	assert.NotNil(t, err)
+
+	err = issuance.WriteChain(dir+"/likexian.com", true)
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.FullChainFile, dir+"/likexian.com.fullchain.pem")
+
+	data, err := os.ReadFile(issuance.FullChainFile)
+	assert.Nil(t, err)
+	assert.Equal(t, data, ChainPEM(chain, true))
+}
+
+func TestWriteBundle(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	err = WriteBundle(dir+"/likexian.com", []*x509.Certificate{issuance.Certificate}, nil)
+	assert.Equal(t, err, ErrInvalidCertificate)
+
+	err = issuance.WriteBundle("not-exists/likexian.com")
+	assert.NotNil(t, err)
+
+	err = issuance.WriteBundle(dir + "/likexian.com")
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.BundleFile, dir+"/likexian.com.pem")
+
+	data, err := os.ReadFile(issuance.BundleFile)
+	assert.Nil(t, err)
+	assert.Equal(t, data, append(append([]byte{}, issuance.PEM...), issuance.KeyPEM...))
+
+	if runtime.GOOS != "windows" {
+		info, err := os.Stat(issuance.BundleFile)
+		assert.Nil(t, err)
+		assert.Equal(t, info.Mode().Perm(), os.FileMode(0600))
+	}
+
+	// the bundle is read as combined file
+	certificates, key, err := ReadCertificate(dir + "/likexian.com")
+	assert.Nil(t, err)
+	assert.Equal(t, certificates[0].Raw, issuance.DER)
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+}
@@ -0,0 +1,158 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"log"
+	"os"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+const (
+	// minAgentValid is the minimum validity of the agent certificate
+	minAgentValid = time.Minute
+	// agentRetry is the delay before retrying a failed issuance
+	agentRetry = 30 * time.Second
+)
+
+// agent keeps a short-lived certificate issued and swaps it before expiring
+func agent(args []string) {
+	fs := flag.NewFlagSet("selfca agent", flag.ExitOnError)
+	name := fs.String("n", "", "Common name of the certificate")
+	host := fs.String("h", "", "Domains, IPs or CIDRs of the certificate, comma separated, @file or - to read from file "+
+		"or stdin")
+	bits := fs.Int("b", 2048, "Number of bits in the key to create (default 2048)")
+	valid := fs.Duration("valid", time.Hour, "Validity of the certificate, for example 5m or 1h (default 1h)")
+	renewBefore := fs.Duration("renew-before", 0, "Renew the certificate this long before it expires (default a third of "+
+		"valid)")
+	output := fs.String("o", "cert", "Folder for saving the certificate (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	weak := fs.Bool("insecure-allow-weak", false, "Allow weak key size and signature, for testing only")
+	fips := fs.Bool("fips", false, "Only allow FIPS approved key sizes and signature algorithms")
+	var hooks, webhooks stringsFlag
+	fs.Var(&hooks, "hook", "Command to run after the certificate is renewed, can be repeated")
+	fs.Var(&webhooks, "notify-webhook", "Slack or Discord webhook to notify when renewal fails, can be repeated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	hosts, err := parseHosts(*host)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse hosts parameter", err)
+	}
+
+	if len(hosts) == 0 {
+		failUsage(fs, "Missing hosts parameter")
+	}
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	if *valid < minAgentValid {
+		failUsage(fs, "The valid parameter must be at least "+minAgentValid.String())
+	}
+
+	if *renewBefore == 0 {
+		*renewBefore = *valid / 3
+	}
+
+	if *renewBefore < 0 || *renewBefore >= *valid {
+		failUsage(fs, "The renew-before parameter must be positive and less than valid")
+	}
+
+	if len(*output) == 0 {
+		*output = "cert"
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	var notify notifyConfig
+	for _, v := range webhooks {
+		notify.Webhooks = append(notify.Webhooks, webhookConfig{URL: v, Type: webhookType(v)})
+	}
+
+	n := newNotifier(notify)
+	var notAfter time.Time
+
+	stop := stopSignal()
+	reload := reloadSignal()
+	for {
+		now := time.Now()
+		issuance, err := issueCertificate(issueRequest{
+			Output:     *output,
+			CA:         *caFlag,
+			CATemplate: caConfig,
+			Config: selfca.Certificate{
+				CommonName: *name,
+				KeySize:    *bits,
+				NotBefore:  now,
+				NotAfter:   now.Add(*valid),
+				Hosts:      hosts,
+			},
+			AllowWeak:  *weak,
+			FIPS:       *fips,
+			ShortLived: true,
+			Hooks:      hooks,
+		})
+
+		wait := agentRetry
+		if issuance != nil {
+			notAfter = issuance.Certificate.NotAfter
+			if !quiet {
+				log.Printf("Issued %s, valid until %s", hosts[0], issuance.Certificate.NotAfter.Format(time.RFC3339))
+			}
+			wait = time.Until(issuance.Certificate.NotAfter.Add(-*renewBefore))
+			issuance.Zero()
+		}
+
+		if err != nil {
+			var e *exitError
+			if errors.As(err, &e) && e.code == exitBadInput {
+				failError(err)
+			}
+			log.Printf("%v", err)
+			n.failed(hosts[0], notAfter, err)
+		} else {
+			n.recovered(hosts[0], notAfter)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-reload:
+			timer.Stop()
+			log.Printf("Reloading, issuing %s with the current ca", hosts[0])
+		case <-timer.C:
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// alertTimeout is the timeout of sending a notification
+const alertTimeout = 30 * time.Second
+
+// notifyConfig is the notification sinks of renewal failures
+type notifyConfig struct {
+	SMTP     *smtpConfig     `json:"smtp"`
+	Webhooks []webhookConfig `json:"webhooks"`
+}
+
+// smtpConfig is the smtp server for sending notification emails
+type smtpConfig struct {
+	Addr     string   `json:"addr"`
+	From     string   `json:"from"`
+	To       []string `json:"to"`
+	Username string   `json:"username"`
+	Password string   `json:"password"`
+}
+
+// webhookConfig is the chat webhook for sending notifications
+type webhookConfig struct {
+	URL  string `json:"url"`
+	Type string `json:"type"`
+}
+
+// validate checks the notification sinks and applies the defaults
+func (c *notifyConfig) validate() error {
+	if c.SMTP != nil && (c.SMTP.Addr == "" || c.SMTP.From == "" || len(c.SMTP.To) == 0) {
+		return errors.New("notify smtp requires addr, from and to")
+	}
+
+	for i := range c.Webhooks {
+		v := &c.Webhooks[i]
+		if v.URL == "" {
+			return fmt.Errorf("notify webhook #%d has no url", i+1)
+		}
+		if v.Type == "" {
+			v.Type = webhookType(v.URL)
+		}
+		if v.Type != "slack" && v.Type != "discord" {
+			return fmt.Errorf("notify webhook #%d has unsupported type %s", i+1, v.Type)
+		}
+	}
+
+	return nil
+}
+
+// webhookType returns the webhook type by url, discord or slack
+func webhookType(url string) string {
+	if strings.Contains(url, "discord") {
+		return "discord"
+	}
+
+	return "slack"
+}
+
+// notifier notifies when renewals fail, and when they recover
+type notifier struct {
+	config  notifyConfig
+	mu      sync.Mutex
+	failing map[string]bool
+}
+
+// newNotifier returns a notifier sending to the sinks
+func newNotifier(c notifyConfig) *notifier {
+	return &notifier{
+		config:  c,
+		failing: map[string]bool{},
+	}
+}
+
+// setConfig replaces the notification sinks, the failure state is kept
+func (n *notifier) setConfig(c notifyConfig) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	n.config = c
+}
+
+// failed notifies the renewal failure of certificate, once until it recovers,
+// notAfter is the expiry time of the existing certificate, zero if missing
+func (n *notifier) failed(name string, notAfter time.Time, err error) {
+	n.mu.Lock()
+	notified := n.failing[name]
+	n.failing[name] = true
+	n.mu.Unlock()
+
+	if notified {
+		return
+	}
+
+	message := fmt.Sprintf("Failed to renew %s: %v", name, err)
+	if !notAfter.IsZero() {
+		message += fmt.Sprintf("\nThe certificate expires at %s, in %s",
+			notAfter.Format(time.RFC3339), time.Until(notAfter).Round(time.Minute))
+	}
+
+	n.send(fmt.Sprintf("selfca: failed to renew %s", name), message)
+}
+
+// recovered notifies the certificate is renewed after failures
+func (n *notifier) recovered(name string, notAfter time.Time) {
+	n.mu.Lock()
+	notified := n.failing[name]
+	delete(n.failing, name)
+	n.mu.Unlock()
+
+	if !notified {
+		return
+	}
+
+	n.send(fmt.Sprintf("selfca: renewed %s", name),
+		fmt.Sprintf("Renewed %s after failures, valid until %s", name, notAfter.Format(time.RFC3339)))
+}
+
+// send sends the notification to all sinks, failures are logged
+func (n *notifier) send(subject, message string) {
+	n.mu.Lock()
+	c := n.config
+	n.mu.Unlock()
+
+	if c.SMTP != nil {
+		err := sendMail(c.SMTP, subject, message)
+		if err != nil {
+			log.Printf("Failed to send notification email: %v", err)
+		}
+	}
+
+	for _, v := range c.Webhooks {
+		err := sendWebhook(v, message)
+		if err != nil {
+			log.Printf("Failed to send notification to %s webhook: %v", v.Type, err)
+		}
+	}
+}
+
+// sendMail sends the notification email
+func sendMail(c *smtpConfig, subject, message string) error {
+	var auth smtp.Auth
+	if c.Username != "" {
+		host := c.Addr
+		if i := strings.LastIndex(host, ":"); i >= 0 {
+			host = host[:i]
+		}
+		auth = smtp.PlainAuth("", c.Username, c.Password, host)
+	}
+
+	body := fmt.Sprintf("From: %s\r\nTo: %s\r\nSubject: %s\r\nDate: %s\r\n\r\n%s\r\n",
+		c.From, strings.Join(c.To, ", "), subject, time.Now().Format(time.RFC1123Z),
+		strings.ReplaceAll(message, "\n", "\r\n"))
+
+	return smtp.SendMail(c.Addr, auth, c.From, c.To, []byte(body))
+}
+
+// sendWebhook posts the notification to slack or discord webhook
+func sendWebhook(c webhookConfig, message string) error {
+	payload := map[string]string{"text": message}
+	if c.Type == "discord" {
+		payload = map[string]string{"content": message}
+	}
+
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), alertTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, c.URL, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	rsp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer rsp.Body.Close()
+	if rsp.StatusCode/100 != 2 {
+		return fmt.Errorf("webhook returned %s", rsp.Status)
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"crypto/md5"
+	"crypto/x509"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+)
+
+// androidCACerts is the folder of system ca certificates on Android
+const androidCACerts = "/system/etc/security/cacerts"
+
+// magiskUpdateBinary is the installer script of magisk module zip
+const magiskUpdateBinary = `#!/sbin/sh
+
+umask 022
+
+ui_print() { echo "$1"; }
+
+require_new_magisk() {
+  ui_print "Please install Magisk v20.4+!"
+  exit 1
+}
+
+OUTFD=$2
+ZIPFILE=$3
+
+mount /data 2>/dev/null
+
+[ -f /data/adb/magisk/util_functions.sh ] || require_new_magisk
+. /data/adb/magisk/util_functions.sh
+[ $MAGISK_VER_CODE -lt 20400 ] && require_new_magisk
+
+install_module
+exit 0
+`
+
+// androidName returns the file name of ca in Android system store, the old style
+// openssl subject hash, which is the first 4 bytes of md5 of subject in little endian
+func androidName(ca *x509.Certificate) string {
+	sum := md5.Sum(ca.RawSubject)
+	return fmt.Sprintf("%08x.0", binary.LittleEndian.Uint32(sum[:4]))
+}
+
+// magiskModule returns the magisk module zip adding the ca to Android system store
+func magiskModule(ca *x509.Certificate) ([]byte, error) {
+	name := ca.Subject.CommonName
+	if name == "" {
+		name = "selfca"
+	}
+
+	prop := fmt.Sprintf(`id=selfca
+name=selfca %s
+version=v1
+versionCode=1
+author=selfca
+description=Trusts the %s certificate authority of selfca as system ca
+`, name, name)
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"META-INF/com/google/android/update-binary", []byte(magiskUpdateBinary)},
+		{"META-INF/com/google/android/updater-script", []byte("#MAGISK\n")},
+		{"module.prop", []byte(prop)},
+		{path.Join(androidCACerts[1:], androidName(ca)), ca.Raw},
+	}
+
+	var b bytes.Buffer
+	w := zip.NewWriter(&b)
+	for _, v := range files {
+		f, err := w.Create(v.name)
+		if err != nil {
+			return nil, err
+		}
+		_, err = f.Write(v.data)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err := w.Close()
+	if err != nil {
+		return nil, err
+	}
+
+	return b.Bytes(), nil
+}
+
+// adbPush installs the ca file to the system store of Android device by adb, which
+// needs root, like emulator started with -writable-system, the device is chosen by ANDROID_SERIAL
+func adbPush(file, name string) error {
+	target := path.Join(androidCACerts, name)
+	commands := [][]string{
+		{"root"},
+		{"wait-for-device"},
+		{"remount"},
+		{"push", file, target},
+		{"shell", "chmod 644 " + target},
+	}
+
+	for _, v := range commands {
+		debugf("Running adb %v", v)
+		cmd := exec.Command("adb", v...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		err := cmd.Run()
+		if err != nil {
+			return fmt.Errorf("adb %s: %w", v[0], err)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,226 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// badKind is the kind of certificate broken on purpose
+type badKind struct {
+	name        string
+	description string
+}
+
+// badKinds is the kinds of broken certificates generated by badcert
+var badKinds = []badKind{
+	{"expired", "expired a day ago"},
+	{"not-yet-valid", "valid from a day later"},
+	{"wrong-host", "issued for wrong-host.invalid"},
+	{"weak-key", "1024-bit RSA key"},
+	{"missing-san", "host only in common name, no SAN"},
+	{"self-signed", "self-signed, not issued by the ca"},
+	{"revoked", "revoked in ca.crl"},
+}
+
+// badcert generates the certificates broken on purpose for testing client error handling
+func badcert(args []string) {
+	fs := flag.NewFlagSet("selfca badcert", flag.ExitOnError)
+	host := fs.String("h", "localhost", "Domain or IP the certificates are for")
+	output := fs.String("o", "cert", "Folder of the ca and for saving the certificates (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca badcert [kind...] [options]\n\nKinds (default all):\n")
+		for _, v := range badKinds {
+			fmt.Fprintf(fs.Output(), "  %-14s %s\n", v.name, v.description)
+		}
+		fmt.Fprintln(fs.Output())
+		fs.PrintDefaults()
+	}
+
+	kinds := parseBadKinds(fs, parseArgs(fs, args))
+	err := os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	unlock, err := lockOutput(*output)
+	if err != nil {
+		fail(exitIO, "Failed to lock output folder", err)
+	}
+
+	defer unlock()
+
+	now := time.Now()
+	caPath := resolveCA(*caFlag, *output)
+	unlockCA, err := lockCA(caPath, *output)
+	if err != nil {
+		fail(exitIO, "Failed to lock ca folder", err)
+	}
+
+	defer unlockCA()
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	caConfig.NotBefore = now
+	caCertificate, caKey, err := loadCA(caPath, caConfig, false)
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to load ca certificate", err)
+	}
+
+	db, err := selfca.OpenDatabase(caPath + ".db")
+	if err != nil {
+		fail(errorCode(err, exitIO), "Failed to load ca database", err)
+	}
+
+	ca := &selfca.CA{Certificate: caCertificate, Key: caKey}
+	revoked := false
+	for _, v := range kinds {
+		config := badConfig(v.name, *host, now)
+		if !config.IsCA {
+			config.CACertificate, config.CAKey = ca.Certificate, ca.Key
+		}
+
+		issuance, err := selfca.Issue(config)
+		if err != nil {
+			fail(exitCrypto, fmt.Sprintf("Failed to generate the %s certificate", v.name), err)
+		}
+
+		if !config.IsCA {
+			err = db.Add(issuance.Certificate)
+			if err != nil {
+				fail(exitIO, "Failed to record the certificate", err)
+			}
+		}
+
+		if v.name == "revoked" {
+			_ = db.Revoke(issuance.Certificate.SerialNumber, now)
+			revoked = true
+		}
+
+		err = issuance.Write(fmt.Sprintf("%s/badcert-%s", *output, v.name))
+		if err != nil {
+			fail(exitIO, "Failed to write the certificate", err)
+		}
+
+		infof("Wrote %s, %s", issuance.CertificateFile, v.description)
+	}
+
+	if revoked {
+		writeBadCRL(db, ca, caPath)
+	}
+
+	err = db.Save()
+	if err != nil {
+		fail(exitIO, "Failed to record the certificate", err)
+	}
+}
+
+// parseBadKinds returns the kinds of names, all kinds if no names
+func parseBadKinds(fs *flag.FlagSet, names []string) []badKind {
+	if len(names) == 0 {
+		return badKinds
+	}
+
+	var kinds []badKind
+	for _, v := range names {
+		kind, ok := findBadKind(v)
+		if !ok {
+			failUsage(fs, fmt.Sprintf("Unknown kind %s", v))
+		}
+		kinds = append(kinds, kind)
+	}
+
+	return kinds
+}
+
+// writeBadCRL writes the CRL of the revoked certificate, warns if the ca can not sign CRL
+func writeBadCRL(db *selfca.Database, ca *selfca.CA, caPath string) {
+	crl, err := db.GenerateCRL(ca, 0)
+	if errors.Is(err, selfca.ErrCRLSignNotAllowed) {
+		fmt.Fprintf(os.Stderr, "WARNING: %v, the revoked certificate is not in ca.crl\n", err)
+		return
+	}
+	if err != nil {
+		fail(exitCrypto, "Failed to generate the CRL", err)
+	}
+
+	err = selfca.WriteCRL(caPath, crl)
+	if err != nil {
+		fail(exitIO, "Failed to write the CRL", err)
+	}
+
+	infof("Wrote %s.crl", caPath)
+}
+
+// findBadKind returns the kind of broken certificate by name
+func findBadKind(name string) (badKind, bool) {
+	for _, v := range badKinds {
+		if v.name == strings.ToLower(name) {
+			return v, true
+		}
+	}
+
+	return badKind{}, false
+}
+
+// badConfig returns the certificate config for host broken as the kind
+func badConfig(kind, host string, now time.Time) selfca.Certificate {
+	c := selfca.Certificate{
+		NotBefore: now.Add(-time.Minute),
+		NotAfter:  now.Add(30 * 24 * time.Hour),
+		Hosts:     []string{host},
+	}
+
+	switch kind {
+	case "expired":
+		c.NotBefore = now.Add(-48 * time.Hour)
+		c.NotAfter = now.Add(-24 * time.Hour)
+	case "not-yet-valid":
+		c.NotBefore = now.Add(24 * time.Hour)
+		c.NotAfter = now.Add(48 * time.Hour)
+	case "wrong-host":
+		c.Hosts = []string{"wrong-host.invalid"}
+	case "weak-key":
+		c.KeySize = 1024
+	case "missing-san":
+		c.CommonName = host
+		c.Hosts = nil
+	case "self-signed":
+		c.IsCA = true
+		c.CommonName = host
+	}
+
+	return c
+}
@@ -0,0 +1,373 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// caRotateBefore is how long before the ca expiring it is warned or rotated
+const caRotateBefore = 30 * 24 * time.Hour
+
+// caFileSuffix matches the suffixes of the files beside the ca, like ca.chain, ca.cross and the archived ca.20240101
+var caFileSuffix = regexp.MustCompile(`^\.(chain|cross|nocrlsign|[0-9]{8})$`)
+
+// addCAFlag adds the -ca flag of the ca outside of the output folder
+func addCAFlag(fs *flag.FlagSet) *string {
+	return fs.String("ca", "", "Path of the ca like /etc/selfca/ca, or folder of the ca, for sharing one ca (default ca "+
+		"in the output folder)")
+}
+
+// resolveCA returns the ca path without extension, ca in the output folder if path is empty,
+// or ca in the folder if path is a folder
+func resolveCA(path, output string) string {
+	if path == "" {
+		return filepath.Join(output, "ca")
+	}
+
+	if stat, err := os.Stat(path); err == nil && stat.IsDir() {
+		return filepath.Join(path, "ca")
+	}
+
+	for _, v := range []string{".crt", ".key", ".pem"} {
+		path = strings.TrimSuffix(path, v)
+	}
+
+	return path
+}
+
+// reservedName returns whether the certificate of name would overwrite the files of the ca at caPath,
+// or of the default ca and intermediate ca
+func reservedName(name, caPath string) bool {
+	name = strings.ToLower(name)
+	for _, v := range []string{"ca", "intermediate", filepath.Base(caPath)} {
+		suffix, ok := strings.CutPrefix(name, strings.ToLower(v))
+		if ok && (suffix == "" || caFileSuffix.MatchString(suffix)) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// addCASubjectFlags adds the -ca-name and -ca-subject flags of the ca created if not exists
+func addCASubjectFlags(fs *flag.FlagSet) (*string, *string) {
+	name := fs.String("ca-name", "", "Common name of the ca created if not exists, to tell the roots apart in trust "+
+		"stores (default Root CA)")
+	subject := fs.String("ca-subject", "", "Subject of the ca created if not exists, as /C=US/O=Acme/CN=Acme Dev CA or "+
+		"O=Acme,C=US")
+	return name, subject
+}
+
+// caTemplate returns the config of the ca created if not exists, of the common name and subject
+func caTemplate(name, subject string) (selfca.Certificate, error) {
+	c := selfca.Certificate{IsCA: true, CommonName: name}
+	if subject != "" {
+		rdns, err := selfca.ParseDN(subject)
+		if err != nil {
+			return c, err
+		}
+		c.Subject = rdns
+	}
+
+	return c, nil
+}
+
+// addNameConstraints parses the name constraints like DNS:.internal.corp, IP:10.0.0.0/8 or email:corp.com,
+// the value without type is DNS, and adds them to the permitted or excluded of ca config
+func addNameConstraints(c *selfca.Certificate, permitted, excluded []string) error {
+	for _, v := range permitted {
+		err := addNameConstraint(v, &c.PermittedDNSDomains, &c.PermittedIPRanges, &c.PermittedEmailAddresses)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, v := range excluded {
+		err := addNameConstraint(v, &c.ExcludedDNSDomains, &c.ExcludedIPRanges, &c.ExcludedEmailAddresses)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// addNameConstraint parses the name constraint and adds it to the domains, ip ranges or emails by its type
+func addNameConstraint(s string, domains *[]string, ipRanges *[]*net.IPNet, emails *[]string) error {
+	kind, value, ok := strings.Cut(s, ":")
+	if !ok {
+		kind, value = "DNS", s
+	}
+
+	switch strings.ToUpper(kind) {
+	case "DNS":
+		*domains = append(*domains, value)
+	case "IP":
+		if !strings.Contains(value, "/") {
+			bits := 128
+			if strings.Contains(value, ".") {
+				bits = 32
+			}
+			value = fmt.Sprintf("%s/%d", value, bits)
+		}
+		_, ipNet, err := net.ParseCIDR(value)
+		if err != nil {
+			return err
+		}
+		*ipRanges = append(*ipRanges, ipNet)
+	case "EMAIL":
+		*emails = append(*emails, value)
+	default:
+		return fmt.Errorf("unsupported name constraint type %s", kind)
+	}
+
+	return nil
+}
+
+// urlFlags is the URLs of OCSP responder, issuer certificate and CRL embedded in the certificate
+type urlFlags struct {
+	ocsp   stringsFlag
+	issuer stringsFlag
+	crl    stringsFlag
+}
+
+// addURLFlags adds the flags of URLs embedded in the certificate
+func addURLFlags(fs *flag.FlagSet) *urlFlags {
+	u := &urlFlags{}
+	fs.Var(&u.ocsp, "ocsp-url", "URL of OCSP responder embedded in the certificate, like http://127.0.0.1:8080, can be "+
+		"repeated")
+	fs.Var(&u.issuer, "issuer-url", "URL of the ca certificate embedded in the certificate, like "+
+		"http://pki.example.com/ca.crt, can be repeated")
+	fs.Var(&u.crl, "crl-url", "URL of CRL distribution point embedded in the certificate, like "+
+		"http://pki.example.com/ca.crl, can be repeated")
+	return u
+}
+
+// apply checks the URLs are http, https or ldap, and sets them to the certificate config
+func (u *urlFlags) apply(c *selfca.Certificate) error {
+	for _, v := range [][]string{u.ocsp, u.issuer, u.crl} {
+		for _, s := range v {
+			p, err := url.Parse(s)
+			if err != nil {
+				return err
+			}
+			if p.Scheme != "http" && p.Scheme != "https" && p.Scheme != "ldap" || p.Host == "" {
+				return fmt.Errorf("unsupported url %s, only http, https or ldap", s)
+			}
+		}
+	}
+
+	c.OCSPServer, c.IssuingCertificateURL, c.CRLDistributionPoints = u.ocsp, u.issuer, u.crl
+
+	return nil
+}
+
+// loadCA loads the ca certificate and key from path, generates them by template if not exists,
+// the expired or expiring ca is replaced by a new ca if rotate, or warned otherwise
+func loadCA(path string, template selfca.Certificate, rotate bool) (*x509.Certificate, crypto.Signer, error) {
+	if _, err := os.Stat(path + ".crt"); err != nil {
+		return createCA(path, template)
+	}
+
+	certificates, key, err := readCA(path)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	debugf("Loaded ca from %s.crt", path)
+
+	ca := &selfca.CA{Certificate: certificates[0], Key: key}
+	notAfter := ca.Certificate.NotAfter.Format(time.RFC3339)
+	if time.Until(ca.Certificate.NotAfter) > caRotateBefore {
+		return ca.Certificate, ca.Key, nil
+	}
+
+	if !rotate {
+		if time.Now().After(ca.Certificate.NotAfter) {
+			return nil, nil, fmt.Errorf("%w at %s, use -auto-rotate-ca to create a new ca", selfca.ErrCAExpired, notAfter)
+		}
+		fmt.Fprintf(os.Stderr, "WARNING: ca certificate expires at %s, use -auto-rotate-ca to create a new ca\n", notAfter)
+		return ca.Certificate, ca.Key, nil
+	}
+
+	return rotateCA(path, ca, template)
+}
+
+// readCAChain returns the issuers of the intermediate ca at path, following the ca certificate
+// in path.crt, or in path.chain.crt, empty for the root ca
+func readCAChain(path string) ([]*x509.Certificate, error) {
+	certificates, err := readCertificates(path + ".crt")
+	if err != nil {
+		return nil, err
+	}
+
+	if len(certificates) > 1 {
+		return certificates[1:], nil
+	}
+
+	if bytes.Equal(certificates[0].RawIssuer, certificates[0].RawSubject) {
+		return nil, nil
+	}
+
+	chain, err := readCertificates(path + ".chain.crt")
+	if os.IsNotExist(err) {
+		fmt.Fprintf(os.Stderr, "WARNING: ca certificate is not self-signed and %s.chain.crt is missing, the chain is "+
+			"incomplete\n", path)
+		return nil, nil
+	}
+
+	return chain, err
+}
+
+// rotateCA archives the ca and creates a new ca with a new key of the same key type and size, the
+// same name constraints, and the same subject unless set by template, the new ca is cross-signed by the archived ca to
+// path.cross.crt if the archived ca is not expired, only the self-signed root ca can be rotated,
+// an intermediate ca is re-issued by its parent instead
+func rotateCA(path string, old *selfca.CA, template selfca.Certificate) (*x509.Certificate, crypto.Signer, error) {
+	if !bytes.Equal(old.Certificate.RawIssuer, old.Certificate.RawSubject) ||
+		old.Certificate.CheckSignatureFrom(old.Certificate) != nil {
+		return nil, nil, fmt.Errorf("%s.crt is an intermediate ca, re-issue it by its parent with -intermediate "+
+			"instead of -auto-rotate-ca", path)
+	}
+
+	archive := fmt.Sprintf("%s.%s", path, old.Certificate.NotAfter.Format("20060102"))
+	for _, v := range []string{".crt", ".key"} {
+		err := os.Rename(path+v, archive+v)
+		if err != nil {
+			return nil, nil, fmt.Errorf("archive: %w", err)
+		}
+	}
+
+	fmt.Fprintf(os.Stderr, "WARNING: ca certificate expires at %s, archived to %s.crt and creating a new ca\n",
+		old.Certificate.NotAfter.Format(time.RFC3339), archive)
+
+	template.KeyType, template.KeySize = selfca.KeyTypeOf(old.Key.Public())
+	if template.CommonName == "" && len(template.Subject) == 0 {
+		template.Subject = old.Certificate.Subject.ToRDNSequence()
+	}
+
+	template.PermittedDNSDomainsCritical = old.Certificate.PermittedDNSDomainsCritical
+	template.PermittedDNSDomains = old.Certificate.PermittedDNSDomains
+	template.ExcludedDNSDomains = old.Certificate.ExcludedDNSDomains
+	template.PermittedIPRanges = old.Certificate.PermittedIPRanges
+	template.ExcludedIPRanges = old.Certificate.ExcludedIPRanges
+	template.PermittedEmailAddresses = old.Certificate.PermittedEmailAddresses
+	template.ExcludedEmailAddresses = old.Certificate.ExcludedEmailAddresses
+	template.PermittedURIDomains = old.Certificate.PermittedURIDomains
+	template.ExcludedURIDomains = old.Certificate.ExcludedURIDomains
+
+	certificate, key, err := createCA(path, template)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	cross, err := old.CrossSign(certificate)
+	if errors.Is(err, selfca.ErrCAExpired) {
+		return certificate, key, nil
+	}
+	if err != nil {
+		return nil, nil, fmt.Errorf("cross-sign: %w", err)
+	}
+
+	err = selfca.WriteCertificate(path+".cross", cross.Raw, nil)
+	if err != nil {
+		return nil, nil, fmt.Errorf("write: %w", err)
+	}
+
+	infof("Cross-signed the new ca by the archived ca to %s.cross.crt", path)
+
+	return certificate, key, nil
+}
+
+// keyName returns the description of key type and bits, like 2048-bit RSA or P-256 ECDSA
+func keyName(keyType selfca.KeyType, bits int) string {
+	switch keyType {
+	case selfca.KeyTypeECDSA:
+		return fmt.Sprintf("P-%d ECDSA", bits)
+	case selfca.KeyTypeEd25519:
+		return "Ed25519"
+	}
+
+	return fmt.Sprintf("%d-bit RSA", bits)
+}
+
+// createCA generates the ca certificate and key by template of key type, size, validity start
+// and subject, and writes them to path
+func createCA(path string, template selfca.Certificate) (*x509.Certificate, crypto.Signer, error) {
+	if template.KeySize <= 0 {
+		template.KeySize = selfca.DefaultKeySize(template.KeyType)
+	}
+
+	template.IsCA = true
+	if template.NotAfter.IsZero() {
+		template.NotAfter = template.NotBefore.Add(10 * 365 * 24 * time.Hour)
+	}
+
+	done := progress("Generating %s ca key", keyName(template.KeyType, template.KeySize))
+	i, err := selfca.Issue(template)
+	done()
+	if err != nil {
+		return nil, nil, fmt.Errorf("generate: %w", err)
+	}
+
+	err = i.WriteWithPassphrase(path, passphrase)
+	if err != nil {
+		return nil, nil, fmt.Errorf("write: %w", err)
+	}
+
+	err = recordCertificate(path, i.Certificate)
+	if err != nil {
+		return nil, nil, fmt.Errorf("write: %w", err)
+	}
+
+	debugf("Wrote ca to %s.crt", path)
+
+	return i.Certificate, i.Key, nil
+}
+
+// recordCertificate records the issued certificate in the database of ca
+func recordCertificate(caPath string, c *x509.Certificate) error {
+	db, err := selfca.OpenDatabase(caPath + ".db")
+	if err != nil {
+		return err
+	}
+
+	err = db.Add(c)
+	if err != nil {
+		return err
+	}
+
+	return db.Save()
+}
@@ -0,0 +1,71 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/selfca"
+)
+
+func TestRotateCA(t *testing.T) {
+	_, internal, err := net.ParseCIDR("10.0.0.0/8")
+	assert.Nil(t, err)
+
+	path := filepath.Join(t.TempDir(), "ca")
+	certificate, key, err := createCA(path, selfca.Certificate{
+		KeyType:                 selfca.KeyTypeECDSA,
+		CommonName:              "selfca test ca",
+		NotBefore:               time.Now().Add(-time.Minute),
+		NotAfter:                time.Now().Add(24 * time.Hour),
+		PermittedDNSDomains:     []string{"likexian.com"},
+		ExcludedDNSDomains:      []string{"internal.likexian.com"},
+		PermittedIPRanges:       []*net.IPNet{internal},
+		PermittedEmailAddresses: []string{"likexian.com"},
+		PermittedURIDomains:     []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	old := &selfca.CA{Certificate: certificate, Key: key}
+	rotated, _, err := rotateCA(path, old, selfca.Certificate{NotBefore: time.Now().Add(-time.Minute)})
+	assert.Nil(t, err)
+	assert.Equal(t, rotated.Subject.CommonName, "selfca test ca")
+
+	cross, err := readCertificates(path + ".cross.crt")
+	assert.Nil(t, err)
+
+	for _, v := range []*x509.Certificate{rotated, cross[0]} {
+		assert.True(t, v.PermittedDNSDomainsCritical)
+		assert.Equal(t, v.PermittedDNSDomains, []string{"likexian.com"})
+		assert.Equal(t, v.ExcludedDNSDomains, []string{"internal.likexian.com"})
+		assert.Equal(t, v.PermittedIPRanges[0].String(), "10.0.0.0/8")
+		assert.Equal(t, v.PermittedEmailAddresses, []string{"likexian.com"})
+		assert.Equal(t, v.PermittedURIDomains, []string{"likexian.com"})
+	}
+
+	_, err = os.Stat(path + "." + certificate.NotAfter.Format("20060102") + ".crt")
+	assert.Nil(t, err)
+}
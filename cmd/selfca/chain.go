@@ -0,0 +1,121 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// chain rewrites the chain file leaf first with duplicates removed,
+// and downloads the missing issuers by AIA if asked
+func chain(args []string) {
+	fs := flag.NewFlagSet("selfca chain", flag.ExitOnError)
+	output := fs.String("o", "", "Path for saving the repaired chain (default stdout)")
+	fetch := fs.Bool("fetch", false, "Download the missing issuers from the AIA CA issuers URL")
+	timeout := fs.Duration("timeout", 30*time.Second, "Timeout of downloading the missing issuers (default 30s)")
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca chain <chain.pem> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	files := parseArgs(fs, args)
+	if len(files) != 1 {
+		failUsage(fs, "Missing chain file")
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		fail(exitIO, "Failed to read the chain file", err)
+	}
+
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			fmt.Fprintf(os.Stderr, "Ignored %s block\n", block.Type)
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			fail(exitCrypto, "Failed to parse the certificate", err)
+		}
+		certificates = append(certificates, c)
+	}
+
+	if len(certificates) == 0 {
+		fail(exitBadInput, "Failed to repair the chain", fmt.Errorf("no certificate found"))
+	}
+
+	sorted, unrelated := selfca.SortChain(certificates)
+	if n := len(certificates) - len(sorted) - len(unrelated); n > 0 {
+		fmt.Fprintf(os.Stderr, "Removed %d duplicate certificates\n", n)
+	}
+
+	for _, v := range unrelated {
+		fmt.Fprintf(os.Stderr, "Removed unrelated certificate: %s\n", v.Subject)
+	}
+
+	if *fetch {
+		n := len(sorted)
+		ctx, cancel := context.WithTimeout(context.Background(), *timeout)
+		sorted, err = selfca.CompleteChain(ctx, nil, sorted)
+		cancel()
+		for _, v := range sorted[n:] {
+			fmt.Fprintf(os.Stderr, "Downloaded issuer: %s\n", v.Subject)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "Failed to download the issuer: %v\n", err)
+		}
+	}
+
+	root := sorted[len(sorted)-1]
+	if !bytes.Equal(root.RawIssuer, root.RawSubject) {
+		fmt.Fprintf(os.Stderr, "Incomplete chain, issuer not found: %s\n", root.Issuer)
+	}
+
+	buf := &bytes.Buffer{}
+	for _, v := range sorted {
+		_ = pem.Encode(buf, &pem.Block{Type: "CERTIFICATE", Bytes: v.Raw})
+	}
+
+	if *output == "" {
+		fmt.Print(buf.String())
+		return
+	}
+
+	err = os.WriteFile(*output, buf.Bytes(), 0644)
+	if err != nil {
+		fail(exitIO, "Failed to write the chain file", err)
+	}
+}
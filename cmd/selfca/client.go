@@ -0,0 +1,119 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"flag"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// client issues the mTLS client certificate of identity like alice@corp, with the client auth
+// extended key usage only and the identity as email or UPN subject alternative name
+func client(args []string) {
+	fs := flag.NewFlagSet("selfca client", flag.ExitOnError)
+	name := fs.String("n", "", "Identity of the client like alice@corp, as the common name and email subject alternative "+
+		"name")
+	upn := fs.Bool("upn", false, "Put the identity as UPN subject alternative name instead of email, for Active Directory")
+	bits := fs.Int("b", 2048, "Number of bits in the key to create, or curve size of ecdsa key (default 2048, or 256 for "+
+		"ecdsa and ed25519)")
+	keyType := fs.String("key-type", "rsa", "Type of the key to create, rsa, ecdsa or ed25519")
+	days := fs.Int("d", 365, "Valid days of the certificate, for example 365 (default 365 days)")
+	output := fs.String("o", "cert", "Folder for saving the certificate (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	fips := fs.Bool("fips", false, "Only allow FIPS approved key sizes and signature algorithms")
+	p12 := fs.Bool("p12", false, "Also write the certificate, key and chain as PKCS #12 file, for importing into browsers")
+	p12Password := fs.String("p12-password", "", "Password of the PKCS #12 file")
+	p12Legacy := fs.Bool("p12-legacy", false, "Encrypt the PKCS #12 file by the legacy 3DES, for older Windows and macOS "+
+		"Keychain")
+	subjectAttrs := addSubjectFlags(fs)
+	var hooks stringsFlag
+	fs.Var(&hooks, "hook", "Command to run after the certificate is issued, can be repeated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	identity := strings.TrimSpace(*name)
+	if identity == "" {
+		failUsage(fs, "Missing n parameter")
+	}
+
+	before, after, ok := strings.Cut(identity, "@")
+	if !ok || before == "" || after == "" || strings.ContainsAny(identity, " ,/") {
+		failUsage(fs, "The n parameter must be an identity like alice@corp")
+	}
+
+	checkKeyFlags(fs, *keyType, bits)
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	now := time.Now()
+	request := issueRequest{
+		Output:     *output,
+		CA:         *caFlag,
+		CATemplate: caConfig,
+		Name:       hostFileName(identity),
+		Config: selfca.Certificate{
+			CommonName: identity,
+			KeySize:    *bits,
+			KeyType:    selfca.KeyType(*keyType),
+			NotBefore:  now,
+			NotAfter:   now.Add(time.Duration(*days*24) * time.Hour),
+			Profile:    selfca.ProfileClient,
+		},
+		FIPS:           *fips,
+		PKCS12:         *p12,
+		PKCS12Password: *p12Password,
+		PKCS12Legacy:   *p12Legacy,
+		Hooks:          hooks,
+	}
+
+	if *upn {
+		request.Config.UPNs = []string{identity}
+	} else {
+		request.Config.Hosts = []string{identity}
+	}
+
+	err = subjectAttrs.apply(&request.Config)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse country parameter", err)
+	}
+
+	issuance, err := issueCertificate(request)
+	if err != nil {
+		failError(err)
+	}
+
+	infof("Issued client certificate %s, valid until %s", issuance.CertificateFile,
+		issuance.Certificate.NotAfter.Format(time.RFC3339))
+}
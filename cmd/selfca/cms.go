@@ -0,0 +1,202 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"math/big"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+var (
+	// oidData is the OID of CMS data content type
+	oidData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	// oidSignedData is the OID of CMS signed data content type
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	// oidContentType is the OID of CMS content type attribute
+	oidContentType = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	// oidMessageDigest is the OID of CMS message digest attribute
+	oidMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	// oidSigningTime is the OID of CMS signing time attribute
+	oidSigningTime = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	// oidSHA256 is the OID of SHA-256 digest algorithm
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	// oidSHA512 is the OID of SHA-512 digest algorithm
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+	// oidRSAEncryption is the OID of RSA signature algorithm in CMS
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	// oidECDSAWithSHA256 is the OID of ECDSA with SHA-256 signature algorithm
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	// oidEd25519 is the OID of Ed25519 signature algorithm
+	oidEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+)
+
+// cmsContentInfo is the CMS content info
+type cmsContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue
+}
+
+// cmsSignedData is the CMS signed data
+type cmsSignedData struct {
+	Version          int
+	DigestAlgorithms []cmsAlgorithm `asn1:"set"`
+	EncapContentInfo cmsContentInfo
+	Certificates     []asn1.RawValue `asn1:"tag:0"`
+	SignerInfos      []cmsSignerInfo `asn1:"set"`
+}
+
+// cmsAlgorithm is the algorithm identifier with NULL parameters
+type cmsAlgorithm struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+// cmsSignerInfo is the CMS signer info identified by issuer and serial number
+type cmsSignerInfo struct {
+	Version            int
+	SignerIdentifier   cmsIssuerAndSerial
+	DigestAlgorithm    cmsAlgorithm
+	SignedAttributes   asn1.RawValue
+	SignatureAlgorithm cmsAlgorithm
+	Signature          []byte
+}
+
+// cmsIssuerAndSerial is the signer identified by issuer and serial number of its certificate
+type cmsIssuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+// cmsAttribute is the CMS signed attribute
+type cmsAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// signCMS returns the DER encoded CMS signed data of content, signed by the key with certificates
+// embedded, the first certificate is the signer
+func signCMS(content []byte, certificates []*x509.Certificate, key crypto.Signer) ([]byte, error) {
+	null := asn1.RawValue{Tag: asn1.TagNull}
+	hash, digestAlgorithm := crypto.SHA256, cmsAlgorithm{Algorithm: oidSHA256, Parameters: null}
+
+	var signatureAlgorithm cmsAlgorithm
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		signatureAlgorithm = cmsAlgorithm{Algorithm: oidRSAEncryption, Parameters: null}
+	case *ecdsa.PublicKey:
+		signatureAlgorithm = cmsAlgorithm{Algorithm: oidECDSAWithSHA256}
+	case ed25519.PublicKey:
+		// RFC 8419 requires SHA-512 message digest with Ed25519
+		hash, digestAlgorithm = crypto.SHA512, cmsAlgorithm{Algorithm: oidSHA512}
+		signatureAlgorithm = cmsAlgorithm{Algorithm: oidEd25519}
+	default:
+		return nil, selfca.ErrUnsupportedKeyType
+	}
+
+	digest := hash.New()
+	digest.Write(content)
+	attributes := []struct {
+		oid   asn1.ObjectIdentifier
+		value any
+	}{
+		{oidContentType, oidData},
+		{oidSigningTime, time.Now().UTC()},
+		{oidMessageDigest, digest.Sum(nil)},
+	}
+
+	signed := []cmsAttribute{}
+	for _, v := range attributes {
+		value, err := asn1.Marshal(v.value)
+		if err != nil {
+			return nil, err
+		}
+		signed = append(signed, cmsAttribute{Type: v.oid, Values: []asn1.RawValue{{FullBytes: value}}})
+	}
+
+	// the signature is over the DER encoding of attributes as SET OF, embedded as implicit [0]
+	set, err := asn1.MarshalWithParams(signed, "set")
+	if err != nil {
+		return nil, err
+	}
+
+	implicit := append([]byte{0xa0}, set[1:]...)
+
+	// Ed25519 signs the whole attributes instead of the digest
+	message, signHash := set, hash
+	if _, ok := key.Public().(ed25519.PublicKey); ok {
+		signHash = 0
+	} else {
+		digest = hash.New()
+		digest.Write(set)
+		message = digest.Sum(nil)
+	}
+
+	signature, err := key.Sign(rand.Reader, message, signHash)
+	if err != nil {
+		return nil, err
+	}
+
+	raw := []asn1.RawValue{}
+	for _, v := range certificates {
+		raw = append(raw, asn1.RawValue{FullBytes: v.Raw})
+	}
+
+	octets, err := asn1.Marshal(content)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := asn1.Marshal(cmsSignedData{
+		Version:          1,
+		DigestAlgorithms: []cmsAlgorithm{digestAlgorithm},
+		EncapContentInfo: cmsContentInfo{ContentType: oidData, Content: explicitTag(octets)},
+		Certificates:     raw,
+		SignerInfos: []cmsSignerInfo{{
+			Version: 1,
+			SignerIdentifier: cmsIssuerAndSerial{
+				Issuer:       asn1.RawValue{FullBytes: certificates[0].RawIssuer},
+				SerialNumber: certificates[0].SerialNumber,
+			},
+			DigestAlgorithm:    digestAlgorithm,
+			SignedAttributes:   asn1.RawValue{FullBytes: implicit},
+			SignatureAlgorithm: signatureAlgorithm,
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(cmsContentInfo{ContentType: oidSignedData, Content: explicitTag(data)})
+}
+
+// explicitTag returns the DER value wrapped in explicit [0] tag
+func explicitTag(der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+}
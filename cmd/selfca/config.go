@@ -0,0 +1,296 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/likexian/gokit/xcron"
+	"github.com/likexian/selfca"
+)
+
+// config is the selfca config file for daemon mode
+type config struct {
+	Output         string              `json:"output"`
+	CA             string              `json:"ca"`
+	CAName         string              `json:"ca_name"`
+	CASubject      string              `json:"ca_subject"`
+	PassphraseFile string              `json:"passphrase_file"`
+	Schedule       string              `json:"schedule"`
+	RenewBefore    duration            `json:"renew_before"`
+	Hooks          []string            `json:"hooks"`
+	Plugins        []string            `json:"plugins"`
+	Escrow         string              `json:"escrow"`
+	AllowWeak      bool                `json:"insecure_allow_weak"`
+	FIPS           bool                `json:"fips"`
+	StrictValidity bool                `json:"strict_validity"`
+	AutoRotateCA   bool                `json:"auto_rotate_ca"`
+	PEMComments    bool                `json:"pem_comments"`
+	FullChainRoot  bool                `json:"fullchain_root"`
+	Notify         notifyConfig        `json:"notify"`
+	CRL            *crlConfig          `json:"crl"`
+	Certificates   []configCertificate `json:"certificates"`
+	caTemplate     selfca.Certificate
+}
+
+// configCertificate is the certificate declared in config file
+type configCertificate struct {
+	Name       string   `json:"name"`
+	CommonName string   `json:"common_name"`
+	Hosts      []string `json:"hosts"`
+	Wildcard   bool     `json:"with_wildcard"`
+	KeyType    string   `json:"key_type"`
+	Bits       int      `json:"bits"`
+	Days       int      `json:"days"`
+	Hooks      []string `json:"hooks"`
+}
+
+// duration is time.Duration which marshals as string like 72h
+type duration struct {
+	time.Duration
+}
+
+// UnmarshalJSON parses duration from json string
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		return err
+	}
+
+	d.Duration = v
+	return nil
+}
+
+// MarshalJSON returns duration as json string
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// configCommand runs the config subcommands
+func configCommand(args []string) {
+	if len(args) == 0 || args[0] != "validate" {
+		fmt.Fprintf(os.Stderr, "Usage: selfca config validate [-c file]\n")
+		os.Exit(exitBadInput)
+	}
+
+	fs := flag.NewFlagSet("selfca config validate", flag.ExitOnError)
+	path := fs.String("c", "selfca.json", "Path of the config file")
+	addPassphraseFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args[1:])
+
+	c, err := loadConfig(*path)
+	if err == nil {
+		err = validateConfig(c)
+	}
+	if err != nil {
+		fail(exitBadInput, "Invalid config file "+*path, err)
+	}
+
+	data, err := json.MarshalIndent(c.redacted(), "", "    ")
+	if err != nil {
+		fail(exitFailure, "Failed to print the config", err)
+	}
+
+	fmt.Println(string(data))
+	infof("Config file %s is valid, %d certificates declared", *path, len(c.Certificates))
+}
+
+// validateConfig cross-checks the loaded config against the policies applied when renewing,
+// returns all the problems found
+func validateConfig(c *config) error {
+	var errs []error
+	names := map[string]int{}
+	now := time.Now()
+	for i, v := range c.Certificates {
+		if j, ok := names[v.Name]; ok {
+			errs = append(errs, fmt.Errorf("certificate #%d has the same name %s as #%d", i+1, v.Name, j))
+		}
+		names[v.Name] = i + 1
+
+		valid := time.Duration(v.Days*24) * time.Hour
+		if c.RenewBefore.Duration >= valid {
+			errs = append(errs, fmt.Errorf("certificate #%d is valid for %d days, not longer than renew_before %s, it would be "+
+				"renewed on every run",
+				i+1, v.Days, c.RenewBefore))
+		}
+
+		template := selfca.Certificate{
+			CommonName: v.CommonName,
+			KeySize:    v.Bits,
+			KeyType:    selfca.KeyType(v.KeyType),
+			NotBefore:  now,
+			NotAfter:   now.Add(valid),
+			Hosts:      v.Hosts,
+		}
+		err := checkWeak(template, c.AllowWeak)
+		if err == nil {
+			err = checkFIPS(template, c.FIPS)
+		}
+		if err != nil {
+			errs = append(errs, fmt.Errorf("certificate #%d: %w", i+1, err))
+		}
+	}
+
+	if c.Escrow != "" {
+		if _, err := readEscrowKey(c.Escrow); err != nil {
+			errs = append(errs, fmt.Errorf("escrow: %w", err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// redacted returns the copy of config with the secrets of notification sinks redacted, for printing
+func (c *config) redacted() *config {
+	r := *c
+	if c.Notify.SMTP != nil {
+		smtp := *c.Notify.SMTP
+		if smtp.Password != "" {
+			smtp.Password = "REDACTED"
+		}
+		r.Notify.SMTP = &smtp
+	}
+
+	r.Notify.Webhooks = nil
+	for _, v := range c.Notify.Webhooks {
+		if u, err := url.Parse(v.URL); err == nil && u.Host != "" {
+			v.URL = u.Scheme + "://" + u.Host + "/REDACTED"
+		} else {
+			v.URL = "REDACTED"
+		}
+		r.Notify.Webhooks = append(r.Notify.Webhooks, v)
+	}
+
+	return &r
+}
+
+// loadConfig reads config from file and applies the defaults
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &config{}
+	err = json.Unmarshal(data, c)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Output == "" {
+		c.Output = "cert"
+	}
+
+	if c.Schedule == "" {
+		c.Schedule = "@hourly"
+	}
+
+	if _, err := xcron.Parse(c.Schedule); err != nil {
+		return nil, err
+	}
+
+	if c.RenewBefore.Duration <= 0 {
+		c.RenewBefore.Duration = 72 * time.Hour
+	}
+
+	if c.PassphraseFile != "" {
+		err = readPassphrase(c.PassphraseFile)
+		if err != nil {
+			return nil, fmt.Errorf("passphrase_file: %w", err)
+		}
+	}
+
+	c.caTemplate, err = caTemplate(c.CAName, c.CASubject)
+	if err != nil {
+		return nil, fmt.Errorf("ca_subject: %w", err)
+	}
+
+	err = c.Notify.validate()
+	if err != nil {
+		return nil, err
+	}
+
+	if c.CRL != nil {
+		err = c.CRL.validate()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(c.Certificates) == 0 {
+		return nil, errors.New("no certificates declared")
+	}
+
+	for i := range c.Certificates {
+		err = c.Certificates[i].applyDefaults(i + 1)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+// applyDefaults expands the hosts and applies the defaults of certificate #n
+func (v *configCertificate) applyDefaults(n int) (err error) {
+	v.Hosts, err = expandHosts(v.Hosts)
+	if err != nil {
+		return fmt.Errorf("certificate #%d: %w", n, err)
+	}
+
+	if len(v.Hosts) == 0 {
+		return fmt.Errorf("certificate #%d has no hosts", n)
+	}
+
+	if v.Wildcard {
+		v.Hosts = editHosts(v.Hosts, pairedHosts(v.Hosts), nil)
+	}
+
+	if v.Name == "" {
+		v.Name = hostFileName(v.Hosts[0])
+	}
+
+	if v.KeyType != "" && !validKeyType(selfca.KeyType(v.KeyType)) {
+		return fmt.Errorf("certificate #%d has unsupported key type %s", n, v.KeyType)
+	}
+
+	if v.Bits <= 0 {
+		v.Bits = selfca.DefaultKeySize(selfca.KeyType(v.KeyType))
+	}
+
+	if v.Days <= 0 {
+		v.Days = 365
+	}
+
+	return nil
+}
@@ -0,0 +1,399 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	cryptorand "crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"math/big"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"time"
+	"unicode/utf16"
+)
+
+// corpusArc is the private OID arc of random extensions and attributes in corpus
+var corpusArc = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 55555}
+
+// corpusAttributes is the subject attribute types used in corpus
+var corpusAttributes = []asn1.ObjectIdentifier{
+	{2, 5, 4, 3}, {2, 5, 4, 6}, {2, 5, 4, 7}, {2, 5, 4, 8}, {2, 5, 4, 10}, {2, 5, 4, 11},
+	{2, 5, 4, 5}, {2, 5, 4, 15}, {0, 9, 2342, 19200300, 100, 1, 25}, {1, 2, 840, 113549, 1, 9, 1},
+}
+
+// corpusStringTags is the ASN.1 string types used for subject values in corpus
+var corpusStringTags = []int{
+	asn1.TagPrintableString, asn1.TagUTF8String, asn1.TagIA5String, asn1.TagT61String, asn1.TagBMPString,
+}
+
+// corpusRunes is the characters of random strings in corpus
+var corpusRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 -.'*@=+,;\\\"éüß中文Ω")
+
+// corpusPrintableRunes is the characters of random PrintableString in corpus
+var corpusPrintableRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 '()+,-./:=?")
+
+// corpusASCIIRunes is the characters of random IA5String and T61String in corpus
+var corpusASCIIRunes = []rune("abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789 -.'*@=+,;\\\"")
+
+// corpusHostRunes is the characters of random host labels in corpus
+var corpusHostRunes = []rune("abcdefghijklmnopqrstuvwxyz0123456789")
+
+// corpusRSASignatures is the signature algorithms chosen for RSA keys in corpus
+var corpusRSASignatures = []x509.SignatureAlgorithm{
+	x509.SHA256WithRSA, x509.SHA384WithRSA, x509.SHA512WithRSA,
+	x509.SHA256WithRSAPSS, x509.SHA384WithRSAPSS, x509.SHA512WithRSAPSS,
+}
+
+// corpus generates structurally diverse certificates as corpus for fuzzing x509 parsers
+func corpus(args []string) {
+	fs := flag.NewFlagSet("selfca corpus", flag.ExitOnError)
+	count := fs.Int("n", 100, "Number of certificate chains to generate")
+	depth := fs.Int("depth", 4, "Max number of certificates in a chain")
+	seed := fs.Int64("seed", 0, "Seed of the random structure (default current time)")
+	output := fs.String("o", "corpus", "Folder for saving the corpus (default corpus)")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if *count <= 0 || *depth <= 0 {
+		failUsage(fs, "Invalid number or depth parameter")
+	}
+
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	err := os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	done := progress("Generating keys")
+	keys, err := corpusKeys()
+	done()
+	if err != nil {
+		fail(exitCrypto, "Failed to generate the keys", err)
+	}
+
+	r := rand.New(rand.NewSource(*seed))
+	total := 0
+	done = progress("Generating %d chains with seed %d", *count, *seed)
+	for i := 0; i < *count; i++ {
+		chain, err := corpusChain(r, keys, 1+r.Intn(*depth))
+		if err != nil {
+			done()
+			fail(exitCrypto, "Failed to generate the certificate", err)
+		}
+
+		var bundle []byte
+		for j, v := range chain {
+			name := filepath.Join(*output, fmt.Sprintf("%06d-%d.der", i, j))
+			err = os.WriteFile(name, v, 0644)
+			if err != nil {
+				done()
+				fail(exitIO, "Failed to write the certificate", err)
+			}
+			bundle = append(bundle, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: v})...)
+		}
+
+		err = os.WriteFile(filepath.Join(*output, fmt.Sprintf("%06d.pem", i)), bundle, 0644)
+		if err != nil {
+			done()
+			fail(exitIO, "Failed to write the chain", err)
+		}
+
+		total += len(chain)
+	}
+	done()
+
+	infof("Wrote %d certificates in %d chains to %s, seed %d", total, *count, *output, *seed)
+}
+
+// corpusKeys returns the keys of different types and sizes used in corpus
+func corpusKeys() ([]crypto.Signer, error) {
+	var keys []crypto.Signer
+	for _, v := range []int{1024, 2048} {
+		key, err := rsa.GenerateKey(cryptorand.Reader, v)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	for _, v := range []elliptic.Curve{elliptic.P256(), elliptic.P384(), elliptic.P521()} {
+		key, err := ecdsa.GenerateKey(v, cryptorand.Reader)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	_, key, err := ed25519.GenerateKey(cryptorand.Reader)
+	if err != nil {
+		return nil, err
+	}
+
+	return append(keys, key), nil
+}
+
+// corpusChain returns the DER encoded chain of n certificates, leaf first
+func corpusChain(r *rand.Rand, keys []crypto.Signer, n int) ([][]byte, error) {
+	var parent *x509.Certificate
+	var parentKey crypto.Signer
+	chain := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		key := keys[r.Intn(len(keys))]
+		template, err := corpusTemplate(r, i > 0)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, issuer := key, template
+		if parent != nil {
+			signer, issuer = parentKey, parent
+		}
+
+		if rsaKey, ok := signer.(*rsa.PrivateKey); ok {
+			signatures := corpusRSASignatures
+			if rsaKey.N.BitLen() < 2048 {
+				signatures = signatures[:4]
+			}
+			template.SignatureAlgorithm = signatures[r.Intn(len(signatures))]
+		}
+
+		der, err := x509.CreateCertificate(cryptorand.Reader, template, issuer, key.Public(), signer)
+		if err != nil {
+			return nil, err
+		}
+
+		parent, err = x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+
+		parentKey = key
+		chain[i] = der
+	}
+
+	return chain, nil
+}
+
+// corpusTemplate returns the certificate template with random structure
+func corpusTemplate(r *rand.Rand, isCA bool) (*x509.Certificate, error) {
+	serial := make([]byte, 1+r.Intn(20))
+	r.Read(serial)
+	serial[0] = serial[0]&0x7f | 0x01
+
+	subject, err := asn1.Marshal(corpusSubject(r))
+	if err != nil {
+		return nil, err
+	}
+
+	notBefore := time.Date(1950+r.Intn(100), time.Month(1+r.Intn(12)), 1+r.Intn(28),
+		r.Intn(24), r.Intn(60), r.Intn(60), 0, time.UTC)
+
+	template := &x509.Certificate{
+		SerialNumber:          new(big.Int).SetBytes(serial),
+		RawSubject:            subject,
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.Add(time.Duration(1+r.Int63n(50*365*24)) * time.Hour),
+		KeyUsage:              x509.KeyUsage(r.Intn(1 << 9)),
+		BasicConstraintsValid: isCA || r.Intn(2) == 0,
+		IsCA:                  isCA,
+	}
+
+	if isCA {
+		template.KeyUsage |= x509.KeyUsageCertSign
+		template.MaxPathLen = r.Intn(4) - 1
+		template.MaxPathLenZero = template.MaxPathLen == 0
+		if r.Intn(4) == 0 {
+			template.PermittedDNSDomains = []string{corpusHost(r)}
+			template.ExcludedIPRanges = []*net.IPNet{{IP: net.IP{10, 0, 0, 0}, Mask: net.CIDRMask(8, 32)}}
+		}
+	}
+
+	for i := r.Intn(4); i > 0; i-- {
+		template.ExtKeyUsage = append(template.ExtKeyUsage, x509.ExtKeyUsage(r.Intn(14)))
+	}
+
+	for i := r.Intn(3); i > 0; i-- {
+		template.UnknownExtKeyUsage = append(template.UnknownExtKeyUsage, corpusOID(r))
+	}
+
+	for i := r.Intn(4); i > 0; i-- {
+		template.DNSNames = append(template.DNSNames, corpusHost(r))
+	}
+
+	for i := r.Intn(3); i > 0; i-- {
+		ip := make(net.IP, []int{4, 16}[r.Intn(2)])
+		r.Read(ip)
+		template.IPAddresses = append(template.IPAddresses, ip)
+	}
+
+	for i := r.Intn(2); i > 0; i-- {
+		template.EmailAddresses = append(template.EmailAddresses, corpusString(r, corpusHostRunes, 8)+"@"+corpusHost(r))
+	}
+
+	for i := r.Intn(2); i > 0; i-- {
+		template.URIs = append(template.URIs, &url.URL{Scheme: "urn", Opaque: corpusString(r, corpusHostRunes, 12)})
+	}
+
+	if r.Intn(3) == 0 {
+		template.SubjectKeyId = make([]byte, r.Intn(32))
+		r.Read(template.SubjectKeyId)
+	}
+
+	if r.Intn(3) == 0 {
+		template.PolicyIdentifiers = []asn1.ObjectIdentifier{corpusOID(r)}
+		template.OCSPServer = []string{"http://" + corpusHost(r) + "/ocsp"}
+		template.IssuingCertificateURL = []string{"http://" + corpusHost(r) + "/ca.crt"}
+		template.CRLDistributionPoints = []string{"http://" + corpusHost(r) + "/ca.crl"}
+	}
+
+	for i := r.Intn(4); i > 0; i-- {
+		template.ExtraExtensions = append(template.ExtraExtensions, pkix.Extension{
+			Id:       corpusOID(r),
+			Critical: r.Intn(4) == 0,
+			Value:    corpusValue(r, 3),
+		})
+	}
+
+	return template, nil
+}
+
+// corpusSubject returns the subject with random attributes, encodings and multi-valued RDNs
+func corpusSubject(r *rand.Rand) pkix.RDNSequence {
+	var subject pkix.RDNSequence
+	for i := r.Intn(6); i > 0; i-- {
+		var set pkix.RelativeDistinguishedNameSET
+		for j := 1 + r.Intn(3)/2; j > 0; j-- {
+			oid := corpusAttributes[r.Intn(len(corpusAttributes))]
+			if r.Intn(8) == 0 {
+				oid = corpusOID(r)
+			}
+			set = append(set, pkix.AttributeTypeAndValue{Type: oid, Value: corpusStringValue(r)})
+		}
+		subject = append(subject, set)
+	}
+
+	return subject
+}
+
+// corpusStringValue returns a random string encoded as a random ASN.1 string type
+func corpusStringValue(r *rand.Rand) asn1.RawValue {
+	tag := corpusStringTags[r.Intn(len(corpusStringTags))]
+	runes := corpusRunes
+	switch tag {
+	case asn1.TagPrintableString:
+		runes = corpusPrintableRunes
+	case asn1.TagIA5String, asn1.TagT61String:
+		runes = corpusASCIIRunes
+	}
+
+	s := corpusString(r, runes, 1+r.Intn(32))
+	if tag != asn1.TagBMPString {
+		return asn1.RawValue{Tag: tag, Bytes: []byte(s)}
+	}
+
+	var b []byte
+	for _, v := range utf16.Encode([]rune(s)) {
+		b = append(b, byte(v>>8), byte(v))
+	}
+
+	return asn1.RawValue{Tag: tag, Bytes: b}
+}
+
+// corpusValue returns random DER encoded value nested up to depth
+func corpusValue(r *rand.Rand, depth int) []byte {
+	var v any
+	switch r.Intn(6) {
+	case 0:
+		return asn1.NullBytes
+	case 1:
+		v = r.Int63() - r.Int63()
+	case 2:
+		b := make([]byte, r.Intn(64))
+		r.Read(b)
+		v = b
+	case 3:
+		v = corpusStringValue(r)
+	case 4:
+		v = corpusOID(r)
+	default:
+		if depth == 0 {
+			return asn1.NullBytes
+		}
+		var items []asn1.RawValue
+		for i := r.Intn(4); i > 0; i-- {
+			items = append(items, asn1.RawValue{FullBytes: corpusValue(r, depth-1)})
+		}
+		v = items
+	}
+
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		return asn1.NullBytes
+	}
+
+	return der
+}
+
+// corpusOID returns a random OID under the private arc
+func corpusOID(r *rand.Rand) asn1.ObjectIdentifier {
+	oid := append(asn1.ObjectIdentifier{}, corpusArc...)
+	for i := 1 + r.Intn(4); i > 0; i-- {
+		oid = append(oid, r.Intn(1<<20))
+	}
+
+	return oid
+}
+
+// corpusHost returns a random host name under .test
+func corpusHost(r *rand.Rand) string {
+	var host string
+	for i := 1 + r.Intn(3); i > 0; i-- {
+		host += corpusString(r, corpusHostRunes, 1+r.Intn(12)) + "."
+	}
+
+	return host + "test"
+}
+
+// corpusString returns a random string of n characters in runes
+func corpusString(r *rand.Rand, runes []rune, n int) string {
+	s := make([]rune, n)
+	for i := range s {
+		s[i] = runes[r.Intn(len(runes))]
+	}
+
+	return string(s)
+}
@@ -0,0 +1,268 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// crlPublishTimeout is the timeout of publishing CRL to an url
+const crlPublishTimeout = 30 * time.Second
+
+// crlConfig is the config of regenerating and publishing CRL in daemon mode
+type crlConfig struct {
+	Validity  duration `json:"validity"`
+	Publish   []string `json:"publish"`
+	UpgradeCA bool     `json:"upgrade_ca"`
+}
+
+// validate checks the CRL config and applies the defaults
+func (c *crlConfig) validate() error {
+	if c.Validity.Duration <= 0 {
+		c.Validity.Duration = selfca.DefaultCRLValidity
+	}
+
+	for _, v := range c.Publish {
+		if strings.TrimSpace(v) == "" {
+			return errors.New("crl publish target is empty")
+		}
+	}
+
+	return nil
+}
+
+// crl generates the CRL of the ca in output folder, and publishes it
+func crl(args []string) {
+	fs := flag.NewFlagSet("selfca crl", flag.ExitOnError)
+	validity := fs.Duration("validity", selfca.DefaultCRLValidity, "Time from this update to next update of the CRL "+
+		"(default 168h)")
+	var publish stringsFlag
+	fs.Var(&publish, "publish", "File path or http url the CRL is published to by PUT, can be repeated")
+	output := fs.String("o", "cert", "Folder of the ca (default cert)")
+	caFlag := addCAFlag(fs)
+	upgradeCA := addUpgradeCAFlag(fs)
+	addPassphraseFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if *validity <= 0 {
+		failUsage(fs, "Invalid validity parameter")
+	}
+
+	caPath := resolveCA(*caFlag, *output)
+	crl, err := generateCRL(caPath, *output, *validity, *upgradeCA)
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to generate the CRL", err)
+	}
+
+	list, err := x509.ParseRevocationList(crl)
+	if err != nil {
+		fail(exitCrypto, "Failed to parse the CRL", err)
+	}
+
+	for _, v := range publish {
+		err = publishCRL(v, crl)
+		if err != nil {
+			fail(exitIO, "Failed to publish the CRL to "+v, err)
+		}
+		debugf("Published %s.crl to %s", caPath, v)
+	}
+
+	infof("Generated %s.crl #%s with %d revoked, next update at %s", caPath, list.Number,
+		len(list.RevokedCertificateEntries), list.NextUpdate.Format(time.RFC3339))
+}
+
+// published is the CRL last published, it is published again only when changed
+var published struct {
+	sync.Mutex
+	crl []byte
+}
+
+// renewCRL regenerates the CRL of ca in caPath if it is missing or past half of
+// its validity, and publishes it if changed, returns the next update of the CRL
+func renewCRL(caPath, output string, c *crlConfig) (time.Time, error) {
+	crl, err := os.ReadFile(caPath + ".crl")
+	var list *x509.RevocationList
+	if err == nil {
+		list, err = x509.ParseRevocationList(crl)
+	}
+
+	if err != nil || time.Until(list.NextUpdate) <= c.Validity.Duration/2 {
+		crl, err = generateCRL(caPath, output, c.Validity.Duration, c.UpgradeCA)
+		if err != nil {
+			return time.Time{}, err
+		}
+		list, err = x509.ParseRevocationList(crl)
+		if err != nil {
+			return time.Time{}, err
+		}
+		log.Printf("Regenerated %s.crl #%s, next update at %s", caPath, list.Number, list.NextUpdate.Format(time.RFC3339))
+	}
+
+	published.Lock()
+	defer published.Unlock()
+
+	if bytes.Equal(crl, published.crl) {
+		return list.NextUpdate, nil
+	}
+
+	for _, v := range c.Publish {
+		err = publishCRL(v, crl)
+		if err != nil {
+			return list.NextUpdate, fmt.Errorf("publish to %s: %w", v, err)
+		}
+		debugf("Published %s.crl to %s", caPath, v)
+	}
+
+	published.crl = crl
+
+	return list.NextUpdate, nil
+}
+
+// addUpgradeCAFlag adds the flag of re-issuing the ca without CRL signing usage
+func addUpgradeCAFlag(fs *flag.FlagSet) *bool {
+	return fs.Bool("upgrade-ca", false, "Re-issue the ca created by older versions without CRL signing usage by its own "+
+		"key with the usage added")
+}
+
+// generateCRL generates the CRL of ca in caPath with the CRL number bumped, and writes it,
+// the ca without CRL signing usage is re-issued with the usage if upgrade
+func generateCRL(caPath, output string, validity time.Duration, upgrade bool) ([]byte, error) {
+	unlock, err := lockOutput(output)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlock()
+
+	unlockCA, err := lockCA(caPath, output)
+	if err != nil {
+		return nil, err
+	}
+
+	defer unlockCA()
+
+	certificates, key, err := readCA(caPath)
+	if err != nil {
+		return nil, err
+	}
+
+	db, err := selfca.OpenDatabase(caPath + ".db")
+	if err != nil {
+		return nil, err
+	}
+
+	ca := &selfca.CA{Certificate: certificates[0], Key: key}
+	if upgrade && ca.Certificate.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		err = upgradeCA(caPath, ca)
+		if err != nil {
+			return nil, fmt.Errorf("upgrade ca: %w", err)
+		}
+	}
+
+	crl, err := db.GenerateCRL(ca, validity)
+	if errors.Is(err, selfca.ErrCRLSignNotAllowed) {
+		return nil, fmt.Errorf("%w, use -upgrade-ca to re-issue it with the usage", err)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = db.Save()
+	if err != nil {
+		return nil, err
+	}
+
+	err = selfca.WriteCRL(caPath, crl)
+	if err != nil {
+		return nil, err
+	}
+
+	return crl, nil
+}
+
+// upgradeCA re-issues the ca certificate in caPath with CRL signing usage by its own key,
+// the old ca certificate is archived to caPath.nocrlsign.crt
+func upgradeCA(caPath string, ca *selfca.CA) error {
+	certificate, err := ca.AllowCRLSign()
+	if err != nil {
+		return err
+	}
+
+	archive := caPath + ".nocrlsign"
+	err = os.Rename(caPath+".crt", archive+".crt")
+	if err != nil {
+		return err
+	}
+
+	err = selfca.WriteCertificate(caPath, certificate.Raw, nil)
+	if err != nil {
+		return err
+	}
+
+	ca.Certificate = certificate
+	fmt.Fprintf(os.Stderr, "WARNING: ca certificate is re-issued with CRL signing usage, archived to %s.crt, "+
+		"update %s.crt in the trust stores verifying the CRL\n", archive, caPath)
+
+	return nil
+}
+
+// publishCRL publishes the CRL to target, http or https url is uploaded by PUT,
+// others are file paths the CRL is written to
+func publishCRL(target string, crl []byte) error {
+	if !strings.HasPrefix(target, "http://") && !strings.HasPrefix(target, "https://") {
+		return os.WriteFile(target, crl, 0644)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), crlPublishTimeout)
+	defer cancel()
+
+	request, err := http.NewRequestWithContext(ctx, http.MethodPut, target, bytes.NewReader(crl))
+	if err != nil {
+		return err
+	}
+
+	request.Header.Set("Content-Type", "application/pkix-crl")
+	response, err := http.DefaultClient.Do(request)
+	if err != nil {
+		return err
+	}
+
+	defer response.Body.Close()
+	if response.StatusCode/100 != 2 {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return nil
+}
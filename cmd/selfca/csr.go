@@ -0,0 +1,177 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto/x509/pkix"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/likexian/selfca"
+)
+
+// csr generates the key and certificate request for submitting to other ca,
+// like issue -csr-only, no ca is created
+func csr(args []string) {
+	fs := flag.NewFlagSet("selfca csr", flag.ExitOnError)
+	name := fs.String("n", "", "Common name of the certificate request")
+	subject := fs.String("subject", "", "Subject of the certificate request, as /C=US/O=Acme/CN=example.com or "+
+		"CN=example.com,O=Acme,C=US")
+	host := fs.String("h", "", "Domains, IPs or emails of the certificate request, comma separated, @file or - to read "+
+		"from file or stdin")
+	withWildcard := fs.Bool("with-wildcard", false, "Also add the wildcard of each domain, and the apex of each "+
+		"wildcard, like example.com and *.example.com")
+	bits := fs.Int("b", 2048, "Number of bits in the key to create, or curve size of ecdsa key (default 2048, or 256 for "+
+		"ecdsa and ed25519)")
+	keyType := fs.String("key-type", "rsa", "Type of the key to create, rsa, ecdsa or ed25519")
+	output := fs.String("o", "cert", "Folder for saving the certificate request (default cert)")
+	file := fs.String("name", "", "Name of the request and key files (default the first host)")
+	password := fs.String("challenge-password", "", "Challenge password of the certificate request")
+	weak := fs.Bool("insecure-allow-weak", false, "Allow weak key size, for testing only")
+	fips := fs.Bool("fips", false, "Only allow FIPS approved key sizes")
+	subjectAttrs := addSubjectFlags(fs)
+	var upns, attrs, hooks stringsFlag
+	fs.Var(&attrs, "subject-attr", "Subject attribute as name=value or oid=value, like 2.5.4.15=Private Organization, "+
+		"can be repeated")
+	fs.Var(&upns, "upn", "User principal name of the certificate request for smart card logon, can be repeated")
+	fs.Var(&hooks, "hook", "Command to run after the certificate request is generated, can be repeated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	checkKeyFlags(fs, *keyType, bits)
+
+	hosts, err := parseHosts(*host)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse hosts parameter", err)
+	}
+
+	if *withWildcard {
+		hosts = editHosts(hosts, pairedHosts(hosts), nil)
+		debugf("Added the paired wildcard and apex hosts, the hosts are %s", strings.Join(hosts, ", "))
+	}
+
+	if len(hosts) == 0 && len(upns) == 0 {
+		failUsage(fs, "Missing hosts parameter")
+	}
+
+	var subjectRDNs pkix.RDNSequence
+	if *subject != "" {
+		subjectRDNs, err = selfca.ParseDN(*subject)
+		if err != nil {
+			fail(exitBadInput, "Failed to parse subject parameter", err)
+		}
+	}
+
+	var extraSubject []pkix.AttributeTypeAndValue
+	for _, v := range attrs {
+		attr, err := selfca.ParseAttribute(v)
+		if err != nil {
+			fail(exitBadInput, "Failed to parse subject-attr parameter", err)
+		}
+		extraSubject = append(extraSubject, attr)
+	}
+
+	if len(*output) == 0 {
+		*output = "cert"
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	config := selfca.Certificate{
+		CommonName:        *name,
+		Subject:           subjectRDNs,
+		ExtraSubject:      extraSubject,
+		KeySize:           *bits,
+		KeyType:           selfca.KeyType(*keyType),
+		Hosts:             hosts,
+		UPNs:              upns,
+		ChallengePassword: *password,
+	}
+
+	err = subjectAttrs.apply(&config)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse country parameter", err)
+	}
+
+	err = requestCertificate(issueRequest{
+		Output:    *output,
+		Name:      *file,
+		Config:    config,
+		AllowWeak: *weak,
+		FIPS:      *fips,
+		Hooks:     hooks,
+	})
+	if err != nil {
+		failError(err)
+	}
+}
+
+// requestCertificate generates the key and certificate request for submitting to other ca,
+// writes them and runs the hooks
+func requestCertificate(r issueRequest) error {
+	config := r.Config
+	r.Name = requestName(r)
+
+	check := config
+	check.NotAfter = check.NotBefore.Add(selfca.MinValidity)
+
+	err := checkWeak(check, r.AllowWeak)
+	if err == nil {
+		err = checkFIPS(check, r.FIPS)
+	}
+	if err != nil {
+		return &exitError{exitBadInput, "Refused to generate the certificate request", err}
+	}
+
+	if config.KeySize <= 0 {
+		config.KeySize = selfca.DefaultKeySize(config.KeyType)
+	}
+
+	done := progress("Generating %s key for %s", keyName(config.KeyType, config.KeySize), r.Name)
+	csr, key, err := selfca.GenerateCSR(config)
+	done()
+	if err != nil {
+		return &exitError{exitCrypto, "Failed to generate the certificate request", err}
+	}
+
+	name := fmt.Sprintf("%s/%s", r.Output, r.Name)
+	err = selfca.WriteCSR(name, csr, key)
+	if err != nil {
+		return &exitError{exitIO, "Failed to write the certificate request", err}
+	}
+
+	infof("Wrote %s.csr and %s.key, submit %s.csr to the ca for signing", name, name, name)
+
+	err = runHooks(r.Hooks, []string{
+		"SELFCA_CSR_FILE=" + name + ".csr",
+		"SELFCA_KEY_FILE=" + name + ".key",
+	})
+	if err != nil {
+		return &exitError{exitHook, "Failed to run the hook", err}
+	}
+
+	return nil
+}
@@ -0,0 +1,230 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"time"
+
+	"github.com/likexian/gokit/xcron"
+	"github.com/likexian/selfca"
+)
+
+// daemon keeps the certificates declared in config file renewed
+func daemon(args []string) {
+	fs := flag.NewFlagSet("selfca daemon", flag.ExitOnError)
+	path := fs.String("c", "selfca.json", "Path of the config file")
+	addPassphraseFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	c, err := loadConfig(*path)
+	if err != nil {
+		fail(exitBadInput, "Failed to load config file", err)
+	}
+
+	if isService() {
+		*path, err = filepath.Abs(*path)
+		if err == nil {
+			err = os.Chdir(filepath.Dir(*path))
+		}
+		if err == nil {
+			err = runService(serviceName, func(stop <-chan struct{}) { runDaemon(c, *path, stop, nil) })
+		}
+		if err != nil {
+			log.Printf("Failed to run the service: %v", err)
+			os.Exit(exitFailure)
+		}
+		return
+	}
+
+	runDaemon(c, *path, stopSignal(), reloadSignal())
+}
+
+// stopSignal returns a channel closed when interrupted or terminated
+func stopSignal() <-chan struct{} {
+	stop := make(chan struct{})
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, stopSignals...)
+		<-signals
+		close(stop)
+	}()
+
+	return stop
+}
+
+// reloadSignal returns a channel receiving when hung up, never if not supported
+func reloadSignal() <-chan struct{} {
+	reload := make(chan struct{})
+	if len(reloadSignals) == 0 {
+		return reload
+	}
+
+	go func() {
+		signals := make(chan os.Signal, 1)
+		signal.Notify(signals, reloadSignals...)
+		for range signals {
+			reload <- struct{}{}
+		}
+	}()
+
+	return reload
+}
+
+// runDaemon renews the certificates on schedule until stopped,
+// the config file is reloaded on reload without interrupting the running renewal
+func runDaemon(c *config, path string, stop, reload <-chan struct{}) {
+	n := newNotifier(c.Notify)
+	cron, err := scheduleRenewal(c, n)
+	if err != nil {
+		log.Printf("Failed to schedule renewal: %v", err)
+		return
+	}
+
+	_ = sdNotify("READY=1")
+	for {
+		select {
+		case <-stop:
+			_ = sdNotify("STOPPING=1")
+			cron.Empty()
+			cron.Wait()
+			return
+		case <-reload:
+			_ = sdNotify("RELOADING=1")
+			nc, err := loadConfig(path)
+			if err != nil {
+				log.Printf("Failed to reload config file, keep the current: %v", err)
+				_ = sdNotify("READY=1")
+				continue
+			}
+
+			cron.Empty()
+			cron.Wait()
+
+			c = nc
+			n.setConfig(c.Notify)
+			cron, err = scheduleRenewal(c, n)
+			if err != nil {
+				log.Printf("Failed to schedule renewal: %v", err)
+				return
+			}
+
+			log.Printf("Reloaded config file %s", path)
+			_ = sdNotify("READY=1")
+		}
+	}
+}
+
+// scheduleRenewal renews the certificates now and then on schedule
+func scheduleRenewal(c *config, n *notifier) (*xcron.Service, error) {
+	err := os.MkdirAll(c.Output, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	renewAll(c, n)
+
+	cron := xcron.New()
+	_, err = cron.Add(c.Schedule, func() { renewAll(c, n) })
+	if err != nil {
+		return nil, err
+	}
+
+	return cron, nil
+}
+
+// renewAll renews the certificates which are missing or about to expire,
+// regenerates the CRL if configured, and notifies the failures
+func renewAll(c *config, n *notifier) {
+	for i, v := range c.Certificates {
+		debugf("Checking %d/%d %s", i+1, len(c.Certificates), v.Name)
+		issuance, err := renew(c, v)
+		if err != nil {
+			log.Printf("Failed to renew %s: %v", v.Name, err)
+			var notAfter time.Time
+			certificates, e := readCertificates(fmt.Sprintf("%s/%s.crt", c.Output, v.Name))
+			if e == nil {
+				notAfter = certificates[0].NotAfter
+			}
+			n.failed(v.Name, notAfter, err)
+		} else if issuance != nil {
+			n.recovered(v.Name, issuance.Certificate.NotAfter)
+			issuance.Zero()
+		}
+	}
+
+	if c.CRL != nil {
+		nextUpdate, err := renewCRL(resolveCA(c.CA, c.Output), c.Output, c.CRL)
+		if err != nil {
+			log.Printf("Failed to renew ca.crl: %v", err)
+			n.failed("ca.crl", nextUpdate, err)
+		} else {
+			n.recovered("ca.crl", nextUpdate)
+		}
+	}
+}
+
+// renew renews the certificate if it is missing or about to expire,
+// returns nil issuance if it is not renewed
+func renew(c *config, v configCertificate) (*selfca.Issuance, error) {
+	now := time.Now()
+
+	certificates, key, err := selfca.ReadCertificate(fmt.Sprintf("%s/%s", c.Output, v.Name))
+	selfca.ZeroKey(key)
+	if err == nil && now.Add(c.RenewBefore.Duration).Before(certificates[0].NotAfter) {
+		return nil, nil
+	}
+
+	issuance, err := issueCertificate(issueRequest{
+		Output:     c.Output,
+		CA:         c.CA,
+		CATemplate: c.caTemplate,
+		Name:       v.Name,
+		Config: selfca.Certificate{
+			CommonName: v.CommonName,
+			KeySize:    v.Bits,
+			KeyType:    selfca.KeyType(v.KeyType),
+			NotBefore:  now,
+			NotAfter:   now.Add(time.Duration(v.Days*24) * time.Hour),
+			Hosts:      v.Hosts,
+		},
+		AllowWeak:      c.AllowWeak,
+		FIPS:           c.FIPS,
+		StrictValidity: c.StrictValidity,
+		RotateCA:       c.AutoRotateCA,
+		Comments:       c.PEMComments,
+		FullChainRoot:  c.FullChainRoot,
+		Hooks:          append(append([]string{}, c.Hooks...), v.Hooks...),
+		Plugins:        c.Plugins,
+		Escrow:         c.Escrow,
+	})
+	if issuance != nil && !quiet {
+		log.Printf("Renewed %s, valid until %s", v.Name, issuance.Certificate.NotAfter.Format(time.RFC3339))
+	}
+
+	return issuance, err
+}
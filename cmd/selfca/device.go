@@ -0,0 +1,194 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// oidSerialNumber is the OID of serial number subject attribute, the device serial of 802.1AR DevID
+var oidSerialNumber = asn1.ObjectIdentifier{2, 5, 4, 5}
+
+// device issues the Matter device attestation certificate (DAC) of vendor and product ID, signed by
+// the product attestation intermediate (PAI) of the vendor, which is signed by the ca as the product
+// attestation authority (PAA), all of P-256 ECDSA keys, the PAI and DAC have no well-defined expiration
+func device(args []string) {
+	fs := flag.NewFlagSet("selfca device", flag.ExitOnError)
+	vid := fs.String("vid", "", "Vendor ID of the device, 4 hex digits like FFF1")
+	pid := fs.String("pid", "", "Product ID of the device, 4 hex digits like 8000")
+	name := fs.String("n", "", "Common name of the certificate (default Matter Test DAC)")
+	deviceSerial := fs.String("device-serial", "", "Serial number of the device in the subject, like IEEE 802.1AR DevID")
+	days := fs.Int("d", 0, "Valid days of the certificate, 0 for no well-defined expiration (default 0)")
+	output := fs.String("o", "cert", "Folder for saving the certificate, a separate folder keeps the attestation ca "+
+		"apart (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	var usages, hooks stringsFlag
+	fs.Var(&usages, "usage", "Key usages and extended key usages overriding the profile, like clientAuth for 802.1AR "+
+		"DevID, can be repeated")
+	fs.Var(&hooks, "hook", "Command to run after the certificate is issued, can be repeated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	vendorID, err := parseDeviceID(*vid)
+	if err != nil {
+		failUsage(fs, "Invalid vid parameter, must be 4 hex digits like FFF1")
+	}
+
+	productID, err := parseDeviceID(*pid)
+	if err != nil {
+		failUsage(fs, "Invalid pid parameter, must be 4 hex digits like 8000")
+	}
+
+	if *days < 0 {
+		failUsage(fs, "Invalid d parameter")
+	}
+
+	if *caName == "" {
+		*caName = "Matter Test PAA"
+	}
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	// the PAI and DAC never expire, so the PAA neither
+	caConfig.Profile, caConfig.NotAfter = selfca.ProfileDevice, selfca.NoExpiration
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	paaPath := resolveCA(*caFlag, *output)
+	if certificates, err := readCertificates(paaPath + ".crt"); err == nil {
+		k, ok := certificates[0].PublicKey.(*ecdsa.PublicKey)
+		if !ok || k.Curve != elliptic.P256() {
+			fail(exitBadInput, "Refused to use the ca certificate",
+				errors.New("the attestation ca must be P-256 ECDSA, use -o or -ca for a separate ca"))
+		}
+	}
+
+	now := time.Now()
+	paiName := fmt.Sprintf("pai-%04X", vendorID)
+	paiPath := filepath.Join(*output, paiName)
+	if _, err := os.Stat(paiPath + ".crt"); err != nil {
+		_, err = issueCertificate(issueRequest{
+			Output:     *output,
+			CA:         *caFlag,
+			CATemplate: caConfig,
+			Name:       paiName,
+			Config: selfca.Certificate{
+				IsCA:           true,
+				CommonName:     "Matter Test PAI",
+				ExtraSubject:   selfca.MatterAttributes(vendorID, 0),
+				KeyType:        selfca.KeyTypeECDSA,
+				KeySize:        256,
+				NotBefore:      now,
+				NotAfter:       selfca.NoExpiration,
+				Profile:        selfca.ProfileDevice,
+				MaxPathLenZero: true,
+			},
+		})
+		if err != nil {
+			failError(err)
+		}
+		infof("Issued PAI of vendor %04X to %s.crt", vendorID, paiPath)
+	}
+
+	extraSubject := selfca.MatterAttributes(vendorID, productID)
+	if *deviceSerial != "" {
+		extraSubject = append(extraSubject, pkix.AttributeTypeAndValue{Type: oidSerialNumber, Value: *deviceSerial})
+	}
+
+	if *name == "" {
+		*name = "Matter Test DAC"
+	}
+
+	notAfter := selfca.NoExpiration
+	if *days > 0 {
+		notAfter = now.Add(time.Duration(*days*24) * time.Hour)
+	}
+
+	request := issueRequest{
+		Output: *output,
+		CA:     paiPath,
+		Name:   fmt.Sprintf("dac-%04X-%04X", vendorID, productID),
+		Config: selfca.Certificate{
+			CommonName:   *name,
+			ExtraSubject: extraSubject,
+			KeyType:      selfca.KeyTypeECDSA,
+			KeySize:      256,
+			NotBefore:    now,
+			NotAfter:     notAfter,
+			Profile:      selfca.ProfileDevice,
+		},
+		Hooks: hooks,
+	}
+
+	if *deviceSerial != "" {
+		request.Name += "-" + hostFileName(*deviceSerial)
+	}
+
+	err = addUsages(&request.Config, usages)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse usage parameter", err)
+	}
+
+	issuance, err := issueCertificate(request)
+	if err != nil {
+		failError(err)
+	}
+
+	infof("Issued DAC %s, valid until %s", issuance.CertificateFile, issuance.Certificate.NotAfter.Format(time.RFC3339))
+}
+
+// parseDeviceID parses the Matter vendor or product ID of 4 hex digits, which must not be zero
+func parseDeviceID(s string) (uint16, error) {
+	if len(s) != 4 {
+		return 0, strconv.ErrSyntax
+	}
+
+	id, err := strconv.ParseUint(s, 16, 16)
+	if err != nil {
+		return 0, err
+	}
+
+	if id == 0 {
+		return 0, strconv.ErrRange
+	}
+
+	return uint16(id), nil
+}
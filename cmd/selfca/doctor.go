@@ -0,0 +1,285 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// doctorReport is the result of doctor checks
+type doctorReport struct {
+	failed bool
+}
+
+// ok reports the passed check
+func (r *doctorReport) ok(format string, args ...any) {
+	fmt.Printf("[ OK ] %s\n", fmt.Sprintf(format, args...))
+}
+
+// warn reports the check to be noticed, with fix suggestion
+func (r *doctorReport) warn(fix string, format string, args ...any) {
+	fmt.Printf("[WARN] %s\n", fmt.Sprintf(format, args...))
+	if fix != "" {
+		fmt.Printf("       fix: %s\n", fix)
+	}
+}
+
+// fail reports the failed check, with fix suggestion
+func (r *doctorReport) fail(fix string, format string, args ...any) {
+	r.failed = true
+	fmt.Printf("[FAIL] %s\n", fmt.Sprintf(format, args...))
+	if fix != "" {
+		fmt.Printf("       fix: %s\n", fix)
+	}
+}
+
+// doctor checks the local setup of ca and issued certificates, and prints the fixes
+func doctor(args []string) {
+	fs := flag.NewFlagSet("selfca doctor", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the ca and certificates (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	r := &doctorReport{}
+	caPath := resolveCA(*caFlag, *output)
+
+	ca := doctorCA(r, caPath)
+	if ca != nil {
+		doctorClock(r, ca)
+		doctorTrust(r, ca, caPath)
+		doctorCertificates(r, *output, ca)
+	}
+	doctorPermissions(r, *output)
+
+	if r.failed {
+		fail(exitFailure, "Doctor found problems", errors.New("see the fixes above"))
+	}
+}
+
+// doctorCA checks the ca is present, matching its key and not expired
+func doctorCA(r *doctorReport, caPath string) *x509.Certificate {
+	certificates, _, err := readCA(caPath)
+	matched := "key matches"
+	if errors.Is(err, selfca.ErrPassphraseRequired) {
+		certificates, err = readCertificates(caPath + ".crt")
+		matched = "key is encrypted, run with -passphrase-file to check it"
+	}
+
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		r.fail("selfca -h localhost -o "+filepath.Dir(caPath), "ca not found at %s.crt", caPath)
+		return nil
+	case errors.Is(err, selfca.ErrKeyMismatch):
+		r.fail("restore the matching "+caPath+".key, or move the ca away and issue again", "ca key does not match the "+
+			"certificate")
+		return nil
+	case err != nil:
+		r.fail("move the ca away and issue again", "ca is unreadable: %v", err)
+		return nil
+	}
+
+	ca := certificates[0]
+	r.ok("ca found at %s.crt, %s", caPath, matched)
+
+	notAfter := ca.NotAfter.Format(time.RFC3339)
+	switch {
+	case time.Now().After(ca.NotAfter):
+		r.fail("issue with -auto-rotate-ca to create a new ca", "ca expired at %s", notAfter)
+	case time.Until(ca.NotAfter) < caRotateBefore:
+		r.warn("issue with -auto-rotate-ca to create a new ca", "ca expires at %s", notAfter)
+	default:
+		r.ok("ca valid until %s", notAfter)
+	}
+
+	if ca.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		r.warn("run selfca crl -upgrade-ca to re-issue it with the usage", "ca has no CRL signing usage")
+	}
+
+	return ca
+}
+
+// doctorClock checks the clock is sane, the ca created locally is not from the future
+func doctorClock(r *doctorReport, ca *x509.Certificate) {
+	now := time.Now()
+	switch {
+	case now.Year() < 2024:
+		r.fail("sync the clock by NTP", "clock is at %s, which is in the past", now.Format(time.RFC3339))
+	case now.Before(ca.NotBefore):
+		r.fail("sync the clock by NTP", "clock is at %s, before the ca was created at %s",
+			now.Format(time.RFC3339), ca.NotBefore.Format(time.RFC3339))
+	default:
+		r.ok("clock is at %s", now.Format(time.RFC3339))
+	}
+}
+
+// doctorTrust checks the ca is trusted by the system and browsers
+func doctorTrust(r *doctorReport, ca *x509.Certificate, caPath string) {
+	_, err := ca.Verify(x509.VerifyOptions{KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageAny}})
+	if err == nil {
+		r.ok("ca is trusted by the %s system store", runtime.GOOS)
+	} else {
+		r.warn(systemTrustFix(caPath), "ca is not trusted by the %s system store", runtime.GOOS)
+	}
+
+	profiles := firefoxProfiles()
+	if len(profiles) == 0 {
+		return
+	}
+
+	_, err = exec.LookPath("certutil")
+	for _, v := range profiles {
+		fix := fmt.Sprintf(`certutil -A -d sql:%s -t C,, -n selfca -i %s.crt`, v, caPath)
+		if err != nil {
+			r.warn(fix+", certutil is in NSS tools", "firefox profile %s is not checked, certutil not found", v)
+			continue
+		}
+		if firefoxTrusts(v, ca) {
+			r.ok("ca is trusted by firefox profile %s", v)
+		} else {
+			r.warn(fix, "ca is not trusted by firefox profile %s", v)
+		}
+	}
+}
+
+// systemTrustFix returns the command trusting the ca in system store
+func systemTrustFix(caPath string) string {
+	switch runtime.GOOS {
+	case "darwin":
+		return "sudo security add-trusted-cert -d -r trustRoot -k /Library/Keychains/System.keychain " + caPath + ".crt"
+	case "windows":
+		return "certutil -addstore -f Root " + caPath + ".crt"
+	default:
+		return "sudo cp " + caPath + ".crt /usr/local/share/ca-certificates/selfca.crt && sudo update-ca-certificates"
+	}
+}
+
+// firefoxProfiles returns the firefox profile folders having NSS database
+func firefoxProfiles() []string {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return nil
+	}
+
+	patterns := []string{
+		filepath.Join(home, ".mozilla", "firefox", "*", "cert9.db"),
+		filepath.Join(home, "snap", "firefox", "common", ".mozilla", "firefox", "*", "cert9.db"),
+		filepath.Join(home, "Library", "Application Support", "Firefox", "Profiles", "*", "cert9.db"),
+		filepath.Join(home, "AppData", "Roaming", "Mozilla", "Firefox", "Profiles", "*", "cert9.db"),
+	}
+
+	var profiles []string
+	for _, v := range patterns {
+		matches, _ := filepath.Glob(v)
+		for _, m := range matches {
+			profiles = append(profiles, filepath.Dir(m))
+		}
+	}
+
+	return profiles
+}
+
+// firefoxTrusts returns whether the ca is in firefox profile with trust for websites
+func firefoxTrusts(profile string, ca *x509.Certificate) bool {
+	out, err := exec.Command("certutil", "-L", "-d", "sql:"+profile).Output()
+	if err != nil {
+		return false
+	}
+
+	for _, line := range strings.Split(string(out), "\n") {
+		// the last column is the trust of SSL, S/MIME and code signing, like C,,
+		fields := strings.Fields(line)
+		if len(fields) < 2 || !strings.Contains(strings.Split(fields[len(fields)-1], ",")[0], "C") {
+			continue
+		}
+		nickname := strings.TrimSpace(strings.TrimSuffix(strings.TrimSpace(line), fields[len(fields)-1]))
+		der, err := exec.Command("certutil", "-L", "-d", "sql:"+profile, "-n", nickname, "-r").Output()
+		if err == nil && bytes.Equal(der, ca.Raw) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// doctorCertificates checks the issued certificates are signed by the ca and not expired
+func doctorCertificates(r *doctorReport, output string, ca *x509.Certificate) {
+	matches, _ := filepath.Glob(filepath.Join(output, "*.crt"))
+	for _, v := range matches {
+		name := strings.TrimSuffix(v, ".crt")
+		if filepath.Base(name) == "ca" || strings.HasPrefix(filepath.Base(name), "ca.") {
+			continue
+		}
+
+		certificates, _, err := selfca.ReadCertificateWithPassphrase(name, passphrase)
+		if errors.Is(err, fs.ErrNotExist) || errors.Is(err, selfca.ErrPassphraseRequired) {
+			// certificates issued from request have no key
+			certificates, err = readCertificates(v)
+		}
+		if err != nil {
+			r.fail("issue "+filepath.Base(name)+" again", "%s is unreadable: %v", v, err)
+			continue
+		}
+
+		c := certificates[0]
+		switch {
+		case c.CheckSignatureFrom(ca) != nil:
+			r.warn("issue "+filepath.Base(name)+" again", "%s is not signed by the ca", v)
+		case time.Now().After(c.NotAfter):
+			r.warn("issue "+filepath.Base(name)+" again", "%s expired at %s", v, c.NotAfter.Format(time.RFC3339))
+		default:
+			r.ok("%s valid until %s", v, c.NotAfter.Format(time.RFC3339))
+		}
+	}
+}
+
+// doctorPermissions checks the keys are not readable by others
+func doctorPermissions(r *doctorReport, output string) {
+	if runtime.GOOS == "windows" {
+		return
+	}
+
+	matches, _ := filepath.Glob(filepath.Join(output, "*.key"))
+	for _, v := range matches {
+		info, err := os.Stat(v)
+		if err != nil {
+			continue
+		}
+		if info.Mode().Perm()&0077 != 0 {
+			r.fail("chmod 600 "+v, "%s is readable by others, mode %04o", v, info.Mode().Perm())
+		} else {
+			r.ok("%s mode %04o", v, info.Mode().Perm())
+		}
+	}
+}
@@ -0,0 +1,221 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bufio"
+	"crypto/tls"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// echoTimeout is how long the echo connection waits for data
+const echoTimeout = time.Minute
+
+// echoServer runs the TLS echo server requiring client certificates issued by the ca,
+// the server certificate is freshly issued in memory
+func echoServer(args []string) {
+	fs := flag.NewFlagSet("selfca echo-server", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the ca (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	hosts := fs.String("h", "localhost,127.0.0.1", "Domains or IPs of the server certificate, comma separated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca echo-server [address] [options]\n")
+		fs.PrintDefaults()
+	}
+
+	addrs := parseArgs(fs, args)
+	if len(addrs) > 1 {
+		failUsage(fs, "Too many addresses")
+	}
+
+	addr := "localhost:4433"
+	if len(addrs) == 1 {
+		addr = addrs[0]
+	}
+
+	names, err := parseHosts(*hosts)
+	if err != nil || len(names) == 0 {
+		failUsage(fs, "Invalid hosts parameter")
+	}
+
+	ca, certificate := issueEcho(resolveCA(*caFlag, *output), selfca.Certificate{Hosts: names})
+	config := &tls.Config{
+		Certificates: []tls.Certificate{certificate},
+		ClientAuth:   tls.RequireAndVerifyClientCert,
+		ClientCAs:    ca.CertPool(),
+	}
+
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		fail(exitIO, "Failed to listen on "+addr, err)
+	}
+
+	infof("Listening on %s as %s", l.Addr(), strings.Join(names, ", "))
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				infof("Failed to accept on %s: %v", l.Addr(), err)
+				return
+			}
+			go serveEcho(tls.Server(conn, config))
+		}
+	}()
+
+	<-stopSignal()
+}
+
+// serveEcho handshakes with the client, logs the result and echoes the data back
+func serveEcho(conn *tls.Conn) {
+	defer conn.Close()
+
+	_ = conn.SetDeadline(time.Now().Add(echoTimeout))
+	err := conn.Handshake()
+	if err != nil {
+		infof("Handshake from %s failed: %v", conn.RemoteAddr(), err)
+		return
+	}
+
+	state := conn.ConnectionState()
+	infof("Handshake from %s, client %q, %s, %s", conn.RemoteAddr(), state.PeerCertificates[0].Subject.CommonName,
+		tls.VersionName(state.Version), tls.CipherSuiteName(state.CipherSuite))
+
+	n, err := io.Copy(conn, conn)
+	if err != nil {
+		infof("Echo to %s failed after %d bytes: %v", conn.RemoteAddr(), n, err)
+		return
+	}
+
+	debugf("Echoed %d bytes to %s", n, conn.RemoteAddr())
+}
+
+// echoClient connects to the echo server with a freshly issued client certificate,
+// verifies the server against the ca and checks the message is echoed back
+func echoClient(args []string) {
+	fs := flag.NewFlagSet("selfca echo-client", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the ca (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	name := fs.String("n", "selfca echo-client", "Common name of the client certificate")
+	serverName := fs.String("servername", "", "Server name for SNI and verification (default the host)")
+	message := fs.String("m", "hello from selfca", "Message to send and expect echoed back")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of connecting, handshake and echo")
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca echo-client [host:port] [options]\n")
+		fs.PrintDefaults()
+	}
+
+	addrs := parseArgs(fs, args)
+	if len(addrs) > 1 {
+		failUsage(fs, "Too many addresses")
+	}
+
+	addr := "localhost:4433"
+	if len(addrs) == 1 {
+		addr = addrs[0]
+	}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		failUsage(fs, "Invalid server address, expected host:port")
+	}
+
+	if *serverName == "" {
+		*serverName = host
+	}
+
+	if strings.ContainsAny(*message, "\r\n") {
+		failUsage(fs, "Message must be one line")
+	}
+
+	ca, certificate := issueEcho(resolveCA(*caFlag, *output), selfca.Certificate{CommonName: *name})
+	config := &tls.Config{
+		ServerName:   *serverName,
+		RootCAs:      ca.CertPool(),
+		Certificates: []tls.Certificate{certificate},
+	}
+
+	started := time.Now()
+	dialer := &net.Dialer{Timeout: *timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, config)
+	if err != nil {
+		fail(exitCrypto, "Failed to handshake with "+addr, err)
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	fmt.Printf("Connected to %s (%s)\n", addr, conn.RemoteAddr())
+	fmt.Printf("Protocol: %s\n", tls.VersionName(state.Version))
+	fmt.Printf("Cipher suite: %s\n", tls.CipherSuiteName(state.CipherSuite))
+	fmt.Printf("Server certificate: %s, verified for %s\n", state.PeerCertificates[0].Subject, *serverName)
+	fmt.Printf("Client certificate: %s\n", certificate.Leaf.Subject)
+
+	// the server verifies the client certificate after the TLS 1.3 handshake,
+	// so the rejection is only seen while echoing
+	_ = conn.SetDeadline(time.Now().Add(*timeout))
+	_, err = fmt.Fprintf(conn, "%s\n", *message)
+	if err != nil {
+		fail(exitFailure, "Failed to send the message", err)
+	}
+
+	echoed, err := bufio.NewReader(conn).ReadString('\n')
+	if err != nil {
+		fail(exitFailure, "Failed to read the echo, the server may have refused the client certificate", err)
+	}
+
+	if strings.TrimSuffix(echoed, "\n") != *message {
+		fail(exitFailure, fmt.Sprintf("Echo mismatch: got %q", echoed), nil)
+	}
+
+	fmt.Printf("Echo: OK, %d bytes in %s\n", len(echoed), time.Since(started).Round(time.Millisecond))
+}
+
+// issueEcho loads the ca of path and issues the short-lived certificate in memory
+func issueEcho(path string, c selfca.Certificate) (*selfca.CA, tls.Certificate) {
+	certificates, key, err := readCA(path)
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to load ca certificate", err)
+	}
+
+	ca := &selfca.CA{Certificate: certificates[0], Key: key}
+	c.KeyType, _ = selfca.KeyTypeOf(key.Public())
+	c.KeySize = selfca.DefaultKeySize(c.KeyType)
+
+	issuance, err := ca.Issue(c)
+	if err != nil {
+		fail(exitCrypto, "Failed to issue the certificate", err)
+	}
+
+	// only the ca certificate is needed after issued
+	ca.Close()
+
+	return ca, issuance.TLSCertificate()
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"github.com/likexian/selfca"
+)
+
+// readEscrowKey reads the RSA escrow public key from the PEM certificate or public key file
+func readEscrowKey(path string) (crypto.PublicKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("no PEM certificate or public key in %s", path)
+	}
+
+	var key crypto.PublicKey
+	switch block.Type {
+	case "CERTIFICATE":
+		var c *x509.Certificate
+		c, err = x509.ParseCertificate(block.Bytes)
+		if err == nil {
+			key = c.PublicKey
+		}
+	case "PUBLIC KEY":
+		key, err = x509.ParsePKIXPublicKey(block.Bytes)
+	case "RSA PUBLIC KEY":
+		key, err = x509.ParsePKCS1PublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("unsupported PEM type %s in %s", block.Type, path)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if keyType, _ := selfca.KeyTypeOf(key); keyType != selfca.KeyTypeRSA {
+		return nil, selfca.ErrUnsupportedEscrowKey
+	}
+
+	return key, nil
+}
+
+// writeEscrow writes the key of issuance encrypted to the escrow key at path to name.key.escrow
+func writeEscrow(name string, issuance *selfca.Issuance, path string) error {
+	escrow, err := readEscrowKey(path)
+	if err != nil {
+		return err
+	}
+
+	block, err := selfca.EscrowPrivateKey(issuance.Key, escrow)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(name+".key.escrow", pem.EncodeToMemory(block), 0600)
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+
+	"github.com/likexian/selfca"
+)
+
+// Exit codes of the failure classes, they are stable for automation
+const (
+	exitFailure   = 1
+	exitBadInput  = 2
+	exitCAMissing = 3
+	exitIO        = 4
+	exitCrypto    = 5
+	exitHook      = 6
+)
+
+// exitNames is the names of exit codes used in json errors
+var exitNames = map[int]string{
+	exitFailure:   "failure",
+	exitBadInput:  "bad_input",
+	exitCAMissing: "ca_missing",
+	exitIO:        "io",
+	exitCrypto:    "crypto",
+	exitHook:      "hook",
+}
+
+// errorJSON is whether to print errors as json
+var errorJSON bool
+
+// exitError is the error with exit code and message for failing
+type exitError struct {
+	code    int
+	message string
+	err     error
+}
+
+// Error returns the error message
+func (e *exitError) Error() string {
+	return fmt.Sprintf("%s: %v", e.message, e.err)
+}
+
+// Unwrap returns the underlying error
+func (e *exitError) Unwrap() error {
+	return e.err
+}
+
+// jsonError is the structured error printed in json
+type jsonError struct {
+	Error    string `json:"error"`
+	ExitCode int    `json:"exit_code"`
+	Message  string `json:"message"`
+	Detail   string `json:"detail,omitempty"`
+}
+
+// addErrorFlag adds the flag for printing errors as json
+func addErrorFlag(fs *flag.FlagSet) {
+	fs.BoolVar(&errorJSON, "error-json", false, "Print errors as JSON to stderr")
+}
+
+// fail prints the error to stderr and exits with code
+func fail(code int, message string, err error) {
+	if errorJSON {
+		e := jsonError{
+			Error:    exitNames[code],
+			ExitCode: code,
+			Message:  message,
+		}
+		if err != nil {
+			e.Detail = err.Error()
+		}
+		_ = json.NewEncoder(os.Stderr).Encode(e)
+	} else if err != nil {
+		fmt.Fprintf(os.Stderr, "%s: %v\n", message, err)
+	} else {
+		fmt.Fprintln(os.Stderr, message)
+	}
+
+	os.Exit(code)
+}
+
+// failError prints the error and exits with the code of exit error
+func failError(err error) {
+	var e *exitError
+	if errors.As(err, &e) {
+		fail(e.code, e.message, e.err)
+	}
+
+	fail(exitFailure, "Failed", err)
+}
+
+// failUsage prints the usage, or the json error, and exits as bad input
+func failUsage(fs *flag.FlagSet, message string) {
+	if !errorJSON {
+		fs.Usage()
+	}
+
+	fail(exitBadInput, message, nil)
+}
+
+// errorCode returns the exit code of error from loading files,
+// missing is the exit code if the file does not exist
+func errorCode(err error, missing int) int {
+	var pathErr *fs.PathError
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		return missing
+	case errors.Is(err, selfca.ErrCAExpired):
+		return exitCAMissing
+	case errors.Is(err, selfca.ErrPassphraseRequired), errors.Is(err, selfca.ErrIncorrectPassphrase),
+		errors.Is(err, selfca.ErrPathLenExceeded), errors.Is(err, selfca.ErrNameNotPermitted),
+		errors.Is(err, selfca.ErrNameNotAllowed), errors.Is(err, selfca.ErrSerialNotFound),
+		errors.Is(err, selfca.ErrInvalidURI),
+		errors.Is(err, selfca.ErrUnsupportedEscrowKey):
+		return exitBadInput
+	case errors.As(err, &pathErr):
+		return exitIO
+	default:
+		return exitCrypto
+	}
+}
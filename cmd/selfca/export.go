@@ -0,0 +1,261 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/likexian/selfca"
+)
+
+// caddyIssuer is the default issuer key of caddy storage, the Let's Encrypt production directory
+const caddyIssuer = "acme-v02.api.letsencrypt.org-directory"
+
+// caddyUnsafe is the characters removed from caddy storage keys
+var caddyUnsafe = regexp.MustCompile(`[^\w@.-]`)
+
+// traefikCertificate is the certificate stored in traefik acme.json
+type traefikCertificate struct {
+	Domain      traefikDomain `json:"domain"`
+	Certificate []byte        `json:"certificate"`
+	Key         []byte        `json:"key"`
+	Store       string        `json:"Store"`
+}
+
+// traefikDomain is the domains of certificate stored in traefik acme.json
+type traefikDomain struct {
+	Main string   `json:"main"`
+	SANs []string `json:"sans,omitempty"`
+}
+
+// export writes the issued certificate into the storage of caddy or traefik,
+// so that it is used as if obtained by ACME
+func export(args []string) {
+	fs := flag.NewFlagSet("selfca export", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the certificate (default cert)")
+	storage := fs.String("storage", "", "Caddy storage folder, like /var/lib/caddy/.local/share/caddy")
+	issuer := fs.String("issuer", caddyIssuer, "Caddy issuer key the certificate is stored under")
+	acme := fs.String("acme", "", "Traefik acme.json file, created if not exists")
+	resolver := fs.String("resolver", "default", "Traefik certificate resolver the certificate is stored under")
+	p12Password := fs.String("p12-password", "", "Password of the PKCS #12 file")
+	p12Legacy := fs.Bool("p12-legacy", false, "Encrypt the PKCS #12 file by the legacy 3DES, for older Windows, Outlook "+
+		"and macOS Keychain")
+	jksPassword := fs.String("jks-password", "changeit", "Password of the Java KeyStore")
+	addOutputFlags(fs)
+	addPassphraseFlags(fs)
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca export caddy|traefik|der|p12|jks|pub <name> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	names := parseArgs(fs, args)
+	if len(names) != 2 {
+		failUsage(fs, "Missing export target or certificate name")
+	}
+
+	certificates, key, err := selfca.ReadCertificateWithPassphrase(fmt.Sprintf("%s/%s", *output, names[1]), passphrase)
+	if err != nil {
+		fail(errorCode(err, exitBadInput), "Failed to load the certificate", err)
+	}
+
+	// only the acme storages are keyed by hosts, the ca without hosts is exported in other forms
+	hosts := certificateHosts(certificates[0])
+	if len(hosts) == 0 && (names[0] == "caddy" || names[0] == "traefik") {
+		fail(exitBadInput, "Failed to export the certificate: no hosts", nil)
+	}
+
+	var path string
+	switch names[0] {
+	case "caddy":
+		if *storage == "" {
+			failUsage(fs, "Missing storage parameter")
+		}
+		path, err = exportCaddy(*storage, *issuer, hosts, certificates, key)
+	case "traefik":
+		if *acme == "" {
+			failUsage(fs, "Missing acme parameter")
+		}
+		path, err = *acme, exportTraefik(*acme, *resolver, hosts, certificates, key)
+	case "der":
+		path = fmt.Sprintf("%s/%s.der", *output, names[1])
+		err = selfca.WriteCertificateDER(strings.TrimSuffix(path, ".der"), certificates[0].Raw, key)
+	case "p12":
+		path = fmt.Sprintf("%s/%s.p12", *output, names[1])
+		if *p12Legacy {
+			err = selfca.WriteLegacyPKCS12(strings.TrimSuffix(path, ".p12"), certificates, key, *p12Password)
+		} else {
+			err = selfca.WritePKCS12(strings.TrimSuffix(path, ".p12"), certificates, key, *p12Password)
+		}
+	case "pub":
+		path = fmt.Sprintf("%s/%s.pub", *output, names[1])
+		err = selfca.WritePublicKey(strings.TrimSuffix(path, ".pub"), certificates[0].PublicKey)
+	case "jks":
+		path = fmt.Sprintf("%s/%s.jks", *output, names[1])
+		err = selfca.WriteJKS(strings.TrimSuffix(path, ".jks"), certificates, key, *jksPassword)
+	default:
+		failUsage(fs, "Unknown export target")
+	}
+
+	if err != nil {
+		fail(errorCode(err, exitIO), "Failed to export the certificate", err)
+	}
+
+	infof("Exported %s to %s", names[1], path)
+}
+
+// exportCaddy writes the certificate, key and metadata in caddy storage layout,
+// returns the folder of the certificate
+func exportCaddy(storage, issuer string, hosts []string,
+	certificates []*x509.Certificate, key crypto.Signer) (string, error) {
+	block, err := selfca.MarshalPrivateKey(key)
+	if err != nil {
+		return "", err
+	}
+
+	name := caddyKey(hosts[0])
+	dir := filepath.Join(storage, "certificates", caddyKey(issuer), name)
+	err = os.MkdirAll(dir, 0700)
+	if err != nil {
+		return "", err
+	}
+
+	meta, err := json.MarshalIndent(map[string]any{
+		"sans":        hosts,
+		"issuer_data": map[string]string{"ca": "selfca"},
+	}, "", "\t")
+	if err != nil {
+		return "", err
+	}
+
+	files := []struct {
+		ext  string
+		data []byte
+	}{
+		{".crt", chainedPEM(certificates[0], certificates[1:])},
+		{".key", pem.EncodeToMemory(block)},
+		{".json", meta},
+	}
+
+	for _, v := range files {
+		err = os.WriteFile(filepath.Join(dir, name+v.ext), v.data, 0600)
+		if err != nil {
+			return "", err
+		}
+	}
+
+	return dir, nil
+}
+
+// caddyKey returns the name safe as caddy storage key, like wildcard_.example.com for *.example.com
+func caddyKey(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	s = strings.NewReplacer(" ", "_", "+", "_plus_", "*", "wildcard_", ":", "-", "..", "").Replace(s)
+
+	return caddyUnsafe.ReplaceAllLiteralString(s, "")
+}
+
+// exportTraefik adds the certificate to the resolver in traefik acme.json, replacing the one
+// with the same main domain, the other content of the file is kept
+func exportTraefik(path, resolver string, hosts []string, certificates []*x509.Certificate, key crypto.Signer) error {
+	block, err := selfca.MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	root := map[string]json.RawMessage{}
+	data, err := os.ReadFile(path)
+	if err != nil && !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+
+	if len(data) > 0 {
+		err = json.Unmarshal(data, &root)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	store := map[string]json.RawMessage{}
+	if v, ok := root[resolver]; ok && string(v) != "null" {
+		err = json.Unmarshal(v, &store)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	var list []traefikCertificate
+	if v, ok := store["Certificates"]; ok && string(v) != "null" {
+		err = json.Unmarshal(v, &list)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+	}
+
+	entry := traefikCertificate{
+		Domain:      traefikDomain{Main: hosts[0], SANs: hosts[1:]},
+		Certificate: chainedPEM(certificates[0], certificates[1:]),
+		Key:         pem.EncodeToMemory(block),
+		Store:       "default",
+	}
+
+	replaced := false
+	for i, v := range list {
+		if v.Domain.Main == entry.Domain.Main {
+			list[i], replaced = entry, true
+		}
+	}
+
+	if !replaced {
+		list = append(list, entry)
+	}
+
+	if store["Certificates"], err = json.Marshal(list); err != nil {
+		return err
+	}
+
+	if root[resolver], err = json.Marshal(store); err != nil {
+		return err
+	}
+
+	data, err = json.MarshalIndent(root, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	err = os.WriteFile(path, append(data, '\n'), 0600)
+	if err != nil {
+		return err
+	}
+
+	// traefik refuses to use acme.json readable by others
+	return os.Chmod(path, 0600)
+}
@@ -0,0 +1,72 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// hookEnv returns the environment variables describing the issued certificate
+func hookEnv(i *selfca.Issuance, caPath string) []string {
+	return []string{
+		"SELFCA_CERT_FILE=" + i.CertificateFile,
+		"SELFCA_KEY_FILE=" + i.KeyFile,
+		"SELFCA_CA_FILE=" + caPath + ".crt",
+		"SELFCA_COMMON_NAME=" + i.Certificate.Subject.CommonName,
+		"SELFCA_HOSTS=" + strings.Join(certificateHosts(i.Certificate), ","),
+		"SELFCA_SERIAL=" + i.Certificate.SerialNumber.Text(16),
+		"SELFCA_NOT_AFTER=" + i.Certificate.NotAfter.UTC().Format(time.RFC3339),
+		"SELFCA_SHA256_FINGERPRINT=" + i.SHA256Fingerprint,
+	}
+}
+
+// runHooks runs the hook commands one by one with the shell
+func runHooks(hooks []string, env []string) error {
+	for _, v := range hooks {
+		debugf("Running hook %s", v)
+		cmd := shellCommand(context.Background(), v)
+		cmd.Env = append(os.Environ(), env...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+
+		if err := cmd.Run(); err != nil {
+			return fmt.Errorf("%s: %w", v, err)
+		}
+	}
+
+	return nil
+}
+
+// shellCommand returns the command run by the shell of system
+func shellCommand(ctx context.Context, command string) *exec.Cmd {
+	if runtime.GOOS == "windows" {
+		return exec.CommandContext(ctx, "cmd", "/C", command)
+	}
+
+	return exec.CommandContext(ctx, "/bin/sh", "-c", command)
+}
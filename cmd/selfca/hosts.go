@@ -0,0 +1,315 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strings"
+
+	"github.com/likexian/selfca"
+)
+
+// maxCIDRHosts is the max number of IPs a CIDR host can expand into
+const maxCIDRHosts = 256
+
+// parseHosts parses comma separated hosts, reads the hosts from file
+// if prefixed with @ or from stdin if is -, and expands the CIDR hosts
+func parseHosts(s string) ([]string, error) {
+	var hosts []string
+	for _, v := range strings.Split(s, ",") {
+		v = strings.TrimSpace(v)
+		switch {
+		case v == "-":
+			ls, err := readHosts(os.Stdin)
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, ls...)
+		case strings.HasPrefix(v, "@"):
+			ls, err := readHostsFile(v[1:])
+			if err != nil {
+				return nil, err
+			}
+			hosts = append(hosts, ls...)
+		case v != "":
+			hosts = append(hosts, v)
+		}
+	}
+
+	return expandHosts(hosts)
+}
+
+// hostGroup is the hosts of a certificate in group issuance
+type hostGroup struct {
+	name  string
+	hosts []string
+}
+
+// parseGroups parses the groups formatted as name=hosts or hosts,
+// the name defaults to the first host
+func parseGroups(groups []string) ([]hostGroup, error) {
+	var result []hostGroup
+	for _, v := range groups {
+		var name string
+		if i := strings.Index(v, "="); i >= 0 && !strings.ContainsAny(v[:i], ",@") {
+			name, v = strings.TrimSpace(v[:i]), v[i+1:]
+		}
+
+		hosts, err := parseHosts(v)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(hosts) == 0 {
+			return nil, fmt.Errorf("group %s has no hosts", name)
+		}
+
+		if name == "" {
+			name = hostFileName(hosts[0])
+		}
+
+		result = append(result, hostGroup{name: name, hosts: hosts})
+	}
+
+	return result, nil
+}
+
+// readHostsFile reads hosts from file
+func readHostsFile(path string) ([]string, error) {
+	fd, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+
+	defer fd.Close()
+
+	return readHosts(fd)
+}
+
+// readHosts reads hosts from reader, one host per line,
+// the empty lines and comments starting with # are ignored
+func readHosts(r io.Reader) ([]string, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+
+	var hosts []string
+	for _, v := range strings.Split(string(data), "\n") {
+		if i := strings.Index(v, "#"); i >= 0 {
+			v = v[:i]
+		}
+		for _, vv := range strings.Split(v, ",") {
+			vv = strings.TrimSpace(vv)
+			if vv != "" {
+				hosts = append(hosts, vv)
+			}
+		}
+	}
+
+	return hosts, nil
+}
+
+// expandHosts expands the CIDR hosts into individual IPs
+func expandHosts(hosts []string) ([]string, error) {
+	var result []string
+	for _, v := range hosts {
+		if !strings.Contains(v, "/") || selfca.IsURI(v) {
+			result = append(result, v)
+			continue
+		}
+
+		ips, err := expandCIDR(v)
+		if err != nil {
+			return nil, err
+		}
+
+		result = append(result, ips...)
+	}
+
+	return result, nil
+}
+
+// expandCIDR returns all IPs in the CIDR
+func expandCIDR(s string) ([]string, error) {
+	ip, ipNet, err := net.ParseCIDR(s)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if bits-ones > 30 || 1<<(bits-ones) > maxCIDRHosts {
+		return nil, fmt.Errorf("%s has more than %d IPs", s, maxCIDRHosts)
+	}
+
+	ip = ip.Mask(ipNet.Mask)
+	ips := make([]string, 0, 1<<(bits-ones))
+	for ; ipNet.Contains(ip); ip = nextIP(ip) {
+		ips = append(ips, ip.String())
+	}
+
+	return ips, nil
+}
+
+// nextIP returns the IP next to ip
+func nextIP(ip net.IP) net.IP {
+	next := make(net.IP, len(ip))
+	copy(next, ip)
+	for i := len(next) - 1; i >= 0; i-- {
+		next[i]++
+		if next[i] != 0 {
+			break
+		}
+	}
+
+	return next
+}
+
+// certificateHosts returns the domains, IPs, URIs and emails of certificate
+func certificateHosts(c *x509.Certificate) []string {
+	hosts := append([]string{}, c.DNSNames...)
+	for _, v := range c.IPAddresses {
+		hosts = append(hosts, v.String())
+	}
+
+	for _, v := range c.URIs {
+		hosts = append(hosts, v.String())
+	}
+
+	hosts = append(hosts, c.EmailAddresses...)
+
+	return hosts
+}
+
+// editHosts returns the hosts with added and removed hosts applied
+func editHosts(hosts, added, removed []string) []string {
+	skip := map[string]bool{}
+	for _, v := range removed {
+		skip[normalizeHost(v)] = true
+	}
+
+	var result []string
+	for _, v := range append(hosts, added...) {
+		k := normalizeHost(v)
+		if !skip[k] {
+			result = append(result, v)
+			skip[k] = true
+		}
+	}
+
+	return result
+}
+
+// pairedHosts returns the wildcard of each domain and the apex of each wildcard,
+// the IPs, emails and single label names are not paired
+func pairedHosts(hosts []string) []string {
+	var result []string
+	for _, v := range hosts {
+		if net.ParseIP(v) != nil || strings.Contains(v, "@") || selfca.IsURI(v) {
+			continue
+		}
+
+		if apex, ok := strings.CutPrefix(v, "*."); ok {
+			if strings.Contains(apex, ".") {
+				result = append(result, apex)
+			}
+			continue
+		}
+
+		if strings.Contains(v, ".") && !strings.Contains(v, "*") {
+			result = append(result, "*."+v)
+		}
+	}
+
+	return result
+}
+
+// devHosts returns the hosts of local development, localhost and loopback IPs,
+// and the hostname and LAN IP of the machine if found
+func devHosts() []string {
+	hosts := []string{"localhost", "127.0.0.1", "::1"}
+	if name, err := os.Hostname(); err == nil && name != "" {
+		hosts = append(hosts, strings.ToLower(name))
+	}
+
+	if ip := lanIP(); ip != nil {
+		hosts = append(hosts, ip.String())
+	}
+
+	return hosts
+}
+
+// lanIP returns the IP of the machine on LAN, the source IP routing to the internet,
+// or the first private IP of interfaces if there is no route
+func lanIP() net.IP {
+	// dialing udp sends no packets, it only chooses the route
+	conn, err := net.Dial("udp", "8.8.8.8:53")
+	if err == nil {
+		defer conn.Close()
+		if addr, ok := conn.LocalAddr().(*net.UDPAddr); ok && !addr.IP.IsLoopback() {
+			return addr.IP
+		}
+	}
+
+	addrs, err := net.InterfaceAddrs()
+	if err != nil {
+		return nil
+	}
+
+	for _, v := range addrs {
+		if ipNet, ok := v.(*net.IPNet); ok && ipNet.IP.IsPrivate() {
+			return ipNet.IP
+		}
+	}
+
+	return nil
+}
+
+// isEmail returns whether the host is an email address, the same as classified in certificate
+func isEmail(s string) bool {
+	return net.ParseIP(s) == nil && !selfca.IsURI(s) && strings.Contains(s, "@")
+}
+
+// normalizeHost returns the host in comparable form
+func normalizeHost(s string) string {
+	if ip := net.ParseIP(s); ip != nil {
+		return ip.String()
+	}
+
+	if selfca.IsURI(s) {
+		return s
+	}
+
+	return strings.ToLower(s)
+}
+
+// hostFileName returns the host usable as file name, the URI like spiffe://example.org/web
+// is flattened to spiffe_example.org_web
+func hostFileName(s string) string {
+	if !selfca.IsURI(s) {
+		return s
+	}
+
+	return strings.NewReplacer("://", "_", "/", "_", ":", "_", "?", "_", "#", "_").Replace(strings.TrimRight(s, "/"))
+}
@@ -0,0 +1,124 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestExpandHosts(t *testing.T) {
+	tests := []struct {
+		in  []string
+		out []string
+		err string
+	}{
+		{nil, nil, ""},
+		{[]string{"likexian.com", "10.0.0.1"}, []string{"likexian.com", "10.0.0.1"}, ""},
+		{[]string{"10.0.0.0/30"}, []string{"10.0.0.0", "10.0.0.1", "10.0.0.2", "10.0.0.3"}, ""},
+		{[]string{"10.0.0.5/30"}, []string{"10.0.0.4", "10.0.0.5", "10.0.0.6", "10.0.0.7"}, ""},
+		{[]string{"10.0.0.1/32"}, []string{"10.0.0.1"}, ""},
+		{[]string{"10.0.0.255/31"}, []string{"10.0.0.254", "10.0.0.255"}, ""},
+		{[]string{"fd00::/127"}, []string{"fd00::", "fd00::1"}, ""},
+		{
+			[]string{"likexian.com", "192.168.1.0/31", "*.likexian.com", "::1"},
+			[]string{"likexian.com", "192.168.1.0", "192.168.1.1", "*.likexian.com", "::1"},
+			"",
+		},
+		{[]string{"spiffe://likexian.com/web"}, []string{"spiffe://likexian.com/web"}, ""},
+		{[]string{"10.0.0.0/23"}, nil, "has more than 256 IPs"},
+		{[]string{"10.0.0.0/0"}, nil, "has more than 256 IPs"},
+		{[]string{"fd00::/64"}, nil, "has more than 256 IPs"},
+		{[]string{"likexian.com/24"}, nil, "invalid CIDR address"},
+	}
+
+	for _, v := range tests {
+		out, err := expandHosts(v.in)
+		if v.err != "" {
+			assert.NotNil(t, err, v.in)
+			if err != nil {
+				assert.Contains(t, err.Error(), v.err)
+			}
+			continue
+		}
+
+		assert.Nil(t, err, v.in)
+		if v.out != nil {
+			assert.Equal(t, out, v.out, v.in)
+		}
+	}
+
+	out, err := expandHosts([]string{"10.0.0.0/24", "likexian.com"})
+	assert.Nil(t, err)
+	assert.Equal(t, len(out), maxCIDRHosts+1)
+	assert.Equal(t, out[0], "10.0.0.0")
+	assert.Equal(t, out[255], "10.0.0.255")
+	assert.Equal(t, out[256], "likexian.com")
+}
+
+func TestReadHostsFile(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "hosts.txt")
+	data := "# the web hosts\nlikexian.com\n\n  www.likexian.com  # the www\n\t\napi.likexian.com,10.0.0.1\n#10.0.0.2\n"
+	assert.Nil(t, os.WriteFile(path, []byte(data), 0600))
+
+	hosts, err := readHostsFile(path)
+	assert.Nil(t, err)
+	assert.Equal(t, hosts, []string{"likexian.com", "www.likexian.com", "api.likexian.com", "10.0.0.1"})
+
+	hosts, err = parseHosts("@" + path + ",10.0.1.0/31")
+	assert.Nil(t, err)
+	assert.Equal(t, hosts, []string{"likexian.com", "www.likexian.com", "api.likexian.com", "10.0.0.1",
+		"10.0.1.0", "10.0.1.1"})
+
+	empty := filepath.Join(dir, "empty.txt")
+	assert.Nil(t, os.WriteFile(empty, []byte("\n# nothing\n\n"), 0600))
+	hosts, err = readHostsFile(empty)
+	assert.Nil(t, err)
+	assert.Equal(t, len(hosts), 0)
+
+	_, err = readHostsFile(filepath.Join(dir, "missing.txt"))
+	assert.True(t, os.IsNotExist(err))
+
+	_, err = parseHosts("likexian.com,@" + filepath.Join(dir, "missing.txt"))
+	assert.True(t, os.IsNotExist(err))
+}
+
+func TestParseHostsStdin(t *testing.T) {
+	r, w, err := os.Pipe()
+	assert.Nil(t, err)
+
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	go func() {
+		_, _ = w.WriteString("# from stdin\nlikexian.com\n\nwww.likexian.com,10.0.0.0/31\n")
+		_ = w.Close()
+	}()
+
+	hosts, err := parseHosts("api.likexian.com, -")
+	assert.Nil(t, err)
+	assert.Equal(t, hosts, []string{"api.likexian.com", "likexian.com", "www.likexian.com", "10.0.0.0", "10.0.0.1"})
+	assert.Nil(t, r.Close())
+}
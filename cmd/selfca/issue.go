@@ -0,0 +1,992 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// ctTimeout is the timeout of submitting to ct logs
+const ctTimeout = 30 * time.Second
+
+// issueRequest is the request of issuing certificate by the ca in output folder
+type issueRequest struct {
+	// Output is the folder of the ca and the certificate
+	Output string
+	// CA is the path of the ca, default ca in the output folder
+	CA string
+	// CATemplate is the subject of the ca created if not exists
+	CATemplate selfca.Certificate
+	// Name is the file name of the certificate, default the first host
+	Name string
+	// Config is the certificate config, the ca is loaded from output
+	Config selfca.Certificate
+	// AllowWeak only warns about the weak parameters
+	AllowWeak bool
+	// FIPS refuses the parameters not FIPS approved
+	FIPS bool
+	// ShortLived allows validity shorter than the weak threshold
+	ShortLived bool
+	// StrictValidity refuses the validity outside of the ca validity instead of clamping it
+	StrictValidity bool
+	// RotateCA replaces the expired or expiring ca by a new ca
+	RotateCA bool
+	// Comments writes the informational comments above the certificate PEM block
+	Comments bool
+	// EncryptKey encrypts the key of the certificate with the passphrase
+	EncryptKey bool
+	// FullChainRoot includes the root ca in the fullchain file
+	FullChainRoot bool
+	// Bundle writes the certificate, chain and key to a single PEM file
+	Bundle bool
+	// DER writes the certificate and key in DER form
+	DER bool
+	// PublicKey writes the public key in PKIX PEM and OpenSSH form
+	PublicKey bool
+	// PKCS12 writes the PKCS #12 file protected by PKCS12Password
+	PKCS12 bool
+	// PKCS12Password is the password of PKCS #12 file
+	PKCS12Password string
+	// PKCS12Legacy encrypts the PKCS #12 file by the legacy 3DES instead of AES
+	PKCS12Legacy bool
+	// JKS writes the Java KeyStore and truststore protected by JKSPassword
+	JKS bool
+	// JKSPassword is the password of Java KeyStore and truststore
+	JKSPassword string
+	// CTLogs is the certificate transparency logs to submit to
+	CTLogs []string
+	// Server is the web server to write the files for, the config is printed
+	Server string
+	// Hooks is the commands to run after issued
+	Hooks []string
+	// Plugins is the commands to run at the plugin points, with json in stdin and out stdout
+	Plugins []string
+	// Escrow is the file of RSA escrow certificate or public key, the key is also written encrypted to it
+	Escrow string
+}
+
+// formats is the output formats supported by -f, the pem is always written
+var formats = []string{"pem", "der", "p12", "jks", "pub"}
+
+// profiles is the certificate profiles supported by -profile
+var profiles = []selfca.Profile{
+	selfca.ProfileServer,
+	selfca.ProfileClient,
+	selfca.ProfileCodeSigning,
+	selfca.ProfileEmail,
+	selfca.ProfileSmartCard,
+	selfca.ProfileIPsec,
+	selfca.ProfileDevice,
+}
+
+// keyTypes is the supported key types
+var keyTypes = []selfca.KeyType{
+	selfca.KeyTypeRSA,
+	selfca.KeyTypeECDSA,
+	selfca.KeyTypeEd25519,
+}
+
+// issueFlags is the parameters of the issue command
+type issueFlags struct {
+	name, subject, host, keyType, start, output, profile, escrow, format                          string
+	p12Password, serial, password, server                                                         string
+	bits, days, pathLen, caPathLen                                                                int
+	withWildcard, dev, version, weak, fips, strict, rotate, bundle                                bool
+	fullChainRoot, comments, encryptKey, p12, p12Legacy, csrOnly, intermediate                    bool
+	urls                                                                                          *urlFlags
+	subjectAttrs                                                                                  *subjectFlags
+	ca, caName, caSubject                                                                         *string
+	upns, attrs, groups, ctLogs, hooks, plugins, usages, permits, excludes, caPermits, caExcludes stringsFlag
+	jksPassword                                                                                   string
+
+	// der, jks and pub is the formats parsed from format by checkFormats
+	der, jks, pub bool
+}
+
+// addIssueFlags adds the flags of the issue command
+func addIssueFlags(fs *flag.FlagSet) *issueFlags {
+	f := &issueFlags{}
+	fs.StringVar(&f.name, "n", "", "Common name of the certificate")
+	fs.StringVar(&f.subject, "subject", "", "Subject of the certificate, as /C=US/O=Acme/CN=example.com or "+
+		"CN=example.com,O=Acme,C=US")
+	fs.StringVar(&f.host, "h", "", "Domains, IPs or CIDRs of the certificate, comma separated, @file or - to read "+
+		"from file or stdin")
+	fs.BoolVar(&f.withWildcard, "with-wildcard", false, "Also add the wildcard of each domain, and the apex of each "+
+		"wildcard, like example.com and *.example.com")
+	fs.BoolVar(&f.dev, "dev", false, "Also add localhost, 127.0.0.1, ::1, and the hostname and LAN IP of the machine "+
+		"to the hosts")
+	fs.IntVar(&f.bits, "b", 2048, "Number of bits in the key to create, or curve size of ecdsa key (default 2048, "+
+		"or 256 for ecdsa and ed25519)")
+	fs.StringVar(&f.keyType, "key-type", "rsa", "Type of the key to create, rsa, ecdsa or ed25519")
+	fs.StringVar(&f.start, "s", "", "Valid from of the certificate, formatted as 2006-01-02 15:04:05 (default now)")
+	fs.IntVar(&f.days, "d", 365, "Valid days of the certificate, for example 365 (default 365 days)")
+	fs.StringVar(&f.output, "o", "cert", "Folder for saving the certificate (default cert)")
+	f.ca = addCAFlag(fs)
+	addPassphraseFlags(fs)
+	f.caName, f.caSubject = addCASubjectFlags(fs)
+	fs.BoolVar(&f.version, "v", false, "Show the selfca version")
+	fs.BoolVar(&f.weak, "insecure-allow-weak", false, "Allow weak key size, signature and validity, for testing only")
+	fs.BoolVar(&f.fips, "fips", false, "Only allow FIPS approved key sizes and signature algorithms")
+	fs.BoolVar(&f.strict, "strict-validity", false, "Refuse validity outside of the ca validity instead of clamping it")
+	fs.BoolVar(&f.rotate, "auto-rotate-ca", false, "Replace the expired or expiring ca by a new ca cross-signed by it")
+	fs.StringVar(&f.profile, "profile", "server", "Profile of the certificate, server, client, codesigning, email, "+
+		"smartcard, ipsec or device")
+	fs.BoolVar(&f.bundle, "bundle", false, "Also write the certificate, chain and key to a single .pem file for "+
+		"HAProxy")
+	fs.BoolVar(&f.fullChainRoot, "fullchain-root", false, "Include the root ca in the fullchain file")
+	fs.BoolVar(&f.comments, "pem-comments", false, "Write the subject, SANs, expiry and fingerprint as comments "+
+		"above the certificate PEM block")
+	fs.BoolVar(&f.encryptKey, "encrypt-key", false, "Encrypt the key of the certificate with the passphrase of the ca")
+	fs.StringVar(&f.escrow, "escrow", "", "RSA certificate or public key file of escrow, the key is also written "+
+		"encrypted to it as .key.escrow")
+	fs.StringVar(&f.format, "f", "pem", "Formats of the files to write, comma separated, "+
+		strings.Join(formats, ", ")+", the pem is always written")
+	fs.BoolVar(&f.p12, "p12", false, "Also write the certificate, key and chain as PKCS #12 file, like -f p12")
+	fs.StringVar(&f.p12Password, "p12-password", "", "Password of the PKCS #12 file")
+	fs.BoolVar(&f.p12Legacy, "p12-legacy", false, "Encrypt the PKCS #12 file by the legacy 3DES, for older Windows, "+
+		"Outlook and macOS Keychain")
+	fs.StringVar(&f.jksPassword, "jks-password", "changeit", "Password of the Java KeyStore and truststore")
+	fs.StringVar(&f.serial, "serial", "", "Serial number of the certificate, decimal or 0x prefixed hex (default "+
+		"random)")
+	fs.BoolVar(&f.intermediate, "intermediate", false, "Issue an intermediate ca signed by the ca instead, written "+
+		"as intermediate.crt for using by -ca")
+	fs.IntVar(&f.pathLen, "path-len", -1, "Max number of intermediate cas below the intermediate ca, only with "+
+		"intermediate (default unlimited)")
+	fs.IntVar(&f.caPathLen, "ca-path-len", -1, "Max number of intermediate cas below the ca created if not exists "+
+		"(default unlimited)")
+	fs.BoolVar(&f.csrOnly, "csr-only", false, "Only generate the key and certificate request for submitting to "+
+		"other ca")
+	fs.StringVar(&f.password, "challenge-password", "", "Challenge password of the certificate request, only with "+
+		"csr-only")
+	fs.StringVar(&f.server, "for", "", "Also write the files needed by web server, "+serverNames())
+	fs.Var(&f.attrs, "subject-attr", "Subject attribute as name=value or oid=value, like 2.5.4.15=Private "+
+		"Organization, can be repeated")
+	fs.Var(&f.groups, "group", "Hosts of another certificate, as name=hosts or hosts, issued in parallel, can be "+
+		"repeated")
+	fs.Var(&f.ctLogs, "ct-log", "URL of certificate transparency log to submit the certificate to, can be repeated")
+	fs.Var(&f.upns, "upn", "User principal name of the certificate for smart card logon, can be repeated")
+	fs.Var(&f.hooks, "hook", "Command to run after the certificate is issued, can be repeated")
+	fs.Var(&f.plugins, "plugin", "Command to run at the mutate, policy and issued points with json in stdin and out "+
+		"stdout, or the .star script, can be repeated")
+	fs.Var(&f.usages, "usage", "Key usages and extended key usages overriding the profile, comma separated like "+
+		"digitalSignature,serverAuth,codeSigning or OIDs, can be repeated")
+	fs.Var(&f.permits, "permit", "Permitted name constraint of the intermediate, like DNS:.internal.corp, "+
+		"IP:10.0.0.0/8 or email:corp.com, can be repeated")
+	fs.Var(&f.excludes, "exclude", "Excluded name constraint of the intermediate, like -permit, can be repeated")
+	fs.Var(&f.caPermits, "ca-permit", "Permitted name constraint of the ca created if not exists, like -permit, can "+
+		"be repeated")
+	fs.Var(&f.caExcludes, "ca-exclude", "Excluded name constraint of the ca created if not exists, like -permit, can "+
+		"be repeated")
+	f.urls = addURLFlags(fs)
+	f.subjectAttrs = addSubjectFlags(fs)
+	return f
+}
+
+// issue issues a certificate signed by the ca in output folder
+func issue(args []string) {
+	fs := flag.NewFlagSet("selfca issue", flag.ExitOnError)
+	f := addIssueFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if f.version {
+		fmt.Println("selfca version " + selfca.Version())
+		fmt.Println(selfca.Author())
+		os.Exit(0)
+	}
+
+	hosts, hostGroups := f.parseHosts()
+	f.check(fs, hosts, hostGroups)
+
+	if len(f.output) == 0 {
+		f.output = "cert"
+	}
+
+	if _, err := os.Stat(f.output); os.IsNotExist(err) {
+		err = os.MkdirAll(f.output, 0755)
+		if err != nil {
+			fail(exitIO, "Failed to create output folder", err)
+		}
+	}
+
+	request := f.newRequest(fs, hosts)
+	if f.csrOnly {
+		err := requestCertificate(request)
+		if err != nil {
+			failError(err)
+		}
+		return
+	}
+
+	errs := issueAll(f.requests(request, hosts, hostGroups))
+	if len(errs) > 0 {
+		if !errorJSON {
+			for _, v := range errs[1:] {
+				fmt.Fprintln(os.Stderr, v)
+			}
+		}
+		failError(errs[0])
+	}
+}
+
+// parseHosts returns the hosts and host groups, with the development, paired wildcard and apex hosts added
+func (f *issueFlags) parseHosts() ([]string, []hostGroup) {
+	hosts, err := parseHosts(f.host)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse hosts parameter", err)
+	}
+
+	if f.dev {
+		hosts = editHosts(hosts, devHosts(), nil)
+		debugf("Added the development hosts, the hosts are %s", strings.Join(hosts, ", "))
+	}
+
+	hostGroups, err := parseGroups(f.groups)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse group parameter", err)
+	}
+
+	if f.withWildcard {
+		hosts = editHosts(hosts, pairedHosts(hosts), nil)
+		for i := range hostGroups {
+			hostGroups[i].hosts = editHosts(hostGroups[i].hosts, pairedHosts(hostGroups[i].hosts), nil)
+		}
+		debugf("Added the paired wildcard and apex hosts, the hosts are %s", strings.Join(hosts, ", "))
+	}
+
+	return hosts, hostGroups
+}
+
+// named returns whether the certificate is named by the common name without hosts
+func (f *issueFlags) named() bool {
+	return selfca.Profile(f.profile) == selfca.ProfileCodeSigning && f.name != ""
+}
+
+// check checks the parameters, fails with usage if they are not valid together
+func (f *issueFlags) check(fs *flag.FlagSet, hosts []string, hostGroups []hostGroup) {
+	f.checkHosts(fs, hosts, hostGroups)
+	f.checkIntermediate(fs, hostGroups)
+	checkKeyFlags(fs, f.keyType, &f.bits)
+	f.checkFormats(fs)
+	f.checkCSROnly(fs, hostGroups)
+
+	if _, ok := serverOutputs[f.server]; f.server != "" && !ok {
+		failUsage(fs, "Unsupported web server parameter")
+	}
+
+	if f.serial != "" && len(hostGroups) > 0 {
+		failUsage(fs, "The serial parameter can not be used with group parameter")
+	}
+
+	if f.encryptKey && (f.bundle || f.server == "haproxy") {
+		failUsage(fs, "The encrypt-key parameter can not be used with bundle parameter, the key in bundle is not "+
+			"encrypted")
+	}
+
+	if f.escrow != "" {
+		if _, err := readEscrowKey(f.escrow); err != nil {
+			fail(exitBadInput, "Failed to read escrow parameter", err)
+		}
+	}
+
+	if f.encryptKey && len(passphrase) == 0 {
+		failUsage(fs, "The encrypt-key parameter requires passphrase, passphrase-file or $"+passphraseEnv)
+	}
+}
+
+// checkHosts checks the hosts are given and match the profile
+func (f *issueFlags) checkHosts(fs *flag.FlagSet, hosts []string, hostGroups []hostGroup) {
+	if len(hosts) == 0 && len(f.upns) == 0 && len(hostGroups) == 0 && !f.intermediate && !f.named() {
+		failUsage(fs, "Missing hosts parameter")
+	}
+
+	profile := selfca.Profile(f.profile)
+	if !validProfile(profile) {
+		failUsage(fs, "Unsupported profile parameter")
+	}
+
+	if profile == selfca.ProfileEmail && len(hosts) > 0 && !slices.ContainsFunc(hosts, isEmail) {
+		failUsage(fs, "The email profile requires an email address in hosts parameter, like -h i@example.com")
+	}
+}
+
+// checkIntermediate checks the parameters used with or only with intermediate
+func (f *issueFlags) checkIntermediate(fs *flag.FlagSet, hostGroups []hostGroup) {
+	if f.intermediate && (f.csrOnly || len(hostGroups) > 0 || f.server != "" || f.bundle || f.withWildcard || f.dev) {
+		failUsage(fs, "The intermediate parameter can not be used with csr-only, group, for, bundle, with-wildcard "+
+			"or dev parameter")
+	}
+
+	if (flagSet(fs, "path-len") || len(f.permits) > 0 || len(f.excludes) > 0) && !f.intermediate {
+		failUsage(fs, "The path-len, permit and exclude parameter can only be used with intermediate parameter")
+	}
+}
+
+// checkCSROnly checks the parameters used with or only with csr-only
+func (f *issueFlags) checkCSROnly(fs *flag.FlagSet, hostGroups []hostGroup) {
+	files := f.p12 || f.der || f.jks || f.pub || f.encryptKey
+	if f.csrOnly && (len(hostGroups) > 0 || f.serial != "" || f.server != "" || files || len(f.ctLogs) > 0) {
+		failUsage(fs, "The csr-only parameter can not be used with group, serial, for, f, p12, encrypt-key or "+
+			"ct-log parameter")
+	}
+
+	if f.password != "" && !f.csrOnly {
+		failUsage(fs, "The challenge-password parameter can only be used with csr-only parameter")
+	}
+}
+
+// checkFormats checks the formats parameter, and sets the formats to write
+func (f *issueFlags) checkFormats(fs *flag.FlagSet) {
+	for _, v := range strings.Split(f.format, ",") {
+		v = strings.ToLower(strings.TrimSpace(v))
+		if !slices.Contains(formats, v) {
+			failUsage(fs, "Unsupported format parameter "+v)
+		}
+		f.p12 = f.p12 || v == "p12"
+		f.der = f.der || v == "der"
+		f.jks = f.jks || v == "jks"
+		f.pub = f.pub || v == "pub"
+	}
+}
+
+// maxPathLen returns the MaxPathLen and MaxPathLenZero of path length, unlimited if negative
+func maxPathLen(n int) (int, bool) {
+	if n < 0 {
+		return -1, false
+	}
+
+	return n, n == 0
+}
+
+// checkKeyFlags checks the key-type and b parameters, the bits defaults to the key type if not set
+func checkKeyFlags(fs *flag.FlagSet, keyType string, bits *int) {
+	if !validKeyType(selfca.KeyType(keyType)) {
+		failUsage(fs, "Unsupported key-type parameter")
+	}
+
+	// the default bits of rsa is not a curve size
+	if keyType != string(selfca.KeyTypeRSA) && !flagSet(fs, "b") {
+		*bits = selfca.DefaultKeySize(selfca.KeyType(keyType))
+	}
+
+	switch {
+	case keyType == string(selfca.KeyTypeECDSA) && *bits != 256 && *bits != 384 && *bits != 521:
+		failUsage(fs, "Unsupported bits parameter, the curve size of ecdsa is 256, 384 or 521")
+	case keyType == string(selfca.KeyTypeEd25519) && *bits != 256:
+		failUsage(fs, "Unsupported bits parameter, the ed25519 key is always 256 bits")
+	}
+}
+
+// newRequest returns the request of the parameters, the intermediate is named intermediate
+func (f *issueFlags) newRequest(fs *flag.FlagSet, hosts []string) issueRequest {
+	request := issueRequest{
+		Output:         f.output,
+		CA:             *f.ca,
+		CATemplate:     f.caTemplate(),
+		Config:         f.config(fs, hosts),
+		AllowWeak:      f.weak,
+		StrictValidity: f.strict,
+		RotateCA:       f.rotate,
+		FIPS:           f.fips,
+		Comments:       f.comments,
+		FullChainRoot:  f.fullChainRoot,
+		Bundle:         f.bundle,
+		EncryptKey:     f.encryptKey,
+		PKCS12:         f.p12,
+		PKCS12Password: f.p12Password,
+		PKCS12Legacy:   f.p12Legacy,
+		DER:            f.der,
+		PublicKey:      f.pub,
+		JKS:            f.jks,
+		JKSPassword:    f.jksPassword,
+		CTLogs:         f.ctLogs,
+		Server:         f.server,
+		Hooks:          f.hooks,
+		Plugins:        f.plugins,
+		Escrow:         f.escrow,
+	}
+
+	if _, ok := nestedUsages[request.Config.Profile]; ok {
+		request.CATemplate.Profile = request.Config.Profile
+	}
+
+	if f.intermediate {
+		request.Name = "intermediate"
+		request.Config.MaxPathLen, request.Config.MaxPathLenZero = maxPathLen(f.pathLen)
+		err := addNameConstraints(&request.Config, f.permits, f.excludes)
+		if err != nil {
+			fail(exitBadInput, "Failed to parse permit or exclude parameter", err)
+		}
+	}
+
+	return request
+}
+
+// caTemplate returns the template of the ca created if not exists
+func (f *issueFlags) caTemplate() selfca.Certificate {
+	caConfig, err := caTemplate(*f.caName, *f.caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	caConfig.MaxPathLen, caConfig.MaxPathLenZero = maxPathLen(f.caPathLen)
+	err = addNameConstraints(&caConfig, f.caPermits, f.caExcludes)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-permit or ca-exclude parameter", err)
+	}
+
+	return caConfig
+}
+
+// config returns the certificate config of the parameters
+func (f *issueFlags) config(fs *flag.FlagSet, hosts []string) selfca.Certificate {
+	var err error
+	var subjectRDNs pkix.RDNSequence
+	if f.subject != "" {
+		subjectRDNs, err = selfca.ParseDN(f.subject)
+		if err != nil {
+			fail(exitBadInput, "Failed to parse subject parameter", err)
+		}
+	}
+
+	var extraSubject []pkix.AttributeTypeAndValue
+	for _, v := range f.attrs {
+		attr, err := selfca.ParseAttribute(v)
+		if err != nil {
+			fail(exitBadInput, "Failed to parse subject-attr parameter", err)
+		}
+		extraSubject = append(extraSubject, attr)
+	}
+
+	var serialNumber *big.Int
+	if f.serial != "" {
+		serialNumber, _ = new(big.Int).SetString(f.serial, 0)
+		if serialNumber == nil || serialNumber.Sign() <= 0 {
+			failUsage(fs, "Invalid serial parameter")
+		}
+	}
+
+	notBefore := time.Now()
+	if len(f.start) > 0 {
+		notBefore, err = time.Parse("2006-01-02 15:04:05", f.start)
+		if err != nil {
+			fail(exitBadInput, "Failed to parse valid from parameter", err)
+		}
+	}
+
+	config := selfca.Certificate{
+		IsCA:              f.intermediate,
+		CommonName:        f.name,
+		Subject:           subjectRDNs,
+		ExtraSubject:      extraSubject,
+		KeySize:           f.bits,
+		KeyType:           selfca.KeyType(f.keyType),
+		NotBefore:         notBefore,
+		NotAfter:          notBefore.Add(time.Duration(f.days*24) * time.Hour),
+		Hosts:             hosts,
+		UPNs:              f.upns,
+		Profile:           selfca.Profile(f.profile),
+		SerialNumber:      serialNumber,
+		ChallengePassword: f.password,
+	}
+
+	err = f.urls.apply(&config)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ocsp-url, issuer-url or crl-url parameter", err)
+	}
+
+	err = f.subjectAttrs.apply(&config)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse country parameter", err)
+	}
+
+	err = addUsages(&config, f.usages)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse usage parameter", err)
+	}
+
+	return config
+}
+
+// requests returns the request of hosts, and a request for each host group
+func (f *issueFlags) requests(request issueRequest, hosts []string, hostGroups []hostGroup) []issueRequest {
+	var requests []issueRequest
+	if len(hosts) > 0 || len(f.upns) > 0 || f.intermediate || f.named() {
+		requests = append(requests, request)
+	}
+
+	for _, v := range hostGroups {
+		r := request
+		r.Name = v.name
+		r.Config.CommonName = ""
+		r.Config.Hosts = v.hosts
+		r.Config.UPNs = nil
+		requests = append(requests, r)
+	}
+
+	return requests
+}
+
+// issueAll issues the certificates in parallel, the keys are generated in parallel
+// and the signing is serialized by the output lock, returns the errors
+func issueAll(requests []issueRequest) []error {
+	var mu sync.Mutex
+	var errs []error
+	var wg sync.WaitGroup
+
+	for _, v := range requests {
+		wg.Add(1)
+		go func(r issueRequest) {
+			defer wg.Done()
+			issuance, err := issueCertificate(r)
+			var e *exitError
+			if len(requests) > 1 && errors.As(err, &e) {
+				err = &exitError{e.code, fmt.Sprintf("%s for %s", e.message, requestName(r)), e.err}
+			}
+			if err != nil {
+				mu.Lock()
+				errs = append(errs, err)
+				mu.Unlock()
+				return
+			}
+			infof("Issued %s, valid until %s", issuance.CertificateFile, issuance.Certificate.NotAfter.Format(time.RFC3339))
+		}(v)
+	}
+
+	wg.Wait()
+
+	return errs
+}
+
+// addUsages adds the comma separated key usages and extended key usages to the certificate config
+func addUsages(c *selfca.Certificate, usages []string) error {
+	for _, v := range usages {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name == "" {
+				continue
+			}
+			err := c.AddUsage(name)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// subjectFlags is the subject attributes of the certificate
+type subjectFlags struct {
+	organization       stringsFlag
+	organizationalUnit stringsFlag
+	country            stringsFlag
+	locality           stringsFlag
+	province           stringsFlag
+	streetAddress      stringsFlag
+	postalCode         stringsFlag
+}
+
+// addSubjectFlags adds the flags of subject attributes, for the subject without -subject
+func addSubjectFlags(fs *flag.FlagSet) *subjectFlags {
+	s := &subjectFlags{}
+	fs.Var(&s.organization, "org", "Organization (O) of the subject, can be repeated")
+	fs.Var(&s.organizationalUnit, "ou", "Organizational unit (OU) of the subject, can be repeated")
+	fs.Var(&s.country, "country", "Two-letter country code (C) of the subject, like US, can be repeated")
+	fs.Var(&s.locality, "locality", "Locality (L) of the subject, can be repeated")
+	fs.Var(&s.province, "province", "State or province (ST) of the subject, can be repeated")
+	fs.Var(&s.streetAddress, "street", "Street address of the subject, can be repeated")
+	fs.Var(&s.postalCode, "postal-code", "Postal code of the subject, can be repeated")
+	return s
+}
+
+// apply checks the country codes, and sets the subject attributes to the certificate config
+func (s *subjectFlags) apply(c *selfca.Certificate) error {
+	for _, v := range s.country {
+		if len(v) != 2 {
+			return fmt.Errorf("invalid country code %s, it must be two letters like US", v)
+		}
+	}
+
+	c.Organization, c.OrganizationalUnit, c.Country = s.organization, s.organizationalUnit, s.country
+	c.Locality, c.Province, c.StreetAddress, c.PostalCode = s.locality, s.province, s.streetAddress, s.postalCode
+
+	return nil
+}
+
+// nestedUsages is the extended key usages of profiles nested through the chain by verifiers,
+// so the ca must allow them, the ca created for these profiles allows the usage only
+var nestedUsages = map[selfca.Profile]struct {
+	usage x509.ExtKeyUsage
+	name  string
+}{
+	selfca.ProfileCodeSigning: {x509.ExtKeyUsageCodeSigning, "code signing"},
+	selfca.ProfileEmail:       {x509.ExtKeyUsageEmailProtection, "email protection"},
+}
+
+// allowsUsage returns whether the extended key usages of ca allow the usage
+func allowsUsage(ca *x509.Certificate, usage x509.ExtKeyUsage) bool {
+	return len(ca.ExtKeyUsage) == 0 && len(ca.UnknownExtKeyUsage) == 0 ||
+		slices.Contains(ca.ExtKeyUsage, usage) || slices.Contains(ca.ExtKeyUsage, x509.ExtKeyUsageAny)
+}
+
+// requestName returns the file name of request, default the first host, or the common name without hosts
+func requestName(r issueRequest) string {
+	if r.Name != "" {
+		return r.Name
+	}
+
+	if len(r.Config.Hosts) > 0 {
+		return hostFileName(r.Config.Hosts[0])
+	}
+
+	if len(r.Config.UPNs) > 0 {
+		return r.Config.UPNs[0]
+	}
+
+	if !r.Config.IsCA && r.Config.CommonName != "" {
+		return strings.NewReplacer(" ", "_", "/", "_").Replace(r.Config.CommonName)
+	}
+
+	return "intermediate"
+}
+
+// issueCertificate issues the certificate signed by the ca in output folder,
+// writes the certificate and runs the hooks
+func issueCertificate(r issueRequest) (*selfca.Issuance, error) {
+	err := mutatePlugins(&r)
+	if err != nil {
+		return nil, &exitError{exitHook, "Failed to run the plugin", err}
+	}
+
+	config := r.Config
+	r.Name = requestName(r)
+
+	err = checkRequest(r)
+	if err != nil {
+		return nil, err
+	}
+
+	if config.KeySize <= 0 {
+		config.KeySize = selfca.DefaultKeySize(config.KeyType)
+	}
+
+	if config.Key == nil {
+		done := progress("Generating %s key for %s", keyName(config.KeyType, config.KeySize), r.Name)
+		config.Key, err = selfca.GenerateKey(config.KeyType, config.KeySize)
+		done()
+		if err != nil {
+			return nil, &exitError{exitCrypto, "Failed to generate the key", err}
+		}
+	}
+
+	unlock, err := lockOutput(r.Output)
+	if err != nil {
+		return nil, &exitError{exitIO, "Failed to lock output folder", err}
+	}
+
+	defer unlock()
+
+	caPath := resolveCA(r.CA, r.Output)
+	unlockCA, err := lockCA(caPath, r.Output)
+	if err != nil {
+		return nil, &exitError{exitIO, "Failed to lock ca folder", err}
+	}
+
+	defer unlockCA()
+
+	caConfig := r.CATemplate
+	caConfig.KeyType, caConfig.KeySize, caConfig.NotBefore = config.KeyType, config.KeySize, config.NotBefore
+	config.CACertificate, config.CAKey, err = loadCA(caPath, caConfig, r.RotateCA)
+	if err != nil {
+		return nil, &exitError{errorCode(err, exitCAMissing), "Failed to load ca certificate", err}
+	}
+
+	// the ca is loaded for each issuance, the daemon and agent keep no ca key between
+	defer selfca.ZeroKey(config.CAKey)
+
+	chain, err := readCAChain(caPath)
+	if err != nil {
+		return nil, &exitError{exitCAMissing, "Failed to load ca chain", err}
+	}
+
+	config.Parent = &selfca.CA{Certificate: config.CACertificate, Key: config.CAKey, Chain: chain}
+
+	err = checkWeakCA(config, r.AllowWeak)
+	if err == nil {
+		err = checkFIPS(config, r.FIPS)
+	}
+	if err != nil {
+		return nil, &exitError{exitBadInput, "Refused to use the ca certificate", err}
+	}
+
+	if v, ok := nestedUsages[config.Profile]; ok && !allowsUsage(config.CACertificate, v.usage) {
+		fmt.Fprintf(os.Stderr, "WARNING: ca certificate does not allow %s, the certificate fails verification, "+
+			"use -o or -ca for a separate %s ca\n", v.name, v.name)
+	}
+
+	err = checkCAValidity(&config, r.StrictValidity)
+	if err != nil {
+		return nil, &exitError{exitBadInput, "Refused to generate the certificate", err}
+	}
+
+	issuance, err := issueRecorded(config, caPath)
+	if err != nil {
+		return nil, err
+	}
+
+	err = writeIssuance(r, issuance)
+	if err != nil {
+		return nil, err
+	}
+
+	err = issuedPlugins(r, issuance)
+	if err != nil {
+		return issuance, &exitError{exitHook, "Failed to run the plugin", err}
+	}
+
+	err = runHooks(r.Hooks, hookEnv(issuance, caPath))
+	if err != nil {
+		return issuance, &exitError{exitHook, "Failed to run the hook", err}
+	}
+
+	return issuance, nil
+}
+
+// checkRequest checks the weak and FIPS parameters of request, and runs the policy plugins
+func checkRequest(r issueRequest) error {
+	check := r.Config
+	if r.ShortLived {
+		check.NotAfter = check.NotBefore.Add(selfca.MinValidity)
+	}
+
+	err := checkWeak(check, r.AllowWeak)
+	if err == nil {
+		err = checkFIPS(check, r.FIPS)
+	}
+	if err != nil {
+		return &exitError{exitBadInput, "Refused to generate the certificate", err}
+	}
+
+	err = policyPlugins(r)
+	if errors.Is(err, errRefusedByPlugin) {
+		return &exitError{exitBadInput, "Refused to generate the certificate", err}
+	} else if err != nil {
+		return &exitError{exitHook, "Failed to run the plugin", err}
+	}
+
+	return nil
+}
+
+// writeIssuance writes the certificate, key and requested files of issuance to output folder,
+// and submits it to ct logs
+func writeIssuance(r issueRequest, issuance *selfca.Issuance) error {
+	if r.Comments {
+		issuance.Annotate()
+	}
+
+	var keyPassphrase []byte
+	if r.EncryptKey {
+		keyPassphrase = passphrase
+	}
+
+	name := fmt.Sprintf("%s/%s", r.Output, r.Name)
+	err := issuance.WriteWithPassphrase(name, keyPassphrase)
+	if err != nil {
+		return &exitError{exitIO, "Failed to write the certificate", err}
+	}
+
+	debugf("Wrote %s and %s", issuance.CertificateFile, issuance.KeyFile)
+
+	if r.Escrow != "" && issuance.KeyFile != "" {
+		err = writeEscrow(name, issuance, r.Escrow)
+		if err != nil {
+			return &exitError{errorCode(err, exitBadInput), "Failed to write the escrowed key", err}
+		}
+		debugf("Wrote %s.key.escrow", name)
+	}
+
+	err = issuance.WriteChain(name, r.FullChainRoot)
+	if err != nil {
+		return &exitError{exitIO, "Failed to write the fullchain file", err}
+	}
+
+	// the intermediate ca is used by -ca with its issuers in the chain file
+	if r.Config.IsCA {
+		err = os.WriteFile(name+".chain.crt", selfca.ChainPEM(issuance.Chain, true), 0644)
+		if err != nil {
+			return &exitError{exitIO, "Failed to write the ca chain", err}
+		}
+	}
+
+	err = writeFormats(r, name, issuance)
+	if err != nil {
+		return err
+	}
+
+	if len(r.CTLogs) > 0 {
+		err = submitCT(r.CTLogs, name, issuance)
+		if err != nil {
+			return &exitError{exitFailure, "Failed to submit to ct log", err}
+		}
+	}
+
+	if r.Server != "" {
+		config, err := writeServerFiles(r.Server, name, issuance)
+		if err != nil {
+			return &exitError{exitIO, "Failed to write the web server files", err}
+		}
+		fmt.Println(config)
+	}
+
+	return nil
+}
+
+// writeFormats writes the bundle, DER, public key, PKCS #12 and Java KeyStore files of request
+func writeFormats(r issueRequest, name string, issuance *selfca.Issuance) error {
+	if r.Bundle {
+		err := issuance.WriteBundle(name)
+		if err != nil {
+			return &exitError{exitIO, "Failed to write the bundle file", err}
+		}
+		debugf("Wrote %s", issuance.BundleFile)
+	}
+
+	if r.DER {
+		err := issuance.WriteDER(name)
+		if err != nil {
+			return &exitError{exitIO, "Failed to write the DER files", err}
+		}
+		debugf("Wrote %s and %s", issuance.DERFile, issuance.KeyDERFile)
+	}
+
+	if r.PublicKey {
+		err := issuance.WritePublicKey(name)
+		if err != nil {
+			return &exitError{exitIO, "Failed to write the public key files", err}
+		}
+		debugf("Wrote %s and %s", issuance.PublicKeyFile, issuance.SSHPublicKeyFile)
+	}
+
+	if r.PKCS12 {
+		var err error
+		if r.PKCS12Legacy {
+			err = issuance.WriteLegacyPKCS12(name, r.PKCS12Password)
+		} else {
+			err = issuance.WritePKCS12(name, r.PKCS12Password)
+		}
+		if err != nil {
+			return &exitError{exitIO, "Failed to write the PKCS #12 file", err}
+		}
+		debugf("Wrote %s", issuance.PKCS12File)
+	}
+
+	if r.JKS {
+		err := issuance.WriteJKS(name, r.JKSPassword)
+		if err != nil {
+			return &exitError{exitIO, "Failed to write the Java KeyStore", err}
+		}
+		debugf("Wrote %s and %s", issuance.JKSFile, issuance.TrustStoreFile)
+	}
+
+	return nil
+}
+
+// issueRecorded issues the certificate and records it to the ca database, the serial must not be recorded
+func issueRecorded(config selfca.Certificate, caPath string) (*selfca.Issuance, error) {
+	db, err := selfca.OpenDatabase(caPath + ".db")
+	if err != nil {
+		return nil, &exitError{errorCode(err, exitIO), "Failed to load ca database", err}
+	}
+
+	if config.SerialNumber != nil && db.Contains(config.SerialNumber) {
+		return nil, &exitError{exitBadInput, "Refused to generate the certificate", selfca.ErrDuplicateSerial}
+	}
+
+	issuance, err := selfca.Issue(config)
+	if err != nil {
+		return nil, &exitError{errorCode(err, exitCrypto), "Failed to generate the certificate", err}
+	}
+
+	err = db.Add(issuance.Certificate)
+	if err == nil {
+		err = db.Save()
+	}
+	if err != nil {
+		return nil, &exitError{exitIO, "Failed to record the certificate", err}
+	}
+
+	return issuance, nil
+}
+
+// validProfile returns whether the profile is supported
+func validProfile(profile selfca.Profile) bool {
+	for _, v := range profiles {
+		if v == profile {
+			return true
+		}
+	}
+
+	return false
+}
+
+// validKeyType returns whether the key type is supported
+func validKeyType(keyType selfca.KeyType) bool {
+	for _, v := range keyTypes {
+		if v == keyType {
+			return true
+		}
+	}
+
+	return false
+}
+
+// submitCT submits the certificate chain to the ct logs, and writes the returned SCTs
+func submitCT(logs []string, name string, i *selfca.Issuance) error {
+	ctx, cancel := context.WithTimeout(context.Background(), ctTimeout)
+	defer cancel()
+
+	chain := append([]*x509.Certificate{i.Certificate}, i.Chain...)
+
+	var scts []*selfca.SCT
+	for _, v := range logs {
+		sct, err := (&selfca.CTLog{URL: v}).AddChain(ctx, chain)
+		if err != nil {
+			return fmt.Errorf("%s: %w", v, err)
+		}
+		debugf("Submitted to %s, timestamp %d", v, sct.Timestamp)
+		scts = append(scts, sct)
+	}
+
+	return selfca.WriteSCTs(name, scts)
+}
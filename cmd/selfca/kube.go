@@ -0,0 +1,153 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	apierrors "k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+)
+
+// kubeClient is the kubernetes api client of secrets and webhook configurations
+type kubeClient struct {
+	clientset kubernetes.Interface
+}
+
+// newKubeClient returns the kubernetes client of server, the service account of pod is used if server is empty,
+// or the current context of kubeconfig outside of pod, the http server like kubectl proxy needs no token
+func newKubeClient(server, tokenFile string) (*kubeClient, error) {
+	config, err := kubeConfig(server)
+	if err != nil {
+		return nil, err
+	}
+
+	if tokenFile != "" {
+		config.BearerToken, config.BearerTokenFile = "", tokenFile
+	}
+
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &kubeClient{clientset: clientset}, nil
+}
+
+// kubeConfig returns the rest config of server, or of the cluster running in, or of the kubeconfig
+// by $KUBECONFIG or ~/.kube/config
+func kubeConfig(server string) (*rest.Config, error) {
+	if server != "" {
+		return &rest.Config{Host: server}, nil
+	}
+
+	config, err := rest.InClusterConfig()
+	if !errors.Is(err, rest.ErrNotInCluster) {
+		return config, err
+	}
+
+	return clientcmd.NewNonInteractiveDeferredLoadingClientConfig(clientcmd.NewDefaultClientConfigLoadingRules(),
+		&clientcmd.ConfigOverrides{}).ClientConfig()
+}
+
+// listSecrets lists the secrets in namespace, or all namespaces if empty
+func (k *kubeClient) listSecrets(ctx context.Context, namespace string) (*corev1.SecretList, error) {
+	return k.clientset.CoreV1().Secrets(namespace).List(ctx, metav1.ListOptions{})
+}
+
+// watchSecrets watches the secrets in namespace changed after resource version, until the timeout
+// in seconds or ctx done, the handle is called for every added or modified secret
+func (k *kubeClient) watchSecrets(ctx context.Context, namespace, resourceVersion string,
+	timeout int, handle func(*corev1.Secret)) error {
+	seconds := int64(timeout)
+	w, err := k.clientset.CoreV1().Secrets(namespace).Watch(ctx, metav1.ListOptions{
+		ResourceVersion: resourceVersion,
+		TimeoutSeconds:  &seconds,
+	})
+	if err != nil {
+		return err
+	}
+
+	defer w.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-w.ResultChan():
+			if !ok {
+				return nil
+			}
+
+			switch event.Type {
+			case watch.Added, watch.Modified:
+				if secret, ok := event.Object.(*corev1.Secret); ok {
+					handle(secret)
+				}
+			case watch.Error:
+				return fmt.Errorf("kubernetes watch failed: %w", apierrors.FromObject(event.Object))
+			}
+		}
+	}
+}
+
+// patchSecretData replaces the data of secret by merge patch, refused if the secret is changed since read
+func (k *kubeClient) patchSecretData(ctx context.Context, s *corev1.Secret, data map[string][]byte) error {
+	patch, err := json.Marshal(map[string]any{
+		"metadata": map[string]string{"resourceVersion": s.ResourceVersion},
+		"data":     data,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = k.clientset.CoreV1().Secrets(s.Namespace).Patch(ctx, s.Name, types.MergePatchType, patch,
+		metav1.PatchOptions{})
+
+	return err
+}
+
+// applySecretData creates the secret of type with data, or replaces the data if it exists
+func (k *kubeClient) applySecretData(ctx context.Context, namespace, name, secretType string,
+	data map[string][]byte) error {
+	secrets := k.clientset.CoreV1().Secrets(namespace)
+	s, err := secrets.Get(ctx, name, metav1.GetOptions{})
+	if apierrors.IsNotFound(err) {
+		_, err = secrets.Create(ctx, &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace},
+			Type:       corev1.SecretType(secretType),
+			Data:       data,
+		}, metav1.CreateOptions{})
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	return k.patchSecretData(ctx, s, data)
+}
@@ -0,0 +1,154 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+)
+
+func TestApplySecretData(t *testing.T) {
+	ctx := context.Background()
+	kube := &kubeClient{clientset: fake.NewSimpleClientset()}
+
+	err := kube.applySecretData(ctx, "default", "web-tls", "kubernetes.io/tls", map[string][]byte{"tls.crt": []byte("a")})
+	assert.Nil(t, err)
+
+	err = kube.applySecretData(ctx, "default", "web-tls", "kubernetes.io/tls", map[string][]byte{"tls.key": []byte("b")})
+	assert.Nil(t, err)
+
+	s, err := kube.clientset.CoreV1().Secrets("default").Get(ctx, "web-tls", metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, s.Type, corev1.SecretTypeTLS)
+	assert.Equal(t, string(s.Data["tls.crt"]), "a")
+	assert.Equal(t, string(s.Data["tls.key"]), "b")
+}
+
+func TestPatchCABundle(t *testing.T) {
+	ctx := context.Background()
+	service := func(name string) admissionregistrationv1.WebhookClientConfig {
+		return admissionregistrationv1.WebhookClientConfig{
+			Service: &admissionregistrationv1.ServiceReference{Name: name, Namespace: "infra"},
+		}
+	}
+
+	kube := &kubeClient{clientset: fake.NewSimpleClientset(&admissionregistrationv1.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: "policy"},
+		Webhooks: []admissionregistrationv1.ValidatingWebhook{
+			{Name: "a.likexian.com", ClientConfig: service("other")},
+			{Name: "b.likexian.com", ClientConfig: service("policy-webhook")},
+			{Name: "c.likexian.com", ClientConfig: admissionregistrationv1.WebhookClientConfig{}},
+		},
+	})}
+
+	n, err := patchCABundle(ctx, kube, "validating", "policy", "policy-webhook", "infra", []byte("ca"))
+	assert.Nil(t, err)
+	assert.Equal(t, n, 1)
+
+	c, err := kube.clientset.AdmissionregistrationV1().ValidatingWebhookConfigurations().Get(ctx, "policy",
+		metav1.GetOptions{})
+	assert.Nil(t, err)
+	assert.Equal(t, len(c.Webhooks[0].ClientConfig.CABundle), 0)
+	assert.Equal(t, string(c.Webhooks[1].ClientConfig.CABundle), "ca")
+
+	_, err = patchCABundle(ctx, kube, "validating", "policy", "missing", "infra", []byte("ca"))
+	assert.NotNil(t, err)
+
+	_, err = patchCABundle(ctx, kube, "mutating", "policy", "policy-webhook", "infra", []byte("ca"))
+	assert.NotNil(t, err)
+}
+
+func TestOperatorReconcile(t *testing.T) {
+	ctx := context.Background()
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "web-tls",
+			Namespace:   "default",
+			Annotations: map[string]string{hostsAnnotation: "web.likexian.com, 10.0.0.0/31", daysAnnotation: "30"},
+		},
+		Type: corev1.SecretTypeTLS,
+	}
+
+	output := t.TempDir()
+	o := &kubeOperator{
+		kube:        &kubeClient{clientset: fake.NewSimpleClientset(secret)},
+		caPath:      filepath.Join(output, "ca"),
+		output:      output,
+		days:        90,
+		renewBefore: 24 * time.Hour,
+	}
+
+	assert.Nil(t, o.loadCA(func(*x509.Certificate, crypto.Signer) error { return nil }))
+
+	list, err := o.kube.listSecrets(ctx, "")
+	assert.Nil(t, err)
+	assert.Equal(t, len(list.Items), 1)
+	o.reconcile(ctx, &list.Items[0])
+
+	s, err := o.kube.clientset.CoreV1().Secrets("default").Get(ctx, "web-tls", metav1.GetOptions{})
+	assert.Nil(t, err)
+
+	block, _ := pem.Decode(s.Data["tls.crt"])
+	assert.NotNil(t, block)
+
+	c, err := x509.ParseCertificate(block.Bytes)
+	assert.Nil(t, err)
+	assert.Nil(t, c.CheckSignatureFrom(o.caCertificate))
+	assert.Equal(t, c.DNSNames, []string{"web.likexian.com"})
+	assert.Equal(t, len(c.IPAddresses), 2)
+	assert.Equal(t, o.renewReason(s, []string{"web.likexian.com", "10.0.0.0", "10.0.0.1"}), "")
+}
+
+func TestKubeConfig(t *testing.T) {
+	t.Setenv("KUBERNETES_SERVICE_HOST", "")
+	t.Setenv("KUBERNETES_SERVICE_PORT", "")
+
+	path := filepath.Join(t.TempDir(), "kubeconfig")
+	assert.Nil(t, os.WriteFile(path, kubeconfigYAML("alice", "https://10.0.0.1:6443", "dev", nil, nil, nil), 0600))
+	t.Setenv("KUBECONFIG", path)
+
+	config, err := kubeConfig("")
+	assert.Nil(t, err)
+	assert.Equal(t, config.Host, "https://10.0.0.1:6443")
+
+	config, err = kubeConfig("http://127.0.0.1:8001")
+	assert.Nil(t, err)
+	assert.Equal(t, config.Host, "http://127.0.0.1:8001")
+
+	token := filepath.Join(filepath.Dir(path), "token")
+	_, err = newKubeClient("http://127.0.0.1:8001", token)
+	assert.NotNil(t, err)
+
+	assert.Nil(t, os.WriteFile(token, []byte("secret\n"), 0600))
+	_, err = newKubeClient("http://127.0.0.1:8001", token)
+	assert.Nil(t, err)
+}
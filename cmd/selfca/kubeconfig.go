@@ -0,0 +1,156 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// oidOrganization is the OID of organization attribute, mapped to the group by kubernetes
+var oidOrganization = asn1.ObjectIdentifier{2, 5, 4, 10}
+
+// kubeconfig issues the client certificate of kubernetes user and groups, and writes the kubeconfig of it,
+// the api server must trust the ca by --client-ca-file
+func kubeconfig(args []string) {
+	fs := flag.NewFlagSet("selfca kubeconfig", flag.ExitOnError)
+	user := fs.String("user", "", "Kubernetes user name, the common name of the certificate")
+	server := fs.String("server", "", "URL of the kubernetes api server, the cluster and context are also written if set")
+	cluster := fs.String("cluster", "selfca", "Name of the cluster in the kubeconfig (default selfca)")
+	clusterCA := fs.String("cluster-ca", "", "Path of the ca of the api server serving certificate (default system roots)")
+	days := fs.Int("d", 365, "Valid days of the certificate, for example 365 (default 365 days)")
+	output := fs.String("o", "cert", "Folder of the ca and for saving the certificate (default cert)")
+	out := fs.String("out", "", "Path for saving the kubeconfig (default stdout)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	var groups stringsFlag
+	fs.Var(&groups, "group", "Kubernetes group of the user, the organization of the certificate, comma separated, can be "+
+		"repeated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if *user == "" {
+		failUsage(fs, "Missing user parameter")
+	}
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	var clusterCAData []byte
+	if *clusterCA != "" {
+		clusterCAData, err = os.ReadFile(*clusterCA)
+		if err != nil {
+			fail(errorCode(err, exitBadInput), "Failed to read the cluster-ca file", err)
+		}
+	}
+
+	var subject []pkix.AttributeTypeAndValue
+	for _, v := range groups {
+		for _, vv := range strings.Split(v, ",") {
+			if vv = strings.TrimSpace(vv); vv != "" {
+				subject = append(subject, pkix.AttributeTypeAndValue{Type: oidOrganization, Value: vv})
+			}
+		}
+	}
+
+	if len(*output) == 0 {
+		*output = "cert"
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	now := time.Now()
+	issuance, err := issueCertificate(issueRequest{
+		Output:     *output,
+		CA:         *caFlag,
+		CATemplate: caConfig,
+		Name:       strings.NewReplacer("/", "_", "\\", "_", ":", "_").Replace(*user),
+		Config: selfca.Certificate{
+			CommonName:   *user,
+			ExtraSubject: subject,
+			KeySize:      selfca.DefaultKeySize(selfca.KeyTypeRSA),
+			NotBefore:    now,
+			NotAfter:     now.Add(time.Duration(*days*24) * time.Hour),
+		},
+	})
+	if err != nil {
+		failError(err)
+	}
+
+	defer issuance.Zero()
+
+	// the intermediates are sent along, the api server only trusts the root
+	certificate := selfca.ChainPEM(append([]*x509.Certificate{issuance.Certificate}, issuance.Chain...), false)
+	data := kubeconfigYAML(*user, *server, *cluster, clusterCAData, certificate, issuance.KeyPEM)
+	defer clear(data)
+
+	if *out == "" {
+		fmt.Print(string(data))
+		return
+	}
+
+	err = os.WriteFile(*out, data, 0600)
+	if err != nil {
+		fail(exitIO, "Failed to write the kubeconfig", err)
+	}
+
+	infof("Wrote kubeconfig of %s to %s, valid until %s", *user, *out, issuance.Certificate.NotAfter.Format(time.RFC3339))
+}
+
+// kubeconfigYAML returns the kubeconfig of the user with embedded certificate and key,
+// and of the cluster and context if server is set
+func kubeconfigYAML(user, server, cluster string, clusterCA, certificate, key []byte) []byte {
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "apiVersion: v1\nkind: Config\n")
+
+	if server != "" {
+		context := user + "@" + cluster
+		fmt.Fprintf(buf, "clusters:\n- name: %s\n  cluster:\n    server: %s\n", strconv.Quote(cluster), strconv.Quote(server))
+		if len(clusterCA) > 0 {
+			fmt.Fprintf(buf, "    certificate-authority-data: %s\n", base64.StdEncoding.EncodeToString(clusterCA))
+		}
+		fmt.Fprintf(buf, "contexts:\n- name: %s\n  context:\n    cluster: %s\n    user: %s\n",
+			strconv.Quote(context), strconv.Quote(cluster), strconv.Quote(user))
+		fmt.Fprintf(buf, "current-context: %s\n", strconv.Quote(context))
+	}
+
+	fmt.Fprintf(buf, "users:\n- name: %s\n  user:\n    client-certificate-data: %s\n    client-key-data: %s\n",
+		strconv.Quote(user), base64.StdEncoding.EncodeToString(certificate), base64.StdEncoding.EncodeToString(key))
+
+	return buf.Bytes()
+}
@@ -0,0 +1,77 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// lockCA acquires the exclusive lock of the ca folder if it is not the output folder,
+// the ca folder is created if not exists
+func lockCA(caPath, output string) (func(), error) {
+	dir := filepath.Dir(caPath)
+	err := os.MkdirAll(dir, 0755)
+	if err != nil {
+		return nil, err
+	}
+
+	// the folders are compared by file, the paths may be relative, absolute or symlinked
+	if sameDir(dir, output) {
+		return func() {}, nil
+	}
+
+	return lockOutput(dir)
+}
+
+// sameDir returns whether the paths are the same folder
+func sameDir(a, b string) bool {
+	ai, err := os.Stat(a)
+	if err != nil {
+		return false
+	}
+
+	bi, err := os.Stat(b)
+	if err != nil {
+		return false
+	}
+
+	return os.SameFile(ai, bi)
+}
+
+// lockOutput acquires the exclusive lock of output folder,
+// it blocks until the lock held by other processes is released
+func lockOutput(output string) (func(), error) {
+	fd, err := os.OpenFile(filepath.Join(output, ".lock"), os.O_CREATE|os.O_RDWR, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	err = lockFile(fd)
+	if err != nil {
+		fd.Close()
+		return nil, err
+	}
+
+	return func() {
+		_ = unlockFile(fd)
+		fd.Close()
+	}, nil
+}
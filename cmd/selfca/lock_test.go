@@ -0,0 +1,70 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestLockCA(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	assert.Nil(t, err)
+	assert.Nil(t, os.Chdir(dir))
+	defer func() { _ = os.Chdir(wd) }()
+
+	assert.Nil(t, os.Mkdir("cert", 0755))
+	assert.Nil(t, os.Symlink("cert", "link"))
+
+	unlock, err := lockOutput("cert")
+	assert.Nil(t, err)
+	defer unlock()
+
+	// the ca in output folder is locked already, by relative, absolute or symlinked path
+	for _, v := range []string{"cert/ca", filepath.Join(dir, "cert", "ca"), "link/ca", "./cert/../cert/ca"} {
+		done := make(chan error, 1)
+		go func() {
+			unlockCA, err := lockCA(v, "cert")
+			if err == nil {
+				unlockCA()
+			}
+			done <- err
+		}()
+
+		select {
+		case err := <-done:
+			assert.Nil(t, err, v)
+		case <-time.After(5 * time.Second):
+			t.Fatalf("lockCA(%q, \"cert\") blocked on the output lock", v)
+		}
+	}
+
+	unlockCA, err := lockCA(filepath.Join("ca", "ca"), "cert")
+	assert.Nil(t, err)
+	unlockCA()
+
+	_, err = os.Stat(filepath.Join("ca", ".lock"))
+	assert.Nil(t, err)
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+)
+
+// mobileconfig returns the configuration profile installing the ca as trusted root on iOS and macOS
+func mobileconfig(ca *x509.Certificate) []byte {
+	sum := sha256.Sum256(ca.Raw)
+	id := fmt.Sprintf("com.likexian.selfca.%x", sum[:8])
+	name := ca.Subject.CommonName
+	if name == "" {
+		name = "selfca"
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" ` +
+		`"http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString("<plist version=\"1.0\">\n<dict>\n")
+	b.WriteString("\t<key>PayloadContent</key>\n\t<array>\n\t\t<dict>\n")
+	b.WriteString("\t\t\t<key>PayloadCertificateFileName</key>\n\t\t\t<string>ca.cer</string>\n")
+	fmt.Fprintf(&b, "\t\t\t<key>PayloadContent</key>\n\t\t\t<data>%s</data>\n", base64.StdEncoding.EncodeToString(ca.Raw))
+	fmt.Fprintf(&b, "\t\t\t<key>PayloadDisplayName</key>\n\t\t\t<string>%s</string>\n", xmlEscape(name))
+	fmt.Fprintf(&b, "\t\t\t<key>PayloadIdentifier</key>\n\t\t\t<string>%s.root</string>\n", id)
+	b.WriteString("\t\t\t<key>PayloadType</key>\n\t\t\t<string>com.apple.security.root</string>\n")
+	fmt.Fprintf(&b, "\t\t\t<key>PayloadUUID</key>\n\t\t\t<string>%s</string>\n", hashUUID(sum[:], "root"))
+	b.WriteString("\t\t\t<key>PayloadVersion</key>\n\t\t\t<integer>1</integer>\n")
+	b.WriteString("\t\t</dict>\n\t</array>\n")
+	fmt.Fprintf(&b, "\t<key>PayloadDescription</key>\n"+
+		"\t<string>Trusts the %s certificate authority of selfca</string>\n", xmlEscape(name))
+	fmt.Fprintf(&b, "\t<key>PayloadDisplayName</key>\n\t<string>%s</string>\n", xmlEscape(name))
+	fmt.Fprintf(&b, "\t<key>PayloadIdentifier</key>\n\t<string>%s</string>\n", id)
+	b.WriteString("\t<key>PayloadRemovalDisallowed</key>\n\t<false/>\n")
+	b.WriteString("\t<key>PayloadType</key>\n\t<string>Configuration</string>\n")
+	fmt.Fprintf(&b, "\t<key>PayloadUUID</key>\n\t<string>%s</string>\n", hashUUID(sum[:], "profile"))
+	b.WriteString("\t<key>PayloadVersion</key>\n\t<integer>1</integer>\n")
+	b.WriteString("</dict>\n</plist>\n")
+
+	return b.Bytes()
+}
+
+// hashUUID returns the name based UUID derived from hash, so the profile is stable for the same ca
+func hashUUID(hash []byte, name string) string {
+	sum := sha256.Sum256(append(append([]byte{}, hash...), name...))
+	sum[6] = sum[6]&0x0f | 0x50
+	sum[8] = sum[8]&0x3f | 0x80
+
+	return fmt.Sprintf("%X-%X-%X-%X-%X", sum[0:4], sum[4:6], sum[6:8], sum[8:10], sum[10:16])
+}
+
+// xmlEscape returns the text escaped for xml
+func xmlEscape(s string) string {
+	var b bytes.Buffer
+	_ = xml.EscapeText(&b, []byte(s))
+
+	return b.String()
+}
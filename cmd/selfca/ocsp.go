@@ -0,0 +1,303 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel/attribute"
+	"golang.org/x/crypto/ocsp"
+)
+
+// maxOCSPRequest is the maximum size of OCSP request
+const maxOCSPRequest = 64 * 1024
+
+// OCSP error responses of internal error and unauthorized status
+var (
+	ocspInternalError = []byte{0x30, 0x03, 0x0A, 0x01, 0x02}
+	ocspUnauthorized  = []byte{0x30, 0x03, 0x0A, 0x01, 0x06}
+)
+
+// ocspResponder responds the OCSP requests of certificates issued by the ca
+type ocspResponder struct {
+	ca       *selfca.CA
+	caPath   string
+	validity time.Duration
+	nonce    bool
+}
+
+// ocspServer serves the RFC 6960 OCSP responses of the certificates issued by the ca,
+// the revocation database is read for every request, so revoking takes effect at once
+func ocspServer(args []string) {
+	fs := flag.NewFlagSet("selfca ocsp", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "Address of OCSP responder (default :8080)")
+	validity := fs.Duration("validity", selfca.DefaultOCSPValidity, "Time from this update to next update of the "+
+		"responses (default 1h)")
+	output := fs.String("o", "cert", "Folder of the ca (default cert)")
+	nonce := fs.Bool("nonce", true, "Echo the nonce of requests, -nonce=false for clients breaking on nonces (default "+
+		"true)")
+	caFlag := addCAFlag(fs)
+	otlpEndpoint := addTraceFlag(fs)
+	addPassphraseFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if *validity <= 0 {
+		failUsage(fs, "Invalid validity parameter")
+	}
+
+	stopTracing, err := startTracing(context.Background(), *otlpEndpoint)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse otlp-endpoint parameter", err)
+	}
+
+	caPath := resolveCA(*caFlag, *output)
+	certificates, key, err := readCA(caPath)
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to read the ca", err)
+	}
+
+	defer selfca.ZeroKey(key)
+
+	l, err := net.Listen("tcp", *listen)
+	if err != nil {
+		fail(exitIO, "Failed to listen on "+*listen, err)
+	}
+
+	responder := &ocspResponder{
+		ca:       &selfca.CA{Certificate: certificates[0], Key: key},
+		caPath:   caPath,
+		validity: *validity,
+		nonce:    *nonce,
+	}
+
+	handler := otelhttp.NewHandler(responder, "ocsp.request")
+	server := &http.Server{Handler: handler, ReadHeaderTimeout: 10 * time.Second}
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		<-stopSignal()
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = server.Shutdown(ctx)
+		stopTracing(ctx)
+	}()
+
+	infof("Responding OCSP of %s on %s", certificates[0].Subject.CommonName, l.Addr())
+	err = server.Serve(l)
+	if err != nil && !errors.Is(err, http.ErrServerClosed) {
+		fail(exitIO, "Failed to serve OCSP", err)
+	}
+
+	<-stopped
+}
+
+// ServeHTTP responds the OCSP request by GET with base64 path or POST with DER body,
+// parsing the request and evaluating it in the spans ocsp.parse and ocsp.policy
+func (o *ocspResponder) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	request, err := parseOCSPRequest(r)
+	response := ocsp.MalformedRequestErrorResponse
+	if err == nil {
+		response, err = o.respond(r.Context(), request)
+	}
+
+	if err != nil {
+		debugf("OCSP request from %s failed: %v", r.RemoteAddr, err)
+	} else {
+		debugf("OCSP request from %s responded", r.RemoteAddr)
+	}
+
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	_, _ = w.Write(response)
+}
+
+// parseOCSPRequest returns the DER of OCSP request of r, checked to be parsed in the span ocsp.parse
+func parseOCSPRequest(r *http.Request) (request []byte, err error) {
+	_, span := startSpan(r.Context(), "ocsp.parse")
+	defer func() { endSpan(span, err) }()
+
+	if r.Method == http.MethodGet {
+		request, err = base64.StdEncoding.DecodeString(strings.TrimPrefix(r.URL.Path, "/"))
+	} else {
+		request, err = io.ReadAll(io.LimitReader(r.Body, maxOCSPRequest))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	parsed, err := ocsp.ParseRequest(request)
+	if err != nil {
+		return nil, err
+	}
+
+	span.SetAttributes(attribute.String("ocsp.serial_number", parsed.SerialNumber.String()))
+
+	return request, nil
+}
+
+// respond returns the OCSP response of request, or the error response with the error,
+// the status is evaluated in the span ocsp.policy with the signing in ocsp.sign under it
+func (o *ocspResponder) respond(ctx context.Context, request []byte) (response []byte, err error) {
+	ctx, span := startSpan(ctx, "ocsp.policy")
+	defer func() { endSpan(span, err) }()
+
+	db, err := selfca.OpenDatabase(o.caPath + ".db")
+	if err != nil {
+		return ocspInternalError, err
+	}
+
+	ca := &selfca.CA{Certificate: o.ca.Certificate, Key: &tracedSigner{o.ca.Key, ctx, "ocsp.sign"}}
+	response, err = db.OCSPResponseWithNonce(ca, request, o.validity, o.nonce)
+	switch {
+	case err == nil:
+		return response, nil
+	case errors.Is(err, selfca.ErrOCSPUnauthorized):
+		return ocspUnauthorized, err
+	default:
+		return ocsp.MalformedRequestErrorResponse, err
+	}
+}
+
+// ocspCheck checks the status of certificate by the OCSP responder, the response must be
+// signed by the ca, and must echo the nonce of request unless -nonce=false
+func ocspCheck(args []string) {
+	fs := flag.NewFlagSet("selfca ocsp-check", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the ca and certificates (default cert)")
+	caFlag := addCAFlag(fs)
+	responder := fs.String("url", "", "URL of OCSP responder (default the OCSP URL in the certificate)")
+	nonce := fs.Bool("nonce", true, "Send the nonce and check it is echoed, -nonce=false for responders breaking on "+
+		"nonces (default true)")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of the OCSP request (default 10s)")
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca ocsp-check <name or file> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	names := parseArgs(fs, args)
+	if len(names) != 1 {
+		failUsage(fs, "Missing certificate")
+	}
+
+	path := names[0]
+	if _, err := os.Stat(path); err != nil {
+		path = filepath.Join(*output, hostFileName(path)+".crt")
+	}
+
+	certificates, err := readCertificates(path)
+	if err != nil {
+		fail(errorCode(err, exitBadInput), "Failed to read certificate", err)
+	}
+
+	ca, err := readCertificates(resolveCA(*caFlag, *output) + ".crt")
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to read ca certificate", err)
+	}
+
+	url := *responder
+	if url == "" {
+		if len(certificates[0].OCSPServer) == 0 {
+			failUsage(fs, "Missing OCSP URL, the certificate has no OCSP URL")
+		}
+		url = certificates[0].OCSPServer[0]
+	}
+
+	var sent []byte
+	if *nonce {
+		sent = make([]byte, 16)
+		_, err = rand.Read(sent)
+		if err != nil {
+			fail(exitCrypto, "Failed to generate nonce", err)
+		}
+	}
+
+	request, err := selfca.NewOCSPRequest(certificates[0], ca[0], sent)
+	if err != nil {
+		fail(exitCrypto, "Failed to create OCSP request", err)
+	}
+
+	client := &http.Client{Timeout: *timeout}
+	rsp, err := client.Post(url, "application/ocsp-request", bytes.NewReader(request))
+	if err != nil {
+		fail(exitIO, "Failed to request "+url, err)
+	}
+	defer rsp.Body.Close()
+
+	data, err := io.ReadAll(io.LimitReader(rsp.Body, maxOCSPRequest))
+	if err != nil {
+		fail(exitIO, "Failed to read OCSP response", err)
+	}
+
+	response, err := ocsp.ParseResponseForCert(data, certificates[0], ca[0])
+	if err != nil {
+		fail(exitCrypto, "Failed to verify OCSP response", err)
+	}
+
+	if *nonce {
+		received, err := selfca.OCSPResponseNonce(data)
+		switch {
+		case err != nil:
+			fail(exitCrypto, "Failed to parse OCSP response", err)
+		case len(received) == 0:
+			fmt.Fprintf(os.Stderr, "WARNING: OCSP response has no nonce, it may be replayed, use -nonce=false if the responder "+
+				"does not support nonces\n")
+		case !bytes.Equal(received, sent):
+			fail(exitCrypto, "Failed to verify OCSP response", errors.New("the nonce mismatched"))
+		}
+	}
+
+	fmt.Printf("Serial: %X\n", response.SerialNumber)
+	fmt.Printf("This update: %s\n", response.ThisUpdate.Format(time.RFC3339))
+	if !response.NextUpdate.IsZero() {
+		fmt.Printf("Next update: %s\n", response.NextUpdate.Format(time.RFC3339))
+	}
+
+	switch response.Status {
+	case ocsp.Good:
+		fmt.Printf("Status: good\n")
+	case ocsp.Revoked:
+		fmt.Printf("Status: revoked at %s, reason %d\n", response.RevokedAt.Format(time.RFC3339), response.RevocationReason)
+		os.Exit(exitFailure)
+	default:
+		fmt.Printf("Status: unknown\n")
+		os.Exit(exitFailure)
+	}
+}
@@ -0,0 +1,318 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+	corev1 "k8s.io/api/core/v1"
+)
+
+const (
+	// operatorRetry is the delay before listing the secrets again after failure
+	operatorRetry = 10 * time.Second
+	// hostsAnnotation is the annotation of secret to be issued, the hosts are comma separated
+	hostsAnnotation = "selfca.likexian.com/hosts"
+	// daysAnnotation is the annotation of the valid days of secret
+	daysAnnotation = "selfca.likexian.com/days"
+)
+
+// kubeOperator keeps the annotated secrets issued by the ca
+type kubeOperator struct {
+	kube          *kubeClient
+	namespace     string
+	caPath        string
+	output        string
+	caTemplate    selfca.Certificate
+	caCertificate *x509.Certificate
+	days          int
+	renewBefore   time.Duration
+	fips          bool
+}
+
+// operator keeps the tls secrets annotated with the hosts in kubernetes cluster issued and renewed by the ca
+func operator(args []string) {
+	fs := flag.NewFlagSet("selfca operator", flag.ExitOnError)
+	server := fs.String("server", "", "URL of the kubernetes api server, like http://127.0.0.1:8001 of kubectl proxy "+
+		"(default the cluster running in, or the current context of kubeconfig)")
+	tokenFile := fs.String("token-file", "", "Path of the bearer token file of the api server (default the service "+
+		"account token)")
+	namespace := fs.String("namespace", "", "Namespace of the secrets to watch (default all namespaces)")
+	days := fs.Int("d", 90, "Valid days of the certificates, unless annotated with "+daysAnnotation+" (default 90 days)")
+	renewBefore := fs.Duration("renew-before", 30*24*time.Hour, "Renew the certificates this long before they expire "+
+		"(default 720h)")
+	resync := fs.Duration("resync", 10*time.Minute, "Interval of checking all the secrets for renewal (default 10m)")
+	output := fs.String("o", "cert", "Folder of the ca (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	fips := fs.Bool("fips", false, "Only allow FIPS approved key sizes and signature algorithms")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if *resync < time.Second {
+		failUsage(fs, "The resync parameter must be at least 1s")
+	}
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	kube, err := newKubeClient(*server, *tokenFile)
+	if err != nil {
+		fail(exitBadInput, "Failed to connect to kubernetes", err)
+	}
+
+	if len(*output) == 0 {
+		*output = "cert"
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	o := &kubeOperator{
+		kube:        kube,
+		namespace:   *namespace,
+		caPath:      resolveCA(*caFlag, *output),
+		caTemplate:  caConfig,
+		output:      *output,
+		days:        *days,
+		renewBefore: *renewBefore,
+		fips:        *fips,
+	}
+
+	// the ca is created if missing, only its certificate is kept for checking the secrets
+	err = o.loadCA(func(*x509.Certificate, crypto.Signer) error { return nil })
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to load the ca", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stop := stopSignal()
+	go func() {
+		<-stop
+		cancel()
+	}()
+
+	for ctx.Err() == nil {
+		err := o.run(ctx, *resync)
+		if err != nil && ctx.Err() == nil {
+			log.Printf("Failed to watch the secrets: %v", err)
+			select {
+			case <-ctx.Done():
+			case <-time.After(operatorRetry):
+			}
+		}
+	}
+}
+
+// run checks all the secrets and then watches the changed ones until the resync
+func (o *kubeOperator) run(ctx context.Context, resync time.Duration) error {
+	list, err := o.kube.listSecrets(ctx, o.namespace)
+	if err != nil {
+		return err
+	}
+
+	debugf("Listed %d secrets", len(list.Items))
+	for i := range list.Items {
+		o.reconcile(ctx, &list.Items[i])
+	}
+
+	return o.kube.watchSecrets(ctx, o.namespace, list.ResourceVersion, int(resync.Seconds()), func(s *corev1.Secret) {
+		o.reconcile(ctx, s)
+	})
+}
+
+// annotationHosts parses the comma separated hosts of annotation, and expands the CIDR hosts,
+// the annotation is set by users of the cluster, so it is never read as @file or - like -h
+func annotationHosts(value string) ([]string, error) {
+	var hosts []string
+	for _, v := range strings.Split(value, ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			hosts = append(hosts, v)
+		}
+	}
+
+	return expandHosts(hosts)
+}
+
+// reconcile issues the secret if it is annotated and its certificate is missing,
+// not issued by the ca, for other hosts or about to expire
+func (o *kubeOperator) reconcile(ctx context.Context, s *corev1.Secret) {
+	value, ok := s.Annotations[hostsAnnotation]
+	if !ok {
+		return
+	}
+
+	name := s.Namespace + "/" + s.Name
+	hosts, err := annotationHosts(value)
+	if err == nil && len(hosts) == 0 {
+		err = fmt.Errorf("no hosts")
+	}
+	if err != nil {
+		log.Printf("Failed to parse %s annotation of %s: %v", hostsAnnotation, name, err)
+		return
+	}
+
+	days := o.days
+	if v, ok := s.Annotations[daysAnnotation]; ok {
+		days, err = strconv.Atoi(v)
+		if err != nil || days <= 0 {
+			log.Printf("Failed to parse %s annotation of %s: invalid days %q", daysAnnotation, name, v)
+			return
+		}
+	}
+
+	reason := o.renewReason(s, hosts)
+	if reason == "" {
+		return
+	}
+
+	debugf("Issuing %s, %s", name, reason)
+	issuance, err := o.issue(hosts, days)
+	if err != nil {
+		log.Printf("Failed to issue %s: %v", name, err)
+		return
+	}
+
+	defer issuance.Zero()
+	err = o.kube.patchSecretData(ctx, s, map[string][]byte{
+		"tls.crt": selfca.ChainPEM(append([]*x509.Certificate{issuance.Certificate}, issuance.Chain...), false),
+		"tls.key": issuance.KeyPEM,
+		"ca.crt":  pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: o.caCertificate.Raw}),
+	})
+	if err != nil {
+		log.Printf("Failed to update %s: %v", name, err)
+		return
+	}
+
+	if !quiet {
+		log.Printf("Issued %s, valid until %s", name, issuance.Certificate.NotAfter.Format(time.RFC3339))
+	}
+}
+
+// renewReason returns why the secret needs to be issued, empty if it is not needed
+func (o *kubeOperator) renewReason(s *corev1.Secret, hosts []string) string {
+	block, _ := pem.Decode(s.Data["tls.crt"])
+	if block == nil {
+		return "certificate is missing"
+	}
+
+	c, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "certificate is invalid"
+	}
+
+	if c.CheckSignatureFrom(o.caCertificate) != nil {
+		return "certificate is not issued by the ca"
+	}
+
+	current, wanted := certificateHosts(c), editHosts(nil, hosts, nil)
+	if len(wanted) != len(current) || len(editHosts(current, wanted, nil)) != len(current) {
+		return "hosts are changed"
+	}
+
+	if time.Until(c.NotAfter) < o.renewBefore {
+		return "certificate is about to expire"
+	}
+
+	return ""
+}
+
+// loadCA loads the ca with the ca folder locked and calls f, the ca key is zeroed after,
+// like the daemon the operator keeps no ca key between issuances
+func (o *kubeOperator) loadCA(f func(*x509.Certificate, crypto.Signer) error) error {
+	unlockCA, err := lockCA(o.caPath, o.output)
+	if err != nil {
+		return err
+	}
+
+	defer unlockCA()
+
+	template := o.caTemplate
+	template.NotBefore = time.Now()
+	certificate, key, err := loadCA(o.caPath, template, false)
+	if err != nil {
+		return err
+	}
+
+	defer selfca.ZeroKey(key)
+	o.caCertificate = certificate
+
+	return f(certificate, key)
+}
+
+// issue issues the certificate of hosts signed by the ca, and records it in the ca database
+func (o *kubeOperator) issue(hosts []string, days int) (issuance *selfca.Issuance, err error) {
+	now := time.Now()
+	config := selfca.Certificate{
+		Hosts:     hosts,
+		NotBefore: now,
+		NotAfter:  now.Add(time.Duration(days*24) * time.Hour),
+	}
+
+	config.KeySize = selfca.DefaultKeySize(config.KeyType)
+	err = checkWeak(config, false)
+	if err == nil {
+		err = checkFIPS(config, o.fips)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	err = o.loadCA(func(certificate *x509.Certificate, key crypto.Signer) error {
+		config.CACertificate, config.CAKey = certificate, key
+		if config.NotAfter.After(certificate.NotAfter) {
+			config.NotAfter = certificate.NotAfter
+		}
+
+		issuance, err = selfca.Issue(config)
+		if err != nil {
+			return err
+		}
+
+		err = recordCertificate(o.caPath, issuance.Certificate)
+		if err != nil {
+			issuance.Zero()
+		}
+
+		return err
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return issuance, nil
+}
@@ -0,0 +1,40 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestAnnotationHosts(t *testing.T) {
+	hosts, err := annotationHosts(" likexian.com,, 10.0.0.0/31 ")
+	assert.Nil(t, err)
+	assert.Equal(t, hosts, []string{"likexian.com", "10.0.0.0", "10.0.0.1"})
+
+	// the annotation is never read as file or stdin
+	hosts, err = annotationHosts("-")
+	assert.Nil(t, err)
+	assert.Equal(t, hosts, []string{"-"})
+
+	_, err = annotationHosts("@/etc/passwd")
+	assert.NotNil(t, err)
+}
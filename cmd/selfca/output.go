@@ -0,0 +1,127 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// progressDelay is how long an operation runs before its progress is shown
+const progressDelay = time.Second
+
+var (
+	// quiet is whether to print errors only
+	quiet bool
+	// verbose is whether to print every step with timing
+	verbose bool
+)
+
+// addOutputFlags adds the flags for quiet and verbose output
+func addOutputFlags(fs *flag.FlagSet) {
+	fs.BoolVar(&quiet, "q", false, "Quiet mode, only print errors")
+	fs.BoolVar(&verbose, "verbose", false, "Verbose mode, print every step with timing")
+}
+
+// infof prints the message to stderr unless in quiet mode
+func infof(format string, args ...any) {
+	if !quiet {
+		printLine(format, args...)
+	}
+}
+
+// debugf prints the message to stderr in verbose mode
+func debugf(format string, args ...any) {
+	if verbose && !quiet {
+		printLine(format, args...)
+	}
+}
+
+// progressLine is held by the progress showing dots on the line
+var progressLine sync.Mutex
+
+// printLine prints the message to stderr after the progress dots are done
+func printLine(format string, args ...any) {
+	progressLine.Lock()
+	defer progressLine.Unlock()
+
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+}
+
+// progress shows the message with progress dots if the operation is slow,
+// or at once in verbose mode, the returned function marks it as done,
+// concurrent operations show a line when done instead of dots
+func progress(format string, args ...any) func() {
+	if quiet {
+		return func() {}
+	}
+
+	message := fmt.Sprintf(format, args...)
+	start := time.Now()
+	done := make(chan struct{})
+	finished := make(chan struct{})
+
+	go func() {
+		defer close(finished)
+
+		owned := false
+		show := func() {
+			if progressLine.TryLock() {
+				owned = true
+				fmt.Fprint(os.Stderr, message+" ...")
+			}
+		}
+
+		if verbose {
+			show()
+		}
+
+		ticker := time.NewTicker(progressDelay)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-done:
+				elapsed := time.Since(start).Round(time.Millisecond)
+				if owned {
+					fmt.Fprintf(os.Stderr, " done in %s\n", elapsed)
+					progressLine.Unlock()
+				} else if verbose || elapsed >= progressDelay {
+					printLine("%s ... done in %s", message, elapsed)
+				}
+				return
+			case <-ticker.C:
+				if owned {
+					fmt.Fprint(os.Stderr, ".")
+				} else {
+					show()
+				}
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		<-finished
+	}
+}
@@ -0,0 +1,86 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"os"
+
+	"github.com/likexian/selfca"
+)
+
+// passphraseEnv is the environment variable of the passphrase
+const passphraseEnv = "SELFCA_PASSPHRASE"
+
+// passphrase is the passphrase of the encrypted keys, nil if the keys are not encrypted
+var passphrase []byte
+
+// addPassphraseFlags adds the -passphrase and -passphrase-file flags of the encrypted keys
+func addPassphraseFlags(fs *flag.FlagSet) {
+	if v := os.Getenv(passphraseEnv); v != "" {
+		passphrase = []byte(v)
+	}
+
+	fs.Func("passphrase", "Passphrase of the encrypted keys, the created ca key is encrypted by it, prefer "+
+		"-passphrase-file or $"+passphraseEnv+" to keep it out of process list", func(s string) error {
+		passphrase = []byte(s)
+		return nil
+	})
+	fs.Func("passphrase-file", "File of the passphrase, the first line is used", func(s string) error {
+		return readPassphrase(s)
+	})
+}
+
+// readPassphrase reads the passphrase from the first line of file
+func readPassphrase(name string) error {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return err
+	}
+
+	line, _, _ := bytes.Cut(data, []byte("\n"))
+	line = bytes.TrimRight(line, "\r")
+	if len(line) == 0 {
+		return errors.New("the passphrase file is empty")
+	}
+
+	passphrase = line
+
+	return nil
+}
+
+// readCA reads the ca certificate and key from path, decrypting the key by the passphrase
+func readCA(path string) ([]*x509.Certificate, crypto.Signer, error) {
+	return selfca.ReadCertificateWithPassphrase(path, passphrase)
+}
+
+// keyEncrypted returns whether the key file of name is encrypted
+func keyEncrypted(name string) bool {
+	data, err := os.ReadFile(name + ".key")
+	if err != nil {
+		return false
+	}
+
+	return bytes.Contains(data, []byte("ENCRYPTED"))
+}
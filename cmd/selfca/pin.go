@@ -0,0 +1,216 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// pinDomain is the domain to pin, the wildcard is pinned as its parent with subdomains
+type pinDomain struct {
+	Name       string
+	Subdomains bool
+}
+
+// pin writes the pinning configs of OkHttp, Android and iOS for the certificate or ca
+func pin(args []string) {
+	fs := flag.NewFlagSet("selfca pin", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the ca and certificates (default cert)")
+	caFlag := addCAFlag(fs)
+	out := fs.String("out", "", "Folder for saving the pinning configs (default pin/<name> in the ca folder)")
+	hosts := fs.String("h", "", "Domains to pin, comma separated (default the domains of certificate)")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca pin <name> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	names := parseArgs(fs, args)
+	if len(names) != 1 {
+		failUsage(fs, "Missing certificate name")
+	}
+
+	ca, err := readCertificates(resolveCA(*caFlag, *output) + ".crt")
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to load ca certificate", err)
+	}
+
+	// the ca is pinned as backup of the leaf, or alone if it is the name
+	var leaf *x509.Certificate
+	domains := []string{}
+	if names[0] != "ca" {
+		certificates, err := readCertificates(filepath.Join(*output, names[0]+".crt"))
+		if err != nil {
+			fail(errorCode(err, exitBadInput), "Failed to load the certificate", err)
+		}
+		leaf = certificates[0]
+		domains = leaf.DNSNames
+	}
+
+	if *hosts != "" {
+		domains = strings.Split(*hosts, ",")
+	}
+
+	if len(domains) == 0 {
+		failUsage(fs, "Missing domains to pin, set by -h")
+	}
+
+	if *out == "" {
+		*out = filepath.Join(*output, "pin", names[0])
+	}
+
+	expiration := ca[0].NotAfter
+	var leafPins []string
+	if leaf != nil {
+		expiration = leaf.NotAfter
+		leafPins = append(leafPins, spkiPin(leaf))
+	}
+	caPins := []string{spkiPin(ca[0])}
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"CertificatePinner.kt", okhttpPinner(pinDomains(domains), append(leafPins, caPins...))},
+		{"network_security_config.xml", androidPinning(pinDomains(domains), append(leafPins, caPins...), expiration)},
+		{"NSPinnedDomains.plist", atsPinning(pinDomains(domains), leafPins, caPins)},
+		{"selfca_ca.pem", chainedPEM(ca[0], nil)},
+	}
+
+	err = os.MkdirAll(*out, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	for _, v := range files {
+		err = os.WriteFile(filepath.Join(*out, v.name), v.data, 0644)
+		if err != nil {
+			fail(exitIO, "Failed to write pinning config", err)
+		}
+		debugf("Wrote %s", filepath.Join(*out, v.name))
+	}
+
+	infof("Wrote pinning configs to %s", *out)
+}
+
+// spkiPin returns the base64 SHA-256 of certificate public key info
+func spkiPin(c *x509.Certificate) string {
+	sum := sha256.Sum256(c.RawSubjectPublicKeyInfo)
+	return base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// pinDomains returns the domains to pin, without duplicates
+func pinDomains(hosts []string) []pinDomain {
+	seen := map[string]bool{}
+	var domains []pinDomain
+	for _, v := range hosts {
+		d := pinDomain{Name: strings.ToLower(strings.TrimSpace(v))}
+		if strings.HasPrefix(d.Name, "*.") {
+			d = pinDomain{Name: d.Name[2:], Subdomains: true}
+		}
+		if d.Name == "" || seen[d.Name] {
+			continue
+		}
+		seen[d.Name] = true
+		domains = append(domains, d)
+	}
+
+	return domains
+}
+
+// okhttpPinner returns the Kotlin snippet of OkHttp CertificatePinner
+func okhttpPinner(domains []pinDomain, pins []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("val certificatePinner = CertificatePinner.Builder()\n")
+	for _, d := range domains {
+		pattern := d.Name
+		if d.Subdomains {
+			pattern = "**." + d.Name
+		}
+		for _, p := range pins {
+			fmt.Fprintf(&b, "    .add(\"%s\", \"sha256/%s\")\n", pattern, p)
+		}
+	}
+	b.WriteString("    .build()\n")
+
+	return b.Bytes()
+}
+
+// androidPinning returns the Android network security config pinning the domains,
+// the ca is trusted from raw resource selfca_ca
+func androidPinning(domains []pinDomain, pins []string, expiration time.Time) []byte {
+	var b bytes.Buffer
+	b.WriteString("<?xml version=\"1.0\" encoding=\"utf-8\"?>\n<network-security-config>\n")
+	b.WriteString("    <domain-config>\n")
+	for _, d := range domains {
+		fmt.Fprintf(&b, "        <domain includeSubdomains=\"%t\">%s</domain>\n", d.Subdomains, xmlEscape(d.Name))
+	}
+	fmt.Fprintf(&b, "        <pin-set expiration=\"%s\">\n", expiration.UTC().Format("2006-01-02"))
+	for _, p := range pins {
+		fmt.Fprintf(&b, "            <pin digest=\"SHA-256\">%s</pin>\n", p)
+	}
+	b.WriteString("        </pin-set>\n")
+	b.WriteString("        <trust-anchors>\n")
+	b.WriteString("            <certificates src=\"@raw/selfca_ca\" />\n")
+	b.WriteString("        </trust-anchors>\n")
+	b.WriteString("    </domain-config>\n</network-security-config>\n")
+
+	return b.Bytes()
+}
+
+// atsPinning returns the NSPinnedDomains fragment of iOS App Transport Security
+func atsPinning(domains []pinDomain, leafPins, caPins []string) []byte {
+	var b bytes.Buffer
+	b.WriteString("<key>NSPinnedDomains</key>\n<dict>\n")
+	for _, d := range domains {
+		fmt.Fprintf(&b, "\t<key>%s</key>\n\t<dict>\n", xmlEscape(d.Name))
+		if d.Subdomains {
+			b.WriteString("\t\t<key>NSIncludesSubdomains</key>\n\t\t<true/>\n")
+		}
+		for _, v := range []struct {
+			key  string
+			pins []string
+		}{{"NSPinnedLeafIdentities", leafPins}, {"NSPinnedCAIdentities", caPins}} {
+			if len(v.pins) == 0 {
+				continue
+			}
+			fmt.Fprintf(&b, "\t\t<key>%s</key>\n\t\t<array>\n", v.key)
+			for _, p := range v.pins {
+				fmt.Fprintf(&b, "\t\t\t<dict>\n\t\t\t\t<key>SPKI-SHA256-BASE64</key>\n"+
+					"\t\t\t\t<string>%s</string>\n\t\t\t</dict>\n", p)
+			}
+			b.WriteString("\t\t</array>\n")
+		}
+		b.WriteString("\t</dict>\n")
+	}
+	b.WriteString("</dict>\n")
+
+	return b.Bytes()
+}
@@ -0,0 +1,250 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// pluginTimeout is the timeout of running a plugin
+const pluginTimeout = 30 * time.Second
+
+// maxPluginOutput is the maximum size of plugin output
+const maxPluginOutput = 1024 * 1024
+
+// The points plugins are run at
+const (
+	// pluginMutate is before checking the request, the plugin can change the request
+	pluginMutate = "mutate"
+	// pluginPolicy is after the request is checked, the plugin can refuse it
+	pluginPolicy = "policy"
+	// pluginIssued is after the certificate is issued and written
+	pluginIssued = "issued"
+)
+
+// errRefusedByPlugin is the request refused by policy plugin error
+var errRefusedByPlugin = errors.New("refused by plugin")
+
+// pluginRequest is the certificate request passed to plugins, and returned by mutate plugins
+type pluginRequest struct {
+	Name       string    `json:"name"`
+	CommonName string    `json:"common_name"`
+	Hosts      []string  `json:"hosts"`
+	UPNs       []string  `json:"upns"`
+	IsCA       bool      `json:"is_ca"`
+	KeyType    string    `json:"key_type"`
+	Bits       int       `json:"bits"`
+	Profile    string    `json:"profile"`
+	NotBefore  time.Time `json:"not_before"`
+	NotAfter   time.Time `json:"not_after"`
+}
+
+// pluginCertificate is the issued certificate passed to issued plugins
+type pluginCertificate struct {
+	Serial            string    `json:"serial"`
+	SHA256Fingerprint string    `json:"sha256_fingerprint"`
+	NotAfter          time.Time `json:"not_after"`
+	PEM               string    `json:"pem"`
+	CertificateFile   string    `json:"certificate_file"`
+	KeyFile           string    `json:"key_file"`
+}
+
+// pluginInput is the json written to stdin of plugins
+type pluginInput struct {
+	Point       string             `json:"point"`
+	Request     pluginRequest      `json:"request"`
+	Certificate *pluginCertificate `json:"certificate,omitempty"`
+}
+
+// pluginOutput is the json read from stdout of plugins, empty output changes nothing
+type pluginOutput struct {
+	// Request replaces the request, only by mutate plugins
+	Request *pluginRequest `json:"request"`
+	// Allow refuses the request if false, only by policy plugins
+	Allow *bool `json:"allow"`
+	// Reason is why the request is refused
+	Reason string `json:"reason"`
+}
+
+// newPluginRequest returns the plugin request of issue request
+func newPluginRequest(r issueRequest) pluginRequest {
+	return pluginRequest{
+		Name:       requestName(r),
+		CommonName: r.Config.CommonName,
+		Hosts:      r.Config.Hosts,
+		UPNs:       r.Config.UPNs,
+		IsCA:       r.Config.IsCA,
+		KeyType:    string(r.Config.KeyType),
+		Bits:       r.Config.KeySize,
+		Profile:    string(r.Config.Profile),
+		NotBefore:  r.Config.NotBefore,
+		NotAfter:   r.Config.NotAfter,
+	}
+}
+
+// mutatePlugins runs the mutate plugins one by one, each sees the request changed by the previous,
+// the name, subject, hosts, key and validity of request are replaced by the returned request
+func mutatePlugins(r *issueRequest) error {
+	for _, v := range r.Plugins {
+		out, err := runPlugin(v, pluginInput{Point: pluginMutate, Request: newPluginRequest(*r)})
+		if err != nil {
+			return err
+		}
+		if out.Request == nil {
+			continue
+		}
+
+		m := out.Request
+		if m.KeyType != "" && !validKeyType(selfca.KeyType(m.KeyType)) {
+			return fmt.Errorf("%s: unsupported key type %s", v, m.KeyType)
+		}
+		if m.Profile != "" && !validProfile(selfca.Profile(m.Profile)) {
+			return fmt.Errorf("%s: unsupported profile %s", v, m.Profile)
+		}
+		if strings.ContainsAny(m.Name, `/\`) {
+			return fmt.Errorf("%s: invalid name %s", v, m.Name)
+		}
+		if !m.NotAfter.After(m.NotBefore) {
+			return fmt.Errorf("%s: not_after is not after not_before", v)
+		}
+
+		r.Name = m.Name
+		r.Config.CommonName = m.CommonName
+		r.Config.Hosts = m.Hosts
+		r.Config.UPNs = m.UPNs
+		r.Config.KeyType = selfca.KeyType(m.KeyType)
+		r.Config.KeySize = m.Bits
+		r.Config.Profile = selfca.Profile(m.Profile)
+		r.Config.NotBefore = m.NotBefore
+		r.Config.NotAfter = m.NotAfter
+		debugf("Plugin %s mutated the request of %s", v, requestName(*r))
+	}
+
+	return nil
+}
+
+// policyPlugins runs the policy plugins, returns the refusal of the first plugin refusing the request
+func policyPlugins(r issueRequest) error {
+	for _, v := range r.Plugins {
+		out, err := runPlugin(v, pluginInput{Point: pluginPolicy, Request: newPluginRequest(r)})
+		if err != nil {
+			return err
+		}
+		if out.Allow != nil && !*out.Allow {
+			if out.Reason == "" {
+				out.Reason = "no reason given"
+			}
+			return fmt.Errorf("%w %s: %s", errRefusedByPlugin, v, out.Reason)
+		}
+	}
+
+	return nil
+}
+
+// issuedPlugins runs the issued plugins with the issued certificate
+func issuedPlugins(r issueRequest, i *selfca.Issuance) error {
+	c := &pluginCertificate{
+		Serial:            i.Certificate.SerialNumber.Text(16),
+		SHA256Fingerprint: i.SHA256Fingerprint,
+		NotAfter:          i.Certificate.NotAfter,
+		PEM:               string(i.PEM),
+		CertificateFile:   i.CertificateFile,
+		KeyFile:           i.KeyFile,
+	}
+
+	for _, v := range r.Plugins {
+		_, err := runPlugin(v, pluginInput{Point: pluginIssued, Request: newPluginRequest(r), Certificate: c})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runPlugin runs the plugin command with the shell, writes the input json to its stdin,
+// and reads the output json from its stdout, the plugin fails if it exits with non-zero,
+// the plugin ending with .star is run by the embedded Starlark interpreter instead
+func runPlugin(plugin string, in pluginInput) (pluginOutput, error) {
+	if isScriptPlugin(plugin) {
+		return runScript(plugin, in)
+	}
+
+	var out pluginOutput
+
+	data, err := json.Marshal(in)
+	if err != nil {
+		return out, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), pluginTimeout)
+	defer cancel()
+
+	debugf("Running plugin %s at %s", plugin, in.Point)
+	var stdout bytes.Buffer
+	cmd := shellCommand(ctx, plugin)
+	cmd.Env = append(os.Environ(), "SELFCA_PLUGIN_POINT="+in.Point)
+	cmd.Stdin = bytes.NewReader(data)
+	cmd.Stdout = &limitedWriter{w: &stdout, n: maxPluginOutput}
+	cmd.Stderr = os.Stderr
+
+	err = cmd.Run()
+	if err != nil {
+		return out, fmt.Errorf("%s: %w", plugin, err)
+	}
+
+	if len(bytes.TrimSpace(stdout.Bytes())) == 0 {
+		return out, nil
+	}
+
+	err = json.Unmarshal(stdout.Bytes(), &out)
+	if err != nil {
+		return out, fmt.Errorf("%s: invalid output: %w", plugin, err)
+	}
+
+	return out, nil
+}
+
+// limitedWriter writes at most n bytes to w, and fails after
+type limitedWriter struct {
+	w io.Writer
+	n int
+}
+
+// Write writes p to w if within the limit
+func (l *limitedWriter) Write(p []byte) (int, error) {
+	if len(p) > l.n {
+		return 0, fmt.Errorf("output exceeds %d bytes", maxPluginOutput)
+	}
+
+	l.n -= len(p)
+
+	return l.w.Write(p)
+}
@@ -0,0 +1,103 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// checkWeak refuses the weak parameters, only warns about them if allowed
+func checkWeak(c selfca.Certificate, allow bool) error {
+	return doCheckWeak("certificate", c, allow)
+}
+
+// checkWeakCA refuses the weak ca certificate, only warns about it if allowed
+func checkWeakCA(c selfca.Certificate, allow bool) error {
+	return doCheckWeak("ca certificate", selfca.Certificate{
+		NotBefore:     c.CACertificate.NotBefore,
+		NotAfter:      c.CACertificate.NotAfter,
+		CAKey:         c.CAKey,
+		CACertificate: c.CACertificate,
+	}, allow)
+}
+
+// doCheckWeak checks the weak parameters of certificate named by what
+func doCheckWeak(what string, c selfca.Certificate, allow bool) error {
+	err := selfca.CheckWeak(c)
+	if err == nil {
+		return nil
+	}
+
+	reason := strings.ReplaceAll(err.Error(), "\n", ", ")
+	if !allow {
+		return fmt.Errorf("%s, use -insecure-allow-weak to allow it for testing", reason)
+	}
+
+	fmt.Fprintf(os.Stderr, "WARNING: %s: %s, DO NOT use it in production\n", what, reason)
+
+	return nil
+}
+
+// checkFIPS refuses the parameters not FIPS approved if enabled
+func checkFIPS(c selfca.Certificate, enabled bool) error {
+	if !enabled {
+		return nil
+	}
+
+	err := selfca.CheckFIPS(c)
+	if err != nil {
+		return errors.New(strings.ReplaceAll(err.Error(), "\n", ", "))
+	}
+
+	return nil
+}
+
+// checkCAValidity clamps the validity to the ca validity with a warning, or refuses it if strict
+func checkCAValidity(c *selfca.Certificate, strict bool) error {
+	err := selfca.CheckCAValidity(*c)
+	if err == nil {
+		return nil
+	}
+
+	issuer := c.CACertificate
+	if c.Parent != nil {
+		issuer = c.Parent.Certificate
+	}
+
+	window := fmt.Sprintf("%s - %s", issuer.NotBefore.Format(time.RFC3339), issuer.NotAfter.Format(time.RFC3339))
+	if strict {
+		return fmt.Errorf("%w %s", err, window)
+	}
+
+	*c = selfca.ClampValidity(*c)
+	if !c.NotAfter.After(c.NotBefore) {
+		return fmt.Errorf("%w %s", err, window)
+	}
+
+	fmt.Fprintf(os.Stderr, "WARNING: certificate validity is clamped to the ca validity %s\n", window)
+
+	return nil
+}
@@ -0,0 +1,239 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+	"github.com/pion/dtls/v2"
+)
+
+// probeState is the negotiated parameters and the presented chain of the server
+type probeState struct {
+	remote      net.Addr
+	protocol    string
+	cipherSuite string
+	alpn        string
+	resumed     bool
+	stapled     bool
+	chain       []*x509.Certificate
+}
+
+// probe performs the TLS or DTLS handshake with server, prints the negotiated parameters
+// and verifies the presented chain against the ca
+func probe(args []string) {
+	fs := flag.NewFlagSet("selfca probe", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the ca and certificates (default cert)")
+	caFlag := addCAFlag(fs)
+	clientCert := fs.String("client-cert", "", "Certificate name in the ca folder to authenticate as client")
+	serverName := fs.String("servername", "", "Server name for SNI and verification (default the host)")
+	timeout := fs.Duration("timeout", 10*time.Second, "Timeout of connecting and handshake")
+	useDTLS := fs.Bool("dtls", false, "Perform DTLS 1.2 handshake on UDP instead of TLS on TCP")
+	var protocols stringsFlag
+	fs.Var(&protocols, "alpn", "ALPN protocol to offer, like h2, may be repeated")
+	addPassphraseFlags(fs)
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca probe <host:port> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	addrs := parseArgs(fs, args)
+	if len(addrs) != 1 {
+		failUsage(fs, "Missing server address")
+	}
+
+	addr := addrs[0]
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		port := "443"
+		if *useDTLS {
+			port = "5684"
+		}
+		host, addr = addr, net.JoinHostPort(addr, port)
+	}
+
+	if *serverName == "" {
+		*serverName = host
+	}
+
+	certificates, err := readCertificates(resolveCA(*caFlag, *output) + ".crt")
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to load ca certificate", err)
+	}
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificates[0])
+
+	config := &tls.Config{
+		ServerName: *serverName,
+		NextProtos: protocols,
+		// the chain is verified against the ca after handshake, for reporting the details
+		InsecureSkipVerify: true,
+	}
+
+	if *clientCert != "" {
+		chain, key, err := selfca.ReadCertificateWithPassphrase(filepath.Join(*output, *clientCert), passphrase)
+		if err != nil {
+			fail(errorCode(err, exitBadInput), "Failed to load client certificate", err)
+		}
+		certificate := tls.Certificate{PrivateKey: key, Leaf: chain[0]}
+		for _, v := range chain {
+			certificate.Certificate = append(certificate.Certificate, v.Raw)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+
+	handshake := probeTLS
+	if *useDTLS {
+		handshake = probeDTLS
+	}
+
+	state, err := handshake(addr, config, *timeout)
+	if err != nil {
+		fail(exitFailure, "Failed to handshake with "+addr, err)
+	}
+
+	printProbe(addr, state, *clientCert)
+
+	intermediates := x509.NewCertPool()
+	for _, v := range state.chain[1:] {
+		intermediates.AddCert(v)
+	}
+
+	_, err = state.chain[0].Verify(x509.VerifyOptions{
+		DNSName:       *serverName,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	if err != nil {
+		var unknown x509.UnknownAuthorityError
+		if errors.As(err, &unknown) {
+			err = fmt.Errorf("the chain is not issued by the ca: %w", err)
+		}
+		fail(exitCrypto, "Verification failed", err)
+	}
+
+	fmt.Printf("Verification: OK, issued by the ca and valid for %s\n", *serverName)
+}
+
+// probeTLS performs the TLS handshake on TCP, returns the negotiated state
+func probeTLS(addr string, config *tls.Config, timeout time.Duration) (*probeState, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	conn, err := tls.DialWithDialer(dialer, "tcp", addr, config)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+
+	return &probeState{
+		remote:      conn.RemoteAddr(),
+		protocol:    tls.VersionName(state.Version),
+		cipherSuite: tls.CipherSuiteName(state.CipherSuite),
+		alpn:        state.NegotiatedProtocol,
+		resumed:     state.DidResume,
+		stapled:     len(state.OCSPResponse) > 0,
+		chain:       state.PeerCertificates,
+	}, nil
+}
+
+// probeDTLS performs the DTLS 1.2 handshake on UDP, returns the negotiated state,
+// the cipher suite is not reported by the DTLS implementation
+func probeDTLS(addr string, config *tls.Config, timeout time.Duration) (*probeState, error) {
+	raddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	conn, err := dtls.DialWithContext(ctx, "udp", raddr, &dtls.Config{
+		Certificates:         config.Certificates,
+		ServerName:           config.ServerName,
+		SupportedProtocols:   config.NextProtos,
+		InsecureSkipVerify:   config.InsecureSkipVerify,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	})
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	state := conn.ConnectionState()
+	result := &probeState{
+		remote:   conn.RemoteAddr(),
+		protocol: "DTLS 1.2",
+		alpn:     state.NegotiatedProtocol,
+	}
+
+	for _, v := range state.PeerCertificates {
+		c, err := x509.ParseCertificate(v)
+		if err != nil {
+			return nil, err
+		}
+		result.chain = append(result.chain, c)
+	}
+
+	if len(result.chain) == 0 {
+		return nil, errors.New("no certificate presented")
+	}
+
+	return result, nil
+}
+
+// printProbe prints the negotiated parameters and the presented chain
+func printProbe(addr string, state *probeState, clientCert string) {
+	fmt.Printf("Connected to %s (%s)\n", addr, state.remote)
+	fmt.Printf("Protocol: %s\n", state.protocol)
+	if state.cipherSuite != "" {
+		fmt.Printf("Cipher suite: %s\n", state.cipherSuite)
+	}
+	if state.alpn != "" {
+		fmt.Printf("ALPN: %s\n", state.alpn)
+	}
+	fmt.Printf("Resumed: %t\n", state.resumed)
+	fmt.Printf("OCSP stapled: %t\n", state.stapled)
+	if clientCert != "" {
+		fmt.Printf("Client certificate: %s\n", clientCert)
+	}
+
+	fmt.Printf("Chain:\n")
+	for i, v := range state.chain {
+		fmt.Printf("  %d subject: %s\n", i, v.Subject)
+		fmt.Printf("    issuer: %s\n", v.Issuer)
+		fmt.Printf("    valid: %s to %s\n", v.NotBefore.Format(time.RFC3339), v.NotAfter.Format(time.RFC3339))
+		if hosts := certificateHosts(v); len(hosts) > 0 {
+			fmt.Printf("    hosts: %s\n", strings.Join(hosts, ", "))
+		}
+	}
+}
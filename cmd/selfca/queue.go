@@ -0,0 +1,222 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/nats-io/nats.go"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// queueTimeout is the timeout of connecting and writing to the queue
+	queueTimeout = 10 * time.Second
+	// redisBlock is how long the redis read blocks waiting for requests
+	redisBlock = 5 * time.Second
+)
+
+// queue is the message queue the worker receives the requests from and publishes the results to
+type queue interface {
+	// receive returns the next request, blocking until one arrives
+	receive() (*queueMessage, error)
+	// publish publishes the result of request, and acknowledges the request
+	publish(m *queueMessage, result []byte) error
+	// close closes the connection
+	close() error
+}
+
+// queueMessage is the request received from queue
+type queueMessage struct {
+	// id is the redis stream entry id, or the nats subject
+	id string
+	// reply is the nats reply subject, empty if not a request
+	reply string
+	// data is the request payload
+	data []byte
+}
+
+// queueConfig is the queue to connect
+type queueConfig struct {
+	// url is redis://[:password@]host:port/db or nats://[user:password@]host:port
+	url *url.URL
+	// requests is the redis stream or nats subject of requests
+	requests string
+	// results is the redis stream or nats subject of results, the nats requests with reply subject are replied
+	results string
+	// group is the redis consumer group or nats queue group
+	group string
+}
+
+// dialQueue connects to the redis or nats queue of config
+func dialQueue(c queueConfig) (queue, error) {
+	switch c.url.Scheme {
+	case "redis":
+		return dialRedis(c)
+	case "nats":
+		return dialNATS(c)
+	}
+
+	return nil, fmt.Errorf("unsupported queue %s, only redis and nats", c.url.Scheme)
+}
+
+// redisQueue consumes the requests from redis stream by consumer group
+type redisQueue struct {
+	client   *redis.Client
+	config   queueConfig
+	consumer string
+}
+
+// dialRedis connects to redis, and creates the consumer group if not exists
+func dialRedis(c queueConfig) (*redisQueue, error) {
+	options, err := redis.ParseURL(c.url.String())
+	if err != nil {
+		return nil, err
+	}
+
+	options.DialTimeout, options.WriteTimeout = queueTimeout, queueTimeout
+	options.MaxRetries = -1
+
+	name, _ := os.Hostname()
+	q := &redisQueue{client: redis.NewClient(options), config: c, consumer: fmt.Sprintf("%s-%d", name, os.Getpid())}
+	err = q.client.XGroupCreateMkStream(context.Background(), c.requests, c.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") {
+		q.client.Close()
+		return nil, err
+	}
+
+	return q, nil
+}
+
+// receive reads the next entry of stream by the consumer group, the request is in field request
+func (q *redisQueue) receive() (*queueMessage, error) {
+	for {
+		streams, err := q.client.XReadGroup(context.Background(), &redis.XReadGroupArgs{
+			Group:    q.config.group,
+			Consumer: q.consumer,
+			Streams:  []string{q.config.requests, ">"},
+			Count:    1,
+			Block:    redisBlock,
+		}).Result()
+		if errors.Is(err, redis.Nil) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		if len(streams) == 0 || len(streams[0].Messages) == 0 {
+			continue
+		}
+
+		entry := streams[0].Messages[0]
+		m := &queueMessage{id: entry.ID}
+		if v, ok := entry.Values["request"].(string); ok {
+			m.data = []byte(v)
+		}
+
+		return m, nil
+	}
+}
+
+// publish adds the result to the results stream with the request id, and acknowledges the request
+func (q *redisQueue) publish(m *queueMessage, result []byte) error {
+	ctx := context.Background()
+	err := q.client.XAdd(ctx, &redis.XAddArgs{
+		Stream: q.config.results,
+		Values: []string{"request_id", m.id, "result", string(result)},
+	}).Err()
+	if err != nil {
+		return err
+	}
+
+	return q.client.XAck(ctx, q.config.requests, q.config.group, m.id).Err()
+}
+
+// close closes the connection
+func (q *redisQueue) close() error {
+	return q.client.Close()
+}
+
+// natsQueue consumes the requests from nats subject by queue group
+type natsQueue struct {
+	conn   *nats.Conn
+	sub    *nats.Subscription
+	config queueConfig
+}
+
+// dialNATS connects to nats and subscribes to the requests subject in queue group, the worker
+// reconnects by itself so the client does not
+func dialNATS(c queueConfig) (*natsQueue, error) {
+	conn, err := nats.Connect(c.url.String(), nats.Name("selfca worker"), nats.Timeout(queueTimeout),
+		nats.NoReconnect())
+	if err != nil {
+		return nil, err
+	}
+
+	sub, err := conn.QueueSubscribeSync(c.requests, c.group)
+	if err == nil {
+		err = conn.FlushTimeout(queueTimeout)
+	}
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	return &natsQueue{conn: conn, sub: sub, config: c}, nil
+}
+
+// receive returns the next message of the subscription
+func (q *natsQueue) receive() (*queueMessage, error) {
+	msg, err := q.sub.NextMsgWithContext(context.Background())
+	if err != nil {
+		return nil, err
+	}
+
+	return &queueMessage{id: msg.Subject, reply: msg.Reply, data: msg.Data}, nil
+}
+
+// publish replies the result to the reply subject, or publishes to the results subject
+func (q *natsQueue) publish(m *queueMessage, result []byte) error {
+	subject := m.reply
+	if subject == "" {
+		subject = q.config.results
+	}
+
+	err := q.conn.Publish(subject, result)
+	if err != nil {
+		return err
+	}
+
+	return q.conn.FlushTimeout(queueTimeout)
+}
+
+// close closes the connection
+func (q *natsQueue) close() error {
+	q.conn.Close()
+
+	return nil
+}
@@ -0,0 +1,146 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/likexian/gokit/assert"
+	"github.com/redis/go-redis/v9"
+)
+
+func TestRedisQueue(t *testing.T) {
+	server := miniredis.RunT(t)
+	u, err := url.Parse("redis://" + server.Addr() + "/0")
+	assert.Nil(t, err)
+
+	config := queueConfig{url: u, requests: "selfca.requests", results: "selfca.results", group: "selfca"}
+	q, err := dialQueue(config)
+	assert.Nil(t, err)
+	defer q.close()
+
+	// the consumer group exists on the second dial
+	again, err := dialQueue(config)
+	assert.Nil(t, err)
+	assert.Nil(t, again.close())
+
+	client := redis.NewClient(&redis.Options{Addr: server.Addr()})
+	defer client.Close()
+
+	ctx := context.Background()
+	id, err := client.XAdd(ctx, &redis.XAddArgs{Stream: "selfca.requests", Values: []string{"request", "hello"}}).Result()
+	assert.Nil(t, err)
+
+	m, err := q.receive()
+	assert.Nil(t, err)
+	assert.Equal(t, m.id, id)
+	assert.Equal(t, string(m.data), "hello")
+
+	assert.Nil(t, q.publish(m, []byte("world")))
+	results, err := client.XRange(ctx, "selfca.results", "-", "+").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, len(results), 1)
+	assert.Equal(t, results[0].Values["request_id"], id)
+	assert.Equal(t, results[0].Values["result"], "world")
+
+	pending, err := client.XPending(ctx, "selfca.requests", "selfca").Result()
+	assert.Nil(t, err)
+	assert.Equal(t, pending.Count, int64(0))
+}
+
+func TestNATSQueue(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	assert.Nil(t, err)
+	defer ln.Close()
+
+	published := make(chan string, 1)
+	go fakeNATS(ln, published)
+
+	u, err := url.Parse("nats://" + ln.Addr().String())
+	assert.Nil(t, err)
+
+	q, err := dialQueue(queueConfig{url: u, requests: "selfca.requests", results: "selfca.results", group: "selfca"})
+	assert.Nil(t, err)
+	defer q.close()
+
+	m, err := q.receive()
+	assert.Nil(t, err)
+	assert.Equal(t, m.id, "selfca.requests")
+	assert.Equal(t, m.reply, "_INBOX.1")
+	assert.Equal(t, string(m.data), "hello")
+
+	assert.Nil(t, q.publish(m, []byte("world")))
+	assert.Equal(t, <-published, "_INBOX.1 world")
+
+	m, err = q.receive()
+	assert.Nil(t, err)
+	assert.Equal(t, m.reply, "")
+	assert.Equal(t, string(m.data), "hi")
+
+	assert.Nil(t, q.publish(m, []byte("there")))
+	assert.Equal(t, <-published, "selfca.results there")
+}
+
+// fakeNATS serves one nats client, sends two requests once subscribed in queue group,
+// and sends the subject and payload of the publishes to published
+func fakeNATS(ln net.Listener, published chan<- string) {
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+
+	defer conn.Close()
+
+	_, _ = io.WriteString(conn, "INFO {\"server_id\":\"selfca\",\"version\":\"2.10.0\",\"max_payload\":1048576}\r\n")
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadString('\n')
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+		case fields[0] == "PING":
+			_, _ = io.WriteString(conn, "PONG\r\n")
+		case fields[0] == "SUB" && len(fields) == 4 && fields[2] == "selfca":
+			_, _ = fmt.Fprintf(conn, "MSG %s %s _INBOX.1 5\r\nhello\r\nMSG %s %s 2\r\nhi\r\n",
+				fields[1], fields[3], fields[1], fields[3])
+		case fields[0] == "PUB":
+			n, _ := strconv.Atoi(fields[len(fields)-1])
+			data := make([]byte, n+2)
+			_, err = io.ReadFull(r, data)
+			if err != nil {
+				return
+			}
+			published <- fields[1] + " " + string(data[:n])
+		}
+	}
+}
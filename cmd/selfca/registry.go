@@ -0,0 +1,140 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// registry issues the certificate of private docker registry, and installs the ca to
+// the docker certs.d folder of the registry, so docker trusts it without insecure-registries
+func registry(args []string) {
+	fs := flag.NewFlagSet("selfca registry", flag.ExitOnError)
+	host := fs.String("h", "", "Host of the registry, with the port if not 443, like registry.local:5000, and other "+
+		"domains or IPs, comma separated")
+	certsDir := fs.String("certs-d", "/etc/docker/certs.d", "Folder of the docker registry certificates, the ca is "+
+		"written to <host>/ca.crt in it")
+	days := fs.Int("d", 365, "Valid days of the certificate, for example 365 (default 365 days)")
+	output := fs.String("o", "cert", "Folder of the ca and for saving the certificate (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	hosts, err := parseHosts(*host)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse hosts parameter", err)
+	}
+
+	if len(hosts) == 0 {
+		failUsage(fs, "Missing hosts parameter")
+	}
+
+	// the port is part of the certs.d folder name, but not of the certificate
+	registryHost := hosts[0]
+	if h, _, err := net.SplitHostPort(registryHost); err == nil {
+		hosts[0] = h
+	}
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	if len(*output) == 0 {
+		*output = "cert"
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	now := time.Now()
+	issuance, err := issueCertificate(issueRequest{
+		Output:     *output,
+		CA:         *caFlag,
+		CATemplate: caConfig,
+		Config: selfca.Certificate{
+			KeySize:   selfca.DefaultKeySize(selfca.KeyTypeRSA),
+			NotBefore: now,
+			NotAfter:  now.Add(time.Duration(*days*24) * time.Hour),
+			Hosts:     hosts,
+		},
+	})
+	if err != nil {
+		failError(err)
+	}
+
+	defer issuance.Zero()
+
+	root := issuance.Chain[len(issuance.Chain)-1]
+	dir := filepath.Join(*certsDir, registryHost)
+	err = os.MkdirAll(dir, 0755)
+	if err == nil {
+		rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+		err = os.WriteFile(filepath.Join(dir, "ca.crt"), rootPEM, 0644)
+	}
+	if err != nil {
+		fail(exitIO, "Failed to write the ca to docker certs.d, run as root or use -certs-d", err)
+	}
+
+	folder, err := filepath.Abs(*output)
+	if err != nil {
+		fail(exitIO, "Failed to resolve output folder", err)
+	}
+
+	_, port, err := net.SplitHostPort(registryHost)
+	if err != nil {
+		port = "443"
+	}
+
+	infof("Issued %s and wrote the ca to %s, valid until %s", issuance.CertificateFile,
+		filepath.Join(dir, "ca.crt"), issuance.Certificate.NotAfter.Format(time.RFC3339))
+	if quiet {
+		return
+	}
+
+	fmt.Printf(`
+Run the registry with the certificate:
+
+  docker run -d --name registry -p %s:5000 -v %s:/certs \
+    -e REGISTRY_HTTP_TLS_CERTIFICATE=/certs/%s.fullchain.pem \
+    -e REGISTRY_HTTP_TLS_KEY=/certs/%s.key registry:2
+
+Docker reads %s on every connection, no restart is needed.
+If %s is listed in "insecure-registries" of /etc/docker/daemon.json,
+remove it and restart the daemon:
+
+  sudo systemctl restart docker
+
+Copy %s to the same path on every docker host pulling from the registry.
+`, port, folder, hosts[0], hosts[0], dir, registryHost, filepath.Join(dir, "ca.crt"))
+}
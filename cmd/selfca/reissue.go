@@ -0,0 +1,213 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"flag"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// reissue issues a new certificate for the existing key with edited hosts
+func reissue(args []string) {
+	fs := flag.NewFlagSet("selfca reissue", flag.ExitOnError)
+	days := fs.Int("d", 0, "Valid days of the certificate (default same as the existing)")
+	output := fs.String("o", "cert", "Folder of the certificate and ca (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	cert := fs.String("cert", "", "Certificate file to reissue instead of the name, can be combined PEM file with the key")
+	fullChainRoot := fs.Bool("fullchain-root", false, "Include the root ca in the fullchain file")
+	strict := fs.Bool("strict-validity", false, "Refuse validity outside of the ca validity instead of clamping it")
+	weak := fs.Bool("insecure-allow-weak", false, "Allow weak key size, signature and validity, for testing only")
+	fips := fs.Bool("fips", false, "Only allow FIPS approved key sizes and signature algorithms")
+	var addHosts, removeHosts, hooks stringsFlag
+	fs.Var(&addHosts, "add-host", "Domain, IP or CIDR to add to the certificate, can be repeated")
+	fs.Var(&removeHosts, "remove-host", "Domain, IP or CIDR to remove from the certificate, can be repeated")
+	fs.Var(&hooks, "hook", "Command to run after the certificate is issued, can be repeated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca reissue <name>|-cert <file> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	names := parseArgs(fs, args)
+	if len(names) == 0 && *cert != "" {
+		names = []string{strings.TrimSuffix(filepath.Base(*cert), filepath.Ext(*cert))}
+	}
+
+	if len(names) != 1 {
+		failUsage(fs, "Missing certificate name")
+	}
+
+	certPath := fmt.Sprintf("%s/%s", *output, names[0])
+	unlock, err := lockOutput(*output)
+	if err != nil {
+		fail(exitIO, "Failed to lock output folder", err)
+	}
+
+	defer unlock()
+
+	var certificates []*x509.Certificate
+	var key crypto.Signer
+	if *cert != "" {
+		certificates, key, err = selfca.ReadCertificateFileWithPassphrase(*cert,
+			strings.TrimSuffix(*cert, filepath.Ext(*cert))+".key", passphrase)
+	} else {
+		certificates, key, err = selfca.ReadCertificateWithPassphrase(certPath, passphrase)
+	}
+	if err != nil {
+		fail(errorCode(err, exitBadInput), "Failed to load the certificate", err)
+	}
+
+	caPath := resolveCA(*caFlag, *output)
+	unlockCA, err := lockCA(caPath, *output)
+	if err != nil {
+		fail(exitIO, "Failed to lock ca folder", err)
+	}
+
+	defer unlockCA()
+
+	caCertificates, caKey, err := readCA(caPath)
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to load ca certificate", err)
+	}
+
+	defer selfca.ZeroKey(caKey)
+
+	existing := certificates[0]
+	hosts, upns := reissueHosts(existing, addHosts, removeHosts)
+	config, err := reissueConfig(existing, key, hosts, upns, *days)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse the subject of the certificate", err)
+	}
+
+	err = checkWeak(config, *weak)
+	if err == nil {
+		err = checkFIPS(config, *fips)
+	}
+	if err != nil {
+		fail(exitBadInput, "Refused to reissue the certificate", err)
+	}
+
+	config.CACertificate, config.CAKey = caCertificates[0], caKey
+	checkSigningCA(&config, caPath, *weak, *fips, *strict)
+
+	done := progress("Signing %s with the existing key", names[0])
+	issuance, err := selfca.Issue(config)
+	done()
+	if err != nil {
+		fail(exitCrypto, "Failed to generate the certificate", err)
+	}
+
+	err = recordCertificate(caPath, issuance.Certificate)
+	if err != nil {
+		fail(exitIO, "Failed to record the certificate", err)
+	}
+
+	// the kept key stays encrypted if it was
+	var keyPassphrase []byte
+	if keyEncrypted(certPath) || *cert != "" && keyEncrypted(strings.TrimSuffix(*cert, filepath.Ext(*cert))) {
+		keyPassphrase = passphrase
+	}
+
+	err = issuance.WriteWithPassphrase(certPath, keyPassphrase)
+	if err != nil {
+		fail(exitIO, "Failed to write the certificate", err)
+	}
+
+	err = issuance.WriteChain(certPath, *fullChainRoot)
+	if err != nil {
+		fail(exitIO, "Failed to write the fullchain file", err)
+	}
+
+	err = runHooks(hooks, hookEnv(issuance, caPath))
+	if err != nil {
+		fail(exitHook, "Failed to run the hook", err)
+	}
+
+	infof("Reissued %s, valid until %s", issuance.CertificateFile, issuance.Certificate.NotAfter.Format(time.RFC3339))
+}
+
+// reissueConfig returns the config reissuing the existing certificate for the key and hosts, the subject,
+// usages and urls are kept, and the validity is the same as the existing unless days is set
+func reissueConfig(existing *x509.Certificate, key crypto.Signer, hosts, upns []string,
+	days int) (selfca.Certificate, error) {
+	var subject pkix.RDNSequence
+	_, err := asn1.Unmarshal(existing.RawSubject, &subject)
+	if err != nil {
+		return selfca.Certificate{}, err
+	}
+
+	notBefore := time.Now()
+	notAfter := notBefore.Add(existing.NotAfter.Sub(existing.NotBefore))
+	if days > 0 {
+		notAfter = notBefore.Add(time.Duration(days*24) * time.Hour)
+	}
+
+	config := selfca.Certificate{
+		CommonName:            existing.Subject.CommonName,
+		Subject:               subject,
+		NotBefore:             notBefore,
+		NotAfter:              notAfter,
+		Hosts:                 hosts,
+		UPNs:                  upns,
+		Profile:               selfca.CertificateProfile(existing),
+		KeyUsage:              existing.KeyUsage,
+		ExtKeyUsage:           existing.ExtKeyUsage,
+		UnknownExtKeyUsage:    existing.UnknownExtKeyUsage,
+		OCSPServer:            existing.OCSPServer,
+		IssuingCertificateURL: existing.IssuingCertificateURL,
+		CRLDistributionPoints: existing.CRLDistributionPoints,
+		Key:                   key,
+	}
+	config.KeyType, config.KeySize = selfca.KeyTypeOf(key.Public())
+
+	return config, nil
+}
+
+// reissueHosts returns the hosts of the existing certificate edited by the parameters, and its UPNs
+func reissueHosts(existing *x509.Certificate, addHosts, removeHosts stringsFlag) ([]string, []string) {
+	added, err := parseHosts(addHosts.String())
+	if err != nil {
+		fail(exitBadInput, "Failed to parse add-host parameter", err)
+	}
+
+	removed, err := parseHosts(removeHosts.String())
+	if err != nil {
+		fail(exitBadInput, "Failed to parse remove-host parameter", err)
+	}
+
+	hosts := editHosts(certificateHosts(existing), added, removed)
+	upns := selfca.CertificateUPNs(existing)
+	if len(hosts) == 0 && len(upns) == 0 {
+		fail(exitBadInput, "Failed to reissue the certificate: no hosts left", nil)
+	}
+
+	return hosts, upns
+}
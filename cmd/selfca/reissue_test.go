@@ -0,0 +1,92 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/selfca"
+)
+
+func TestReissueConfig(t *testing.T) {
+	root, err := selfca.NewEphemeralCA()
+	assert.Nil(t, err)
+
+	intermediate, err := root.Issue(selfca.Certificate{
+		IsCA:       true,
+		CommonName: "Intermediate CA",
+		NotBefore:  time.Now().Add(-time.Hour),
+		NotAfter:   time.Now().Add(7 * 24 * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	caPath := filepath.Join(t.TempDir(), "ca")
+	assert.Nil(t, intermediate.Write(caPath))
+	assert.Nil(t, selfca.WriteCertificate(caPath+".chain", root.Certificate.Raw, nil))
+
+	subject, err := selfca.ParseDN("CN=web,O=Acme,C=US")
+	assert.Nil(t, err)
+
+	existing, err := intermediate.CA().Issue(selfca.Certificate{
+		Subject:     subject,
+		Hosts:       []string{"likexian.com"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning},
+		OCSPServer:  []string{"http://ocsp.likexian.com"},
+		NotBefore:   time.Now(),
+		NotAfter:    time.Now().Add(48 * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	config, err := reissueConfig(existing.Certificate, existing.Key, []string{"likexian.com", "www.likexian.com"},
+		nil, 0)
+	assert.Nil(t, err)
+	assert.Nil(t, checkWeak(config, false))
+
+	config.CACertificate, config.CAKey = intermediate.Certificate, intermediate.Key
+	checkSigningCA(&config, caPath, false, false, false)
+
+	issuance, err := selfca.Issue(config)
+	assert.Nil(t, err)
+
+	c := issuance.Certificate
+	assert.Equal(t, c.RawSubject, existing.Certificate.RawSubject)
+	assert.Equal(t, c.KeyUsage, existing.Certificate.KeyUsage)
+	assert.Equal(t, c.ExtKeyUsage, existing.Certificate.ExtKeyUsage)
+	assert.Equal(t, c.OCSPServer, existing.Certificate.OCSPServer)
+	assert.Equal(t, c.DNSNames, []string{"likexian.com", "www.likexian.com"})
+	assert.Equal(t, c.PublicKey, existing.Certificate.PublicKey)
+	assert.Equal(t, c.NotAfter.Sub(c.NotBefore), 48*time.Hour)
+
+	// the chain is of the intermediate ca up to the root
+	assert.Len(t, issuance.Chain, 2)
+	intermediates := x509.NewCertPool()
+	intermediates.AddCert(issuance.Chain[0])
+	_, err = c.Verify(x509.VerifyOptions{
+		Roots:         root.CertPool(),
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	})
+	assert.Nil(t, err)
+}
@@ -0,0 +1,159 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// revocationReasons is the RFC 5280 CRL reason codes by name
+var revocationReasons = map[string]int{
+	"unspecified":          0,
+	"keyCompromise":        1,
+	"cACompromise":         2,
+	"affiliationChanged":   3,
+	"superseded":           4,
+	"cessationOfOperation": 5,
+	"certificateHold":      6,
+	"privilegeWithdrawn":   9,
+	"aACompromise":         10,
+}
+
+// reasonNames returns the supported revocation reasons
+func reasonNames() string {
+	var names []string
+	for k := range revocationReasons {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// revoke revokes the certificates issued by the ca in output folder, and regenerates the CRL
+func revoke(args []string) {
+	fs := flag.NewFlagSet("selfca revoke", flag.ExitOnError)
+	reason := fs.String("reason", "unspecified", "Reason of the revocation, "+reasonNames())
+	validity := fs.Duration("crl-validity", selfca.DefaultCRLValidity, "Time from this update to next update of the CRL "+
+		"(default 168h)")
+	output := fs.String("o", "cert", "Folder of the ca (default cert)")
+	caFlag := addCAFlag(fs)
+	upgradeCA := addUpgradeCAFlag(fs)
+	addPassphraseFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca revoke <serial or certificate file>... [options]\n")
+		fs.PrintDefaults()
+	}
+
+	targets := parseArgs(fs, args)
+	if len(targets) == 0 {
+		failUsage(fs, "Missing serial or certificate file")
+	}
+
+	code, ok := revocationReasons[*reason]
+	if !ok {
+		failUsage(fs, "Unsupported reason parameter")
+	}
+
+	var serials []*big.Int
+	for _, v := range targets {
+		serial, err := parseSerial(v)
+		if err != nil {
+			fail(exitBadInput, "Failed to parse "+v, err)
+		}
+		serials = append(serials, serial)
+	}
+
+	caPath := resolveCA(*caFlag, *output)
+	err := revokeSerials(caPath, *output, serials, code)
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to revoke the certificate", err)
+	}
+
+	crl, err := generateCRL(caPath, *output, *validity, *upgradeCA)
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to generate the CRL", err)
+	}
+
+	infof("Revoked %d certificates and wrote %s.crl of %d bytes", len(serials), caPath, len(crl))
+}
+
+// parseSerial returns the serial of the certificate file, or the hex serial like 1a2b, 0x1a2b or 1a:2b
+func parseSerial(s string) (*big.Int, error) {
+	if _, err := os.Stat(s); err == nil {
+		certificates, err := readCertificates(s)
+		if err != nil {
+			return nil, err
+		}
+		return certificates[0].SerialNumber, nil
+	}
+
+	hex := strings.ReplaceAll(strings.TrimPrefix(strings.ToLower(s), "0x"), ":", "")
+	serial, ok := new(big.Int).SetString(hex, 16)
+	if !ok || serial.Sign() <= 0 {
+		return nil, errors.New("invalid serial, it is neither a file nor a hex serial")
+	}
+
+	return serial, nil
+}
+
+// revokeSerials marks the serials revoked in the database of ca, none is revoked if any is not issued
+func revokeSerials(caPath, output string, serials []*big.Int, reason int) error {
+	unlock, err := lockOutput(output)
+	if err != nil {
+		return err
+	}
+
+	defer unlock()
+
+	unlockCA, err := lockCA(caPath, output)
+	if err != nil {
+		return err
+	}
+
+	defer unlockCA()
+
+	db, err := selfca.OpenDatabase(caPath + ".db")
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, v := range serials {
+		err = db.RevokeWithReason(v, now, reason)
+		if err != nil {
+			return fmt.Errorf("%w: %s", err, v.Text(16))
+		}
+	}
+
+	return db.Save()
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/user"
+	"strings"
+	"time"
+
+	starlarkjson "go.starlark.net/lib/json"
+	"go.starlark.net/starlark"
+	"go.starlark.net/syntax"
+)
+
+// scriptPluginExt is the extension of plugins run by the embedded Starlark interpreter instead of the shell
+const scriptPluginExt = ".star"
+
+// maxScriptSteps is the maximum execution steps of a script plugin, to stop the endless loops
+const maxScriptSteps = 1000000
+
+// scriptRequest is the request passed to script plugins, with the validity in days for computing
+type scriptRequest struct {
+	pluginRequest
+	Days int `json:"days"`
+}
+
+// isScriptPlugin returns whether the plugin is a script run by the embedded interpreter
+func isScriptPlugin(plugin string) bool {
+	return strings.HasSuffix(plugin, scriptPluginExt) && !strings.ContainsAny(plugin, " \t")
+}
+
+// runScript runs the function named by the point in the script plugin, mutate(request) returns the
+// request or None for the request changed in place, policy(request) returns False or the reason to
+// refuse, or None or True to allow, and issued(request, certificate) returns nothing
+func runScript(plugin string, in pluginInput) (pluginOutput, error) {
+	var out pluginOutput
+
+	src, err := os.ReadFile(plugin)
+	if err != nil {
+		return out, fmt.Errorf("%s: %w", plugin, err)
+	}
+
+	// the load is not set, so the script can not load other files
+	thread := &starlark.Thread{
+		Name:  plugin,
+		Print: func(_ *starlark.Thread, msg string) { fmt.Fprintf(os.Stderr, "%s: %s\n", plugin, msg) },
+	}
+	thread.SetMaxExecutionSteps(maxScriptSteps)
+
+	debugf("Running script plugin %s at %s", plugin, in.Point)
+	globals, err := starlark.ExecFileOptions(&syntax.FileOptions{}, thread, plugin, src, scriptPredeclared())
+	if err != nil {
+		return out, err
+	}
+
+	fn, ok := globals[in.Point].(starlark.Callable)
+	if !ok {
+		return out, nil
+	}
+
+	days := int(in.Request.NotAfter.Sub(in.Request.NotBefore) / (24 * time.Hour))
+	request, err := toScript(thread, scriptRequest{pluginRequest: in.Request, Days: days})
+	if err != nil {
+		return out, fmt.Errorf("%s: %w", plugin, err)
+	}
+
+	args := starlark.Tuple{request}
+	if in.Point == pluginIssued && in.Certificate != nil {
+		c, err := toScript(thread, in.Certificate)
+		if err != nil {
+			return out, fmt.Errorf("%s: %w", plugin, err)
+		}
+		args = append(args, c)
+	}
+
+	v, err := starlark.Call(thread, fn, args, nil)
+	if err != nil {
+		return out, err
+	}
+
+	switch in.Point {
+	case pluginMutate:
+		if v == starlark.None {
+			v = request
+		}
+		r, err := fromScript(thread, v, days)
+		if err != nil {
+			return out, fmt.Errorf("%s: mutate: %w", plugin, err)
+		}
+		out.Request = r
+	case pluginPolicy:
+		return policyOutput(plugin, v)
+	}
+
+	return out, nil
+}
+
+// policyOutput returns the output of the value returned by policy
+func policyOutput(plugin string, v starlark.Value) (pluginOutput, error) {
+	var out pluginOutput
+	switch v := v.(type) {
+	case starlark.NoneType:
+	case starlark.Bool:
+		if !v {
+			allow := false
+			out = pluginOutput{Allow: &allow}
+		}
+	case starlark.String:
+		allow := false
+		out = pluginOutput{Allow: &allow, Reason: string(v)}
+	default:
+		return out, fmt.Errorf("%s: policy returns %s, want bool, string or None", plugin, v.Type())
+	}
+
+	return out, nil
+}
+
+// scriptPredeclared returns the names predeclared to script plugins besides the Starlark builtins,
+// only the requester of the user and hostname running selfca
+func scriptPredeclared() starlark.StringDict {
+	name := ""
+	if u, err := user.Current(); err == nil {
+		name = u.Username
+	}
+
+	hostname, _ := os.Hostname()
+	requester := starlark.NewDict(2)
+	_ = requester.SetKey(starlark.String("user"), starlark.String(name))
+	_ = requester.SetKey(starlark.String("hostname"), starlark.String(hostname))
+
+	return starlark.StringDict{"requester": requester}
+}
+
+// toScript returns the value as Starlark value by its json
+func toScript(thread *starlark.Thread, v any) (starlark.Value, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+
+	return starlark.Call(thread, starlarkjson.Module.Members["decode"], starlark.Tuple{starlark.String(data)}, nil)
+}
+
+// fromScript returns the request returned by mutate, not_after is moved to days after not_before
+// if days is changed
+func fromScript(thread *starlark.Thread, v starlark.Value, days int) (*pluginRequest, error) {
+	if _, ok := v.(*starlark.Dict); !ok {
+		return nil, fmt.Errorf("returns %s, want dict or None", v.Type())
+	}
+
+	data, err := starlark.Call(thread, starlarkjson.Module.Members["encode"], starlark.Tuple{v}, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	r := scriptRequest{}
+	err = json.Unmarshal([]byte(data.(starlark.String)), &r)
+	if err != nil {
+		return nil, err
+	}
+
+	if r.Days != days {
+		if r.Days <= 0 {
+			return nil, fmt.Errorf("invalid days %d", r.Days)
+		}
+		r.NotAfter = r.NotBefore.Add(time.Duration(r.Days) * 24 * time.Hour)
+	}
+
+	return &r.pluginRequest, nil
+}
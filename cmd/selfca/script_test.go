@@ -0,0 +1,119 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+const testScript = `
+def mutate(request):
+    request["hosts"] += [h + ".svc.cluster.local" for h in request["hosts"] if h.endswith(".default")]
+    request["days"] = min(request["days"], 30)
+
+def policy(request):
+    for h in request["hosts"]:
+        if h.endswith(".prod.internal"):
+            return "production hosts are issued by the production CA"
+
+def issued(request, certificate):
+    print("issued", certificate["serial"])
+`
+
+func TestRunScript(t *testing.T) {
+	plugin := filepath.Join(t.TempDir(), "policy.star")
+	assert.Nil(t, os.WriteFile(plugin, []byte(testScript), 0600))
+	assert.True(t, isScriptPlugin(plugin))
+	assert.False(t, isScriptPlugin("python3 policy.star"))
+
+	now := time.Now().UTC().Truncate(time.Second)
+	request := pluginRequest{
+		Name:      "web.default",
+		Hosts:     []string{"web.default"},
+		NotBefore: now,
+		NotAfter:  now.Add(365 * 24 * time.Hour),
+	}
+
+	out, err := runPlugin(plugin, pluginInput{Point: pluginMutate, Request: request})
+	assert.Nil(t, err)
+	assert.NotNil(t, out.Request)
+	assert.Equal(t, out.Request.Hosts, []string{"web.default", "web.default.svc.cluster.local"})
+	assert.Equal(t, out.Request.NotAfter, now.Add(30*24*time.Hour))
+	assert.Equal(t, out.Request.Name, "web.default")
+
+	out, err = runPlugin(plugin, pluginInput{Point: pluginPolicy, Request: request})
+	assert.Nil(t, err)
+	assert.True(t, out.Allow == nil)
+
+	request.Hosts = []string{"db.prod.internal"}
+	out, err = runPlugin(plugin, pluginInput{Point: pluginPolicy, Request: request})
+	assert.Nil(t, err)
+	assert.False(t, *out.Allow)
+	assert.Equal(t, out.Reason, "production hosts are issued by the production CA")
+
+	c := &pluginCertificate{Serial: "01"}
+	out, err = runPlugin(plugin, pluginInput{Point: pluginIssued, Request: request, Certificate: c})
+	assert.Nil(t, err)
+	assert.True(t, out.Request == nil)
+}
+
+func TestRunScriptError(t *testing.T) {
+	dir := t.TempDir()
+	request := pluginRequest{NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+
+	tests := []struct {
+		src   string
+		point string
+		out   string
+	}{
+		{"def mutate(request):\n    return 1\n", pluginMutate, "want dict or None"},
+		{"def mutate(request):\n    request['days'] = -1\n", pluginMutate, "invalid days -1"},
+		{"def policy(request):\n    return 1\n", pluginPolicy, "want bool, string or None"},
+		{"def issued(request, certificate):\n    fail('oops')\n", pluginIssued, "fail: oops"},
+		{"def policy(request)\n", pluginPolicy, "want ':'"},
+		{"def policy(request):\n    for i in range(100000000):\n        pass\n", pluginPolicy, "too many steps"},
+		{"load('other.star', 'x')\n", pluginPolicy, "load not implemented"},
+		{"def policy(request):\n    return open('/etc/passwd')\n", pluginPolicy, "undefined: open"},
+	}
+
+	for _, v := range tests {
+		plugin := filepath.Join(dir, "bad.star")
+		assert.Nil(t, os.WriteFile(plugin, []byte(v.src), 0600))
+		_, err := runPlugin(plugin, pluginInput{Point: v.point, Request: request, Certificate: &pluginCertificate{}})
+		assert.NotNil(t, err, v.src)
+		if err != nil {
+			assert.Contains(t, err.Error(), v.out, err.Error())
+		}
+	}
+
+	plugin := filepath.Join(dir, "refuse.star")
+	assert.Nil(t, os.WriteFile(plugin, []byte("def policy(request):\n    return False\n"), 0600))
+	out, err := runPlugin(plugin, pluginInput{Point: pluginPolicy, Request: request})
+	assert.Nil(t, err)
+	assert.False(t, *out.Allow)
+
+	_, err = runPlugin(filepath.Join(dir, "missing.star"), pluginInput{Point: pluginPolicy, Request: request})
+	assert.NotNil(t, err)
+}
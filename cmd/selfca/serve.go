@@ -0,0 +1,456 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/likexian/selfca"
+	"github.com/pion/dtls/v2"
+	"github.com/pion/transport/v2/udp"
+	"github.com/quic-go/quic-go/http3"
+)
+
+// mailTimeout is how long the mail session waits for a command
+const mailTimeout = 5 * time.Minute
+
+// dtlsTimeout is how long the DTLS handshake and session wait for the client
+const dtlsTimeout = 30 * time.Second
+
+// certificateStore serves the issued certificates by SNI, falling back to the default one
+type certificateStore struct {
+	output   string
+	fallback string
+	mutex    sync.Mutex
+	loaded   map[string]*tls.Certificate
+}
+
+// mailSession is the connection of mail client, upgraded by STARTTLS
+type mailSession struct {
+	conn net.Conn
+	r    *bufio.Reader
+	tls  bool
+}
+
+// serve runs the minimal SMTP and IMAP servers with STARTTLS, the HTTPS server with HTTP/3
+// and the DTLS echo server, using the issued certificates for testing the certificate handling of clients
+func serve(args []string) {
+	fs := flag.NewFlagSet("selfca serve", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the certificates (default cert)")
+	name := fs.String("cert", "localhost", "Certificate name served without SNI or not issued for the SNI")
+	smtp := fs.String("smtp", "", "Address of SMTP server, like :2525")
+	imap := fs.String("imap", "", "Address of IMAP server, like :1143")
+	h3 := fs.String("http3", "", "Address of HTTPS server on TCP and HTTP/3 server on UDP, like :8443")
+	dtlsAddr := fs.String("dtls", "", "Address of DTLS server on UDP echoing the datagrams, like :5684")
+	addOutputFlags(fs)
+	addPassphraseFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if *smtp == "" && *imap == "" && *h3 == "" && *dtlsAddr == "" {
+		failUsage(fs, "Missing -smtp, -imap, -http3 or -dtls address")
+	}
+
+	store := &certificateStore{output: *output, fallback: *name, loaded: map[string]*tls.Certificate{}}
+	_, err := store.load(*name)
+	if err != nil {
+		fail(errorCode(err, exitBadInput), "Failed to load the certificate", err)
+	}
+
+	config := &tls.Config{GetCertificate: store.get}
+	servers := []struct {
+		addr   string
+		handle func(*mailSession, *tls.Config)
+	}{
+		{*smtp, serveSMTP},
+		{*imap, serveIMAP},
+	}
+
+	for _, v := range servers {
+		if v.addr == "" {
+			continue
+		}
+		l, err := net.Listen("tcp", v.addr)
+		if err != nil {
+			fail(exitIO, "Failed to listen on "+v.addr, err)
+		}
+		infof("Listening on %s", l.Addr())
+		go acceptMail(l, config, v.handle)
+	}
+
+	if *h3 != "" {
+		serveHTTP3(*h3, config, store)
+	}
+
+	if *dtlsAddr != "" {
+		serveDTLS(*dtlsAddr, store)
+	}
+
+	<-stopSignal()
+}
+
+// serveHTTP3 runs the HTTPS server on TCP and the HTTP/3 server on UDP of the same port,
+// the HTTPS responses advertise HTTP/3 by Alt-Svc for browsers to switch
+func serveHTTP3(addr string, config *tls.Config, store *certificateStore) {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		fail(exitIO, "Failed to listen on "+addr, err)
+	}
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	host, _, _ := net.SplitHostPort(addr)
+	udp := net.JoinHostPort(host, port)
+
+	pc, err := net.ListenPacket("udp", udp)
+	if err != nil {
+		fail(exitIO, "Failed to listen on "+udp, err)
+	}
+
+	h3Server := &http3.Server{TLSConfig: http3.ConfigureTLSConfig(config.Clone())}
+	h3Server.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		debugf("%s %s %s from %s, SNI %q", r.Proto, r.Method, r.URL.Path, r.RemoteAddr, r.TLS.ServerName)
+		_ = h3Server.SetQUICHeaders(w.Header())
+		w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+		fmt.Fprintf(w, "Protocol: %s\n", r.Proto)
+		fmt.Fprintf(w, "TLS: %s, %s\n", tls.VersionName(r.TLS.Version), tls.CipherSuiteName(r.TLS.CipherSuite))
+		fmt.Fprintf(w, "SNI: %s\n", r.TLS.ServerName)
+		if c, err := store.get(&tls.ClientHelloInfo{ServerName: r.TLS.ServerName}); err == nil {
+			fmt.Fprintf(w, "Certificate: %s\n", c.Leaf.Subject)
+		}
+		fmt.Fprintf(w, "Remote: %s\n", r.RemoteAddr)
+	})
+
+	server := &http.Server{Handler: h3Server.Handler, TLSConfig: config.Clone(), ReadHeaderTimeout: 10 * time.Second}
+	go func() {
+		err := server.ServeTLS(l, "", "")
+		infof("Failed to serve HTTPS on %s: %v", l.Addr(), err)
+	}()
+
+	go func() {
+		err := h3Server.Serve(pc)
+		infof("Failed to serve HTTP/3 on %s: %v", pc.LocalAddr(), err)
+	}()
+
+	infof("Listening on %s (HTTPS) and %s (HTTP/3)", l.Addr(), pc.LocalAddr())
+}
+
+// serveDTLS runs the DTLS server on UDP, the datagrams of the clients are echoed back
+func serveDTLS(addr string, store *certificateStore) {
+	laddr, err := net.ResolveUDPAddr("udp", addr)
+	if err != nil {
+		fail(exitBadInput, "Failed to resolve "+addr, err)
+	}
+
+	l, err := udp.Listen("udp", laddr)
+	if err != nil {
+		fail(exitIO, "Failed to listen on "+addr, err)
+	}
+
+	config := &dtls.Config{
+		GetCertificate: func(hello *dtls.ClientHelloInfo) (*tls.Certificate, error) {
+			return store.get(&tls.ClientHelloInfo{ServerName: hello.ServerName})
+		},
+		ClientAuth:           dtls.RequestClientCert,
+		ExtendedMasterSecret: dtls.RequireExtendedMasterSecret,
+	}
+
+	infof("Listening on %s (DTLS)", l.Addr())
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				infof("Failed to accept on %s: %v", l.Addr(), err)
+				return
+			}
+			go echoDTLS(conn, config)
+		}
+	}()
+}
+
+// echoDTLS performs the DTLS handshake on the connection, and echoes the datagrams back
+func echoDTLS(conn net.Conn, config *dtls.Config) {
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), dtlsTimeout)
+	defer cancel()
+
+	s, err := dtls.ServerWithContext(ctx, conn, config)
+	if err != nil {
+		infof("Failed to handshake with %s: %v", conn.RemoteAddr(), err)
+		return
+	}
+	defer s.Close()
+
+	state := s.ConnectionState()
+	client := "none"
+	if len(state.PeerCertificates) > 0 {
+		if c, err := x509.ParseCertificate(state.PeerCertificates[0]); err == nil {
+			client = c.Subject.String()
+		}
+	}
+	infof("DTLS handshake with %s, client certificate %s", conn.RemoteAddr(), client)
+
+	buf := make([]byte, 8192)
+	for {
+		_ = s.SetReadDeadline(time.Now().Add(dtlsTimeout))
+		n, err := s.Read(buf)
+		if err != nil {
+			debugf("Closed DTLS session with %s: %v", conn.RemoteAddr(), err)
+			return
+		}
+		_, err = s.Write(buf[:n])
+		if err != nil {
+			return
+		}
+	}
+}
+
+// acceptMail accepts the connections and handles them in sessions
+func acceptMail(l net.Listener, config *tls.Config, handle func(*mailSession, *tls.Config)) {
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			infof("Failed to accept on %s: %v", l.Addr(), err)
+			return
+		}
+		go func() {
+			debugf("Connected from %s", conn.RemoteAddr())
+			s := &mailSession{conn: conn, r: bufio.NewReader(conn)}
+			handle(s, config)
+			// closing the upgraded connection sends TLS close notify
+			s.conn.Close()
+		}()
+	}
+}
+
+// get returns the certificate issued for the SNI, or its wildcard, or the default one
+func (s *certificateStore) get(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	name := strings.ToLower(hello.ServerName)
+	candidates := []string{}
+	if name != "" {
+		candidates = append(candidates, name)
+		if i := strings.Index(name, "."); i > 0 {
+			candidates = append(candidates, "*"+name[i:])
+		}
+	}
+
+	for _, v := range append(candidates, s.fallback) {
+		if strings.ContainsAny(v, `/\`) {
+			continue
+		}
+		c, err := s.load(v)
+		if err == nil {
+			debugf("Serving %s for SNI %q", v, hello.ServerName)
+			return c, nil
+		}
+	}
+
+	return nil, errors.New("no certificate found")
+}
+
+// load returns the certificate of name in the output folder, cached once loaded
+func (s *certificateStore) load(name string) (*tls.Certificate, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if c, ok := s.loaded[name]; ok {
+		return c, nil
+	}
+
+	certificates, key, err := selfca.ReadCertificateWithPassphrase(filepath.Join(s.output, name), passphrase)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &tls.Certificate{PrivateKey: key, Leaf: certificates[0]}
+	for _, v := range certificates {
+		c.Certificate = append(c.Certificate, v.Raw)
+	}
+
+	s.loaded[name] = c
+
+	return c, nil
+}
+
+// reply writes the response line
+func (s *mailSession) reply(format string, args ...any) {
+	fmt.Fprintf(s.conn, format+"\r\n", args...)
+}
+
+// read returns the next command line
+func (s *mailSession) read() (string, error) {
+	_ = s.conn.SetReadDeadline(time.Now().Add(mailTimeout))
+	line, err := s.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
+// startTLS upgrades the session to TLS, and logs the negotiated parameters
+func (s *mailSession) startTLS(config *tls.Config, protocol string) error {
+	conn := tls.Server(s.conn, config)
+	_ = conn.SetDeadline(time.Now().Add(mailTimeout))
+	err := conn.Handshake()
+	if err != nil {
+		infof("%s STARTTLS from %s failed: %v", protocol, s.conn.RemoteAddr(), err)
+		return err
+	}
+
+	state := conn.ConnectionState()
+	infof("%s STARTTLS from %s, SNI %q, %s", protocol, s.conn.RemoteAddr(), state.ServerName,
+		tls.VersionName(state.Version))
+	s.conn, s.r, s.tls = conn, bufio.NewReader(conn), true
+
+	return nil
+}
+
+// serveSMTP handles the SMTP session, the messages are accepted and discarded
+func serveSMTP(s *mailSession, config *tls.Config) {
+	s.reply("220 selfca ESMTP ready")
+	for {
+		line, err := s.read()
+		if err != nil {
+			return
+		}
+
+		verb, _, _ := strings.Cut(line, " ")
+		switch strings.ToUpper(verb) {
+		case "EHLO":
+			s.reply("250-selfca")
+			if !s.tls {
+				s.reply("250-STARTTLS")
+			}
+			s.reply("250 8BITMIME")
+		case "HELO":
+			s.reply("250 selfca")
+		case "STARTTLS":
+			if s.tls {
+				s.reply("503 TLS already active")
+				continue
+			}
+			s.reply("220 Ready to start TLS")
+			if s.startTLS(config, "SMTP") != nil {
+				return
+			}
+		case "MAIL", "RCPT", "RSET", "NOOP":
+			s.reply("250 OK")
+		case "DATA":
+			s.reply("354 End data with <CR><LF>.<CR><LF>")
+			for {
+				line, err = s.read()
+				if err != nil {
+					return
+				}
+				if line == "." {
+					break
+				}
+			}
+			s.reply("250 OK discarded")
+		case "QUIT":
+			s.reply("221 Bye")
+			return
+		default:
+			s.reply("502 Command not implemented")
+		}
+	}
+}
+
+// serveIMAP handles the IMAP session, any login is accepted after STARTTLS with empty INBOX
+func serveIMAP(s *mailSession, config *tls.Config) {
+	capability := func() string {
+		if s.tls {
+			return "IMAP4rev1 AUTH=PLAIN"
+		}
+		return "IMAP4rev1 STARTTLS LOGINDISABLED"
+	}
+
+	s.reply("* OK [CAPABILITY %s] selfca IMAP ready", capability())
+	for {
+		line, err := s.read()
+		if err != nil {
+			return
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			s.reply("* BAD Invalid command")
+			continue
+		}
+
+		tag := fields[0]
+		switch strings.ToUpper(fields[1]) {
+		case "CAPABILITY":
+			s.reply("* CAPABILITY %s", capability())
+			s.reply("%s OK CAPABILITY completed", tag)
+		case "STARTTLS":
+			if s.tls {
+				s.reply("%s BAD TLS already active", tag)
+				continue
+			}
+			s.reply("%s OK Begin TLS negotiation now", tag)
+			if s.startTLS(config, "IMAP") != nil {
+				return
+			}
+		case "LOGIN", "AUTHENTICATE":
+			if !s.tls {
+				s.reply("%s NO Use STARTTLS first", tag)
+				continue
+			}
+			if strings.EqualFold(fields[1], "AUTHENTICATE") && len(fields) < 4 {
+				s.reply("+ ")
+				_, err = s.read()
+				if err != nil {
+					return
+				}
+			}
+			s.reply("%s OK LOGIN completed", tag)
+		case "SELECT", "EXAMINE":
+			s.reply("* FLAGS ()")
+			s.reply("* 0 EXISTS")
+			s.reply("* 0 RECENT")
+			s.reply("%s OK [READ-WRITE] SELECT completed", tag)
+		case "LIST", "LSUB":
+			s.reply(`* %s () "/" INBOX`, strings.ToUpper(fields[1]))
+			s.reply("%s OK %s completed", tag, strings.ToUpper(fields[1]))
+		case "NOOP", "CHECK", "CLOSE":
+			s.reply("%s OK %s completed", tag, strings.ToUpper(fields[1]))
+		case "LOGOUT":
+			s.reply("* BYE selfca IMAP logging out")
+			s.reply("%s OK LOGOUT completed", tag)
+			return
+		default:
+			s.reply("%s BAD Command not implemented", tag)
+		}
+	}
+}
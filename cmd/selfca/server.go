@@ -0,0 +1,108 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/likexian/selfca"
+)
+
+// serverOutput is the files and config of web server
+type serverOutput struct {
+	// bundle is whether the key is appended to the chained certificate
+	bundle bool
+	// config is the config stanza, formatted with certificate and key file
+	config string
+}
+
+// serverOutputs is the web servers supported by -for
+var serverOutputs = map[string]serverOutput{
+	"nginx": {
+		config: "ssl_certificate %s;\nssl_certificate_key %s;",
+	},
+	"apache": {
+		config: "SSLCertificateFile %s\nSSLCertificateKeyFile %s",
+	},
+	"haproxy": {
+		bundle: true,
+		config: "bind :443 ssl crt %s",
+	},
+	"caddy": {
+		config: "tls %s %s",
+	},
+}
+
+// serverNames returns the web servers supported by -for
+func serverNames() string {
+	var names []string
+	for k := range serverOutputs {
+		names = append(names, k)
+	}
+
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// writeServerFiles writes the files needed by web server, the certificate is followed
+// by the intermediate certificates, and then the key if the server needs a bundle,
+// returns the config stanza using the files
+func writeServerFiles(server, name string, i *selfca.Issuance) (string, error) {
+	s := serverOutputs[server]
+
+	path := name + ".chained.crt"
+	var err error
+	if s.bundle {
+		path = name + ".pem"
+		err = i.WriteBundle(name)
+	} else {
+		err = os.WriteFile(path, chainedPEM(i.Certificate, i.Chain), 0644)
+	}
+	if err != nil {
+		return "", err
+	}
+
+	certificateFile, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+
+	keyFile, err := filepath.Abs(i.KeyFile)
+	if err != nil {
+		return "", err
+	}
+
+	if s.bundle {
+		return fmt.Sprintf(s.config, certificateFile), nil
+	}
+
+	return fmt.Sprintf(s.config, certificateFile, keyFile), nil
+}
+
+// chainedPEM returns the PEM encoded certificate followed by the intermediate certificates
+func chainedPEM(c *x509.Certificate, chain []*x509.Certificate) []byte {
+	return selfca.ChainPEM(append([]*x509.Certificate{c}, chain...), false)
+}
@@ -0,0 +1,106 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// serviceName is the name of the installed system service
+const serviceName = "selfca"
+
+// systemdUnit is the template of systemd unit file
+const systemdUnit = `[Unit]
+Description=selfca certificate renewal daemon
+After=network.target
+
+[Service]
+Type=notify
+WorkingDirectory=%s
+ExecStart=%s daemon -c %s
+ExecReload=/bin/kill -HUP $MAINPID
+Restart=on-failure
+
+[Install]
+WantedBy=multi-user.target
+`
+
+// service manages running the daemon mode as a system service
+func service(args []string) {
+	fs := flag.NewFlagSet("selfca service", flag.ExitOnError)
+	path := fs.String("c", "selfca.json", "Path of the config file")
+	output := fs.String("o", "", "Path for saving the systemd unit file (default stdout)")
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca service systemd|install|uninstall [options]\n")
+		fs.PrintDefaults()
+	}
+
+	if len(args) == 0 {
+		failUsage(fs, "Missing service action")
+	}
+
+	action := args[0]
+	_ = fs.Parse(args[1:])
+
+	exe, err := os.Executable()
+	if err != nil {
+		fail(exitIO, "Failed to get the executable path", err)
+	}
+
+	config, err := filepath.Abs(*path)
+	if err != nil {
+		fail(exitBadInput, "Failed to get the config path", err)
+	}
+
+	switch action {
+	case "systemd":
+		unit := fmt.Sprintf(systemdUnit, strings.ReplaceAll(filepath.Dir(config), "%", "%%"), systemdQuote(exe),
+			systemdQuote(config))
+		if *output == "" {
+			fmt.Print(unit)
+			return
+		}
+		err = os.WriteFile(*output, []byte(unit), 0644)
+		if err != nil {
+			fail(exitIO, "Failed to write the unit file", err)
+		}
+		return
+	case "install":
+		err = installService(serviceName, exe, "daemon", "-c", config)
+	case "uninstall":
+		err = removeService(serviceName)
+	default:
+		failUsage(fs, "Unknown service action")
+	}
+
+	if err != nil {
+		fail(exitFailure, fmt.Sprintf("Failed to %s the service", action), err)
+	}
+}
+
+// systemdQuote quotes the argument of ExecStart as systemd.syntax, escaping the specifiers and variables
+func systemdQuote(s string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`, "%", "%%", "$", "$$").Replace(s) + `"`
+}
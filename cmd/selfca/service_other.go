@@ -0,0 +1,49 @@
+//go:build !windows
+
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"errors"
+)
+
+// errServiceUnsupported is service unsupported error
+var errServiceUnsupported = errors.New("only supported on windows, use systemd instead")
+
+// isService returns if running as windows service
+func isService() bool {
+	return false
+}
+
+// runService runs as windows service until stopped
+func runService(_ string, _ func(stop <-chan struct{})) error {
+	return errServiceUnsupported
+}
+
+// installService installs the windows service
+func installService(_, _ string, _ ...string) error {
+	return errServiceUnsupported
+}
+
+// removeService removes the windows service
+func removeService(_ string) error {
+	return errServiceUnsupported
+}
@@ -0,0 +1,43 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestSystemdQuote(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"/usr/local/bin/selfca", `"/usr/local/bin/selfca"`},
+		{"/opt/my certs/selfca.yaml", `"/opt/my certs/selfca.yaml"`},
+		{`/opt/"quoted"/a\b`, `"/opt/\"quoted\"/a\\b"`},
+		{"/opt/100%/$HOME", `"/opt/100%%/$$HOME"`},
+		{"/opt/a\nb", `"/opt/a\nb"`},
+	}
+
+	for _, v := range tests {
+		assert.Equal(t, systemdQuote(v.in), v.out, v.in)
+	}
+}
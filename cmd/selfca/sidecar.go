@@ -0,0 +1,222 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"encoding/pem"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// sidecarData is the symlink to the current version folder of the shared files
+const sidecarData = "..data"
+
+// sidecarFile is the file written to the shared folder
+type sidecarFile struct {
+	name string
+	data []byte
+	mode os.FileMode
+}
+
+// sidecar keeps the certificate, key and ca files in a shared folder rotated, for the
+// containers which can not request certificates, the files are swapped atomically
+func sidecar(args []string) {
+	fs := flag.NewFlagSet("selfca sidecar", flag.ExitOnError)
+	name := fs.String("n", "", "Common name of the certificate")
+	host := fs.String("h", "", "Domains, IPs or CIDRs of the certificate, comma separated, @file or - to read from file "+
+		"or stdin")
+	bits := fs.Int("b", 2048, "Number of bits in the key to create (default 2048)")
+	keyType := fs.String("key-type", "rsa", "Type of the key to create, rsa, ecdsa or ed25519")
+	dir := fs.String("dir", "", "Shared folder the tls.crt, tls.key and ca.crt are written to")
+	period := fs.Duration("period", 24*time.Hour, "Interval of rotating the certificate (default 24h)")
+	valid := fs.Duration("valid", 72*time.Hour, "Validity of the certificate, must be longer than period (default 72h)")
+	output := fs.String("o", "cert", "Folder of the ca (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	fips := fs.Bool("fips", false, "Only allow FIPS approved key sizes and signature algorithms")
+	var hooks stringsFlag
+	fs.Var(&hooks, "hook", "Command to run after the files are rotated, can be repeated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	hosts, err := parseHosts(*host)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse hosts parameter", err)
+	}
+
+	if len(hosts) == 0 {
+		failUsage(fs, "Missing hosts parameter")
+	}
+
+	if *dir == "" {
+		failUsage(fs, "Missing dir parameter")
+	}
+
+	checkKeyFlags(fs, *keyType, bits)
+
+	if *period < minAgentValid || *valid <= *period {
+		failUsage(fs, "The period parameter must be at least "+minAgentValid.String()+" and less than valid")
+	}
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	for _, v := range []string{*output, *dir} {
+		err = os.MkdirAll(v, 0755)
+		if err != nil {
+			fail(exitIO, "Failed to create folder "+v, err)
+		}
+	}
+
+	stop := stopSignal()
+	reload := reloadSignal()
+	for {
+		now := time.Now()
+		issuance, err := issueCertificate(issueRequest{
+			Output:     *output,
+			CA:         *caFlag,
+			CATemplate: caConfig,
+			Config: selfca.Certificate{
+				CommonName: *name,
+				KeySize:    *bits,
+				KeyType:    selfca.KeyType(*keyType),
+				NotBefore:  now,
+				NotAfter:   now.Add(*valid),
+				Hosts:      hosts,
+			},
+			FIPS:       *fips,
+			ShortLived: true,
+		})
+
+		wait := agentRetry
+		if issuance != nil {
+			err = errors.Join(err, writeSidecarFiles(*dir, issuance))
+			if err == nil {
+				wait = *period
+				if !quiet {
+					log.Printf("Rotated %s in %s, valid until %s", hosts[0], *dir, issuance.Certificate.NotAfter.Format(time.RFC3339))
+				}
+				issuance.CertificateFile = filepath.Join(*dir, "tls.crt")
+				issuance.KeyFile = filepath.Join(*dir, "tls.key")
+				err = runHooks(hooks, hookEnv(issuance, filepath.Join(*dir, "ca")))
+			}
+			issuance.Zero()
+		}
+
+		if err != nil {
+			var e *exitError
+			if errors.As(err, &e) && e.code == exitBadInput {
+				failError(err)
+			}
+			log.Printf("%v", err)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-stop:
+			timer.Stop()
+			return
+		case <-reload:
+			timer.Stop()
+			log.Printf("Reloading, rotating %s now", hosts[0])
+		case <-timer.C:
+		}
+	}
+}
+
+// writeSidecarFiles writes the files to a new version folder in dir, and swaps the ..data
+// symlink to it by rename, the files in dir are symlinks through ..data, so the readers
+// always see the certificate, key and ca of the same version
+func writeSidecarFiles(dir string, i *selfca.Issuance) error {
+	if i.KeyPEM == nil {
+		return errors.New("the key can not be exported to the shared folder")
+	}
+
+	files := []sidecarFile{
+		{"tls.crt", chainedPEM(i.Certificate, i.Chain), 0644},
+		{"tls.key", i.KeyPEM, 0600},
+	}
+	if len(i.Chain) > 0 {
+		root := i.Chain[len(i.Chain)-1]
+		rootPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+		files = append(files, sidecarFile{"ca.crt", rootPEM, 0644})
+	}
+
+	version, err := os.MkdirTemp(dir, ".."+time.Now().UTC().Format("2006_01_02_15_04_05."))
+	if err != nil {
+		return err
+	}
+
+	err = os.Chmod(version, 0755)
+	for _, v := range files {
+		if err == nil {
+			err = os.WriteFile(filepath.Join(version, v.name), v.data, v.mode)
+		}
+	}
+	if err != nil {
+		_ = os.RemoveAll(version)
+		return err
+	}
+
+	data := filepath.Join(dir, sidecarData)
+	previous, _ := os.Readlink(data)
+
+	tmp := filepath.Join(dir, sidecarData+"_tmp")
+	_ = os.Remove(tmp)
+	err = os.Symlink(filepath.Base(version), tmp)
+	if err == nil {
+		err = os.Rename(tmp, data)
+	}
+	if err != nil {
+		_ = os.RemoveAll(version)
+		return err
+	}
+
+	for _, v := range files {
+		link := filepath.Join(dir, v.name)
+		target := filepath.Join(sidecarData, v.name)
+		if current, _ := os.Readlink(link); current == target {
+			continue
+		}
+		_ = os.Remove(link)
+		err = os.Symlink(target, link)
+		if err != nil {
+			return fmt.Errorf("link %s: %w", link, err)
+		}
+	}
+
+	if previous != "" && previous != filepath.Base(version) && strings.HasPrefix(previous, "..") {
+		_ = os.RemoveAll(filepath.Join(dir, previous))
+	}
+
+	return nil
+}
@@ -0,0 +1,308 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// sign signs the certificate request file by the ca in output folder
+func sign(args []string) {
+	fs := flag.NewFlagSet("selfca sign", flag.ExitOnError)
+	csrFile := fs.String("csr", "", "Path of the certificate request file, instead of the argument")
+	name := fs.String("name", "", "File name of the certificate (default the request file name)")
+	days := fs.Int("days", 365, "Valid days of the certificate, for example 90 (default 365 days)")
+	profile := fs.String("profile", "server", "Profile of the certificate, server, client, codesigning, email, "+
+		"smartcard, ipsec or device")
+	output := fs.String("o", "cert", "Folder of the ca and for saving the certificate (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	weak := fs.Bool("insecure-allow-weak", false, "Allow weak key size, signature and validity, for testing only")
+	fips := fs.Bool("fips", false, "Only allow FIPS approved key sizes and signature algorithms")
+	strict := fs.Bool("strict-validity", false, "Refuse validity outside of the ca validity instead of clamping it")
+	fullChainRoot := fs.Bool("fullchain-root", false, "Include the root ca in the fullchain file")
+	rotate := fs.Bool("auto-rotate-ca", false, "Replace the expired or expiring ca by a new ca cross-signed by it")
+	password := fs.String("challenge-password", "", "Only sign the certificate request with this challenge password")
+	csrSubject := fs.String("csr-subject", "", "How the subject of request is taken, cn for common name only, full or "+
+		"none (default full for codesigning and device, cn for others)")
+	urls := addURLFlags(fs)
+	var copyExtensions, allowHosts, hooks, usages stringsFlag
+	fs.Var(&usages, "usage", "Key usages and extended key usages overriding the profile, comma separated like "+
+		"digitalSignature,serverAuth,codeSigning or OIDs, can be repeated")
+	fs.Var(&copyExtensions, "copy-extension", "OID of requested extension to copy into the certificate, can be repeated")
+	fs.Var(&allowHosts, "allow-host", "Allowed requested names, like example.com, *.example.com, .example.com, "+
+		"10.0.0.0/8, @example.com or spiffe://example.org/, comma separated, can be repeated (default all)")
+	fs.Var(&hooks, "hook", "Command to run after the certificate is signed, can be repeated")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca sign <request.csr> [options]\n")
+		fmt.Fprintf(fs.Output(), "       selfca sign -csr <request.csr> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	files := parseArgs(fs, args)
+	if *csrFile != "" {
+		files = append(files, *csrFile)
+	}
+
+	if len(files) != 1 {
+		failUsage(fs, "Missing certificate request file")
+	}
+
+	policy := signPolicy(fs, *profile, *csrSubject, allowHosts)
+	data, csr := readCSR(files[0], *password)
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	var oids []asn1.ObjectIdentifier
+	for _, v := range copyExtensions {
+		oid, err := parseOID(v)
+		if err != nil {
+			fail(exitBadInput, "Failed to parse copy-extension parameter", err)
+		}
+		oids = append(oids, oid)
+	}
+
+	*name, err = signName(*name, files[0], resolveCA(*caFlag, *output))
+	if err != nil {
+		fail(exitBadInput, "Failed to parse name parameter", err)
+	}
+
+	now := time.Now()
+	config := selfca.Certificate{
+		NotBefore:      now,
+		NotAfter:       now.Add(time.Duration(*days*24) * time.Hour),
+		Profile:        selfca.Profile(*profile),
+		CopyExtensions: oids,
+		CSRPolicy:      &policy,
+	}
+
+	err = urls.apply(&config)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ocsp-url, issuer-url or crl-url parameter", err)
+	}
+
+	err = addUsages(&config, usages)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse usage parameter", err)
+	}
+
+	config.KeyType, config.KeySize = selfca.KeyTypeOf(csr.PublicKey)
+
+	err = checkWeak(config, *weak)
+	if err == nil {
+		err = checkFIPS(config, *fips)
+	}
+	if err != nil {
+		fail(exitBadInput, "Refused to sign the certificate request", err)
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	unlock, err := lockOutput(*output)
+	if err != nil {
+		fail(exitIO, "Failed to lock output folder", err)
+	}
+
+	defer unlock()
+
+	caPath := resolveCA(*caFlag, *output)
+	unlockCA, err := lockCA(caPath, *output)
+	if err != nil {
+		fail(exitIO, "Failed to lock ca folder", err)
+	}
+
+	defer unlockCA()
+
+	caConfig.KeyType, caConfig.KeySize, caConfig.NotBefore = config.KeyType, config.KeySize, now
+	config.CACertificate, config.CAKey, err = loadCA(caPath, caConfig, *rotate)
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to load ca certificate", err)
+	}
+
+	defer selfca.ZeroKey(config.CAKey)
+
+	checkSigningCA(&config, caPath, *weak, *fips, *strict)
+
+	issuance, err := selfca.SignCSR(data, config)
+	if err != nil {
+		fail(errorCode(err, exitCrypto), "Failed to sign the certificate request", err)
+	}
+
+	err = recordCertificate(caPath, issuance.Certificate)
+	if err != nil {
+		fail(exitIO, "Failed to record the certificate", err)
+	}
+
+	certPath := fmt.Sprintf("%s/%s", *output, *name)
+	writeSigned(certPath, issuance, *fullChainRoot)
+
+	err = runHooks(hooks, hookEnv(issuance, caPath))
+	if err != nil {
+		fail(exitHook, "Failed to run the hook", err)
+	}
+
+	infof("Signed %s and wrote %s.chain.crt, valid until %s", issuance.CertificateFile,
+		certPath, issuance.Certificate.NotAfter.Format(time.RFC3339))
+}
+
+// signPolicy returns the request policy of profile, with the subject and allowed hosts parameters
+func signPolicy(fs *flag.FlagSet, profile, csrSubject string, allowHosts []string) selfca.CSRPolicy {
+	if !validProfile(selfca.Profile(profile)) {
+		failUsage(fs, "Unsupported profile parameter")
+	}
+
+	policy := selfca.ProfileCSRPolicy(selfca.Profile(profile))
+	switch subject := selfca.CSRSubject(csrSubject); subject {
+	case "":
+	case selfca.CSRSubjectCommonName, selfca.CSRSubjectFull, selfca.CSRSubjectNone:
+		policy.Subject = subject
+	default:
+		failUsage(fs, "Unsupported csr-subject parameter")
+	}
+
+	for _, v := range allowHosts {
+		for _, s := range strings.Split(v, ",") {
+			if s = strings.TrimSpace(s); s != "" {
+				policy.AllowedHosts = append(policy.AllowedHosts, s)
+			}
+		}
+	}
+
+	return policy
+}
+
+// readCSR reads the certificate request file, and checks the challenge password if not empty
+func readCSR(file, password string) ([]byte, *x509.CertificateRequest) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		fail(errorCode(err, exitBadInput), "Failed to read the certificate request", err)
+	}
+
+	csr, err := selfca.ParseCSR(data)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse the certificate request", err)
+	}
+
+	if password != "" && selfca.CSRChallengePassword(csr) != password {
+		fail(exitBadInput, "Refused to sign the certificate request: challenge password mismatch", nil)
+	}
+
+	return data, csr
+}
+
+// signName returns the file name of the certificate signed from the request file, the request file name
+// by default, the name of the ca files is refused so that the ca is not overwritten
+func signName(name, file, caPath string) (string, error) {
+	if name == "" {
+		name = strings.TrimSuffix(filepath.Base(file), filepath.Ext(file))
+	}
+
+	if reservedName(name, caPath) {
+		return "", fmt.Errorf("invalid name %q, reserved for the ca, set another by -name", name)
+	}
+
+	return name, nil
+}
+
+// checkSigningCA loads the ca chain to config, checks the ca and clamps the validity to it
+func checkSigningCA(config *selfca.Certificate, caPath string, weak, fips, strict bool) {
+	chain, err := readCAChain(caPath)
+	if err != nil {
+		fail(exitCAMissing, "Failed to load ca chain", err)
+	}
+
+	config.Parent = &selfca.CA{Certificate: config.CACertificate, Key: config.CAKey, Chain: chain}
+
+	err = checkWeakCA(*config, weak)
+	if err == nil {
+		err = checkFIPS(*config, fips)
+	}
+	if err != nil {
+		fail(exitBadInput, "Refused to use the ca certificate", err)
+	}
+
+	err = checkCAValidity(config, strict)
+	if err != nil {
+		fail(exitBadInput, "Refused to sign the certificate request", err)
+	}
+}
+
+// writeSigned writes the certificate, the fullchain file and the chain file of issuance
+func writeSigned(certPath string, issuance *selfca.Issuance, fullChainRoot bool) {
+	err := issuance.Write(certPath)
+	if err != nil {
+		fail(exitIO, "Failed to write the certificate", err)
+	}
+
+	err = issuance.WriteChain(certPath, fullChainRoot)
+	if err != nil {
+		fail(exitIO, "Failed to write the fullchain file", err)
+	}
+
+	var chain bytes.Buffer
+	for _, v := range issuance.Chain {
+		_ = pem.Encode(&chain, &pem.Block{Type: "CERTIFICATE", Bytes: v.Raw})
+	}
+
+	err = os.WriteFile(certPath+".chain.crt", chain.Bytes(), 0644)
+	if err != nil {
+		fail(exitIO, "Failed to write the certificate chain", err)
+	}
+}
+
+// parseOID parses the dotted OID like 1.2.3.4
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, fmt.Errorf("invalid OID %s", s)
+	}
+
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, v := range parts {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid OID %s", s)
+		}
+		oid[i] = n
+	}
+
+	return oid, nil
+}
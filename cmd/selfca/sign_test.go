@@ -0,0 +1,55 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestSignName(t *testing.T) {
+	tests := []struct {
+		name   string
+		file   string
+		caPath string
+		out    string
+	}{
+		{"", "csr/web.csr", "cert/ca", "web"},
+		{"api", "csr/web.csr", "cert/ca", "api"},
+		{"", "ca.likexian.com.csr", "cert/ca", "ca.likexian.com"},
+		{"", "ca.csr", "cert/ca", ""},
+		{"", "CA.csr", "cert/ca", ""},
+		{"", "intermediate.csr", "cert/ca", ""},
+		{"", "root.csr", "pki/root", ""},
+		{"root.chain", "web.csr", "pki/root", ""},
+		{"ca.20240101", "web.csr", "cert/ca", ""},
+	}
+
+	for _, v := range tests {
+		name, err := signName(v.name, v.file, v.caPath)
+		if v.out == "" {
+			assert.NotNil(t, err, v)
+			continue
+		}
+		assert.Nil(t, err, v)
+		assert.Equal(t, name, v.out, v)
+	}
+}
@@ -0,0 +1,33 @@
+//go:build !(js || plan9 || wasip1)
+
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// stopSignals are the signals stopping the long running commands
+var stopSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
+
+// reloadSignals are the signals reloading the config and certificates
+var reloadSignals = []os.Signal{syscall.SIGHUP}
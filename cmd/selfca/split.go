@@ -0,0 +1,185 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto"
+	"crypto/x509"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/likexian/selfca"
+)
+
+// unsafeName matches the chars not safe for file name
+var unsafeName = regexp.MustCompile(`[^A-Za-z0-9._-]+`)
+
+// publicKey is the public key which can be compared
+type publicKey interface {
+	Equal(crypto.PublicKey) bool
+}
+
+// privateKey is the private key which has public key
+type privateKey interface {
+	Public() crypto.PublicKey
+}
+
+// split splits the concatenated PEM file into individual files
+func split(args []string) {
+	fs := flag.NewFlagSet("selfca split", flag.ExitOnError)
+	output := fs.String("o", ".", "Folder for saving the split files (default current folder)")
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca split <bundle.pem> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	files := parseArgs(fs, args)
+	if len(files) != 1 {
+		failUsage(fs, "Missing bundle file")
+	}
+
+	data, err := os.ReadFile(files[0])
+	if err != nil {
+		fail(exitIO, "Failed to read the bundle file", err)
+	}
+
+	var blocks []*pem.Block
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		blocks = append(blocks, block)
+	}
+
+	if len(blocks) == 0 {
+		fail(exitBadInput, "Failed to split the bundle file", fmt.Errorf("no PEM block found"))
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	names := splitNames(blocks)
+	for i, block := range blocks {
+		path := filepath.Join(*output, names[i])
+		mode := os.FileMode(0644)
+		if strings.HasSuffix(block.Type, "PRIVATE KEY") {
+			mode = 0600
+		}
+		err = os.WriteFile(path, pem.EncodeToMemory(block), mode)
+		if err != nil {
+			fail(exitIO, "Failed to write the split file", err)
+		}
+		fmt.Println(path)
+	}
+}
+
+// splitNames returns the file names of PEM blocks, derived from
+// the certificate subjects, keys are named after their certificates
+func splitNames(blocks []*pem.Block) []string {
+	names := make([]string, len(blocks))
+	used := map[string]bool{}
+	uniqueName := func(name, ext string) string {
+		result := name + ext
+		for i := 2; used[result]; i++ {
+			result = fmt.Sprintf("%s-%d%s", name, i, ext)
+		}
+		used[result] = true
+		return result
+	}
+
+	var certificates []*x509.Certificate
+	var certificateNames []string
+	for i, block := range blocks {
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		name := fmt.Sprintf("certificate-%d", i+1)
+		if c, err := x509.ParseCertificate(block.Bytes); err == nil {
+			name = subjectName(c, name)
+			certificates = append(certificates, c)
+			certificateNames = append(certificateNames, name)
+		}
+		names[i] = uniqueName(name, ".crt")
+	}
+
+	for i, block := range blocks {
+		switch {
+		case block.Type == "CERTIFICATE":
+		case strings.HasSuffix(block.Type, "PRIVATE KEY"):
+			name := fmt.Sprintf("key-%d", i+1)
+			if key, err := selfca.ParsePrivateKey(block.Bytes); err == nil {
+				for j, c := range certificates {
+					if k, ok := c.PublicKey.(publicKey); ok && k.Equal(publicKeyOf(key)) {
+						name = certificateNames[j]
+						break
+					}
+				}
+			}
+			names[i] = uniqueName(name, ".key")
+		case block.Type == "CERTIFICATE REQUEST":
+			names[i] = uniqueName(fmt.Sprintf("request-%d", i+1), ".csr")
+		case block.Type == "X509 CRL":
+			names[i] = uniqueName(fmt.Sprintf("crl-%d", i+1), ".crl")
+		default:
+			names[i] = uniqueName(fmt.Sprintf("block-%d", i+1), ".pem")
+		}
+	}
+
+	return names
+}
+
+// subjectName returns the file name derived from certificate subject
+func subjectName(c *x509.Certificate, name string) string {
+	switch {
+	case c.Subject.CommonName != "":
+		name = c.Subject.CommonName
+	case len(c.DNSNames) > 0:
+		name = c.DNSNames[0]
+	case len(c.Subject.Organization) > 0:
+		name = c.Subject.Organization[0]
+	}
+
+	name = strings.ReplaceAll(name, "*", "wildcard")
+	name = strings.Trim(unsafeName.ReplaceAllString(name, "_"), "._")
+	if name == "" {
+		name = "certificate"
+	}
+
+	return name
+}
+
+// publicKeyOf returns the public key of private key
+func publicKeyOf(key crypto.PrivateKey) crypto.PublicKey {
+	if k, ok := key.(privateKey); ok {
+		return k.Public()
+	}
+
+	return nil
+}
@@ -0,0 +1,83 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/binary"
+	"encoding/pem"
+	"fmt"
+	"os"
+)
+
+const (
+	// sstCertificate is the element type of certificate in serialized store
+	sstCertificate = 32
+	// sstEncoding is the X509_ASN_ENCODING of elements
+	sstEncoding = 1
+)
+
+// sst returns the Windows serialized certificate store containing the certificates
+func sst(certificates []*x509.Certificate) []byte {
+	var b bytes.Buffer
+	// the header is a zero version followed by the CERT magic
+	_ = binary.Write(&b, binary.LittleEndian, []uint32{0, 0x54524543})
+	for _, v := range certificates {
+		_ = binary.Write(&b, binary.LittleEndian, []uint32{sstCertificate, sstEncoding, uint32(len(v.Raw))})
+		b.Write(v.Raw)
+	}
+
+	// the store ends with an empty element
+	_ = binary.Write(&b, binary.LittleEndian, []uint32{0, 0, 0})
+
+	return b.Bytes()
+}
+
+// readCertificates returns the certificates in PEM file, other blocks are ignored
+func readCertificates(name string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+
+	var certificates []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+		certificates = append(certificates, c)
+	}
+
+	if len(certificates) == 0 {
+		return nil, fmt.Errorf("%s: no certificate found", name)
+	}
+
+	return certificates, nil
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// tlsa prints the DANE TLSA records of the certificate
+func tlsa(args []string) {
+	fs := flag.NewFlagSet("selfca tlsa", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the ca, for usage 0 and 2 (default cert)")
+	caFlag := addCAFlag(fs)
+	usage := fs.Int("usage", 3, "Certificate usage, 0 PKIX-TA, 1 PKIX-EE, 2 DANE-TA or 3 DANE-EE")
+	selector := fs.Int("selector", 1, "Selector, 0 full certificate or 1 public key")
+	mtype := fs.Int("mtype", 1, "Matching type, 0 full, 1 SHA-256 or 2 SHA-512")
+	port := fs.Int("port", 443, "Port of the service")
+	proto := fs.String("proto", "tcp", "Protocol of the service")
+	hosts := fs.String("h", "", "Hosts of the records, comma separated (default the domains of certificate)")
+	addErrorFlag(fs)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: selfca tlsa <cert.crt> [options]\n")
+		fs.PrintDefaults()
+	}
+
+	files := parseArgs(fs, args)
+	if len(files) != 1 {
+		failUsage(fs, "Missing certificate file")
+	}
+
+	if *usage < 0 || *usage > 3 || *selector < 0 || *selector > 1 || *mtype < 0 || *mtype > 2 {
+		failUsage(fs, "Invalid usage, selector or matching type")
+	}
+
+	certificates, err := readCertificates(files[0])
+	if err != nil {
+		fail(errorCode(err, exitBadInput), "Failed to load the certificate", err)
+	}
+
+	domains := certificates[0].DNSNames
+	if *hosts != "" {
+		domains = strings.Split(*hosts, ",")
+	}
+
+	// the trust anchor usages match the ca instead of the certificate
+	c := certificates[0]
+	if *usage == 0 || *usage == 2 {
+		ca, err := readCertificates(resolveCA(*caFlag, *output) + ".crt")
+		if err != nil {
+			fail(errorCode(err, exitCAMissing), "Failed to load ca certificate", err)
+		}
+		c = ca[0]
+	}
+
+	data := tlsaData(c, *selector, *mtype)
+	printed := 0
+	for _, v := range domains {
+		v = strings.TrimSpace(v)
+		if strings.HasPrefix(v, "*.") {
+			fmt.Fprintf(os.Stderr, "WARNING: skipped wildcard %s, TLSA record needs the exact host\n", v)
+			continue
+		}
+		fmt.Printf("_%d._%s.%s. IN TLSA %d %d %d %s\n", *port, *proto, strings.TrimSuffix(v, "."),
+			*usage, *selector, *mtype, data)
+		printed++
+	}
+
+	if printed == 0 {
+		fail(exitBadInput, "Failed to generate TLSA record", errors.New("no domain found, set by -h"))
+	}
+}
+
+// tlsaData returns the hex certificate association data of TLSA record
+func tlsaData(c *x509.Certificate, selector, mtype int) string {
+	data := c.Raw
+	if selector == 1 {
+		data = c.RawSubjectPublicKeyInfo
+	}
+
+	switch mtype {
+	case 1:
+		sum := sha256.Sum256(data)
+		data = sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		data = sum[:]
+	}
+
+	return hex.EncodeToString(data)
+}
@@ -0,0 +1,115 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"crypto"
+	"flag"
+	"io"
+	"os"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	semconv "go.opentelemetry.io/otel/semconv/v1.26.0"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// tracerName is the instrumentation name of the spans of selfca
+const tracerName = "github.com/likexian/selfca/cmd/selfca"
+
+// addTraceFlag adds the flag of OTLP endpoint, the OpenTelemetry environments are the default
+func addTraceFlag(fs *flag.FlagSet) *string {
+	return fs.String("otlp-endpoint", "", "OTLP/HTTP endpoint of OpenTelemetry collector the spans are exported to, "+
+		"like http://127.0.0.1:4318 (default $OTEL_EXPORTER_OTLP_TRACES_ENDPOINT or $OTEL_EXPORTER_OTLP_ENDPOINT, "+
+		"disabled if unset)")
+}
+
+// startTracing sets the global tracer provider exporting to the OTLP endpoint, and returns the shutdown
+// flushing the spans, the tracing is disabled if neither the endpoint nor the environments are set
+func startTracing(ctx context.Context, endpoint string) (func(context.Context), error) {
+	if endpoint == "" && os.Getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT") == "" &&
+		os.Getenv("OTEL_EXPORTER_OTLP_ENDPOINT") == "" {
+		return func(context.Context) {}, nil
+	}
+
+	var options []otlptracehttp.Option
+	if endpoint != "" {
+		options = append(options, otlptracehttp.WithEndpointURL(endpoint))
+	}
+
+	exporter, err := otlptracehttp.New(ctx, options...)
+	if err != nil {
+		return nil, err
+	}
+
+	// the OTEL_SERVICE_NAME and OTEL_RESOURCE_ATTRIBUTES override the service name
+	res, err := resource.New(ctx, resource.WithAttributes(semconv.ServiceName("selfca")),
+		resource.WithFromEnv(), resource.WithTelemetrySDK())
+	if err != nil {
+		return nil, err
+	}
+
+	provider := sdktrace.NewTracerProvider(sdktrace.WithBatcher(exporter), sdktrace.WithResource(res))
+	otel.SetTracerProvider(provider)
+	otel.SetTextMapPropagator(propagation.TraceContext{})
+
+	return func(ctx context.Context) {
+		err := provider.Shutdown(ctx)
+		if err != nil {
+			debugf("Failed to export the spans: %v", err)
+		}
+	}, nil
+}
+
+// startSpan starts the child span of ctx by the global tracer provider
+func startSpan(ctx context.Context, name string) (context.Context, trace.Span) {
+	return otel.Tracer(tracerName).Start(ctx, name)
+}
+
+// endSpan ends the span, recording the error if any
+func endSpan(span trace.Span, err error) {
+	if err != nil {
+		span.RecordError(err)
+		span.SetStatus(codes.Error, err.Error())
+	}
+
+	span.End()
+}
+
+// tracedSigner is the signer tracing each signing in a child span of ctx
+type tracedSigner struct {
+	crypto.Signer
+	ctx  context.Context
+	name string
+}
+
+// Sign signs the digest in the span
+func (t *tracedSigner) Sign(r io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	_, span := startSpan(t.ctx, t.name)
+	signature, err := t.Signer.Sign(r, digest, opts)
+	endSpan(span, err)
+
+	return signature, err
+}
@@ -0,0 +1,89 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/selfca"
+	"go.opentelemetry.io/contrib/instrumentation/net/http/otelhttp"
+	"go.opentelemetry.io/otel"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+	"go.opentelemetry.io/otel/sdk/trace/tracetest"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestStartTracingDisabled(t *testing.T) {
+	t.Setenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT", "")
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "")
+
+	stop, err := startTracing(context.Background(), "")
+	assert.Nil(t, err)
+	stop(context.Background())
+}
+
+func TestOCSPSpans(t *testing.T) {
+	recorder := tracetest.NewSpanRecorder()
+	provider := sdktrace.NewTracerProvider(sdktrace.WithSpanProcessor(recorder))
+	previous := otel.GetTracerProvider()
+	otel.SetTracerProvider(provider)
+	defer otel.SetTracerProvider(previous)
+
+	ca, err := selfca.NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(selfca.Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	caPath := filepath.Join(t.TempDir(), "ca")
+	db, err := selfca.OpenDatabase(caPath + ".db")
+	assert.Nil(t, err)
+	assert.Nil(t, db.Add(leaf.Certificate))
+	assert.Nil(t, db.Save())
+
+	request, err := ocsp.CreateRequest(leaf.Certificate, ca.Certificate, nil)
+	assert.Nil(t, err)
+
+	handler := otelhttp.NewHandler(&ocspResponder{ca: ca, caPath: caPath, validity: selfca.DefaultOCSPValidity},
+		"ocsp.request")
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(request)))
+
+	response, err := ocsp.ParseResponseForCert(w.Body.Bytes(), leaf.Certificate, ca.Certificate)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Status, ocsp.Good)
+
+	spans := map[string]sdktrace.ReadOnlySpan{}
+	for _, v := range recorder.Ended() {
+		spans[v.Name()] = v
+	}
+
+	assert.Equal(t, len(spans), 4)
+	root := spans["ocsp.request"].SpanContext().SpanID()
+	assert.Equal(t, spans["ocsp.parse"].Parent().SpanID(), root)
+	assert.Equal(t, spans["ocsp.policy"].Parent().SpanID(), root)
+	assert.Equal(t, spans["ocsp.sign"].Parent().SpanID(), spans["ocsp.policy"].SpanContext().SpanID())
+}
@@ -0,0 +1,235 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"bytes"
+	"crypto/x509"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/likexian/selfca"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// systemBundles is the well-known paths of system ca bundle
+var systemBundles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",
+	"/etc/pki/tls/certs/ca-bundle.crt",
+	"/etc/ssl/ca-bundle.pem",
+	"/etc/pki/tls/cacert.pem",
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem",
+	"/etc/ssl/cert.pem",
+	"/usr/local/etc/ssl/cert.pem",
+	"/usr/local/share/certs/ca-root-nss.crt",
+}
+
+// trust writes the trust setup of the ca for common runtimes
+func trust(args []string) {
+	fs := flag.NewFlagSet("selfca trust", flag.ExitOnError)
+	output := fs.String("o", "cert", "Folder of the ca (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	out := fs.String("out", "", "Folder for saving the trust files (default trust in the ca folder)")
+	certifi := fs.String("certifi", "", "Path of the Python certifi bundle to append to (default the system bundle)")
+	password := fs.String("password", "changeit", "Password of the Java truststore")
+	var intermediates stringsFlag
+	fs.Var(&intermediates, "intermediate", "PEM file of intermediate ca for intermediates.sst, may be repeated")
+	adb := fs.Bool("adb", false, "Install the ca to the system store of rooted Android device or emulator by adb")
+	signWith := fs.String("sign-mobileconfig", "", "Certificate name in the ca folder to sign the mobileconfig with, "+
+		"like ca (default unsigned)")
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	caPath := resolveCA(*caFlag, *output)
+	if *out == "" {
+		*out = filepath.Join(filepath.Dir(caPath), "trust")
+	}
+
+	certificates, err := readCertificates(caPath + ".crt")
+	if err != nil {
+		fail(errorCode(err, exitCAMissing), "Failed to load ca certificate", err)
+	}
+
+	caPEM, err := os.ReadFile(caPath + ".crt")
+	if err != nil {
+		fail(exitIO, "Failed to load ca certificate", err)
+	}
+
+	system, systemPath := readSystemBundle()
+	if systemPath == "" {
+		infof("WARNING: system ca bundle not found, the bundles only trust the ca")
+	}
+
+	python := system
+	if *certifi != "" {
+		python, err = os.ReadFile(*certifi)
+		if err != nil {
+			fail(exitIO, "Failed to read certifi bundle", err)
+		}
+	}
+
+	truststore, err := pkcs12.Modern.EncodeTrustStore(certificates[:1], *password)
+	if err != nil {
+		fail(exitCrypto, "Failed to encode Java truststore", err)
+	}
+
+	jks, err := selfca.JKSTrustStore(certificates[:1], *password)
+	if err != nil {
+		fail(exitCrypto, "Failed to encode Java truststore", err)
+	}
+
+	profile := mobileconfig(certificates[0])
+	if *signWith != "" {
+		profile = signMobileconfig(profile, *output, *signWith, caPath)
+	}
+
+	chain := readIntermediates(intermediates)
+
+	magisk, err := magiskModule(certificates[0])
+	if err != nil {
+		fail(exitFailure, "Failed to create magisk module", err)
+	}
+
+	err = os.MkdirAll(*out, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	dir, err := filepath.Abs(*out)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	env := fmt.Sprintf(`export NODE_EXTRA_CA_CERTS="%[1]s/node-extra-ca-certs.pem"
+export REQUESTS_CA_BUNDLE="%[1]s/python-ca-bundle.pem"
+export SSL_CERT_FILE="%[1]s/ca-bundle.pem"
+export JAVA_TOOL_OPTIONS="-Djavax.net.ssl.trustStore=%[1]s/truststore.p12 -Djavax.net.ssl.trustStoreType=PKCS12 `+
+		`-Djavax.net.ssl.trustStorePassword=%[2]s"
+`, dir, *password)
+
+	files := []struct {
+		name string
+		data []byte
+	}{
+		{"node-extra-ca-certs.pem", caPEM},
+		{"python-ca-bundle.pem", appendBundle(python, caPEM)},
+		{"ca-bundle.pem", appendBundle(system, caPEM)},
+		{"truststore.p12", truststore},
+		{"truststore.jks", jks},
+		{"ca.mobileconfig", profile},
+		{androidName(certificates[0]), certificates[0].Raw},
+		{"selfca-magisk.zip", magisk},
+		{"ca.sst", sst(certificates[:1])},
+		{"trust.env", []byte(env)},
+	}
+
+	if len(chain) > 0 {
+		files = append(files, struct {
+			name string
+			data []byte
+		}{"intermediates.sst", sst(chain)})
+	}
+
+	for _, v := range files {
+		err = os.WriteFile(filepath.Join(*out, v.name), v.data, 0644)
+		if err != nil {
+			fail(exitIO, "Failed to write trust file", err)
+		}
+		debugf("Wrote %s", filepath.Join(*out, v.name))
+	}
+
+	if *adb {
+		err = adbPush(filepath.Join(*out, androidName(certificates[0])), androidName(certificates[0]))
+		if err != nil {
+			fail(exitFailure, "Failed to install ca by adb", err)
+		}
+		infof("Installed ca to Android system store by adb, reboot the device to apply")
+	}
+
+	infof("Wrote trust files to %s, load them with: . %s", *out, filepath.Join(*out, "trust.env"))
+}
+
+// signMobileconfig signs the mobileconfig profile by the certificate name in the ca folder, or the ca
+func signMobileconfig(profile []byte, output, name, caPath string) []byte {
+	signer := filepath.Join(output, name)
+	if name == "ca" {
+		signer = caPath
+	}
+
+	signers, key, err := selfca.ReadCertificateWithPassphrase(signer, passphrase)
+	if err != nil {
+		fail(errorCode(err, exitBadInput), "Failed to load mobileconfig signer", err)
+	}
+
+	profile, err = signCMS(profile, signers, key)
+	if err != nil {
+		fail(exitCrypto, "Failed to sign mobileconfig", err)
+	}
+
+	return profile
+}
+
+// readIntermediates reads the intermediate cas from the PEM files
+func readIntermediates(files []string) []*x509.Certificate {
+	var chain []*x509.Certificate
+	for _, v := range files {
+		list, err := readCertificates(v)
+		if err != nil {
+			fail(exitBadInput, "Failed to load intermediate ca", err)
+		}
+		chain = append(chain, list...)
+	}
+
+	return chain
+}
+
+// readSystemBundle returns the system ca bundle and its path, empty if not found
+func readSystemBundle() ([]byte, string) {
+	paths := systemBundles
+	if v := os.Getenv("SSL_CERT_FILE"); v != "" {
+		paths = append([]string{v}, paths...)
+	}
+
+	for _, v := range paths {
+		data, err := os.ReadFile(v)
+		if err == nil {
+			return data, v
+		}
+	}
+
+	return nil, ""
+}
+
+// appendBundle returns the bundle with the ca appended, unless it is included
+func appendBundle(bundle, ca []byte) []byte {
+	if bytes.Contains(bundle, bytes.TrimSpace(ca)) {
+		return bundle
+	}
+
+	result := append([]byte{}, bundle...)
+	if len(result) > 0 && result[len(result)-1] != '\n' {
+		result = append(result, '\n')
+	}
+
+	return append(result, ca...)
+}
@@ -0,0 +1,201 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"flag"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/likexian/selfca"
+	admissionregistrationv1 "k8s.io/api/admissionregistration/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+// webhookTimeout is the timeout of updating kubernetes for the webhook
+const webhookTimeout = 30 * time.Second
+
+// webhook issues the serving certificate of kubernetes admission webhook service, writes it to the tls secret,
+// and patches the ca bundle of the validating and mutating webhook configurations
+func webhook(args []string) {
+	fs := flag.NewFlagSet("selfca webhook", flag.ExitOnError)
+	service := fs.String("service", "", "Name of the webhook service")
+	namespace := fs.String("namespace", "default", "Namespace of the webhook service and secret (default default)")
+	secret := fs.String("secret", "", "Name of the tls secret created or updated (default the service name with -tls)")
+	validating := fs.String("validating", "", "Name of the ValidatingWebhookConfiguration to patch the caBundle")
+	mutating := fs.String("mutating", "", "Name of the MutatingWebhookConfiguration to patch the caBundle")
+	server := fs.String("server", "", "URL of the kubernetes api server, like http://127.0.0.1:8001 of kubectl proxy "+
+		"(default the cluster running in, or the current context of kubeconfig)")
+	tokenFile := fs.String("token-file", "", "Path of the bearer token file of the api server (default the service "+
+		"account token)")
+	days := fs.Int("d", 365, "Valid days of the certificate, for example 365 (default 365 days)")
+	output := fs.String("o", "cert", "Folder of the ca and for saving the certificate (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if *service == "" {
+		failUsage(fs, "Missing service parameter")
+	}
+
+	if *secret == "" {
+		*secret = *service + "-tls"
+	}
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	kube, err := newKubeClient(*server, *tokenFile)
+	if err != nil {
+		fail(exitBadInput, "Failed to connect to kubernetes", err)
+	}
+
+	if len(*output) == 0 {
+		*output = "cert"
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	host := *service + "." + *namespace + ".svc"
+	now := time.Now()
+	issuance, err := issueCertificate(issueRequest{
+		Output:     *output,
+		CA:         *caFlag,
+		CATemplate: caConfig,
+		Name:       host,
+		Config: selfca.Certificate{
+			KeySize:   selfca.DefaultKeySize(selfca.KeyTypeRSA),
+			NotBefore: now,
+			NotAfter:  now.Add(time.Duration(*days*24) * time.Hour),
+			Hosts:     []string{host, *service, *service + "." + *namespace, host + ".cluster.local"},
+		},
+	})
+	if err != nil {
+		failError(err)
+	}
+
+	defer issuance.Zero()
+
+	root := issuance.Chain[len(issuance.Chain)-1]
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: root.Raw})
+
+	ctx, cancel := context.WithTimeout(context.Background(), webhookTimeout)
+	defer cancel()
+
+	err = kube.applySecretData(ctx, *namespace, *secret, "kubernetes.io/tls", map[string][]byte{
+		"tls.crt": selfca.ChainPEM(append([]*x509.Certificate{issuance.Certificate}, issuance.Chain...), false),
+		"tls.key": issuance.KeyPEM,
+		"ca.crt":  caBundle,
+	})
+	if err != nil {
+		fail(exitFailure, "Failed to write the secret", err)
+	}
+
+	infof("Wrote secret %s/%s of %s, valid until %s", *namespace, *secret, host,
+		issuance.Certificate.NotAfter.Format(time.RFC3339))
+
+	for _, v := range [][2]string{{"validating", *validating}, {"mutating", *mutating}} {
+		kind, name := v[0], v[1]
+		if name == "" {
+			continue
+		}
+		n, err := patchCABundle(ctx, kube, kind, name, *service, *namespace, caBundle)
+		if err != nil {
+			fail(exitFailure, fmt.Sprintf("Failed to patch the %s webhook configuration %s", kind, name), err)
+		}
+		infof("Patched the caBundle of %d webhooks in %s webhook configuration %s", n, kind, name)
+	}
+}
+
+// patchCABundle patches the ca bundle of the webhooks calling the service in the validating or mutating
+// webhook configuration, returns the number of webhooks patched
+func patchCABundle(ctx context.Context, kube *kubeClient, kind, name, service, namespace string,
+	caBundle []byte) (int, error) {
+	admission := kube.clientset.AdmissionregistrationV1()
+
+	var services []*admissionregistrationv1.ServiceReference
+	switch kind {
+	case "validating":
+		c, err := admission.ValidatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		for _, v := range c.Webhooks {
+			services = append(services, v.ClientConfig.Service)
+		}
+	case "mutating":
+		c, err := admission.MutatingWebhookConfigurations().Get(ctx, name, metav1.GetOptions{})
+		if err != nil {
+			return 0, err
+		}
+		for _, v := range c.Webhooks {
+			services = append(services, v.ClientConfig.Service)
+		}
+	default:
+		return 0, fmt.Errorf("unsupported webhook configuration %s", kind)
+	}
+
+	var patch []map[string]any
+	for i, s := range services {
+		if s != nil && s.Name == service && s.Namespace == namespace {
+			patch = append(patch, map[string]any{
+				"op":    "add",
+				"path":  fmt.Sprintf("/webhooks/%d/clientConfig/caBundle", i),
+				"value": caBundle,
+			})
+		}
+	}
+
+	if len(patch) == 0 {
+		return 0, fmt.Errorf("no webhook calls service %s/%s", namespace, service)
+	}
+
+	data, err := json.Marshal(patch)
+	if err != nil {
+		return 0, err
+	}
+
+	if kind == "validating" {
+		_, err = admission.ValidatingWebhookConfigurations().Patch(ctx, name, types.JSONPatchType, data,
+			metav1.PatchOptions{})
+	} else {
+		_, err = admission.MutatingWebhookConfigurations().Patch(ctx, name, types.JSONPatchType, data,
+			metav1.PatchOptions{})
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	return len(patch), nil
+}
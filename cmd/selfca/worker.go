@@ -0,0 +1,261 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/likexian/selfca"
+)
+
+// workerRetry is the delay before reconnecting to the queue
+const workerRetry = 5 * time.Second
+
+// workerMaxBits is the max key size of the queued requests, larger RSA keys take too long to generate
+const workerMaxBits = 4096
+
+// workerMaxDays is the max valid days of the queued requests
+const workerMaxDays = 825
+
+// workerRequest is the issuance request received from queue
+type workerRequest struct {
+	// ID is echoed back in the result for correlating
+	ID string `json:"id"`
+	configCertificate
+}
+
+// workerResult is the issuance result published to queue
+type workerResult struct {
+	ID          string     `json:"id"`
+	Name        string     `json:"name,omitempty"`
+	Certificate string     `json:"certificate,omitempty"`
+	Chain       string     `json:"chain,omitempty"`
+	Key         string     `json:"key,omitempty"`
+	NotAfter    *time.Time `json:"not_after,omitempty"`
+	Error       string     `json:"error,omitempty"`
+}
+
+// worker issues the certificates requested from redis stream or nats subject, and publishes the results
+func worker(args []string) {
+	fs := flag.NewFlagSet("selfca worker", flag.ExitOnError)
+	queueURL := fs.String("queue", "", "URL of the queue, redis://[:password@]host:port/db or "+
+		"nats://[user:password@]host:port")
+	requests := fs.String("requests", "selfca.requests", "Redis stream or nats subject of the requests")
+	results := fs.String("results", "selfca.results", "Redis stream or nats subject of the results, the nats requests "+
+		"are replied to instead")
+	group := fs.String("group", "selfca", "Redis consumer group or nats queue group of the workers")
+	noKey := fs.Bool("no-key", false, "Do not publish the key in the results, only write it to the output folder")
+	output := fs.String("o", "cert", "Folder for saving the certificates (default cert)")
+	caFlag := addCAFlag(fs)
+	addPassphraseFlags(fs)
+	caName, caSubject := addCASubjectFlags(fs)
+	fips := fs.Bool("fips", false, "Only allow FIPS approved key sizes and signature algorithms")
+	var hooks stringsFlag
+	fs.Var(&hooks, "hook", "Command to run after each certificate is issued, can be repeated, the hooks in requests are "+
+		"refused")
+	addOutputFlags(fs)
+	addErrorFlag(fs)
+	_ = fs.Parse(args)
+
+	if *queueURL == "" {
+		failUsage(fs, "Missing queue parameter")
+	}
+
+	u, err := url.Parse(*queueURL)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse queue parameter", err)
+	}
+
+	if u.Scheme != "redis" && u.Scheme != "nats" || u.Host == "" {
+		failUsage(fs, "Unsupported queue parameter, only redis://host:port and nats://host:port")
+	}
+
+	caConfig, err := caTemplate(*caName, *caSubject)
+	if err != nil {
+		fail(exitBadInput, "Failed to parse ca-subject parameter", err)
+	}
+
+	err = os.MkdirAll(*output, 0755)
+	if err != nil {
+		fail(exitIO, "Failed to create output folder", err)
+	}
+
+	config := queueConfig{url: u, requests: *requests, results: *results, group: *group}
+	base := issueRequest{Output: *output, CA: *caFlag, CATemplate: caConfig, FIPS: *fips, Hooks: hooks}
+	stop := stopSignal()
+	for {
+		q, err := dialQueue(config)
+		if err != nil {
+			log.Printf("Failed to connect to the queue, retrying in %s: %v", workerRetry, err)
+		} else {
+			infof("Waiting for requests on %s %s", u.Scheme, *requests)
+			done := make(chan error, 1)
+			go func() { done <- consume(q, base, !*noKey) }()
+			select {
+			case <-stop:
+				q.close()
+				return
+			case err = <-done:
+				q.close()
+				log.Printf("Disconnected from the queue, retrying in %s: %v", workerRetry, err)
+			}
+		}
+
+		select {
+		case <-stop:
+			return
+		case <-time.After(workerRetry):
+		}
+	}
+}
+
+// consume issues the requests from queue until the queue fails
+func consume(q queue, base issueRequest, withKey bool) error {
+	for {
+		m, err := q.receive()
+		if err != nil {
+			return err
+		}
+
+		result := issueQueued(m.data, base, withKey)
+		if result.Error != "" {
+			log.Printf("Failed to issue request %q: %s", result.ID, result.Error)
+		} else if !quiet {
+			log.Printf("Issued %s, valid until %s", result.Name, result.NotAfter.Format(time.RFC3339))
+		}
+
+		data, _ := json.Marshal(result)
+		err = q.publish(m, data)
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// issueQueued issues the certificate of request json, the failure is returned in result
+func issueQueued(data []byte, base issueRequest, withKey bool) workerResult {
+	var r workerRequest
+	err := json.Unmarshal(data, &r)
+	if err != nil {
+		return workerResult{Error: fmt.Sprintf("invalid request: %v", err)}
+	}
+
+	v := &r.configCertificate
+	err = checkQueued(v, resolveCA(base.CA, base.Output))
+	if err != nil {
+		return workerResult{ID: r.ID, Error: fmt.Sprintf("invalid request: %v", err)}
+	}
+
+	if v.Wildcard {
+		v.Hosts = editHosts(v.Hosts, pairedHosts(v.Hosts), nil)
+	}
+
+	now := time.Now()
+	request := base
+	request.Name = v.Name
+	request.Config = selfca.Certificate{
+		CommonName: v.CommonName,
+		KeySize:    v.Bits,
+		KeyType:    selfca.KeyType(v.KeyType),
+		NotBefore:  now,
+		NotAfter:   now.Add(time.Duration(v.Days*24) * time.Hour),
+		Hosts:      v.Hosts,
+	}
+
+	issuance, err := issueCertificate(request)
+	if issuance == nil {
+		return workerResult{ID: r.ID, Name: v.Name, Error: err.Error()}
+	}
+
+	defer issuance.Zero()
+
+	result := workerResult{
+		ID:          r.ID,
+		Name:        v.Name,
+		Certificate: string(issuance.PEM),
+		Chain:       string(selfca.ChainPEM(issuance.Chain, true)),
+		NotAfter:    &issuance.Certificate.NotAfter,
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+	if withKey {
+		result.Key = string(issuance.KeyPEM)
+	}
+
+	return result
+}
+
+// checkQueued checks the queued request from the untrusted queue, and applies the defaults,
+// the hooks are refused as only the operator runs commands, the name must be a plain file name
+// not of the ca files at caPath, and the bits and days are capped
+func checkQueued(v *configCertificate, caPath string) error {
+	var err error
+	v.Hosts, err = expandHosts(v.Hosts)
+	if err != nil {
+		return err
+	}
+
+	if len(v.Hosts) == 0 {
+		return errors.New("no hosts")
+	}
+
+	if len(v.Hooks) > 0 {
+		return errors.New("hooks are not allowed, use -hook of the worker")
+	}
+
+	if v.KeyType != "" && !validKeyType(selfca.KeyType(v.KeyType)) {
+		return fmt.Errorf("unsupported key type %s", v.KeyType)
+	}
+
+	if v.Name == "" {
+		v.Name = hostFileName(v.Hosts[0])
+	}
+	if strings.ContainsAny(v.Name, `/\`) || strings.Contains(v.Name, "..") || v.Name == "." {
+		return fmt.Errorf("invalid name %q, must not contain path separators or ..", v.Name)
+	}
+	if reservedName(v.Name, caPath) {
+		return fmt.Errorf("invalid name %q, reserved for the ca", v.Name)
+	}
+
+	if v.Bits <= 0 {
+		v.Bits = selfca.DefaultKeySize(selfca.KeyType(v.KeyType))
+	}
+	if v.Bits > workerMaxBits {
+		return fmt.Errorf("bits %d is larger than %d", v.Bits, workerMaxBits)
+	}
+
+	if v.Days <= 0 {
+		v.Days = 365
+	}
+	if v.Days > workerMaxDays {
+		return fmt.Errorf("days %d is larger than %d", v.Days, workerMaxDays)
+	}
+
+	return nil
+}
@@ -0,0 +1,113 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package main
+
+import (
+	"encoding/json"
+	"os"
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestCheckQueued(t *testing.T) {
+	v := &configCertificate{Hosts: []string{"likexian.com"}}
+	assert.Nil(t, checkQueued(v, "cert/ca"))
+	assert.Equal(t, v.Name, "likexian.com")
+	assert.Equal(t, v.Bits, 2048)
+	assert.Equal(t, v.Days, 365)
+
+	v = &configCertificate{Hosts: []string{"likexian.com"}, KeyType: "ecdsa"}
+	assert.Nil(t, checkQueued(v, "cert/ca"))
+	assert.Equal(t, v.Bits, 256)
+
+	v = &configCertificate{Hosts: []string{"ca.likexian.com"}}
+	assert.Nil(t, checkQueued(v, "cert/ca"))
+	assert.Equal(t, v.Name, "ca.likexian.com")
+
+	v = &configCertificate{Hosts: []string{"spiffe://example.org/web"}}
+	assert.Nil(t, checkQueued(v, "cert/ca"))
+	assert.Equal(t, v.Name, "spiffe_example.org_web")
+
+	tests := []struct {
+		in  configCertificate
+		out string
+	}{
+		{configCertificate{}, "no hosts"},
+		{configCertificate{Hosts: []string{"10.0.0.0/33"}}, "invalid CIDR"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Hooks: []string{"rm -rf /"}}, "hooks are not allowed"},
+		{configCertificate{Hosts: []string{"likexian.com"}, KeyType: "dsa"}, "unsupported key type"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: "../ca"}, "invalid name"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: "a/b"}, "invalid name"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: `a\b`}, "invalid name"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: ".."}, "invalid name"},
+		{configCertificate{Hosts: []string{"ca"}}, "reserved for the ca"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: "CA"}, "reserved for the ca"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: "ca.chain"}, "reserved for the ca"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: "intermediate"}, "reserved for the ca"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: "root"}, "reserved for the ca"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: "root.cross"}, "reserved for the ca"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Name: "root.20240101"}, "reserved for the ca"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Bits: 16384}, "bits 16384"},
+		{configCertificate{Hosts: []string{"likexian.com"}, Days: 36500}, "days 36500"},
+	}
+
+	for _, v := range tests {
+		err := checkQueued(&v.in, "/etc/selfca/root")
+		assert.NotNil(t, err)
+		assert.Contains(t, err.Error(), v.out)
+	}
+}
+
+func TestIssueQueued(t *testing.T) {
+	output := t.TempDir()
+	base := issueRequest{Output: output}
+
+	result := issueQueued([]byte("{"), base, true)
+	assert.Contains(t, result.Error, "invalid request")
+
+	result = issueQueued([]byte(`{"id": "1", "hosts": ["likexian.com"], "hooks": ["touch pwned"]}`), base, true)
+	assert.Equal(t, result.ID, "1")
+	assert.Contains(t, result.Error, "hooks are not allowed")
+
+	result = issueQueued([]byte(`{"id": "2", "hosts": ["likexian.com"], "name": "../../pwned"}`), base, true)
+	assert.Equal(t, result.ID, "2")
+	assert.Contains(t, result.Error, "invalid name")
+
+	result = issueQueued([]byte(`{"id": "4", "hosts": ["likexian.com"], "name": "ca"}`), base, true)
+	assert.Equal(t, result.ID, "4")
+	assert.Contains(t, result.Error, "reserved for the ca")
+
+	request, _ := json.Marshal(workerRequest{ID: "3", configCertificate: configCertificate{
+		Hosts:   []string{"likexian.com"},
+		KeyType: "ecdsa",
+		Days:    30,
+	}})
+	result = issueQueued(request, base, false)
+	assert.Equal(t, result.Error, "")
+	assert.Equal(t, result.ID, "3")
+	assert.Equal(t, result.Name, "likexian.com")
+	assert.Equal(t, result.Key, "")
+	assert.True(t, strings.HasPrefix(result.Certificate, "-----BEGIN CERTIFICATE-----"))
+
+	_, err := os.Stat(output + "/likexian.com.crt")
+	assert.Nil(t, err)
+}
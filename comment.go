@@ -0,0 +1,61 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// PEMComment returns the comments of subject, SANs, expiry and fingerprint of certificate,
+// for writing above its PEM block, the PEM parsers ignore the text outside of blocks
+func PEMComment(c *x509.Certificate) []byte {
+	var sans []string
+	sans = append(sans, c.DNSNames...)
+	for _, v := range c.IPAddresses {
+		sans = append(sans, v.String())
+	}
+	sans = append(sans, c.EmailAddresses...)
+	for _, v := range c.URIs {
+		sans = append(sans, v.String())
+	}
+
+	sum := sha256.Sum256(c.Raw)
+	fingerprint := make([]string, len(sum))
+	for i, v := range sum {
+		fingerprint[i] = fmt.Sprintf("%02X", v)
+	}
+
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "# Subject: %s\n", c.Subject)
+	if len(sans) > 0 {
+		fmt.Fprintf(&b, "# SANs: %s\n", strings.Join(sans, ", "))
+	}
+	fmt.Fprintf(&b, "# Issuer: %s\n", c.Issuer)
+	fmt.Fprintf(&b, "# Not After: %s\n", c.NotAfter.UTC().Format(time.RFC3339))
+	fmt.Fprintf(&b, "# SHA-256 Fingerprint: %s\n", strings.Join(fingerprint, ":"))
+	fmt.Fprintf(&b, "# Generated by selfca %s\n", Version())
+
+	return b.Bytes()
+}
@@ -0,0 +1,55 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestPEMComment(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com", "127.0.0.1", "i@likexian.com"}})
+	assert.Nil(t, err)
+
+	comment := string(PEMComment(issuance.Certificate))
+	assert.Contains(t, comment, "# Subject: CN=likexian.com\n")
+	assert.Contains(t, comment, "# SANs: likexian.com, 127.0.0.1, i@likexian.com\n")
+	assert.Contains(t, comment, "# Issuer: "+ca.Certificate.Subject.String()+"\n")
+	assert.Contains(t, comment, "# Generated by selfca "+Version()+"\n")
+	assert.Contains(t, comment, strings.ToUpper(issuance.SHA256Fingerprint[:2])+":")
+
+	issuance.Annotate()
+	issuance.Annotate()
+	assert.Equal(t, strings.Count(string(issuance.PEM), "# Subject:"), 1)
+
+	name := t.TempDir() + "/likexian.com"
+	err = issuance.Write(name)
+	assert.Nil(t, err)
+
+	certificates, key, err := ReadCertificate(name)
+	assert.Nil(t, err)
+	assert.Equal(t, certificates[0].Raw, issuance.DER)
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+}
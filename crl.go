@@ -0,0 +1,123 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+)
+
+var (
+	// ErrCRLSignNotAllowed is ca certificate without CRL signing usage error
+	ErrCRLSignNotAllowed = errors.New("selfca: the ca certificate is not allowed to sign CRL")
+	// ErrNotSelfSigned is ca certificate not self-signed error
+	ErrNotSelfSigned = errors.New("selfca: the ca certificate is not self-signed")
+)
+
+// DefaultCRLValidity is the default time from this update to next update of CRL
+const DefaultCRLValidity = 7 * 24 * time.Hour
+
+// GenerateCRL generates the DER encoded CRL of certificates revoked in database signed by the ca,
+// the CRL number of database is bumped, the database should be saved after it
+func (d *Database) GenerateCRL(ca *CA, validity time.Duration) ([]byte, error) {
+	if ca.Certificate.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return nil, ErrCRLSignNotAllowed
+	}
+
+	if validity <= 0 {
+		validity = DefaultCRLValidity
+	}
+
+	var entries []x509.RevocationListEntry
+	for _, v := range d.Certificates {
+		if v.RevokedAt == nil {
+			continue
+		}
+		serial, ok := new(big.Int).SetString(v.Serial, 16)
+		if !ok {
+			return nil, fmt.Errorf("selfca: invalid serial %s in database", v.Serial)
+		}
+		entries = append(entries, x509.RevocationListEntry{
+			SerialNumber:   serial,
+			RevocationTime: *v.RevokedAt,
+			ReasonCode:     v.Reason,
+		})
+	}
+
+	now := time.Now()
+	crl, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:                    big.NewInt(d.CRLNumber + 1),
+		ThisUpdate:                now,
+		NextUpdate:                now.Add(validity),
+		RevokedCertificateEntries: entries,
+	}, ca.Certificate, ca.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	d.CRLNumber++
+
+	return crl, nil
+}
+
+// AllowCRLSign re-issues the self-signed ca certificate by its own key with the CRL signing usage
+// added, for the ca created by older versions, the subject, key, extensions and validity are kept,
+// so the certificates issued by the ca still chain to the new ca certificate
+func (ca *CA) AllowCRLSign() (*x509.Certificate, error) {
+	if ca.Key == nil {
+		return nil, ErrCAClosed
+	}
+
+	if !bytes.Equal(ca.Certificate.RawIssuer, ca.Certificate.RawSubject) ||
+		ca.Certificate.CheckSignatureFrom(ca.Certificate) != nil {
+		return nil, ErrNotSelfSigned
+	}
+
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+
+	template := *ca.Certificate
+	template.SerialNumber = serialNumber
+	template.KeyUsage |= x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+	template.AuthorityKeyId = ca.Certificate.SubjectKeyId
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, ca.Key.Public(), ca.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+// WriteCRL writes the DER encoded CRL to name.crl atomically
+func WriteCRL(name string, crl []byte) error {
+	return writeFile(fmt.Sprintf("%s.crl", name), func(w io.Writer) error {
+		_, err := w.Write(crl)
+		return err
+	}, 0644)
+}
@@ -0,0 +1,123 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestGenerateCRL(t *testing.T) {
+	path := t.TempDir() + "/ca"
+
+	d, err := OpenDatabase(path + ".db")
+	assert.Nil(t, err)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Add(leaf.Certificate))
+
+	crl, err := d.GenerateCRL(ca, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, d.CRLNumber, int64(1))
+
+	list, err := x509.ParseRevocationList(crl)
+	assert.Nil(t, err)
+	assert.Nil(t, list.CheckSignatureFrom(ca.Certificate))
+	assert.Equal(t, list.Number.Int64(), int64(1))
+	assert.Len(t, list.RevokedCertificateEntries, 0)
+	assert.True(t, list.NextUpdate.Sub(list.ThisUpdate) == DefaultCRLValidity)
+
+	revokedAt := time.Now().Truncate(time.Second)
+	assert.Nil(t, d.RevokeWithReason(leaf.Certificate.SerialNumber, revokedAt, 1))
+	crl, err = d.GenerateCRL(ca, time.Hour)
+	assert.Nil(t, err)
+	assert.Equal(t, d.CRLNumber, int64(2))
+
+	list, err = x509.ParseRevocationList(crl)
+	assert.Nil(t, err)
+	assert.Equal(t, list.Number.Int64(), int64(2))
+	assert.Len(t, list.RevokedCertificateEntries, 1)
+	assert.Equal(t, list.RevokedCertificateEntries[0].SerialNumber, leaf.Certificate.SerialNumber)
+	assert.True(t, list.RevokedCertificateEntries[0].RevocationTime.Equal(revokedAt))
+	assert.Equal(t, list.RevokedCertificateEntries[0].ReasonCode, 1)
+
+	assert.Nil(t, d.Save())
+	d, err = OpenDatabase(path + ".db")
+	assert.Nil(t, err)
+	assert.Equal(t, d.CRLNumber, int64(2))
+
+	err = WriteCRL(path, crl)
+	assert.Nil(t, err)
+	data, err := os.ReadFile(path + ".crl")
+	assert.Nil(t, err)
+	assert.Equal(t, data, crl)
+
+	ca.Certificate.KeyUsage = x509.KeyUsageCertSign
+	_, err = d.GenerateCRL(ca, 0)
+	assert.Equal(t, err, ErrCRLSignNotAllowed)
+}
+
+func TestAllowCRLSign(t *testing.T) {
+	config := Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+		KeyType:   KeyTypeECDSA,
+		KeyUsage:  x509.KeyUsageCertSign,
+	}
+
+	root, err := Issue(config)
+	assert.Nil(t, err)
+	assert.Equal(t, root.Certificate.KeyUsage&x509.KeyUsageCRLSign, x509.KeyUsage(0))
+
+	ca := root.CA()
+	leaf, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	certificate, err := ca.AllowCRLSign()
+	assert.Nil(t, err)
+	assert.Equal(t, certificate.KeyUsage, x509.KeyUsageCertSign|x509.KeyUsageCRLSign)
+	assert.Equal(t, certificate.RawSubject, root.Certificate.RawSubject)
+	assert.Equal(t, certificate.SubjectKeyId, root.Certificate.SubjectKeyId)
+	assert.True(t, certificate.NotAfter.Equal(root.Certificate.NotAfter))
+	assert.NotEqual(t, certificate.SerialNumber, root.Certificate.SerialNumber)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(certificate)
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{Roots: roots, DNSName: "likexian.com"})
+	assert.Nil(t, err)
+
+	ca.Certificate = certificate
+	_, err = (&Database{}).GenerateCRL(ca, 0)
+	assert.Nil(t, err)
+
+	intermediate, err := ca.Issue(Certificate{IsCA: true, KeyUsage: x509.KeyUsageCertSign})
+	assert.Nil(t, err)
+	_, err = intermediate.CA().AllowCRLSign()
+	assert.Equal(t, err, ErrNotSelfSigned)
+}
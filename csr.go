@@ -0,0 +1,348 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"slices"
+)
+
+// ErrInvalidCSR is invalid certificate request error
+var ErrInvalidCSR = errors.New("selfca: the certificate request is invalid")
+
+var (
+	// oidChallengePassword is the OID of PKCS #9 challenge password attribute
+	oidChallengePassword = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 7}
+	// oidExtensionBasicConstraints is the OID of basic constraints extension
+	oidExtensionBasicConstraints = asn1.ObjectIdentifier{2, 5, 29, 19}
+)
+
+// signatureHashes is the hash of signature algorithms chosen for the generated certificate request
+var signatureHashes = map[x509.SignatureAlgorithm]crypto.Hash{
+	x509.SHA256WithRSA:   crypto.SHA256,
+	x509.ECDSAWithSHA256: crypto.SHA256,
+	x509.ECDSAWithSHA384: crypto.SHA384,
+	x509.ECDSAWithSHA512: crypto.SHA512,
+	x509.PureEd25519:     0,
+}
+
+// certificateRequest is the PKCS #10 certificate request
+type certificateRequest struct {
+	TBS                asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+// tbsCertificateRequest is the signed info of PKCS #10 certificate request
+type tbsCertificateRequest struct {
+	Raw           asn1.RawContent
+	Version       int
+	Subject       asn1.RawValue
+	PublicKey     asn1.RawValue
+	RawAttributes []asn1.RawValue `asn1:"tag:0"`
+}
+
+// csrAttribute is the attribute of PKCS #10 certificate request
+type csrAttribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+// GenerateCSR generates PKCS #10 certificate request and key, for signing by other ca
+func GenerateCSR(c Certificate) ([]byte, crypto.Signer, error) {
+	key := c.Key
+	if key == nil {
+		var err error
+		key, err = GenerateKey(c.KeyType, c.KeySize)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	names := x509.Certificate{}
+	err := addHosts(&names, c.Hosts)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template := x509.CertificateRequest{
+		DNSNames:       names.DNSNames,
+		EmailAddresses: names.EmailAddresses,
+		IPAddresses:    names.IPAddresses,
+		URIs:           names.URIs,
+	}
+
+	template.Subject.CommonName = commonNameOf(c.Hosts, c.UPNs)
+	if c.CommonName != "" {
+		template.Subject.CommonName = c.CommonName
+	}
+
+	template.RawSubject, err = rawSubject(c, template.Subject.CommonName)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	template.ExtraExtensions = append(template.ExtraExtensions, c.ExtraExtensions...)
+
+	if len(c.UPNs) > 0 {
+		san, err := marshalSANs(&names, c.UPNs)
+		if err != nil {
+			return nil, nil, err
+		}
+		template.ExtraExtensions = append(template.ExtraExtensions, san)
+	}
+
+	csr, err := x509.CreateCertificateRequest(rand.Reader, &template, key)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if c.ChallengePassword != "" {
+		csr, err = addChallengePassword(csr, c.ChallengePassword, key)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	return csr, key, nil
+}
+
+// addChallengePassword returns the certificate request with challenge password attribute added,
+// re-signed by key with the same signature algorithm
+func addChallengePassword(csr []byte, password string, key crypto.Signer) ([]byte, error) {
+	parsed, err := x509.ParseCertificateRequest(csr)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, ok := signatureHashes[parsed.SignatureAlgorithm]
+	if !ok {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	var request certificateRequest
+	_, err = asn1.Unmarshal(csr, &request)
+	if err != nil {
+		return nil, err
+	}
+
+	var tbs tbsCertificateRequest
+	_, err = asn1.Unmarshal(request.TBS.FullBytes, &tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	value, err := asn1.Marshal(password)
+	if err != nil {
+		return nil, err
+	}
+
+	attribute, err := asn1.Marshal(csrAttribute{Type: oidChallengePassword, Values: []asn1.RawValue{{FullBytes: value}}})
+	if err != nil {
+		return nil, err
+	}
+
+	tbs.Raw = nil
+	tbs.RawAttributes = append(tbs.RawAttributes, asn1.RawValue{FullBytes: attribute})
+	tbsDER, err := asn1.Marshal(tbs)
+	if err != nil {
+		return nil, err
+	}
+
+	// Ed25519 signs the whole message instead of the digest
+	message := tbsDER
+	if hash != 0 {
+		digest := hash.New()
+		digest.Write(tbsDER)
+		message = digest.Sum(nil)
+	}
+
+	signature, err := key.Sign(rand.Reader, message, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	request.TBS = asn1.RawValue{FullBytes: tbsDER}
+	request.Signature = asn1.BitString{Bytes: signature, BitLength: len(signature) * 8}
+
+	return asn1.Marshal(request)
+}
+
+// CSRChallengePassword returns the challenge password of certificate request, empty if not set
+func CSRChallengePassword(r *x509.CertificateRequest) string {
+	var tbs tbsCertificateRequest
+	_, err := asn1.Unmarshal(r.RawTBSCertificateRequest, &tbs)
+	if err != nil {
+		return ""
+	}
+
+	for _, v := range tbs.RawAttributes {
+		var attribute csrAttribute
+		_, err = asn1.Unmarshal(v.FullBytes, &attribute)
+		if err != nil || !attribute.Type.Equal(oidChallengePassword) || len(attribute.Values) == 0 {
+			continue
+		}
+		var password string
+		_, err = asn1.Unmarshal(attribute.Values[0].FullBytes, &password)
+		if err == nil {
+			return password
+		}
+	}
+
+	return ""
+}
+
+// WriteCSR writes certificate request and key to files
+func WriteCSR(name string, csr []byte, key crypto.Signer) error {
+	csrName := fmt.Sprintf("%s.csr", name)
+	err := writeFile(csrName, func(w io.Writer) error {
+		return pem.Encode(w, &pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr})
+	}, 0644)
+	if err != nil {
+		return err
+	}
+
+	block, err := MarshalPrivateKey(key)
+	if err != nil {
+		return err
+	}
+
+	keyName := fmt.Sprintf("%s.key", name)
+	err = writeFile(keyName, func(w io.Writer) error {
+		return pem.Encode(w, block)
+	}, 0600)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// ParseCSR parses the PEM or DER encoded certificate request, and checks its signature
+func ParseCSR(data []byte) (*x509.CertificateRequest, error) {
+	if p, _ := pem.Decode(data); p != nil {
+		data = p.Bytes
+	}
+
+	csr, err := x509.ParseCertificateRequest(data)
+	if err != nil {
+		return nil, ErrInvalidCSR
+	}
+
+	err = csr.CheckSignature()
+	if err != nil {
+		return nil, ErrInvalidCSR
+	}
+
+	return csr, nil
+}
+
+// SignCSR signs the PEM or DER encoded certificate request by the config ca, the alternative names
+// are taken from request and must be allowed by the CSRPolicy, the subject is taken by the CSRPolicy,
+// the requested extensions listed in CopyExtensions of config and CSRPolicy are copied except basic
+// constraints, the issuance has no key
+func SignCSR(csr []byte, c Certificate) (*Issuance, error) {
+	request, err := ParseCSR(csr)
+	if err != nil {
+		return nil, err
+	}
+
+	err = c.useParent()
+	if err != nil {
+		return nil, err
+	}
+
+	policy := ProfileCSRPolicy(c.Profile)
+	if c.CSRPolicy != nil {
+		policy = *c.CSRPolicy
+	}
+
+	c.IsCA = false
+	c.Hosts = csrHosts(request)
+	c.UPNs = csrUPNs(request)
+	err = policy.checkAllowedHosts(c.Hosts, c.UPNs)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.Subject != CSRSubjectNone && request.Subject.CommonName != "" {
+		c.CommonName = request.Subject.CommonName
+	}
+
+	template, err := certificateTemplate(c)
+	if err != nil {
+		return nil, err
+	}
+
+	if policy.Subject == CSRSubjectFull && len(request.Subject.Names) > 0 {
+		template.RawSubject = request.RawSubject
+	}
+
+	fitKeyUsage(template, request.PublicKey)
+
+	if c.CACertificate != nil {
+		err = checkNameConstraints(c.CACertificate, template)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, v := range request.Extensions {
+		copied := slices.ContainsFunc(c.CopyExtensions, v.Id.Equal) || slices.ContainsFunc(policy.CopyExtensions, v.Id.Equal)
+		if copied && !v.Id.Equal(oidExtensionBasicConstraints) {
+			template.ExtraExtensions = append(template.ExtraExtensions, v)
+		}
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader,
+		template, c.CACertificate, request.PublicKey, c.CAKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return newIssuance(certificate, nil, c)
+}
+
+// csrHosts returns the domains, IPs, URIs and emails of certificate request
+func csrHosts(r *x509.CertificateRequest) []string {
+	hosts := append([]string{}, r.DNSNames...)
+	for _, v := range r.IPAddresses {
+		hosts = append(hosts, v.String())
+	}
+
+	for _, v := range r.URIs {
+		hosts = append(hosts, v.String())
+	}
+
+	return append(hosts, r.EmailAddresses...)
+}
+
+// csrUPNs returns the UPN otherNames of certificate request
+func csrUPNs(r *x509.CertificateRequest) []string {
+	return CertificateUPNs(&x509.Certificate{Extensions: r.Extensions})
+}
@@ -0,0 +1,178 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestGenerateCSR(t *testing.T) {
+	csr, key, err := GenerateCSR(Certificate{
+		Hosts: []string{"likexian.com", "127.0.0.1", "i@likexian.com"},
+		UPNs:  []string{"i@corp.likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	request, err := x509.ParseCertificateRequest(csr)
+	assert.Nil(t, err)
+	assert.Nil(t, request.CheckSignature())
+	assert.Equal(t, request.Subject.CommonName, "likexian.com")
+	assert.Equal(t, request.DNSNames, []string{"likexian.com"})
+	assert.Equal(t, request.EmailAddresses, []string{"i@likexian.com"})
+	assert.Equal(t, request.IPAddresses[0].String(), "127.0.0.1")
+	assert.Equal(t, request.PublicKey, key.Public())
+
+	again, _, err := GenerateCSR(Certificate{
+		CommonName: "Li Kexian",
+		Hosts:      []string{"likexian.com"},
+		Key:        key,
+	})
+	assert.Nil(t, err)
+	request, err = x509.ParseCertificateRequest(again)
+	assert.Nil(t, err)
+	assert.Equal(t, request.Subject.CommonName, "Li Kexian")
+	assert.Equal(t, request.PublicKey, key.Public())
+
+	err = WriteCSR("not-exists/likexian.com", csr, key)
+	assert.NotNil(t, err)
+
+	name := t.TempDir() + "/likexian.com"
+	err = WriteCSR(name, csr, key)
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(name + ".csr")
+	assert.Nil(t, err)
+	p, _ := pem.Decode(data)
+	assert.Equal(t, p.Type, "CERTIFICATE REQUEST")
+	assert.Equal(t, p.Bytes, csr)
+
+	_, err = os.Stat(name + ".key")
+	assert.Nil(t, err)
+}
+
+func TestSignCSR(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	csr, key, err := GenerateCSR(Certificate{
+		CommonName: "Li Kexian",
+		Hosts:      []string{"likexian.com", "127.0.0.1"},
+		UPNs:       []string{"i@corp.likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	config := Certificate{
+		NotBefore:     ca.Certificate.NotBefore,
+		NotAfter:      ca.Certificate.NotBefore.Add(90 * 24 * time.Hour),
+		Hosts:         []string{"ignored.com"},
+		Profile:       ProfileSmartCard,
+		CAKey:         ca.Key,
+		CACertificate: ca.Certificate,
+	}
+
+	signed, err := SignCSR(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE REQUEST", Bytes: csr}), config)
+	assert.Nil(t, err)
+	assert.True(t, signed.Key == nil)
+	assert.Len(t, signed.KeyPEM, 0)
+	assert.Equal(t, signed.Certificate.PublicKey, key.Public())
+	assert.Equal(t, signed.Certificate.Subject.CommonName, "Li Kexian")
+	assert.Equal(t, signed.Certificate.DNSNames, []string{"likexian.com"})
+	assert.Equal(t, CertificateUPNs(signed.Certificate), []string{"i@corp.likexian.com"})
+	assert.Equal(t, CertificateProfile(signed.Certificate), ProfileSmartCard)
+	assert.Equal(t, signed.Chain, []*x509.Certificate{ca.Certificate})
+	assert.Nil(t, signed.Certificate.CheckSignatureFrom(ca.Certificate))
+
+	der, err := SignCSR(csr, config)
+	assert.Nil(t, err)
+	assert.NotEqual(t, der.Certificate.SerialNumber, signed.Certificate.SerialNumber)
+
+	name := t.TempDir() + "/likexian.com"
+	err = signed.Write(name)
+	assert.Nil(t, err)
+	assert.Equal(t, signed.KeyFile, "")
+	_, err = os.Stat(name + ".key")
+	assert.True(t, os.IsNotExist(err))
+
+	csr[len(csr)-1] ^= 0xff
+	_, err = SignCSR(csr, config)
+	assert.Equal(t, err, ErrInvalidCSR)
+
+	_, err = ParseCSR([]byte("invalid"))
+	assert.Equal(t, err, ErrInvalidCSR)
+}
+
+func TestCSRAttributes(t *testing.T) {
+	oidTest := asn1.ObjectIdentifier{1, 2, 3, 4}
+	csr, _, err := GenerateCSR(Certificate{
+		Hosts:             []string{"likexian.com"},
+		ChallengePassword: "secret",
+		ExtraExtensions: []pkix.Extension{
+			{Id: oidTest, Value: asn1.NullBytes},
+			{Id: oidExtensionBasicConstraints, Critical: true, Value: []byte{0x30, 0x03, 0x01, 0x01, 0xff}},
+		},
+	})
+	assert.Nil(t, err)
+
+	request, err := ParseCSR(csr)
+	assert.Nil(t, err)
+	assert.Equal(t, CSRChallengePassword(request), "secret")
+	assert.Equal(t, request.DNSNames, []string{"likexian.com"})
+	assert.Equal(t, len(request.Extensions), 3)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	config := Certificate{
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Hour),
+		CAKey:         ca.Key,
+		CACertificate: ca.Certificate,
+	}
+
+	issuance, err := SignCSR(csr, config)
+	assert.Nil(t, err)
+	for _, v := range issuance.Certificate.Extensions {
+		assert.False(t, v.Id.Equal(oidTest))
+	}
+
+	config.CopyExtensions = []asn1.ObjectIdentifier{oidTest, oidExtensionBasicConstraints}
+	issuance, err = SignCSR(csr, config)
+	assert.Nil(t, err)
+	assert.False(t, issuance.Certificate.IsCA)
+	copied := false
+	for _, v := range issuance.Certificate.Extensions {
+		copied = copied || v.Id.Equal(oidTest)
+	}
+	assert.True(t, copied)
+
+	plain, _, err := GenerateCSR(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+	request, err = ParseCSR(plain)
+	assert.Nil(t, err)
+	assert.Equal(t, CSRChallengePassword(request), "")
+}
@@ -0,0 +1,121 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"slices"
+	"strings"
+)
+
+// ErrNameNotAllowed is requested name outside of the csr policy allowlist error
+var ErrNameNotAllowed = errors.New("selfca: the name is not allowed by the csr policy")
+
+// CSRSubject is how the subject of certificate request is taken by SignCSR
+type CSRSubject string
+
+const (
+	// CSRSubjectCommonName takes the common name of request only, it is the default
+	CSRSubjectCommonName CSRSubject = "cn"
+	// CSRSubjectFull takes the full subject of request, like organization and country
+	CSRSubjectFull CSRSubject = "full"
+	// CSRSubjectNone ignores the subject of request, the common name is of config or the first host
+	CSRSubjectNone CSRSubject = "none"
+)
+
+// CSRPolicy is the policy of honoring the contents of certificate request by SignCSR
+type CSRPolicy struct {
+	// Subject is how the subject of request is taken, CSRSubjectCommonName if empty
+	Subject CSRSubject
+	// CopyExtensions is the OIDs of requested extensions to copy, except basic constraints,
+	// in addition to the CopyExtensions of config
+	CopyExtensions []asn1.ObjectIdentifier
+	// AllowedHosts is the allowlist of requested names, all are allowed if empty, as exact names,
+	// *.example.com for one label, .example.com for any subdomains, CIDRs like 10.0.0.0/8,
+	// @example.com for emails and UPNs, or URI prefixes like spiffe://example.org/
+	AllowedHosts []string
+}
+
+// profileCSRPolicies is the default csr policies of profiles, CSRSubjectCommonName otherwise
+var profileCSRPolicies = map[Profile]CSRPolicy{
+	ProfileCodeSigning: {Subject: CSRSubjectFull},
+	ProfileDevice:      {Subject: CSRSubjectFull},
+}
+
+// ProfileCSRPolicy returns the default csr policy of profile, the code signing and device
+// certificates take the full subject as their identity, the others take the common name only
+func ProfileCSRPolicy(profile Profile) CSRPolicy {
+	if v, ok := profileCSRPolicies[profile]; ok {
+		return v
+	}
+
+	return CSRPolicy{Subject: CSRSubjectCommonName}
+}
+
+// checkAllowedHosts returns ErrNameNotAllowed for the hosts and upns not in the allowlist
+func (p CSRPolicy) checkAllowedHosts(hosts, upns []string) error {
+	if len(p.AllowedHosts) == 0 {
+		return nil
+	}
+
+	var errs []error
+	for _, v := range append(slices.Clone(hosts), upns...) {
+		if !slices.ContainsFunc(p.AllowedHosts, func(pattern string) bool { return hostAllowed(v, pattern) }) {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrNameNotAllowed, v))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// hostAllowed returns whether the host matches the allowlist pattern
+func hostAllowed(host, pattern string) bool {
+	host, pattern = strings.ToLower(host), strings.ToLower(pattern)
+	if IsURI(pattern) {
+		return IsURI(host) && strings.HasPrefix(host, pattern)
+	}
+
+	if _, ipNet, err := net.ParseCIDR(pattern); err == nil {
+		ip := net.ParseIP(host)
+		return ip != nil && ipNet.Contains(ip)
+	}
+
+	if IsURI(host) {
+		return false
+	}
+
+	if strings.HasPrefix(pattern, "@") {
+		return strings.Contains(host, "@") && strings.HasSuffix(host, pattern)
+	}
+
+	if suffix, ok := strings.CutPrefix(pattern, "*"); ok && strings.HasPrefix(suffix, ".") {
+		label, ok := strings.CutSuffix(host, suffix)
+		return host == pattern || ok && label != "" && !strings.Contains(label, ".")
+	}
+
+	if strings.HasPrefix(pattern, ".") {
+		return strings.HasSuffix(host, pattern) && len(host) > len(pattern)
+	}
+
+	return host == pattern
+}
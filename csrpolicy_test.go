@@ -0,0 +1,118 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestCSRPolicy(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	oidTest := asn1.ObjectIdentifier{1, 2, 3, 4}
+	csr, _, err := GenerateCSR(Certificate{
+		CommonName:      "Li Kexian",
+		Organization:    []string{"Acme"},
+		Hosts:           []string{"api.likexian.com", "10.0.0.1"},
+		ExtraExtensions: []pkix.Extension{{Id: oidTest, Value: []byte{0x05, 0x00}}},
+	})
+	assert.Nil(t, err)
+
+	config := Certificate{NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+
+	signed, err := ca.SignCSR(csr, config)
+	assert.Nil(t, err)
+	assert.Equal(t, signed.Certificate.Subject.CommonName, "Li Kexian")
+	assert.Len(t, signed.Certificate.Subject.Organization, 0)
+	for _, v := range signed.Certificate.Extensions {
+		assert.False(t, v.Id.Equal(oidTest))
+	}
+
+	config.CSRPolicy = &CSRPolicy{Subject: CSRSubjectFull, CopyExtensions: []asn1.ObjectIdentifier{oidTest}}
+	signed, err = ca.SignCSR(csr, config)
+	assert.Nil(t, err)
+	assert.Equal(t, signed.Certificate.Subject.CommonName, "Li Kexian")
+	assert.Equal(t, signed.Certificate.Subject.Organization, []string{"Acme"})
+	copied := false
+	for _, v := range signed.Certificate.Extensions {
+		copied = copied || v.Id.Equal(oidTest)
+	}
+	assert.True(t, copied)
+
+	config.CSRPolicy = &CSRPolicy{Subject: CSRSubjectNone}
+	signed, err = ca.SignCSR(csr, config)
+	assert.Nil(t, err)
+	assert.Equal(t, signed.Certificate.Subject.CommonName, "api.likexian.com")
+
+	config.CSRPolicy = &CSRPolicy{AllowedHosts: []string{"*.likexian.com", "10.0.0.0/8"}}
+	_, err = ca.SignCSR(csr, config)
+	assert.Nil(t, err)
+
+	config.CSRPolicy = &CSRPolicy{AllowedHosts: []string{"likexian.com"}}
+	_, err = ca.SignCSR(csr, config)
+	assert.True(t, errors.Is(err, ErrNameNotAllowed))
+
+	config.CSRPolicy = nil
+	config.Profile = ProfileCodeSigning
+	signed, err = ca.SignCSR(csr, config)
+	assert.Nil(t, err)
+	assert.Equal(t, signed.Certificate.Subject.Organization, []string{"Acme"})
+
+	assert.Equal(t, ProfileCSRPolicy(ProfileServer).Subject, CSRSubjectCommonName)
+	assert.Equal(t, ProfileCSRPolicy(ProfileDevice).Subject, CSRSubjectFull)
+}
+
+func TestHostAllowed(t *testing.T) {
+	tests := []struct {
+		host    string
+		pattern string
+		allowed bool
+	}{
+		{"likexian.com", "likexian.com", true},
+		{"LikeXian.com", "likexian.com", true},
+		{"api.likexian.com", "likexian.com", false},
+		{"api.likexian.com", "*.likexian.com", true},
+		{"a.api.likexian.com", "*.likexian.com", false},
+		{"*.likexian.com", "*.likexian.com", true},
+		{"likexian.com", "*.likexian.com", false},
+		{"a.api.likexian.com", ".likexian.com", true},
+		{"likexian.com", ".likexian.com", false},
+		{"10.1.2.3", "10.0.0.0/8", true},
+		{"192.168.1.1", "10.0.0.0/8", false},
+		{"likexian.com", "10.0.0.0/8", false},
+		{"i@likexian.com", "@likexian.com", true},
+		{"i@corp.likexian.com", "@likexian.com", false},
+		{"likexian.com", "@likexian.com", false},
+		{"spiffe://likexian.com/ns/web", "spiffe://likexian.com/", true},
+		{"spiffe://other.com/ns/web", "spiffe://likexian.com/", false},
+		{"spiffe://likexian.com/ns/web", ".likexian.com", false},
+	}
+
+	for _, v := range tests {
+		assert.Equal(t, hostAllowed(v.host, v.pattern), v.allowed, v.host, v.pattern)
+	}
+}
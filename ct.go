@@ -0,0 +1,137 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// oidExtensionCTPoison is the OID of certificate transparency precertificate poison extension
+var oidExtensionCTPoison = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 3}
+
+// SCT is the signed certificate timestamp returned by certificate transparency log
+type SCT struct {
+	// Version is the SCT version, 0 for v1
+	Version int `json:"sct_version"`
+	// LogID is the SHA-256 hash of the log public key
+	LogID []byte `json:"id"`
+	// Timestamp is the milliseconds since epoch
+	Timestamp uint64 `json:"timestamp"`
+	// Extensions is the SCT extensions
+	Extensions []byte `json:"extensions"`
+	// Signature is the TLS encoded digitally-signed struct
+	Signature []byte `json:"signature"`
+}
+
+// CTLog is the certificate transparency log client, as RFC 6962
+type CTLog struct {
+	// URL is the log url, for example https://ct.example.com/log
+	URL string
+	// Client is the http client, default http.DefaultClient
+	Client *http.Client
+}
+
+// poisonExtension returns the precertificate poison extension
+func poisonExtension() pkix.Extension {
+	return pkix.Extension{Id: oidExtensionCTPoison, Critical: true, Value: asn1.NullBytes}
+}
+
+// AddChain submits the certificate followed by its chain to the log, returns the SCT
+func (l *CTLog) AddChain(ctx context.Context, chain []*x509.Certificate) (*SCT, error) {
+	return l.add(ctx, "add-chain", chain)
+}
+
+// AddPreChain submits the precertificate followed by its chain to the log, returns the SCT
+func (l *CTLog) AddPreChain(ctx context.Context, chain []*x509.Certificate) (*SCT, error) {
+	return l.add(ctx, "add-pre-chain", chain)
+}
+
+// add posts the chain to the endpoint of log
+func (l *CTLog) add(ctx context.Context, endpoint string, chain []*x509.Certificate) (*SCT, error) {
+	request := struct {
+		Chain [][]byte `json:"chain"`
+	}{}
+	for _, v := range chain {
+		request.Chain = append(request.Chain, v.Raw)
+	}
+
+	data, err := json.Marshal(request)
+	if err != nil {
+		return nil, err
+	}
+
+	url := strings.TrimSuffix(l.URL, "/") + "/ct/v1/" + endpoint
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(data))
+	if err != nil {
+		return nil, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	client := l.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	rsp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+
+	defer rsp.Body.Close()
+	body, err := io.ReadAll(io.LimitReader(rsp.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("selfca: ct log returned %s: %s", rsp.Status, bytes.TrimSpace(body))
+	}
+
+	sct := &SCT{}
+	err = json.Unmarshal(body, sct)
+	if err != nil {
+		return nil, err
+	}
+
+	return sct, nil
+}
+
+// WriteSCTs writes the SCTs to name.sct.json
+func WriteSCTs(name string, scts []*SCT) error {
+	data, err := json.MarshalIndent(scts, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(fmt.Sprintf("%s.sct.json", name), func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	}, 0644)
+}
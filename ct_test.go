@@ -0,0 +1,86 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestCTLog(t *testing.T) {
+	var received [][]byte
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/log/ct/v1/add-chain" || r.URL.Path == "/log/ct/v1/add-pre-chain" {
+			request := struct {
+				Chain [][]byte `json:"chain"`
+			}{}
+			_ = json.NewDecoder(r.Body).Decode(&request)
+			received = request.Chain
+			_, _ = w.Write([]byte(`{"sct_version":0,"id":"AQID","timestamp":1700000000000,"extensions":"","signature":"BAME"}`))
+			return
+		}
+		http.Error(w, "unknown endpoint", http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{
+		Hosts: []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	log := &CTLog{URL: server.URL + "/log/"}
+	sct, err := log.AddChain(context.Background(), append([]*x509.Certificate{leaf.Certificate}, leaf.Chain...))
+	assert.Nil(t, err)
+	assert.Equal(t, sct.LogID, []byte{1, 2, 3})
+	assert.Equal(t, sct.Timestamp, uint64(1700000000000))
+	assert.Equal(t, received, [][]byte{leaf.DER, ca.Certificate.Raw})
+
+	precert, err := ca.Issue(Certificate{
+		Hosts:          []string{"likexian.com"},
+		Precertificate: true,
+	})
+	assert.Nil(t, err)
+	assert.True(t, precert.Certificate.Extensions[len(precert.Certificate.Extensions)-1].Id.Equal(oidExtensionCTPoison))
+
+	_, err = log.AddPreChain(context.Background(), []*x509.Certificate{precert.Certificate, ca.Certificate})
+	assert.Nil(t, err)
+	assert.Equal(t, received[0], precert.DER)
+
+	_, err = (&CTLog{URL: server.URL}).AddChain(context.Background(), []*x509.Certificate{leaf.Certificate})
+	assert.NotNil(t, err)
+
+	name := t.TempDir() + "/likexian.com"
+	err = WriteSCTs(name, []*SCT{sct})
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(name + ".sct.json")
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), `"id": "AQID"`)
+}
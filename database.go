@@ -0,0 +1,157 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"io"
+	"io/fs"
+	"math/big"
+	"os"
+	"time"
+)
+
+var (
+	// ErrDuplicateSerial is duplicate serial number error
+	ErrDuplicateSerial = errors.New("selfca: the serial number is already issued")
+	// ErrSerialNotFound is serial number not issued error
+	ErrSerialNotFound = errors.New("selfca: the serial number is not issued")
+)
+
+// Database stores the certificates issued by ca, saved as json file
+type Database struct {
+	// Certificates is the issued certificates
+	Certificates []Record `json:"certificates"`
+	// CRLNumber is the number of the last generated CRL
+	CRLNumber int64 `json:"crl_number,omitempty"`
+	path      string
+}
+
+// Record stores information of issued certificate
+type Record struct {
+	// Serial is the hex encoded serial number
+	Serial string `json:"serial"`
+	// CommonName is the subject common name
+	CommonName string `json:"common_name"`
+	// NotBefore is the valid from time
+	NotBefore time.Time `json:"not_before"`
+	// NotAfter is the expiry time
+	NotAfter time.Time `json:"not_after"`
+	// SHA256Fingerprint is the hex encoded SHA-256 fingerprint
+	SHA256Fingerprint string `json:"sha256_fingerprint"`
+	// RevokedAt is the revocation time, nil if not revoked
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+	// Reason is the RFC 5280 CRL reason code of revocation, 0 is unspecified
+	Reason int `json:"reason,omitempty"`
+}
+
+// OpenDatabase reads the database from file, returns empty database if not exists
+func OpenDatabase(path string) (*Database, error) {
+	d := &Database{path: path}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		if errors.Is(err, fs.ErrNotExist) {
+			return d, nil
+		}
+		return nil, err
+	}
+
+	err = json.Unmarshal(data, d)
+	if err != nil {
+		return nil, err
+	}
+
+	return d, nil
+}
+
+// Contains returns whether the serial number is issued
+func (d *Database) Contains(serial *big.Int) bool {
+	return d.find(serial) >= 0
+}
+
+// Add records the issued certificate, returns ErrDuplicateSerial if the serial is issued
+func (d *Database) Add(c *x509.Certificate) error {
+	if d.Contains(c.SerialNumber) {
+		return ErrDuplicateSerial
+	}
+
+	sum := sha256.Sum256(c.Raw)
+	d.Certificates = append(d.Certificates, Record{
+		Serial:            c.SerialNumber.Text(16),
+		CommonName:        c.Subject.CommonName,
+		NotBefore:         c.NotBefore,
+		NotAfter:          c.NotAfter,
+		SHA256Fingerprint: hex.EncodeToString(sum[:]),
+	})
+
+	return nil
+}
+
+// Revoke marks the issued certificate revoked at the time, returns ErrSerialNotFound if not issued,
+// the time of the already revoked certificate is kept
+func (d *Database) Revoke(serial *big.Int, at time.Time) error {
+	return d.RevokeWithReason(serial, at, 0)
+}
+
+// RevokeWithReason marks the issued certificate revoked like Revoke with the RFC 5280 CRL reason code,
+// like 1 for key compromise, the time and reason of the already revoked certificate are kept
+func (d *Database) RevokeWithReason(serial *big.Int, at time.Time, reason int) error {
+	i := d.find(serial)
+	if i < 0 {
+		return ErrSerialNotFound
+	}
+
+	if d.Certificates[i].RevokedAt == nil {
+		d.Certificates[i].RevokedAt = &at
+		d.Certificates[i].Reason = reason
+	}
+
+	return nil
+}
+
+// Save writes the database to file atomically
+func (d *Database) Save() error {
+	data, err := json.MarshalIndent(d, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return writeFile(d.path, func(w io.Writer) error {
+		_, err := w.Write(append(data, '\n'))
+		return err
+	}, 0644)
+}
+
+// find returns the index of serial number, -1 if not found
+func (d *Database) find(serial *big.Int) int {
+	s := serial.Text(16)
+	for i, v := range d.Certificates {
+		if v.Serial == s {
+			return i
+		}
+	}
+
+	return -1
+}
@@ -0,0 +1,78 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestDatabase(t *testing.T) {
+	path := t.TempDir() + "/ca.db"
+
+	d, err := OpenDatabase(path)
+	assert.Nil(t, err)
+	assert.Len(t, d.Certificates, 0)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{
+		Hosts:        []string{"likexian.com"},
+		SerialNumber: big.NewInt(1024),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.Certificate.SerialNumber, big.NewInt(1024))
+
+	err = d.Add(leaf.Certificate)
+	assert.Nil(t, err)
+	assert.True(t, d.Contains(big.NewInt(1024)))
+
+	now := time.Now()
+	err = d.Revoke(big.NewInt(1024), now)
+	assert.Nil(t, err)
+	err = d.Revoke(big.NewInt(1024), now.Add(time.Hour))
+	assert.Nil(t, err)
+	assert.Equal(t, *d.Certificates[0].RevokedAt, now)
+
+	err = d.Revoke(big.NewInt(1), now)
+	assert.Equal(t, err, ErrSerialNotFound)
+	assert.False(t, d.Contains(big.NewInt(1025)))
+
+	err = d.Add(leaf.Certificate)
+	assert.Equal(t, err, ErrDuplicateSerial)
+
+	err = d.Save()
+	assert.Nil(t, err)
+
+	d, err = OpenDatabase(path)
+	assert.Nil(t, err)
+	assert.Len(t, d.Certificates, 1)
+	assert.Equal(t, d.Certificates[0].Serial, "400")
+	assert.Equal(t, d.Certificates[0].CommonName, "likexian.com")
+	assert.Equal(t, d.Certificates[0].SHA256Fingerprint, leaf.SHA256Fingerprint)
+	assert.True(t, d.Contains(big.NewInt(1024)))
+
+	_, err = OpenDatabase(t.TempDir())
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,255 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"strings"
+)
+
+// ErrInvalidDN is invalid distinguished name error
+var ErrInvalidDN = errors.New("selfca: the distinguished name is invalid")
+
+// oidCommonName is the OID of common name attribute
+var oidCommonName = asn1.ObjectIdentifier{2, 5, 4, 3}
+
+// dnAttributes is the OIDs of attribute names in distinguished name
+var dnAttributes = map[string]asn1.ObjectIdentifier{
+	"C":                {2, 5, 4, 6},
+	"ST":               {2, 5, 4, 8},
+	"L":                {2, 5, 4, 7},
+	"O":                {2, 5, 4, 10},
+	"OU":               {2, 5, 4, 11},
+	"CN":               oidCommonName,
+	"STREET":           {2, 5, 4, 9},
+	"POSTALCODE":       {2, 5, 4, 17},
+	"SERIALNUMBER":     {2, 5, 4, 5},
+	"TITLE":            {2, 5, 4, 12},
+	"SN":               {2, 5, 4, 4},
+	"GN":               {2, 5, 4, 42},
+	"BUSINESSCATEGORY": {2, 5, 4, 15},
+	"DC":               {0, 9, 2342, 19200300, 100, 1, 25},
+	"UID":              {0, 9, 2342, 19200300, 100, 1, 1},
+	"EMAILADDRESS":     {1, 2, 840, 113549, 1, 9, 1},
+	"E":                {1, 2, 840, 113549, 1, 9, 1},
+	"JURISDICTIONC":    {1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 3},
+	"JURISDICTIONST":   {1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 2},
+	"JURISDICTIONL":    {1, 3, 6, 1, 4, 1, 311, 60, 2, 1, 1},
+}
+
+// ia5Attributes is the attributes encoded as IA5String
+var ia5Attributes = []asn1.ObjectIdentifier{
+	{0, 9, 2342, 19200300, 100, 1, 25},
+	{1, 2, 840, 113549, 1, 9, 1},
+}
+
+// ParseDN parses the distinguished name in openssl form like /C=US/O=Acme/CN=example.com,
+// or in RFC 4514 form like CN=example.com,O=Acme,C=US, the multi-valued RDNs are joined
+// by + and the attribute types can be names or dotted OIDs
+func ParseDN(s string) (pkix.RDNSequence, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return nil, ErrInvalidDN
+	}
+
+	openssl := strings.HasPrefix(s, "/")
+	var rdns []string
+	if openssl {
+		rdns = splitEscaped(s[1:], "/")
+	} else {
+		rdns = splitEscaped(s, ",;")
+	}
+
+	var sequence pkix.RDNSequence
+	for _, v := range rdns {
+		var set pkix.RelativeDistinguishedNameSET
+		for _, vv := range splitEscaped(v, "+") {
+			atv, err := ParseAttribute(vv)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, atv)
+		}
+		sequence = append(sequence, set)
+	}
+
+	if !openssl {
+		for i, j := 0, len(sequence)-1; i < j; i, j = i+1, j-1 {
+			sequence[i], sequence[j] = sequence[j], sequence[i]
+		}
+	}
+
+	return sequence, nil
+}
+
+// ParseAttribute parses the subject attribute formatted as type=value,
+// the type can be a name like serialNumber or a dotted OID like 2.5.4.15
+func ParseAttribute(s string) (pkix.AttributeTypeAndValue, error) {
+	parts := splitEscaped(s, "=")
+	if len(parts) < 2 {
+		return pkix.AttributeTypeAndValue{}, ErrInvalidDN
+	}
+
+	name := strings.TrimSpace(parts[0])
+	oid, err := parseAttributeType(name)
+	if err != nil {
+		return pkix.AttributeTypeAndValue{}, err
+	}
+
+	raw := strings.TrimSpace(s[len(parts[0])+1:])
+	if strings.HasPrefix(raw, "#") {
+		der, err := hex.DecodeString(raw[1:])
+		if err != nil {
+			return pkix.AttributeTypeAndValue{}, ErrInvalidDN
+		}
+		var value asn1.RawValue
+		_, err = asn1.Unmarshal(der, &value)
+		if err != nil {
+			return pkix.AttributeTypeAndValue{}, ErrInvalidDN
+		}
+		return pkix.AttributeTypeAndValue{Type: oid, Value: value}, nil
+	}
+
+	value, err := unescapeDN(raw)
+	if err != nil {
+		return pkix.AttributeTypeAndValue{}, err
+	}
+
+	for _, v := range ia5Attributes {
+		if v.Equal(oid) {
+			return pkix.AttributeTypeAndValue{Type: oid, Value: asn1.RawValue{Tag: asn1.TagIA5String, Bytes: []byte(value)}}, nil
+		}
+	}
+
+	return pkix.AttributeTypeAndValue{Type: oid, Value: value}, nil
+}
+
+// parseAttributeType returns the OID of attribute name or dotted OID
+func parseAttributeType(s string) (asn1.ObjectIdentifier, error) {
+	if oid, ok := dnAttributes[strings.ToUpper(s)]; ok {
+		return oid, nil
+	}
+
+	s = strings.TrimPrefix(strings.TrimPrefix(s, "OID."), "oid.")
+	parts := strings.Split(s, ".")
+	if len(parts) < 2 {
+		return nil, ErrInvalidDN
+	}
+
+	oid := make(asn1.ObjectIdentifier, len(parts))
+	for i, v := range parts {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 {
+			return nil, ErrInvalidDN
+		}
+		oid[i] = n
+	}
+
+	return oid, nil
+}
+
+// splitEscaped splits s by any of the separators which are not escaped by backslash
+func splitEscaped(s, separators string) []string {
+	var result []string
+	start := 0
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' {
+			i++
+			continue
+		}
+		if strings.IndexByte(separators, s[i]) >= 0 {
+			result = append(result, s[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(result, s[start:])
+}
+
+// unescapeDN returns the value with backslash escapes decoded,
+// the escape is a backslash followed by a character or two hex digits
+func unescapeDN(s string) (string, error) {
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' {
+			b.WriteByte(s[i])
+			continue
+		}
+
+		if i+1 >= len(s) {
+			return "", ErrInvalidDN
+		}
+
+		if i+2 < len(s) {
+			if v, err := hex.DecodeString(s[i+1 : i+3]); err == nil {
+				b.Write(v)
+				i += 2
+				continue
+			}
+		}
+
+		b.WriteByte(s[i+1])
+		i++
+	}
+
+	return b.String(), nil
+}
+
+// rawSubject returns the DER encoded subject with the subject attributes of config,
+// the common name is appended if not in subject, it returns nil if no subject is set
+func rawSubject(c Certificate, commonName string) ([]byte, error) {
+	subject := pkix.Name{
+		Country:            c.Country,
+		Organization:       c.Organization,
+		OrganizationalUnit: c.OrganizationalUnit,
+		Locality:           c.Locality,
+		Province:           c.Province,
+		StreetAddress:      c.StreetAddress,
+		PostalCode:         c.PostalCode,
+	}.ToRDNSequence()
+
+	if len(subject) == 0 && len(c.Subject) == 0 && len(c.ExtraSubject) == 0 {
+		return nil, nil
+	}
+
+	subject = append(subject, c.Subject...)
+	for _, v := range c.ExtraSubject {
+		subject = append(subject, pkix.RelativeDistinguishedNameSET{v})
+	}
+
+	hasCN := false
+	for _, v := range subject {
+		for _, vv := range v {
+			if vv.Type.Equal(oidCommonName) {
+				hasCN = true
+			}
+		}
+	}
+
+	if !hasCN && commonName != "" {
+		subject = append(subject, pkix.RelativeDistinguishedNameSET{{Type: oidCommonName, Value: commonName}})
+	}
+
+	return asn1.Marshal(subject)
+}
@@ -0,0 +1,145 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestParseDN(t *testing.T) {
+	tests := []struct {
+		in  string
+		out string
+	}{
+		{"/C=US/ST=CA/O=Acme/OU=Dev/CN=example.com", "CN=example.com,OU=Dev,O=Acme,ST=CA,C=US"},
+		{"CN=example.com,OU=Dev,O=Acme,ST=CA,C=US", "CN=example.com,OU=Dev,O=Acme,ST=CA,C=US"},
+		{"/O=Acme/OU=Dev+OU=Ops/CN=example.com", "CN=example.com,OU=Dev+OU=Ops,O=Acme"},
+		{"CN=example.com, O=Acme\\, Inc.", "CN=example.com,O=Acme\\, Inc."},
+		{"/O=A\\/B/CN=example.com", "CN=example.com,O=A/B"},
+		{"CN=\\4c\\69 Kexian", "CN=Li Kexian"},
+		{"1.2.3.4=custom,CN=example.com", "1.2.3.4=custom,CN=example.com"},
+		{"OID.1.2.3.4=#0c06637573746f6d", "1.2.3.4=#0c06637573746f6d"},
+	}
+
+	for _, v := range tests {
+		rdns, err := ParseDN(v.in)
+		assert.Nil(t, err, v.in)
+		assert.Equal(t, rdns.String(), v.out, v.in)
+	}
+
+	for _, v := range []string{"", "CN", "/FOO=bar", "1=bar", "CN=a\\", "1.2.3=#zz"} {
+		_, err := ParseDN(v)
+		assert.Equal(t, err, ErrInvalidDN, v)
+	}
+}
+
+func TestCertificateSubject(t *testing.T) {
+	subject, err := ParseDN("/C=US/O=Acme/emailAddress=i@likexian.com/1.2.3.4=custom")
+	assert.Nil(t, err)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{
+		Subject: subject,
+		Hosts:   []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	name := issuance.Certificate.Subject
+	assert.Equal(t, name.CommonName, "likexian.com")
+	assert.Equal(t, name.Country, []string{"US"})
+	assert.Equal(t, name.Organization, []string{"Acme"})
+	assert.Equal(t, len(name.Names), 5)
+	assert.Equal(t, name.Names[4].Value, "likexian.com")
+
+	subject, err = ParseDN("CN=Li Kexian,O=Acme")
+	assert.Nil(t, err)
+
+	csr, _, err := GenerateCSR(Certificate{
+		CommonName: "ignored",
+		Subject:    subject,
+		Hosts:      []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	request, err := x509.ParseCertificateRequest(csr)
+	assert.Nil(t, err)
+	assert.Equal(t, request.Subject.CommonName, "Li Kexian")
+	assert.Equal(t, request.Subject.Organization, []string{"Acme"})
+
+	issuance, err = ca.Issue(Certificate{
+		Organization:       []string{"Acme"},
+		OrganizationalUnit: []string{"Dev", "Ops"},
+		Country:            []string{"US"},
+		Locality:           []string{"San Francisco"},
+		Province:           []string{"California"},
+		StreetAddress:      []string{"1 Market St"},
+		PostalCode:         []string{"94105"},
+		Hosts:              []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	name = issuance.Certificate.Subject
+	assert.Equal(t, name.CommonName, "likexian.com")
+	assert.Equal(t, name.Organization, []string{"Acme"})
+	assert.Equal(t, name.OrganizationalUnit, []string{"Dev", "Ops"})
+	assert.Equal(t, name.Country, []string{"US"})
+	assert.Equal(t, name.Locality, []string{"San Francisco"})
+	assert.Equal(t, name.Province, []string{"California"})
+	assert.Equal(t, name.StreetAddress, []string{"1 Market St"})
+	assert.Equal(t, name.PostalCode, []string{"94105"})
+	assert.Equal(t, name.Names[len(name.Names)-1].Value, "likexian.com")
+}
+
+func TestParseAttribute(t *testing.T) {
+	attr, err := ParseAttribute("jurisdictionC=US")
+	assert.Nil(t, err)
+	assert.Equal(t, attr.Type.String(), "1.3.6.1.4.1.311.60.2.1.3")
+	assert.Equal(t, attr.Value, "US")
+
+	attr, err = ParseAttribute("2.5.4.15=Private Organization")
+	assert.Nil(t, err)
+	assert.Equal(t, attr.Type.String(), "2.5.4.15")
+	assert.Equal(t, attr.Value, "Private Organization")
+
+	_, err = ParseAttribute("Private Organization")
+	assert.Equal(t, err, ErrInvalidDN)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	serial, err := ParseAttribute("serialNumber=1234")
+	assert.Nil(t, err)
+	issuance, err := ca.Issue(Certificate{
+		ExtraSubject: []pkix.AttributeTypeAndValue{attr, serial},
+		Hosts:        []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+
+	name := issuance.Certificate.Subject
+	assert.Equal(t, name.CommonName, "likexian.com")
+	assert.Equal(t, name.SerialNumber, "1234")
+	assert.Equal(t, name.Names[0].Value, "Private Organization")
+}
@@ -0,0 +1,263 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+)
+
+var (
+	// ErrUnsupportedEscrowKey is escrow public key not RSA error
+	ErrUnsupportedEscrowKey = errors.New("selfca: the escrow key must be RSA")
+	// ErrInvalidEscrow is invalid escrowed key or not for the escrow key error
+	ErrInvalidEscrow = errors.New("selfca: the escrowed key is invalid or not for the escrow key")
+)
+
+var (
+	// oidEnvelopedData is the OID of CMS enveloped data content type
+	oidEnvelopedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 3}
+	// oidData is the OID of CMS data content type
+	oidData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	// oidRSAESOAEP is the OID of RSAES-OAEP key transport
+	oidRSAESOAEP = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 7}
+	// oidMGF1 is the OID of MGF1 mask generation function
+	oidMGF1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	// oidSHA256 is the OID of SHA-256 digest algorithm
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+)
+
+// escrowContentInfo is the CMS content info of the escrowed key
+type escrowContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     escrowEnvelopedData `asn1:"explicit,tag:0"`
+}
+
+// escrowEnvelopedData is the CMS enveloped data with key transport recipients
+type escrowEnvelopedData struct {
+	Version              int
+	RecipientInfos       []escrowRecipientInfo `asn1:"set"`
+	EncryptedContentInfo escrowEncryptedContentInfo
+}
+
+// escrowRecipientInfo is the CMS key transport recipient identified by subject key identifier
+type escrowRecipientInfo struct {
+	Version                int
+	SubjectKeyID           []byte `asn1:"tag:0"`
+	KeyEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedKey           []byte
+}
+
+// escrowEncryptedContentInfo is the CMS encrypted content
+type escrowEncryptedContentInfo struct {
+	ContentType                asn1.ObjectIdentifier
+	ContentEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedContent           []byte `asn1:"tag:0"`
+}
+
+// rsaOAEPParams is the parameters of RSAES-OAEP, the label is empty by default
+type rsaOAEPParams struct {
+	HashFunc    pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MaskGenFunc pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+}
+
+// EscrowPrivateKey returns the PEM block of private key encrypted to the RSA escrow public key,
+// as CMS enveloped data of RSAES-OAEP-SHA256 and AES-256-CBC, the recipient is identified by
+// the subject key identifier of escrow key, the key is recovered by RecoverEscrowedKey, or by
+// openssl cms -decrypt -inform PEM -inkey escrow.key as the PEM private key
+func EscrowPrivateKey(key crypto.Signer, escrow crypto.PublicKey) (*pem.Block, error) {
+	pub, ok := escrow.(*rsa.PublicKey)
+	if !ok {
+		return nil, ErrUnsupportedEscrowKey
+	}
+
+	block, err := MarshalPrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	content := pem.EncodeToMemory(block)
+	clear(block.Bytes)
+	defer clear(content)
+
+	contentKey := make([]byte, 32)
+	iv := make([]byte, aes.BlockSize)
+	for _, v := range [][]byte{contentKey, iv} {
+		if _, err = rand.Read(v); err != nil {
+			return nil, err
+		}
+	}
+
+	defer clear(contentKey)
+
+	encryptedKey, err := rsa.EncryptOAEP(sha256.New(), rand.Reader, pub, contentKey, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	c, err := aes.NewCipher(contentKey)
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS #7 padding, a full block is added if already aligned
+	padding := aes.BlockSize - len(content)%aes.BlockSize
+	data := append(bytes.Clone(content), bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(c, iv).CryptBlocks(data, data)
+
+	algorithm, err := oaepAlgorithm()
+	if err != nil {
+		return nil, err
+	}
+
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	der, err := asn1.Marshal(escrowContentInfo{
+		ContentType: oidEnvelopedData,
+		Content: escrowEnvelopedData{
+			Version: 2,
+			RecipientInfos: []escrowRecipientInfo{{
+				Version:                2,
+				SubjectKeyID:           escrowKeyID(pub),
+				KeyEncryptionAlgorithm: algorithm,
+				EncryptedKey:           encryptedKey,
+			}},
+			EncryptedContentInfo: escrowEncryptedContentInfo{
+				ContentType: oidData,
+				ContentEncryptionAlgorithm: pkix.AlgorithmIdentifier{
+					Algorithm:  oidAES256CBC,
+					Parameters: asn1.RawValue{FullBytes: ivDER},
+				},
+				EncryptedContent: data,
+			},
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pem.Block{Type: "CMS", Bytes: der}, nil
+}
+
+// RecoverEscrowedKey decrypts the PEM or DER encoded escrowed key by the escrow private key
+func RecoverEscrowedKey(data []byte, escrow *rsa.PrivateKey) (crypto.Signer, error) {
+	if p, _ := pem.Decode(data); p != nil {
+		data = p.Bytes
+	}
+
+	var info escrowContentInfo
+	_, err := asn1.Unmarshal(data, &info)
+	if err != nil || !info.ContentType.Equal(oidEnvelopedData) {
+		return nil, ErrInvalidEscrow
+	}
+
+	content := info.Content.EncryptedContentInfo
+	var iv []byte
+	_, err = asn1.Unmarshal(content.ContentEncryptionAlgorithm.Parameters.FullBytes, &iv)
+	if err != nil || !content.ContentEncryptionAlgorithm.Algorithm.Equal(oidAES256CBC) || len(iv) != aes.BlockSize ||
+		len(content.EncryptedContent) == 0 || len(content.EncryptedContent)%aes.BlockSize != 0 {
+		return nil, ErrInvalidEscrow
+	}
+
+	keyID := escrowKeyID(&escrow.PublicKey)
+	for _, v := range info.Content.RecipientInfos {
+		if !bytes.Equal(v.SubjectKeyID, keyID) || !v.KeyEncryptionAlgorithm.Algorithm.Equal(oidRSAESOAEP) {
+			continue
+		}
+
+		contentKey, err := rsa.DecryptOAEP(sha256.New(), nil, escrow, v.EncryptedKey, nil)
+		if err != nil || len(contentKey) != 32 {
+			return nil, ErrInvalidEscrow
+		}
+
+		c, err := aes.NewCipher(contentKey)
+		clear(contentKey)
+		if err != nil {
+			return nil, err
+		}
+
+		plain := bytes.Clone(content.EncryptedContent)
+		defer clear(plain)
+		cipher.NewCBCDecrypter(c, iv).CryptBlocks(plain, plain)
+		padding := int(plain[len(plain)-1])
+		if padding == 0 || padding > aes.BlockSize {
+			return nil, ErrInvalidEscrow
+		}
+
+		block, _ := pem.Decode(plain[:len(plain)-padding])
+		if block == nil {
+			return nil, ErrInvalidEscrow
+		}
+
+		defer clear(block.Bytes)
+		key, err := ParsePrivateKey(block.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		signer, ok := key.(crypto.Signer)
+		if !ok {
+			return nil, ErrUnsupportedKeyType
+		}
+
+		return signer, nil
+	}
+
+	return nil, ErrInvalidEscrow
+}
+
+// escrowKeyID returns the subject key identifier of escrow key, the SHA-1 of public key by RFC 5280
+func escrowKeyID(pub *rsa.PublicKey) []byte {
+	sum := sha1.Sum(x509.MarshalPKCS1PublicKey(pub))
+	return sum[:]
+}
+
+// oaepAlgorithm returns the RSAES-OAEP algorithm identifier of SHA-256 and MGF1-SHA256
+func oaepAlgorithm() (pkix.AlgorithmIdentifier, error) {
+	sha := pkix.AlgorithmIdentifier{Algorithm: oidSHA256, Parameters: asn1.NullRawValue}
+	shaDER, err := asn1.Marshal(sha)
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	params, err := asn1.Marshal(rsaOAEPParams{
+		HashFunc:    sha,
+		MaskGenFunc: pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: shaDER}},
+	})
+	if err != nil {
+		return pkix.AlgorithmIdentifier{}, err
+	}
+
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSAESOAEP, Parameters: asn1.RawValue{FullBytes: params}}, nil
+}
@@ -0,0 +1,66 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/pem"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestEscrowPrivateKey(t *testing.T) {
+	escrow, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	for _, v := range []KeyType{KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519} {
+		key, err := GenerateKey(v, DefaultKeySize(v))
+		assert.Nil(t, err)
+
+		block, err := EscrowPrivateKey(key, &escrow.PublicKey)
+		assert.Nil(t, err)
+		assert.Equal(t, block.Type, "CMS")
+
+		recovered, err := RecoverEscrowedKey(pem.EncodeToMemory(block), escrow)
+		assert.Nil(t, err)
+		assert.True(t, recovered.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(key.Public()))
+	}
+
+	key, err := GenerateKey(KeyTypeECDSA, 256)
+	assert.Nil(t, err)
+
+	block, err := EscrowPrivateKey(key, &escrow.PublicKey)
+	assert.Nil(t, err)
+
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+	_, err = RecoverEscrowedKey(block.Bytes, other)
+	assert.Equal(t, err, ErrInvalidEscrow)
+
+	_, err = RecoverEscrowedKey([]byte("invalid"), escrow)
+	assert.Equal(t, err, ErrInvalidEscrow)
+
+	_, err = EscrowPrivateKey(key, key.(*ecdsa.PrivateKey).Public())
+	assert.Equal(t, err, ErrUnsupportedEscrowKey)
+}
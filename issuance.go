@@ -0,0 +1,309 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1" //nolint:gosec
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// Issuance stores the result of issuing certificate
+type Issuance struct {
+	// DER is the DER encoded certificate
+	DER []byte
+	// PEM is the PEM encoded certificate
+	PEM []byte
+	// Certificate is the parsed certificate
+	Certificate *x509.Certificate
+	// Key is the private key of certificate, nil if signed from request
+	Key crypto.Signer
+	// KeyPEM is the PEM encoded private key, nil if the key can not be exported like HSM or KMS
+	KeyPEM []byte
+	// Chain is the issuer certificates, empty if self-signed
+	Chain []*x509.Certificate
+	// SHA1Fingerprint is the hex encoded SHA-1 fingerprint
+	SHA1Fingerprint string
+	// SHA256Fingerprint is the hex encoded SHA-256 fingerprint
+	SHA256Fingerprint string
+	// CertificateFile is the certificate file path, set after written
+	CertificateFile string
+	// KeyFile is the key file path, set after written
+	KeyFile string
+	// FullChainFile is the certificate and chain file path, set after written
+	FullChainFile string
+	// BundleFile is the certificate, chain and key file path, set after written
+	BundleFile string
+	// DERFile is the DER certificate file path, set after written
+	DERFile string
+	// KeyDERFile is the DER key file path, set after written
+	KeyDERFile string
+	// PublicKeyFile is the PKIX PEM public key file path, set after written
+	PublicKeyFile string
+	// SSHPublicKeyFile is the OpenSSH public key file path, set after written
+	SSHPublicKeyFile string
+	// PKCS12File is the PKCS #12 file path, set after written
+	PKCS12File string
+	// JKSFile is the Java KeyStore file path, set after written
+	JKSFile string
+	// TrustStoreFile is the Java truststore file path, set after written
+	TrustStoreFile string
+}
+
+// Issue generates X.509 certificate and key, returns the issuance
+func Issue(c Certificate) (*Issuance, error) {
+	certificate, key, err := GenerateCertificate(c)
+	if err != nil {
+		return nil, err
+	}
+
+	return newIssuance(certificate, key, c)
+}
+
+// newIssuance returns the issuance of certificate signed by config ca, key may be nil
+func newIssuance(certificate []byte, key crypto.Signer, c Certificate) (*Issuance, error) {
+	parsed, err := x509.ParseCertificate(certificate)
+	if err != nil {
+		return nil, err
+	}
+
+	sha1Sum := sha1.Sum(certificate) //nolint:gosec
+	sha256Sum := sha256.Sum256(certificate)
+
+	i := &Issuance{
+		DER:               certificate,
+		PEM:               pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+		Certificate:       parsed,
+		Key:               key,
+		SHA1Fingerprint:   hex.EncodeToString(sha1Sum[:]),
+		SHA256Fingerprint: hex.EncodeToString(sha256Sum[:]),
+	}
+
+	// the external signer like HSM or KMS keeps the key inside
+	if key != nil {
+		block, err := MarshalPrivateKey(key)
+		if err != nil && !errors.Is(err, ErrUnsupportedKeyType) {
+			return nil, err
+		}
+		if block != nil {
+			i.KeyPEM = pem.EncodeToMemory(block)
+			clear(block.Bytes)
+		}
+	}
+
+	if c.Parent != nil {
+		i.Chain = append([]*x509.Certificate{c.Parent.Certificate}, c.Parent.Chain...)
+	} else if !c.IsCA && c.CACertificate != nil {
+		i.Chain = []*x509.Certificate{c.CACertificate}
+	}
+
+	return i, nil
+}
+
+// Write writes certificate and key to files, and records the file paths,
+// only the certificate is written if there is no key or it can not be exported
+func (i *Issuance) Write(name string) error {
+	return i.WriteWithPassphrase(name, nil)
+}
+
+// WriteWithPassphrase writes certificate and key like Write, the key is encrypted by passphrase unless it is empty
+func (i *Issuance) WriteWithPassphrase(name string, passphrase []byte) error {
+	var key crypto.Signer
+	if i.KeyPEM != nil {
+		key = i.Key
+	}
+
+	err := writeCertificate(name, i.PEM, key, passphrase)
+	if err != nil {
+		return err
+	}
+
+	i.CertificateFile = fmt.Sprintf("%s.crt", name)
+	if key != nil {
+		i.KeyFile = fmt.Sprintf("%s.key", name)
+	}
+
+	return nil
+}
+
+// WriteDER writes certificate and key in DER form to name.der and name.key.der, and records the file paths,
+// only the certificate is written if there is no key or it can not be exported
+func (i *Issuance) WriteDER(name string) error {
+	var key crypto.Signer
+	if i.KeyPEM != nil {
+		key = i.Key
+	}
+
+	err := WriteCertificateDER(name, i.DER, key)
+	if err != nil {
+		return err
+	}
+
+	i.DERFile = fmt.Sprintf("%s.der", name)
+	if key != nil {
+		i.KeyDERFile = fmt.Sprintf("%s.key.der", name)
+	}
+
+	return nil
+}
+
+// WritePublicKey writes public key of certificate to name.pub.pem and name.pub, and records the file paths
+func (i *Issuance) WritePublicKey(name string) error {
+	err := WritePublicKey(name, i.Certificate.PublicKey)
+	if err != nil {
+		return err
+	}
+
+	i.PublicKeyFile = fmt.Sprintf("%s.pub.pem", name)
+	i.SSHPublicKeyFile = fmt.Sprintf("%s.pub", name)
+
+	return nil
+}
+
+// CA returns the ca of the issued ca certificate and key, for issuing by the intermediate ca
+func (i *Issuance) CA() *CA {
+	return &CA{
+		Certificate: i.Certificate,
+		Key:         i.Key,
+		Chain:       i.Chain,
+	}
+}
+
+// Annotate prepends the PEMComment of certificate to PEM, so the written certificate file
+// can be identified at a glance, it is a no-op if already annotated
+func (i *Issuance) Annotate() {
+	if !bytes.HasPrefix(i.PEM, []byte("#")) {
+		i.PEM = append(PEMComment(i.Certificate), i.PEM...)
+	}
+}
+
+// Zero overwrites the key and key PEM in memory and drops them, for the long running
+// processes to keep no key material once the files are written or served
+func (i *Issuance) Zero() {
+	ZeroKey(i.Key)
+	clear(i.KeyPEM)
+	i.Key, i.KeyPEM = nil, nil
+}
+
+// TLSCertificate returns the certificate and chain for tls config
+func (i *Issuance) TLSCertificate() tls.Certificate {
+	certificate := tls.Certificate{
+		Certificate: [][]byte{i.DER},
+		PrivateKey:  i.Key,
+		Leaf:        i.Certificate,
+	}
+
+	for _, v := range i.Chain {
+		certificate.Certificate = append(certificate.Certificate, v.Raw)
+	}
+
+	return certificate
+}
+
+// PKCS12 returns the certificate, key and chain encoded as password protected PKCS #12
+func (i *Issuance) PKCS12(password string) ([]byte, error) {
+	return pkcs12.Modern.Encode(i.Key, i.Certificate, i.Chain, password)
+}
+
+// WritePKCS12 writes PKCS #12 of certificate, key and chain to file, and records the file path
+func (i *Issuance) WritePKCS12(name, password string) error {
+	return i.writePKCS12(name, password, pkcs12.Modern)
+}
+
+// WriteLegacyPKCS12 writes PKCS #12 like WritePKCS12 but encrypted by the legacy 3DES,
+// for importing into older Windows, Outlook and macOS Keychain not supporting AES
+func (i *Issuance) WriteLegacyPKCS12(name, password string) error {
+	return i.writePKCS12(name, password, pkcs12.Legacy)
+}
+
+// writePKCS12 writes PKCS #12 of certificate, key and chain by encoder, and records the file path
+func (i *Issuance) writePKCS12(name, password string, encoder *pkcs12.Encoder) error {
+	err := writePKCS12(name, append([]*x509.Certificate{i.Certificate}, i.Chain...), i.Key, password, encoder)
+	if err != nil {
+		return err
+	}
+
+	i.PKCS12File = fmt.Sprintf("%s.p12", name)
+
+	return nil
+}
+
+// WriteJKS writes Java KeyStore of certificate, key and chain to name.jks,
+// and the truststore of the root ca to name.truststore.jks, and records the file paths
+func (i *Issuance) WriteJKS(name, password string) error {
+	err := WriteJKS(name, append([]*x509.Certificate{i.Certificate}, i.Chain...), i.Key, password)
+	if err != nil {
+		return err
+	}
+
+	root := i.Certificate
+	if len(i.Chain) > 0 {
+		root = i.Chain[len(i.Chain)-1]
+	}
+
+	err = WriteJKSTrustStore(name+".truststore", []*x509.Certificate{root}, password)
+	if err != nil {
+		return err
+	}
+
+	i.JKSFile = fmt.Sprintf("%s.jks", name)
+	i.TrustStoreFile = fmt.Sprintf("%s.truststore.jks", name)
+
+	return nil
+}
+
+// WritePKCS12 writes the certificates and key as password protected PKCS #12 to name.p12,
+// the first certificate is the leaf of key and the others are its chain
+func WritePKCS12(name string, certificates []*x509.Certificate, key crypto.Signer, password string) error {
+	return writePKCS12(name, certificates, key, password, pkcs12.Modern)
+}
+
+// WriteLegacyPKCS12 writes the certificates and key as PKCS #12 like WritePKCS12 but encrypted by the legacy 3DES
+func WriteLegacyPKCS12(name string, certificates []*x509.Certificate, key crypto.Signer, password string) error {
+	return writePKCS12(name, certificates, key, password, pkcs12.Legacy)
+}
+
+// writePKCS12 writes the certificates and key as PKCS #12 encoded by encoder to name.p12
+func writePKCS12(name string, certificates []*x509.Certificate, key crypto.Signer,
+	password string, encoder *pkcs12.Encoder) error {
+	if len(certificates) == 0 || key == nil {
+		return ErrInvalidCertificate
+	}
+
+	data, err := encoder.Encode(key, certificates[0], certificates[1:], password)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(fmt.Sprintf("%s.p12", name), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, 0600)
+}
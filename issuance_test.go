@@ -0,0 +1,137 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto"
+	"crypto/x509"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+// externalSigner is the signer keeping the key inside, like HSM or KMS
+type externalSigner struct {
+	crypto.Signer
+}
+
+func TestIssue(t *testing.T) {
+	certPath := "cert"
+	caPath := certPath + "/ca"
+
+	ca, err := Issue(Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	})
+	assert.Nil(t, err)
+	assert.True(t, ca.Certificate.IsCA)
+	assert.Len(t, ca.Chain, 0)
+	assert.Len(t, ca.SHA1Fingerprint, 40)
+	assert.Len(t, ca.SHA256Fingerprint, 64)
+	assert.Contains(t, string(ca.PEM), "BEGIN CERTIFICATE")
+	assert.Contains(t, string(ca.KeyPEM), "BEGIN RSA PRIVATE KEY")
+	assert.Equal(t, ca.CertificateFile, "")
+
+	leaf, err := Issue(Certificate{
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Duration(365*24) * time.Hour),
+		Hosts:         []string{"likexian.com", "127.0.0.1"},
+		CAKey:         ca.Key,
+		CACertificate: ca.Certificate,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.Certificate.Subject.CommonName, "likexian.com")
+	assert.Equal(t, leaf.Chain, []*x509.Certificate{ca.Certificate})
+	assert.NotEqual(t, leaf.SHA256Fingerprint, ca.SHA256Fingerprint)
+
+	_, err = Issue(Certificate{
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Duration(365*24) * time.Hour),
+		Hosts:         []string{"likexian.com"},
+		CAKey:         leaf.Key,
+		CACertificate: ca.Certificate,
+	})
+	assert.NotNil(t, err)
+
+	err = ca.Write("not-exists/ca")
+	assert.NotNil(t, err)
+
+	_ = os.Mkdir(certPath, 0755)
+	defer os.RemoveAll(certPath)
+
+	err = ca.Write(caPath)
+	assert.Nil(t, err)
+	assert.Equal(t, ca.CertificateFile, caPath+".crt")
+	assert.Equal(t, ca.KeyFile, caPath+".key")
+
+	certificates, key, err := ReadCertificate(caPath)
+	assert.Nil(t, err)
+	assert.Equal(t, certificates[0].Raw, ca.DER)
+	assert.Equal(t, key.Public(), ca.Key.Public())
+}
+
+func TestIssueExternalSigner(t *testing.T) {
+	caKey, err := GenerateKey(KeyTypeECDSA, 384)
+	assert.Nil(t, err)
+
+	ca, err := Issue(Certificate{
+		IsCA:      true,
+		Key:       externalSigner{caKey},
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(24 * time.Hour),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ca.Certificate.PublicKey, caKey.Public())
+	assert.Len(t, ca.KeyPEM, 0)
+
+	key, err := GenerateKey(KeyTypeRSA, 2048)
+	assert.Nil(t, err)
+
+	leaf, err := Issue(Certificate{
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Hour),
+		Hosts:         []string{"likexian.com"},
+		Key:           externalSigner{key},
+		CAKey:         ca.Key,
+		CACertificate: ca.Certificate,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, leaf.Certificate.CheckSignatureFrom(ca.Certificate))
+	assert.Len(t, leaf.KeyPEM, 0)
+	assert.Nil(t, CheckFIPS(Certificate{CAKey: ca.Key, CACertificate: ca.Certificate}))
+
+	name := t.TempDir() + "/likexian.com"
+	err = leaf.Write(name)
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.CertificateFile, name+".crt")
+	assert.Equal(t, leaf.KeyFile, "")
+
+	_, err = os.Stat(name + ".key")
+	assert.True(t, os.IsNotExist(err))
+
+	err = WriteCertificate(name, leaf.DER, leaf.Key)
+	assert.Equal(t, err, ErrUnsupportedKeyType)
+
+	_, err = MarshalPrivateKey(leaf.Key)
+	assert.Equal(t, err, ErrUnsupportedKeyType)
+}
@@ -0,0 +1,57 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto"
+	"crypto/x509"
+)
+
+// Issuer issues certificates, it is implemented by CA for self-signed certificates,
+// and can be implemented by proxying to an upstream ACME or enterprise ca,
+// so the applications depending on Issuer switch to real certificates without code changes
+type Issuer interface {
+	// Issue issues certificate of the config, the ca fields of config are set by the issuer
+	Issue(c Certificate) (*Issuance, error)
+}
+
+// IssuerFunc is the function implementing Issuer
+type IssuerFunc func(c Certificate) (*Issuance, error)
+
+// Issue calls f(c)
+func (f IssuerFunc) Issue(c Certificate) (*Issuance, error) {
+	return f(c)
+}
+
+// the local ca is an issuer
+var _ Issuer = (*CA)(nil)
+
+// NewIssuance returns the issuance of certificate issued by other issuers, like the DER returned by ACME,
+// key may be nil if the certificate is issued from request, and chain is the issuer certificates
+func NewIssuance(certificate []byte, key crypto.Signer, chain ...*x509.Certificate) (*Issuance, error) {
+	i, err := newIssuance(certificate, key, Certificate{})
+	if err != nil {
+		return nil, err
+	}
+
+	i.Chain = chain
+
+	return i, nil
+}
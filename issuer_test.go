@@ -0,0 +1,62 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+// upstream issues by the ca like an upstream issuer returning only DER
+func upstream(ca *CA) Issuer {
+	return IssuerFunc(func(c Certificate) (*Issuance, error) {
+		key, err := GenerateKey(c.KeyType, c.KeySize)
+		if err != nil {
+			return nil, err
+		}
+
+		c.Key = key
+		i, err := ca.Issue(c)
+		if err != nil {
+			return nil, err
+		}
+
+		return NewIssuance(i.DER, key, ca.Certificate)
+	})
+}
+
+func TestIssuer(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	for _, issuer := range []Issuer{ca, upstream(ca)} {
+		issuance, err := issuer.Issue(Certificate{Hosts: []string{"likexian.com"}})
+		assert.Nil(t, err)
+		assert.Equal(t, issuance.Certificate.DNSNames, []string{"likexian.com"})
+		assert.Len(t, issuance.Chain, 1)
+		assert.Equal(t, issuance.Chain[0].Raw, ca.Certificate.Raw)
+		assert.NotNil(t, issuance.KeyPEM)
+		assert.Equal(t, len(issuance.SHA256Fingerprint), 64)
+	}
+
+	_, err = NewIssuance([]byte("invalid"), nil)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,232 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha1" //nolint:gosec
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"path/filepath"
+	"strings"
+	"time"
+	"unicode/utf16"
+)
+
+const (
+	// jksMagic is the magic number of Java KeyStore
+	jksMagic = 0xfeedfeed
+	// jksVersion is the version of Java KeyStore
+	jksVersion = 2
+	// jksPrivateKey is the tag of private key entry
+	jksPrivateKey = 1
+	// jksTrustedCertificate is the tag of trusted certificate entry
+	jksTrustedCertificate = 2
+	// jksWhitener is appended to the password for the integrity digest
+	jksWhitener = "Mighty Aphrodite"
+)
+
+// oidJKSKeyProtector is the key protection algorithm of Java KeyStore
+var oidJKSKeyProtector = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 42, 2, 17, 1, 1}
+
+// jksEncryptedKey is the EncryptedPrivateKeyInfo of Java KeyStore key entry
+type jksEncryptedKey struct {
+	Algorithm pkix.AlgorithmIdentifier
+	Data      []byte
+}
+
+// jksWriter writes the Java KeyStore entries
+type jksWriter struct {
+	bytes.Buffer
+}
+
+// JKS returns the certificates and key encoded as password protected Java KeyStore,
+// the first certificate is the leaf of key and the others are its chain
+func JKS(alias string, certificates []*x509.Certificate, key crypto.Signer, password string) ([]byte, error) {
+	if len(certificates) == 0 || key == nil {
+		return nil, ErrInvalidCertificate
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, err
+	}
+
+	defer clear(der)
+
+	protected, err := jksProtectKey(der, password)
+	if err != nil {
+		return nil, err
+	}
+
+	w := newJKSWriter(1)
+	w.writeUint32(jksPrivateKey)
+	w.writeUTF(strings.ToLower(alias))
+	w.writeUint64(uint64(time.Now().UnixMilli()))
+	w.writeUint32(uint32(len(protected)))
+	w.Write(protected)
+	w.writeUint32(uint32(len(certificates)))
+	for _, v := range certificates {
+		w.writeCertificate(v)
+	}
+
+	return w.sum(password), nil
+}
+
+// JKSTrustStore returns the certificates encoded as password protected Java KeyStore of trusted certificates
+func JKSTrustStore(certificates []*x509.Certificate, password string) ([]byte, error) {
+	if len(certificates) == 0 {
+		return nil, ErrInvalidCertificate
+	}
+
+	w := newJKSWriter(len(certificates))
+	for i, v := range certificates {
+		alias := strings.ToLower(v.Subject.CommonName)
+		if alias == "" || i > 0 {
+			alias = fmt.Sprintf("%s%d", alias, i)
+		}
+		w.writeUint32(jksTrustedCertificate)
+		w.writeUTF(alias)
+		w.writeUint64(uint64(time.Now().UnixMilli()))
+		w.writeCertificate(v)
+	}
+
+	return w.sum(password), nil
+}
+
+// WriteJKS writes the certificates and key as password protected Java KeyStore to name.jks,
+// the key is stored under the alias of base name
+func WriteJKS(name string, certificates []*x509.Certificate, key crypto.Signer, password string) error {
+	data, err := JKS(filepath.Base(name), certificates, key, password)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(fmt.Sprintf("%s.jks", name), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, 0600)
+}
+
+// WriteJKSTrustStore writes the certificates as password protected Java KeyStore of trusted certificates to name.jks
+func WriteJKSTrustStore(name string, certificates []*x509.Certificate, password string) error {
+	data, err := JKSTrustStore(certificates, password)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(fmt.Sprintf("%s.jks", name), func(w io.Writer) error {
+		_, err := w.Write(data)
+		return err
+	}, 0644)
+}
+
+// jksProtectKey encrypts the PKCS #8 key by the proprietary key protector of Java KeyStore,
+// the key stream is chained SHA-1 of password and salt, and the SHA-1 of password and key is appended
+func jksProtectKey(der []byte, password string) ([]byte, error) {
+	salt := make([]byte, sha1.Size)
+	_, err := rand.Read(salt)
+	if err != nil {
+		return nil, err
+	}
+
+	passwordBytes := jksPassword(password)
+	encrypted := make([]byte, len(der))
+	digest := salt
+	for i := 0; i < len(der); i += sha1.Size {
+		h := sha1.New() //nolint:gosec
+		h.Write(passwordBytes)
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(der); j++ {
+			encrypted[i+j] = der[i+j] ^ digest[j]
+		}
+	}
+
+	h := sha1.New() //nolint:gosec
+	h.Write(passwordBytes)
+	h.Write(der)
+
+	data := append(append(salt, encrypted...), h.Sum(nil)...)
+
+	return asn1.Marshal(jksEncryptedKey{
+		Algorithm: pkix.AlgorithmIdentifier{Algorithm: oidJKSKeyProtector, Parameters: asn1.NullRawValue},
+		Data:      data,
+	})
+}
+
+// jksPassword returns the password as big endian UTF-16 bytes
+func jksPassword(password string) []byte {
+	var b []byte
+	for _, v := range utf16.Encode([]rune(password)) {
+		b = append(b, byte(v>>8), byte(v))
+	}
+
+	return b
+}
+
+// newJKSWriter returns the writer with header of count entries
+func newJKSWriter(count int) *jksWriter {
+	w := &jksWriter{}
+	w.writeUint32(jksMagic)
+	w.writeUint32(jksVersion)
+	w.writeUint32(uint32(count))
+
+	return w
+}
+
+// writeUint32 writes big endian uint32
+func (w *jksWriter) writeUint32(v uint32) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+// writeUint64 writes big endian uint64
+func (w *jksWriter) writeUint64(v uint64) {
+	_ = binary.Write(w, binary.BigEndian, v)
+}
+
+// writeUTF writes the length prefixed string
+func (w *jksWriter) writeUTF(s string) {
+	_ = binary.Write(w, binary.BigEndian, uint16(len(s)))
+	w.WriteString(s)
+}
+
+// writeCertificate writes the X.509 certificate
+func (w *jksWriter) writeCertificate(c *x509.Certificate) {
+	w.writeUTF("X.509")
+	w.writeUint32(uint32(len(c.Raw)))
+	w.Write(c.Raw)
+}
+
+// sum returns the keystore with the integrity digest of password appended
+func (w *jksWriter) sum(password string) []byte {
+	h := sha1.New() //nolint:gosec
+	h.Write(jksPassword(password))
+	h.Write([]byte(jksWhitener))
+	h.Write(w.Bytes())
+
+	return h.Sum(w.Bytes())
+}
@@ -0,0 +1,190 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha1" //nolint:gosec
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"os"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+// jksEntry is the entry read from Java KeyStore for testing
+type jksEntry struct {
+	tag          uint32
+	alias        string
+	key          []byte
+	certificates [][]byte
+}
+
+// readJKS reads the Java KeyStore and checks its integrity digest
+func readJKS(t *testing.T, data []byte, password string) []jksEntry {
+	t.Helper()
+
+	assert.True(t, len(data) > 12+sha1.Size)
+	body, digest := data[:len(data)-sha1.Size], data[len(data)-sha1.Size:]
+	h := sha1.New() //nolint:gosec
+	h.Write(jksPassword(password))
+	h.Write([]byte(jksWhitener))
+	h.Write(body)
+	assert.Equal(t, h.Sum(nil), digest)
+
+	r := bytes.NewReader(body)
+	readUint32 := func() uint32 {
+		var v uint32
+		assert.Nil(t, binary.Read(r, binary.BigEndian, &v))
+		return v
+	}
+	readBytes := func(n int) []byte {
+		b := make([]byte, n)
+		_, err := r.Read(b)
+		assert.Nil(t, err)
+		return b
+	}
+	readUTF := func() string {
+		var n uint16
+		assert.Nil(t, binary.Read(r, binary.BigEndian, &n))
+		return string(readBytes(int(n)))
+	}
+	readCertificate := func() []byte {
+		assert.Equal(t, readUTF(), "X.509")
+		return readBytes(int(readUint32()))
+	}
+
+	assert.Equal(t, readUint32(), uint32(jksMagic))
+	assert.Equal(t, readUint32(), uint32(jksVersion))
+
+	var entries []jksEntry
+	for i := readUint32(); i > 0; i-- {
+		e := jksEntry{tag: readUint32(), alias: readUTF()}
+		readBytes(8)
+		if e.tag == jksPrivateKey {
+			e.key = readBytes(int(readUint32()))
+			for j := readUint32(); j > 0; j-- {
+				e.certificates = append(e.certificates, readCertificate())
+			}
+		} else {
+			e.certificates = append(e.certificates, readCertificate())
+		}
+		entries = append(entries, e)
+	}
+	assert.Equal(t, r.Len(), 0)
+
+	return entries
+}
+
+// recoverJKSKey decrypts the key protected by Java KeyStore key protector
+func recoverJKSKey(t *testing.T, data []byte, password string) crypto.Signer {
+	t.Helper()
+
+	var info jksEncryptedKey
+	_, err := asn1.Unmarshal(data, &info)
+	assert.Nil(t, err)
+	assert.Equal(t, info.Algorithm.Algorithm, oidJKSKeyProtector)
+
+	salt, encrypted := info.Data[:sha1.Size], info.Data[sha1.Size:len(info.Data)-sha1.Size]
+	der := make([]byte, len(encrypted))
+	digest := salt
+	for i := 0; i < len(encrypted); i += sha1.Size {
+		h := sha1.New() //nolint:gosec
+		h.Write(jksPassword(password))
+		h.Write(digest)
+		digest = h.Sum(nil)
+		for j := 0; j < sha1.Size && i+j < len(encrypted); j++ {
+			der[i+j] = encrypted[i+j] ^ digest[j]
+		}
+	}
+
+	h := sha1.New() //nolint:gosec
+	h.Write(jksPassword(password))
+	h.Write(der)
+	assert.Equal(t, h.Sum(nil), info.Data[len(info.Data)-sha1.Size:])
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	assert.Nil(t, err)
+
+	return key.(crypto.Signer)
+}
+
+func TestJKS(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	_, err = JKS("likexian.com", nil, issuance.Key, "changeit")
+	assert.Equal(t, err, ErrInvalidCertificate)
+
+	_, err = JKSTrustStore(nil, "changeit")
+	assert.Equal(t, err, ErrInvalidCertificate)
+
+	data, err := JKS("LikeXian.com", []*x509.Certificate{issuance.Certificate, ca.Certificate}, issuance.Key, "changeit")
+	assert.Nil(t, err)
+
+	entries := readJKS(t, data, "changeit")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, entries[0].tag, uint32(jksPrivateKey))
+	assert.Equal(t, entries[0].alias, "likexian.com")
+	assert.Equal(t, entries[0].certificates, [][]byte{issuance.DER, ca.Certificate.Raw})
+	key := recoverJKSKey(t, entries[0].key, "changeit")
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+
+	data, err = JKSTrustStore([]*x509.Certificate{ca.Certificate}, "changeit")
+	assert.Nil(t, err)
+
+	entries = readJKS(t, data, "changeit")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, entries[0].tag, uint32(jksTrustedCertificate))
+	assert.Equal(t, entries[0].certificates, [][]byte{ca.Certificate.Raw})
+}
+
+func TestIssuanceWriteJKS(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	err = issuance.WriteJKS("not-exists/likexian.com", "changeit")
+	assert.NotNil(t, err)
+
+	dir := t.TempDir()
+	err = issuance.WriteJKS(dir+"/likexian.com", "changeit")
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.JKSFile, dir+"/likexian.com.jks")
+	assert.Equal(t, issuance.TrustStoreFile, dir+"/likexian.com.truststore.jks")
+
+	data, err := os.ReadFile(issuance.JKSFile)
+	assert.Nil(t, err)
+	assert.Len(t, readJKS(t, data, "changeit"), 1)
+
+	data, err = os.ReadFile(issuance.TrustStoreFile)
+	assert.Nil(t, err)
+	entries := readJKS(t, data, "changeit")
+	assert.Len(t, entries, 1)
+	assert.Equal(t, entries[0].certificates, [][]byte{ca.Certificate.Raw})
+}
@@ -0,0 +1,231 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"path/filepath"
+
+	"golang.org/x/crypto/ssh"
+)
+
+// KeyType is the type of generated key
+type KeyType string
+
+const (
+	// KeyTypeRSA is RSA key, the key size is the modulus bits, the default
+	KeyTypeRSA KeyType = "rsa"
+	// KeyTypeECDSA is ECDSA key, the key size is the curve bits, 256, 384 or 521
+	KeyTypeECDSA KeyType = "ecdsa"
+	// KeyTypeEd25519 is Ed25519 key, the key size is always 256
+	KeyTypeEd25519 KeyType = "ed25519"
+)
+
+// ErrInvalidKeySize is invalid key size error
+var ErrInvalidKeySize = errors.New("selfca: the key size is invalid")
+
+// curves is the ECDSA curves by key size
+var curves = map[int]elliptic.Curve{
+	256: elliptic.P256(),
+	384: elliptic.P384(),
+	521: elliptic.P521(),
+}
+
+// DefaultKeySize returns the default key size of key type, 2048 for RSA and 256 for others
+func DefaultKeySize(t KeyType) int {
+	if t == KeyTypeECDSA || t == KeyTypeEd25519 {
+		return 256
+	}
+
+	return 2048
+}
+
+// GenerateKey generates private key of key type and size, empty type is RSA,
+// and the default size is used if size is not positive
+func GenerateKey(t KeyType, size int) (crypto.Signer, error) {
+	if size <= 0 {
+		size = DefaultKeySize(t)
+	}
+
+	switch t {
+	case "", KeyTypeRSA:
+		return rsa.GenerateKey(rand.Reader, size)
+	case KeyTypeECDSA:
+		curve, ok := curves[size]
+		if !ok {
+			return nil, ErrInvalidKeySize
+		}
+		return ecdsa.GenerateKey(curve, rand.Reader)
+	case KeyTypeEd25519:
+		if size != 256 {
+			return nil, ErrInvalidKeySize
+		}
+		_, key, err := ed25519.GenerateKey(rand.Reader)
+		return key, err
+	default:
+		return nil, ErrUnsupportedKeyType
+	}
+}
+
+// KeyTypeOf returns the key type and size of public key, empty type if unsupported
+func KeyTypeOf(pub crypto.PublicKey) (KeyType, int) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return KeyTypeRSA, k.N.BitLen()
+	case *ecdsa.PublicKey:
+		return KeyTypeECDSA, k.Curve.Params().BitSize
+	case ed25519.PublicKey:
+		return KeyTypeEd25519, 256
+	default:
+		return "", 0
+	}
+}
+
+// ParsePrivateKey parses private key in PKCS #1, PKCS #8 or SEC 1 DER form,
+// the key type is *rsa.PrivateKey, *ecdsa.PrivateKey or ed25519.PrivateKey
+func ParsePrivateKey(der []byte) (crypto.PrivateKey, error) {
+	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	if key, err := x509.ParseECPrivateKey(der); err == nil {
+		return key, nil
+	}
+
+	key, err := x509.ParsePKCS8PrivateKey(der)
+	if err != nil {
+		return nil, ErrInvalidCertificateKey
+	}
+
+	return key, nil
+}
+
+// ParsePrivateKeyPEM parses private key PEM block, the encrypted PKCS #8 or legacy
+// encrypted PEM key is decrypted by passphrase
+func ParsePrivateKeyPEM(block *pem.Block, passphrase []byte) (crypto.PrivateKey, error) {
+	der, encrypted := block.Bytes, false
+	switch {
+	case block.Type == "ENCRYPTED PRIVATE KEY":
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		decrypted, err := decryptPKCS8(der, passphrase)
+		if err != nil {
+			return nil, err
+		}
+		der, encrypted = decrypted, true
+	case x509.IsEncryptedPEMBlock(block): //nolint:staticcheck
+		if len(passphrase) == 0 {
+			return nil, ErrPassphraseRequired
+		}
+		decrypted, err := x509.DecryptPEMBlock(block, passphrase) //nolint:staticcheck
+		if errors.Is(err, x509.IncorrectPasswordError) {
+			return nil, ErrIncorrectPassphrase
+		}
+		if err != nil {
+			return nil, ErrUnsupportedEncryption
+		}
+		der, encrypted = decrypted, true
+	}
+
+	key, err := ParsePrivateKey(der)
+	if encrypted {
+		clear(der)
+	}
+	if err != nil && encrypted {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	return key, err
+}
+
+// MarshalPrivateKey returns the PEM block of private key, in PKCS #1 form for RSA,
+// SEC 1 form for ECDSA and PKCS #8 form for others like Ed25519
+func MarshalPrivateKey(key crypto.Signer) (*pem.Block, error) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		return &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(k)}, nil
+	case *ecdsa.PrivateKey:
+		der, err := x509.MarshalECPrivateKey(k)
+		if err != nil {
+			return nil, err
+		}
+		return &pem.Block{Type: "EC PRIVATE KEY", Bytes: der}, nil
+	default:
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return nil, ErrUnsupportedKeyType
+		}
+		return &pem.Block{Type: "PRIVATE KEY", Bytes: der}, nil
+	}
+}
+
+// MarshalSSHPublicKey returns the public key in OpenSSH authorized_keys form,
+// like ssh-ed25519 AAAA... comment, the comment is omitted if empty
+func MarshalSSHPublicKey(pub crypto.PublicKey, comment string) ([]byte, error) {
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	line := bytes.TrimSuffix(ssh.MarshalAuthorizedKey(key), []byte("\n"))
+	if comment != "" {
+		line = append(append(line, ' '), comment...)
+	}
+
+	return append(line, '\n'), nil
+}
+
+// WritePublicKey writes public key in PKIX PEM form to name.pub.pem and OpenSSH form to name.pub,
+// the comment of OpenSSH form is the base of name, the existing files are replaced atomically
+func WritePublicKey(name string, pub crypto.PublicKey) error {
+	line, err := MarshalSSHPublicKey(pub, filepath.Base(name))
+	if err != nil {
+		return err
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(pub)
+	if err != nil {
+		return ErrUnsupportedKeyType
+	}
+
+	err = writeFile(fmt.Sprintf("%s.pub.pem", name), func(w io.Writer) error {
+		return pem.Encode(w, &pem.Block{Type: "PUBLIC KEY", Bytes: der})
+	}, 0644)
+	if err != nil {
+		return err
+	}
+
+	return writeFile(fmt.Sprintf("%s.pub", name), func(w io.Writer) error {
+		_, err := w.Write(line)
+		return err
+	}, 0644)
+}
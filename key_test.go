@@ -0,0 +1,330 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"golang.org/x/crypto/ssh"
+)
+
+func TestParsePrivateKey(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	assert.Nil(t, err)
+
+	key, err := ParsePrivateKey(x509.MarshalPKCS1PrivateKey(rsaKey))
+	assert.Nil(t, err)
+	assert.True(t, rsaKey.Equal(key))
+
+	der, err := x509.MarshalPKCS8PrivateKey(rsaKey)
+	assert.Nil(t, err)
+	key, err = ParsePrivateKey(der)
+	assert.Nil(t, err)
+	assert.True(t, rsaKey.Equal(key))
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	der, err = x509.MarshalECPrivateKey(ecKey)
+	assert.Nil(t, err)
+	key, err = ParsePrivateKey(der)
+	assert.Nil(t, err)
+	assert.True(t, ecKey.Equal(key))
+
+	der, err = x509.MarshalPKCS8PrivateKey(ecKey)
+	assert.Nil(t, err)
+	key, err = ParsePrivateKey(der)
+	assert.Nil(t, err)
+	assert.True(t, ecKey.Equal(key))
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	assert.Nil(t, err)
+	key, err = ParsePrivateKey(der)
+	assert.Nil(t, err)
+	assert.Equal(t, key, edKey)
+
+	_, err = ParsePrivateKey([]byte("0"))
+	assert.Equal(t, err, ErrInvalidCertificateKey)
+}
+
+func TestReadCertificateKeyForm(t *testing.T) {
+	certPath := "cert"
+	caPath := certPath + "/ca"
+
+	i, err := Issue(Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	_ = os.Mkdir(certPath, 0755)
+	defer os.RemoveAll(certPath)
+
+	err = i.Write(caPath)
+	assert.Nil(t, err)
+
+	der, err := x509.MarshalPKCS8PrivateKey(i.Key)
+	assert.Nil(t, err)
+
+	_ = os.WriteFile(caPath+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	_, key, err := ReadCertificate(caPath)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Public(), i.Key.Public())
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	assert.Nil(t, err)
+
+	der, err = x509.MarshalECPrivateKey(ecKey)
+	assert.Nil(t, err)
+
+	_ = os.WriteFile(caPath+".key", pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: der}), 0600)
+	_, _, err = ReadCertificate(caPath)
+	assert.Equal(t, err, ErrKeyMismatch)
+
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	der, err = x509.MarshalPKCS8PrivateKey(edKey)
+	assert.Nil(t, err)
+
+	_ = os.WriteFile(caPath+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	_, _, err = ReadCertificate(caPath)
+	assert.Equal(t, err, ErrKeyMismatch)
+
+	xKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+
+	der, err = x509.MarshalPKCS8PrivateKey(xKey)
+	assert.Nil(t, err)
+
+	_ = os.WriteFile(caPath+".key", pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}), 0600)
+	_, _, err = ReadCertificate(caPath)
+	assert.Equal(t, err, ErrUnsupportedKeyType)
+}
+
+func TestGenerateKey(t *testing.T) {
+	key, err := GenerateKey("", 0)
+	assert.Nil(t, err)
+	keyType, size := KeyTypeOf(key.Public())
+	assert.Equal(t, keyType, KeyTypeRSA)
+	assert.Equal(t, size, 2048)
+
+	for _, v := range []int{0, 256, 384, 521} {
+		key, err = GenerateKey(KeyTypeECDSA, v)
+		assert.Nil(t, err)
+		keyType, size = KeyTypeOf(key.Public())
+		assert.Equal(t, keyType, KeyTypeECDSA)
+		assert.Equal(t, size, max(v, 256))
+
+		block, err := MarshalPrivateKey(key)
+		assert.Nil(t, err)
+		assert.Equal(t, block.Type, "EC PRIVATE KEY")
+		parsed, err := ParsePrivateKey(block.Bytes)
+		assert.Nil(t, err)
+		assert.True(t, key.(*ecdsa.PrivateKey).Equal(parsed))
+	}
+
+	_, err = GenerateKey(KeyTypeECDSA, 2048)
+	assert.Equal(t, err, ErrInvalidKeySize)
+
+	key, err = GenerateKey(KeyTypeEd25519, 0)
+	assert.Nil(t, err)
+	keyType, size = KeyTypeOf(key.Public())
+	assert.Equal(t, keyType, KeyTypeEd25519)
+	assert.Equal(t, size, 256)
+
+	block, err := MarshalPrivateKey(key)
+	assert.Nil(t, err)
+	assert.Equal(t, block.Type, "PRIVATE KEY")
+	parsed, err := ParsePrivateKey(block.Bytes)
+	assert.Nil(t, err)
+	assert.True(t, key.(ed25519.PrivateKey).Equal(parsed))
+
+	_, err = GenerateKey(KeyTypeEd25519, 384)
+	assert.Equal(t, err, ErrInvalidKeySize)
+
+	_, err = GenerateKey("dsa", 2048)
+	assert.Equal(t, err, ErrUnsupportedKeyType)
+
+	keyType, size = KeyTypeOf(nil)
+	assert.Equal(t, keyType, KeyType(""))
+	assert.Equal(t, size, 0)
+}
+
+func TestECDSACertificate(t *testing.T) {
+	ca, err := Issue(Certificate{
+		IsCA:      true,
+		KeyType:   KeyTypeECDSA,
+		KeySize:   384,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(24 * time.Hour),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ca.Certificate.SignatureAlgorithm, x509.ECDSAWithSHA384)
+
+	issuance, err := Issue(Certificate{
+		KeyType:       KeyTypeECDSA,
+		Hosts:         []string{"likexian.com"},
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Hour),
+		CAKey:         ca.Key,
+		CACertificate: ca.Certificate,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, issuance.Certificate.CheckSignatureFrom(ca.Certificate))
+	assert.Equal(t, issuance.Certificate.PublicKeyAlgorithm, x509.ECDSA)
+	assert.Equal(t, issuance.Certificate.KeyUsage&x509.KeyUsageKeyEncipherment, x509.KeyUsage(0))
+
+	name := t.TempDir() + "/likexian.com"
+	err = issuance.Write(name)
+	assert.Nil(t, err)
+
+	certificates, key, err := ReadCertificate(name)
+	assert.Nil(t, err)
+	assert.Equal(t, certificates[0].Raw, issuance.DER)
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+
+	csr, key, err := GenerateCSR(Certificate{
+		KeyType:           KeyTypeECDSA,
+		KeySize:           521,
+		Hosts:             []string{"likexian.com"},
+		ChallengePassword: "secret",
+	})
+	assert.Nil(t, err)
+	request, err := ParseCSR(csr)
+	assert.Nil(t, err)
+	assert.Equal(t, request.PublicKey, key.Public())
+	assert.Equal(t, CSRChallengePassword(request), "secret")
+}
+
+func TestEd25519Certificate(t *testing.T) {
+	ca, err := Issue(Certificate{
+		IsCA:      true,
+		KeyType:   KeyTypeEd25519,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(24 * time.Hour),
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, ca.Certificate.SignatureAlgorithm, x509.PureEd25519)
+
+	issuance, err := Issue(Certificate{
+		KeyType:       KeyTypeEd25519,
+		Hosts:         []string{"likexian.com"},
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Hour),
+		CAKey:         ca.Key,
+		CACertificate: ca.Certificate,
+	})
+	assert.Nil(t, err)
+	assert.Nil(t, issuance.Certificate.CheckSignatureFrom(ca.Certificate))
+	assert.Equal(t, issuance.Certificate.PublicKeyAlgorithm, x509.Ed25519)
+	assert.Equal(t, issuance.Certificate.KeyUsage&x509.KeyUsageKeyEncipherment, x509.KeyUsage(0))
+	assert.Contains(t, string(issuance.KeyPEM), "BEGIN PRIVATE KEY")
+
+	name := t.TempDir() + "/likexian.com"
+	err = issuance.Write(name)
+	assert.Nil(t, err)
+
+	certificates, key, err := ReadCertificate(name)
+	assert.Nil(t, err)
+	assert.Equal(t, certificates[0].Raw, issuance.DER)
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+
+	csr, key, err := GenerateCSR(Certificate{
+		KeyType:           KeyTypeEd25519,
+		Hosts:             []string{"likexian.com"},
+		ChallengePassword: "secret",
+	})
+	assert.Nil(t, err)
+	request, err := ParseCSR(csr)
+	assert.Nil(t, err)
+	assert.Nil(t, request.CheckSignature())
+	assert.Equal(t, request.PublicKey, key.Public())
+	assert.Equal(t, CSRChallengePassword(request), "secret")
+
+	assert.Nil(t, CheckWeak(Certificate{KeyType: KeyTypeEd25519, KeySize: 256,
+		NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}))
+	assert.Nil(t, CheckFIPS(Certificate{KeyType: KeyTypeEd25519, CAKey: ca.Key, CACertificate: ca.Certificate}))
+}
+
+func TestWritePublicKey(t *testing.T) {
+	certPath := "cert"
+	_ = os.Mkdir(certPath, 0755)
+	defer os.RemoveAll(certPath)
+
+	for _, v := range []KeyType{KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519} {
+		key, err := GenerateKey(v, 0)
+		assert.Nil(t, err)
+
+		err = WritePublicKey(certPath+"/"+string(v), key.Public())
+		assert.Nil(t, err)
+
+		data, err := os.ReadFile(certPath + "/" + string(v) + ".pub.pem")
+		assert.Nil(t, err)
+		block, _ := pem.Decode(data)
+		assert.Equal(t, block.Type, "PUBLIC KEY")
+		pub, err := x509.ParsePKIXPublicKey(block.Bytes)
+		assert.Nil(t, err)
+		assert.Equal(t, pub, key.Public())
+
+		data, err = os.ReadFile(certPath + "/" + string(v) + ".pub")
+		assert.Nil(t, err)
+		sshKey, comment, _, _, err := ssh.ParseAuthorizedKey(data)
+		assert.Nil(t, err)
+		assert.Equal(t, comment, string(v))
+		assert.Equal(t, sshKey.(ssh.CryptoPublicKey).CryptoPublicKey(), key.Public())
+	}
+
+	line, err := MarshalSSHPublicKey(ed25519.PublicKey(make([]byte, ed25519.PublicKeySize)), "")
+	assert.Nil(t, err)
+	assert.Equal(t, string(line), "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAAA\n")
+
+	xKey, err := ecdh.X25519().GenerateKey(rand.Reader)
+	assert.Nil(t, err)
+	err = WritePublicKey(certPath+"/x25519", xKey.Public())
+	assert.Equal(t, err, ErrUnsupportedKeyType)
+
+	i, err := Issue(Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	err = i.WritePublicKey(certPath + "/ca")
+	assert.Nil(t, err)
+	assert.Equal(t, i.PublicKeyFile, certPath+"/ca.pub.pem")
+	assert.Equal(t, i.SSHPublicKeyFile, certPath+"/ca.pub")
+}
@@ -0,0 +1,153 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+)
+
+// ErrNameNotPermitted is name outside of the ca name constraints error
+var ErrNameNotPermitted = errors.New("selfca: the name is not permitted by the ca name constraints")
+
+// setNameConstraints sets the name constraints of config to the ca template,
+// the extension is critical as required by RFC 5280
+func setNameConstraints(template *x509.Certificate, c Certificate) {
+	template.PermittedDNSDomains = c.PermittedDNSDomains
+	template.ExcludedDNSDomains = c.ExcludedDNSDomains
+	template.PermittedIPRanges = c.PermittedIPRanges
+	template.ExcludedIPRanges = c.ExcludedIPRanges
+	template.PermittedEmailAddresses = c.PermittedEmailAddresses
+	template.ExcludedEmailAddresses = c.ExcludedEmailAddresses
+	template.PermittedURIDomains = c.PermittedURIDomains
+	template.ExcludedURIDomains = c.ExcludedURIDomains
+
+	template.PermittedDNSDomainsCritical = c.PermittedDNSDomainsCritical ||
+		len(c.PermittedDNSDomains) > 0 || len(c.ExcludedDNSDomains) > 0 ||
+		len(c.PermittedIPRanges) > 0 || len(c.ExcludedIPRanges) > 0 ||
+		len(c.PermittedEmailAddresses) > 0 || len(c.ExcludedEmailAddresses) > 0 ||
+		len(c.PermittedURIDomains) > 0 || len(c.ExcludedURIDomains) > 0
+}
+
+// checkNameConstraints checks the names of template are permitted by the name constraints of ca,
+// so that the certificate is not refused by clients
+func checkNameConstraints(ca *x509.Certificate, template *x509.Certificate) error {
+	var errs []error
+	for _, v := range template.DNSNames {
+		if !permitted(v, ca.PermittedDNSDomains, ca.ExcludedDNSDomains, matchDomain) {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrNameNotPermitted, v))
+		}
+	}
+
+	for _, v := range template.IPAddresses {
+		if !permittedIP(v, ca.PermittedIPRanges, ca.ExcludedIPRanges) {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrNameNotPermitted, v))
+		}
+	}
+
+	for _, v := range template.EmailAddresses {
+		if !permitted(v, ca.PermittedEmailAddresses, ca.ExcludedEmailAddresses, matchEmail) {
+			errs = append(errs, fmt.Errorf("%w: %s", ErrNameNotPermitted, v))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// permitted returns whether the name is within the permitted if any, and outside of the excluded
+func permitted(name string, permitted, excluded []string, match func(string, string) bool) bool {
+	for _, v := range excluded {
+		if match(name, v) {
+			return false
+		}
+	}
+
+	if len(permitted) == 0 {
+		return true
+	}
+
+	for _, v := range permitted {
+		if match(name, v) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// permittedIP returns whether the ip is within the permitted ranges if any, and outside of the excluded
+func permittedIP(ip net.IP, permitted, excluded []*net.IPNet) bool {
+	for _, v := range excluded {
+		if v.Contains(ip) {
+			return false
+		}
+	}
+
+	if len(permitted) == 0 {
+		return true
+	}
+
+	for _, v := range permitted {
+		if v.Contains(ip) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// matchDomain returns whether the domain is within the constraint, the constraint with leading dot
+// only matches the subdomains, otherwise also the domain itself
+func matchDomain(domain, constraint string) bool {
+	domain, constraint = strings.ToLower(domain), strings.ToLower(constraint)
+	if constraint == "" {
+		return true
+	}
+
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(domain, constraint)
+	}
+
+	return domain == constraint || strings.HasSuffix(domain, "."+constraint)
+}
+
+// matchEmail returns whether the email is within the constraint, which is a mailbox, a host,
+// or a domain with leading dot only matching the subdomains
+func matchEmail(email, constraint string) bool {
+	if strings.Contains(constraint, "@") {
+		return strings.EqualFold(email, constraint)
+	}
+
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return false
+	}
+
+	host := strings.ToLower(email[i+1:])
+	constraint = strings.ToLower(constraint)
+	if strings.HasPrefix(constraint, ".") {
+		return strings.HasSuffix(host, constraint)
+	}
+
+	return host == constraint
+}
@@ -0,0 +1,100 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestNameConstraints(t *testing.T) {
+	_, internal, err := net.ParseCIDR("10.0.0.0/8")
+	assert.Nil(t, err)
+	_, excluded, err := net.ParseCIDR("10.1.0.0/16")
+	assert.Nil(t, err)
+
+	root, err := Issue(Certificate{
+		IsCA:                    true,
+		NotBefore:               time.Now(),
+		NotAfter:                time.Now().Add(time.Hour),
+		PermittedDNSDomains:     []string{".internal.corp", "localhost"},
+		ExcludedDNSDomains:      []string{"secret.internal.corp"},
+		PermittedIPRanges:       []*net.IPNet{internal},
+		ExcludedIPRanges:        []*net.IPNet{excluded},
+		PermittedEmailAddresses: []string{"corp.com"},
+	})
+	assert.Nil(t, err)
+	assert.True(t, root.Certificate.PermittedDNSDomainsCritical)
+	assert.Equal(t, root.Certificate.PermittedDNSDomains, []string{".internal.corp", "localhost"})
+
+	ca := root.CA()
+	leaf, err := ca.Issue(Certificate{
+		Hosts: []string{"api.internal.corp", "*.web.internal.corp", "localhost", "10.2.0.1", "admin@corp.com"},
+	})
+	assert.Nil(t, err)
+
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{
+		DNSName: "api.internal.corp",
+		Roots:   ca.CertPool(),
+	})
+	assert.Nil(t, err)
+
+	tests := []string{
+		"internal.corp",
+		"example.com",
+		"a.secret.internal.corp",
+		"192.168.1.1",
+		"10.1.2.3",
+		"admin@mail.corp.com",
+	}
+
+	for _, v := range tests {
+		_, err = ca.Issue(Certificate{Hosts: []string{"api.internal.corp", v}})
+		assert.True(t, errors.Is(err, ErrNameNotPermitted), v)
+	}
+
+	csr, _, err := GenerateCSR(Certificate{Hosts: []string{"example.com"}})
+	assert.Nil(t, err)
+	_, err = ca.SignCSR(csr, Certificate{})
+	assert.True(t, errors.Is(err, ErrNameNotPermitted))
+
+	// the intermediate has no names to check
+	_, err = ca.Issue(Certificate{IsCA: true})
+	assert.Nil(t, err)
+
+	unconstrained, err := NewEphemeralCA()
+	assert.Nil(t, err)
+	assert.False(t, unconstrained.Certificate.PermittedDNSDomainsCritical)
+	_, err = unconstrained.Issue(Certificate{Hosts: []string{"example.com"}})
+	assert.Nil(t, err)
+}
+
+func TestMatchEmail(t *testing.T) {
+	assert.True(t, matchEmail("admin@corp.com", "admin@corp.com"))
+	assert.False(t, matchEmail("root@corp.com", "admin@corp.com"))
+	assert.True(t, matchEmail("admin@mail.corp.com", ".corp.com"))
+	assert.False(t, matchEmail("admin@corp.com", ".corp.com"))
+	assert.False(t, matchEmail("invalid", "corp.com"))
+}
@@ -0,0 +1,368 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+)
+
+var (
+	// ErrOCSPUnauthorized is OCSP request not for the certificates issued by ca error
+	ErrOCSPUnauthorized = errors.New("selfca: the OCSP request is not for the ca")
+	// ErrInvalidOCSP is invalid OCSP request or response error
+	ErrInvalidOCSP = errors.New("selfca: the OCSP request or response is invalid")
+	// ErrOCSPNonce is OCSP nonce longer than 32 bytes or empty error
+	ErrOCSPNonce = errors.New("selfca: the OCSP nonce must be 1 to 32 bytes")
+)
+
+// DefaultOCSPValidity is the default time from this update to next update of OCSP response
+const DefaultOCSPValidity = time.Hour
+
+// maxOCSPNonce is the max length of OCSP nonce by RFC 8954
+const maxOCSPNonce = 32
+
+var (
+	// oidOCSPNonce is the OID of OCSP nonce extension
+	oidOCSPNonce = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 2}
+	// oidOCSPBasic is the OID of OCSP basic response type
+	oidOCSPBasic = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 1}
+)
+
+// ocspHashes is the hash algorithms of OCSP certificate ID by OID
+var ocspHashes = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}, crypto.SHA1},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, crypto.SHA256},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, crypto.SHA384},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}, crypto.SHA512},
+}
+
+// ocspCertID is the certificate ID of OCSP request and response
+type ocspCertID struct {
+	Raw           asn1.RawContent
+	HashAlgorithm pkix.AlgorithmIdentifier
+	NameHash      []byte
+	IssuerKeyHash []byte
+	SerialNumber  *big.Int
+}
+
+// ocspRequest is the OCSP request, the extensions are kept unlike x/crypto/ocsp
+type ocspRequest struct {
+	TBSRequest struct {
+		Version           int           `asn1:"explicit,tag:0,default:0,optional"`
+		RequestorName     asn1.RawValue `asn1:"explicit,tag:1,optional"`
+		RequestList       []struct{ Cert ocspCertID }
+		RequestExtensions []pkix.Extension `asn1:"explicit,tag:2,optional"`
+	}
+}
+
+// ocspResponse is the OCSP response
+type ocspResponse struct {
+	Status   asn1.Enumerated
+	Response struct {
+		ResponseType asn1.ObjectIdentifier
+		Response     []byte
+	} `asn1:"explicit,tag:0,optional"`
+}
+
+// ocspBasicResponse is the OCSP basic response
+type ocspBasicResponse struct {
+	TBSResponseData    asn1.RawValue
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          asn1.BitString
+}
+
+// ocspResponseData is the signed data of OCSP basic response, the response extensions
+// are not supported by x/crypto/ocsp, which is needed by the nonce
+type ocspResponseData struct {
+	Version            int `asn1:"optional,default:0,explicit,tag:0"`
+	ResponderID        asn1.RawValue
+	ProducedAt         time.Time `asn1:"generalized"`
+	Responses          []ocspSingleResponse
+	ResponseExtensions []pkix.Extension `asn1:"explicit,tag:1,optional"`
+}
+
+// ocspSingleResponse is the status of a certificate in OCSP response
+type ocspSingleResponse struct {
+	CertID     asn1.RawValue
+	Good       asn1.Flag       `asn1:"tag:0,optional"`
+	Revoked    ocspRevokedInfo `asn1:"tag:1,optional"`
+	Unknown    asn1.Flag       `asn1:"tag:2,optional"`
+	ThisUpdate time.Time       `asn1:"generalized"`
+	NextUpdate time.Time       `asn1:"generalized,explicit,tag:0,optional"`
+}
+
+// ocspRevokedInfo is the revocation of a certificate in OCSP response
+type ocspRevokedInfo struct {
+	RevocationTime time.Time       `asn1:"generalized"`
+	Reason         asn1.Enumerated `asn1:"explicit,tag:0,optional"`
+}
+
+// OCSPResponse returns the DER encoded OCSP response to the DER encoded request signed by the ca,
+// the status is good if issued, revoked if revoked in database, or unknown if not issued,
+// the nonce of request is echoed, returns ErrOCSPUnauthorized if the request is for other issuer
+func (d *Database) OCSPResponse(ca *CA, request []byte, validity time.Duration) ([]byte, error) {
+	return d.OCSPResponseWithNonce(ca, request, validity, true)
+}
+
+// OCSPResponseWithNonce returns the OCSP response like OCSPResponse, the nonce of request
+// is echoed only if nonce, for testing the clients breaking on nonces
+func (d *Database) OCSPResponseWithNonce(ca *CA, request []byte, validity time.Duration, nonce bool) ([]byte, error) {
+	var r ocspRequest
+	rest, err := asn1.Unmarshal(request, &r)
+	if err != nil || len(rest) > 0 || len(r.TBSRequest.RequestList) == 0 {
+		return nil, ErrInvalidOCSP
+	}
+
+	if validity <= 0 {
+		validity = DefaultOCSPValidity
+	}
+
+	id := r.TBSRequest.RequestList[0].Cert
+	err = checkOCSPIssuer(ca.Certificate, id)
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	single := ocspSingleResponse{
+		CertID:     asn1.RawValue{FullBytes: id.Raw},
+		Unknown:    true,
+		ThisUpdate: now,
+		NextUpdate: now.Add(validity),
+	}
+
+	if i := d.find(id.SerialNumber); i >= 0 {
+		single.Unknown, single.Good = false, true
+		if v := d.Certificates[i]; v.RevokedAt != nil {
+			single.Good = false
+			single.Revoked = ocspRevokedInfo{RevocationTime: v.RevokedAt.UTC(), Reason: asn1.Enumerated(v.Reason)}
+		}
+	}
+
+	data := ocspResponseData{
+		ResponderID: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        1,
+			IsCompound: true,
+			Bytes:      ca.Certificate.RawSubject,
+		},
+		ProducedAt: now,
+		Responses:  []ocspSingleResponse{single},
+	}
+
+	for _, v := range r.TBSRequest.RequestExtensions {
+		if !nonce || !v.Id.Equal(oidOCSPNonce) {
+			continue
+		}
+		var value []byte
+		_, err = asn1.Unmarshal(v.Value, &value)
+		if err != nil || len(value) == 0 || len(value) > maxOCSPNonce {
+			return nil, ErrOCSPNonce
+		}
+		data.ResponseExtensions = append(data.ResponseExtensions, pkix.Extension{Id: oidOCSPNonce, Value: v.Value})
+	}
+
+	return signOCSPResponse(ca, data)
+}
+
+// checkOCSPIssuer returns ErrOCSPUnauthorized if the certificate ID is not for the ca
+func checkOCSPIssuer(ca *x509.Certificate, id ocspCertID) error {
+	var hash crypto.Hash
+	for _, v := range ocspHashes {
+		if v.oid.Equal(id.HashAlgorithm.Algorithm) {
+			hash = v.hash
+		}
+	}
+
+	if hash == 0 || !hash.Available() {
+		return ErrOCSPUnauthorized
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(ca.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return err
+	}
+
+	h := hash.New()
+	h.Write(spki.PublicKey.RightAlign())
+	if !bytes.Equal(h.Sum(nil), id.IssuerKeyHash) {
+		return ErrOCSPUnauthorized
+	}
+
+	return nil
+}
+
+// signOCSPResponse returns the successful OCSP response of data signed by the ca
+func signOCSPResponse(ca *CA, data ocspResponseData) ([]byte, error) {
+	tbs, err := asn1.Marshal(data)
+	if err != nil {
+		return nil, err
+	}
+
+	hash, algorithm, err := ocspSignatureAlgorithm(ca.Key.Public())
+	if err != nil {
+		return nil, err
+	}
+
+	digest := tbs
+	if hash != 0 {
+		h := hash.New()
+		h.Write(tbs)
+		digest = h.Sum(nil)
+	}
+
+	signature, err := ca.Key.Sign(rand.Reader, digest, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	basic, err := asn1.Marshal(ocspBasicResponse{
+		TBSResponseData:    asn1.RawValue{FullBytes: tbs},
+		SignatureAlgorithm: algorithm,
+		Signature:          asn1.BitString{Bytes: signature, BitLength: 8 * len(signature)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var response ocspResponse
+	response.Response.ResponseType = oidOCSPBasic
+	response.Response.Response = basic
+
+	return asn1.Marshal(response)
+}
+
+// ocspSignatureAlgorithm returns the hash and signature algorithm of the ca key
+func ocspSignatureAlgorithm(pub crypto.PublicKey) (crypto.Hash, pkix.AlgorithmIdentifier, error) {
+	switch k := pub.(type) {
+	case *rsa.PublicKey:
+		return crypto.SHA256, pkix.AlgorithmIdentifier{
+			Algorithm:  asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11},
+			Parameters: asn1.NullRawValue,
+		}, nil
+	case *ecdsa.PublicKey:
+		switch k.Curve {
+		case elliptic.P384():
+			return crypto.SHA384, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}}, nil
+		case elliptic.P521():
+			return crypto.SHA512, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}}, nil
+		default:
+			return crypto.SHA256, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}}, nil
+		}
+	case ed25519.PublicKey:
+		return 0, pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 3, 101, 112}}, nil
+	default:
+		return 0, pkix.AlgorithmIdentifier{}, ErrUnsupportedKeyType
+	}
+}
+
+// NewOCSPRequest returns the DER encoded OCSP request of the certificate issued by issuer,
+// with the nonce extension if nonce is not empty
+func NewOCSPRequest(c, issuer *x509.Certificate, nonce []byte) ([]byte, error) {
+	if len(nonce) > maxOCSPNonce {
+		return nil, ErrOCSPNonce
+	}
+
+	var spki struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	_, err := asn1.Unmarshal(issuer.RawSubjectPublicKeyInfo, &spki)
+	if err != nil {
+		return nil, err
+	}
+
+	nameHash := crypto.SHA1.New()
+	nameHash.Write(issuer.RawSubject)
+	keyHash := crypto.SHA1.New()
+	keyHash.Write(spki.PublicKey.RightAlign())
+
+	var r ocspRequest
+	r.TBSRequest.RequestList = []struct{ Cert ocspCertID }{{Cert: ocspCertID{
+		HashAlgorithm: pkix.AlgorithmIdentifier{Algorithm: ocspHashes[0].oid, Parameters: asn1.NullRawValue},
+		NameHash:      nameHash.Sum(nil),
+		IssuerKeyHash: keyHash.Sum(nil),
+		SerialNumber:  c.SerialNumber,
+	}}}
+
+	if len(nonce) > 0 {
+		value, err := asn1.Marshal(nonce)
+		if err != nil {
+			return nil, err
+		}
+		r.TBSRequest.RequestExtensions = []pkix.Extension{{Id: oidOCSPNonce, Value: value}}
+	}
+
+	return asn1.Marshal(r)
+}
+
+// OCSPResponseNonce returns the nonce of the DER encoded OCSP response, nil if it has no nonce,
+// the response is not verified, parse it by x/crypto/ocsp for the status and signature
+func OCSPResponseNonce(response []byte) ([]byte, error) {
+	var r ocspResponse
+	_, err := asn1.Unmarshal(response, &r)
+	if err != nil || !r.Response.ResponseType.Equal(oidOCSPBasic) {
+		return nil, ErrInvalidOCSP
+	}
+
+	var basic ocspBasicResponse
+	_, err = asn1.Unmarshal(r.Response.Response, &basic)
+	if err != nil {
+		return nil, ErrInvalidOCSP
+	}
+
+	var data ocspResponseData
+	_, err = asn1.Unmarshal(basic.TBSResponseData.FullBytes, &data)
+	if err != nil {
+		return nil, ErrInvalidOCSP
+	}
+
+	for _, v := range data.ResponseExtensions {
+		if v.Id.Equal(oidOCSPNonce) {
+			var nonce []byte
+			_, err = asn1.Unmarshal(v.Value, &nonce)
+			if err != nil {
+				return nil, ErrInvalidOCSP
+			}
+			return nonce, nil
+		}
+	}
+
+	return nil, nil
+}
@@ -0,0 +1,132 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestOCSPResponse(t *testing.T) {
+	d, err := OpenDatabase(t.TempDir() + "/ca.db")
+	assert.Nil(t, err)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	request, err := ocsp.CreateRequest(leaf.Certificate, ca.Certificate, nil)
+	assert.Nil(t, err)
+
+	data, err := d.OCSPResponse(ca, request, 0)
+	assert.Nil(t, err)
+	response, err := ocsp.ParseResponseForCert(data, leaf.Certificate, ca.Certificate)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Status, ocsp.Unknown)
+	assert.True(t, response.NextUpdate.Sub(response.ThisUpdate) == DefaultOCSPValidity)
+
+	assert.Nil(t, d.Add(leaf.Certificate))
+	data, err = d.OCSPResponse(ca, request, time.Minute)
+	assert.Nil(t, err)
+	response, err = ocsp.ParseResponseForCert(data, leaf.Certificate, ca.Certificate)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Status, ocsp.Good)
+	assert.Equal(t, response.SerialNumber, leaf.Certificate.SerialNumber)
+
+	revokedAt := time.Now().Truncate(time.Second)
+	assert.Nil(t, d.RevokeWithReason(leaf.Certificate.SerialNumber, revokedAt, ocsp.KeyCompromise))
+	data, err = d.OCSPResponse(ca, request, 0)
+	assert.Nil(t, err)
+	response, err = ocsp.ParseResponseForCert(data, leaf.Certificate, ca.Certificate)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Status, ocsp.Revoked)
+	assert.True(t, response.RevokedAt.Equal(revokedAt))
+	assert.Equal(t, response.RevocationReason, ocsp.KeyCompromise)
+
+	other, err := NewEphemeralCA()
+	assert.Nil(t, err)
+	_, err = d.OCSPResponse(other, request, 0)
+	assert.Equal(t, err, ErrOCSPUnauthorized)
+
+	_, err = d.OCSPResponse(ca, []byte("invalid"), 0)
+	assert.NotNil(t, err)
+
+	leaf.Certificate.SerialNumber = big.NewInt(1)
+	request, err = ocsp.CreateRequest(leaf.Certificate, ca.Certificate, nil)
+	assert.Nil(t, err)
+	data, err = d.OCSPResponse(ca, request, 0)
+	assert.Nil(t, err)
+	response, err = ocsp.ParseResponse(data, ca.Certificate)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Status, ocsp.Unknown)
+}
+
+func TestOCSPNonce(t *testing.T) {
+	d, err := OpenDatabase(t.TempDir() + "/ca.db")
+	assert.Nil(t, err)
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+	assert.Nil(t, d.Add(leaf.Certificate))
+
+	nonce := []byte("0123456789abcdef")
+	request, err := NewOCSPRequest(leaf.Certificate, ca.Certificate, nonce)
+	assert.Nil(t, err)
+
+	data, err := d.OCSPResponse(ca, request, 0)
+	assert.Nil(t, err)
+	response, err := ocsp.ParseResponseForCert(data, leaf.Certificate, ca.Certificate)
+	assert.Nil(t, err)
+	assert.Equal(t, response.Status, ocsp.Good)
+	echoed, err := OCSPResponseNonce(data)
+	assert.Nil(t, err)
+	assert.Equal(t, echoed, nonce)
+
+	data, err = d.OCSPResponseWithNonce(ca, request, 0, false)
+	assert.Nil(t, err)
+	_, err = ocsp.ParseResponseForCert(data, leaf.Certificate, ca.Certificate)
+	assert.Nil(t, err)
+	echoed, err = OCSPResponseNonce(data)
+	assert.Nil(t, err)
+	assert.Len(t, echoed, 0)
+
+	request, err = NewOCSPRequest(leaf.Certificate, ca.Certificate, nil)
+	assert.Nil(t, err)
+	data, err = d.OCSPResponse(ca, request, 0)
+	assert.Nil(t, err)
+	echoed, err = OCSPResponseNonce(data)
+	assert.Nil(t, err)
+	assert.Len(t, echoed, 0)
+
+	_, err = NewOCSPRequest(leaf.Certificate, ca.Certificate, make([]byte, 33))
+	assert.Equal(t, err, ErrOCSPNonce)
+
+	_, err = OCSPResponseNonce([]byte("invalid"))
+	assert.Equal(t, err, ErrInvalidOCSP)
+}
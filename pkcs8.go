@@ -0,0 +1,236 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"errors"
+	"hash"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+var (
+	// ErrPassphraseRequired is encrypted key without passphrase error
+	ErrPassphraseRequired = errors.New("selfca: the key is encrypted, passphrase required")
+	// ErrIncorrectPassphrase is incorrect passphrase of encrypted key error
+	ErrIncorrectPassphrase = errors.New("selfca: the passphrase of key is incorrect")
+	// ErrUnsupportedEncryption is unsupported key encryption error
+	ErrUnsupportedEncryption = errors.New("selfca: the key encryption is unsupported")
+)
+
+// pbkdf2Iterations is the PBKDF2 iterations of encrypting key, as recommended by OWASP for HMAC-SHA256
+const pbkdf2Iterations = 600000
+
+var (
+	// oidPBES2 is the OID of PBES2 encryption scheme
+	oidPBES2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 13}
+	// oidPBKDF2 is the OID of PBKDF2 key derivation function
+	oidPBKDF2 = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 5, 12}
+	// oidHMACWithSHA1 is the OID of HMAC-SHA1, the default PRF of PBKDF2
+	oidHMACWithSHA1 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 7}
+	// oidHMACWithSHA256 is the OID of HMAC-SHA256
+	oidHMACWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 9}
+	// oidHMACWithSHA384 is the OID of HMAC-SHA384
+	oidHMACWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 10}
+	// oidHMACWithSHA512 is the OID of HMAC-SHA512
+	oidHMACWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 11}
+	// oidAES128CBC is the OID of AES-128-CBC
+	oidAES128CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 2}
+	// oidAES192CBC is the OID of AES-192-CBC
+	oidAES192CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 22}
+	// oidAES256CBC is the OID of AES-256-CBC
+	oidAES256CBC = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 1, 42}
+)
+
+// encryptedPrivateKeyInfo is the PKCS #8 encrypted private key
+type encryptedPrivateKeyInfo struct {
+	Algorithm     pkix.AlgorithmIdentifier
+	EncryptedData []byte
+}
+
+// pbes2Params is the parameters of PBES2
+type pbes2Params struct {
+	KeyDerivationFunc pkix.AlgorithmIdentifier
+	EncryptionScheme  pkix.AlgorithmIdentifier
+}
+
+// pbkdf2Params is the parameters of PBKDF2
+type pbkdf2Params struct {
+	Salt           []byte
+	IterationCount int
+	KeyLength      int                      `asn1:"optional"`
+	PRF            pkix.AlgorithmIdentifier `asn1:"optional"`
+}
+
+// pbkdf2Hashes is the hash of PBKDF2 PRFs
+var pbkdf2Hashes = map[string]func() hash.Hash{
+	oidHMACWithSHA1.String():   sha1.New,
+	oidHMACWithSHA256.String(): sha256.New,
+	oidHMACWithSHA384.String(): sha512.New384,
+	oidHMACWithSHA512.String(): sha512.New,
+}
+
+// aesKeySizes is the key size of AES-CBC ciphers
+var aesKeySizes = map[string]int{
+	oidAES128CBC.String(): 16,
+	oidAES192CBC.String(): 24,
+	oidAES256CBC.String(): 32,
+}
+
+// EncryptPrivateKey returns the PEM block of private key in PKCS #8 form encrypted by passphrase,
+// with PBES2 of PBKDF2-HMAC-SHA256 and AES-256-CBC
+func EncryptPrivateKey(key crypto.Signer, passphrase []byte) (*pem.Block, error) {
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		return nil, ErrUnsupportedKeyType
+	}
+
+	defer clear(der)
+
+	salt := make([]byte, 16)
+	iv := make([]byte, aes.BlockSize)
+	for _, v := range [][]byte{salt, iv} {
+		if _, err = rand.Read(v); err != nil {
+			return nil, err
+		}
+	}
+
+	kdf, err := asn1.Marshal(pbkdf2Params{
+		Salt:           salt,
+		IterationCount: pbkdf2Iterations,
+		PRF:            pkix.AlgorithmIdentifier{Algorithm: oidHMACWithSHA256, Parameters: asn1.NullRawValue},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	ivDER, err := asn1.Marshal(iv)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := asn1.Marshal(pbes2Params{
+		KeyDerivationFunc: pkix.AlgorithmIdentifier{Algorithm: oidPBKDF2, Parameters: asn1.RawValue{FullBytes: kdf}},
+		EncryptionScheme:  pkix.AlgorithmIdentifier{Algorithm: oidAES256CBC, Parameters: asn1.RawValue{FullBytes: ivDER}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, salt, pbkdf2Iterations, 32, sha256.New))
+	if err != nil {
+		return nil, err
+	}
+
+	// PKCS #7 padding, a full block is added if already aligned
+	padding := aes.BlockSize - len(der)%aes.BlockSize
+	data := append(der, bytes.Repeat([]byte{byte(padding)}, padding)...)
+	cipher.NewCBCEncrypter(block, iv).CryptBlocks(data, data)
+
+	encrypted, err := asn1.Marshal(encryptedPrivateKeyInfo{
+		Algorithm:     pkix.AlgorithmIdentifier{Algorithm: oidPBES2, Parameters: asn1.RawValue{FullBytes: params}},
+		EncryptedData: data,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: encrypted}, nil
+}
+
+// decryptPKCS8 returns the PKCS #8 DER of encrypted private key, only PBES2 of PBKDF2 and AES-CBC is supported
+func decryptPKCS8(der, passphrase []byte) ([]byte, error) {
+	var info encryptedPrivateKeyInfo
+	_, err := asn1.Unmarshal(der, &info)
+	if err != nil {
+		return nil, ErrInvalidCertificateKey
+	}
+
+	if !info.Algorithm.Algorithm.Equal(oidPBES2) {
+		return nil, ErrUnsupportedEncryption
+	}
+
+	var params pbes2Params
+	_, err = asn1.Unmarshal(info.Algorithm.Parameters.FullBytes, &params)
+	if err != nil || !params.KeyDerivationFunc.Algorithm.Equal(oidPBKDF2) {
+		return nil, ErrUnsupportedEncryption
+	}
+
+	var kdf pbkdf2Params
+	_, err = asn1.Unmarshal(params.KeyDerivationFunc.Parameters.FullBytes, &kdf)
+	if err != nil {
+		return nil, ErrUnsupportedEncryption
+	}
+
+	prf := oidHMACWithSHA1
+	if len(kdf.PRF.Algorithm) > 0 {
+		prf = kdf.PRF.Algorithm
+	}
+
+	newHash, ok := pbkdf2Hashes[prf.String()]
+	if !ok {
+		return nil, ErrUnsupportedEncryption
+	}
+
+	keySize, ok := aesKeySizes[params.EncryptionScheme.Algorithm.String()]
+	if !ok {
+		return nil, ErrUnsupportedEncryption
+	}
+
+	var iv []byte
+	_, err = asn1.Unmarshal(params.EncryptionScheme.Parameters.FullBytes, &iv)
+	if err != nil || len(iv) != aes.BlockSize {
+		return nil, ErrUnsupportedEncryption
+	}
+
+	data := info.EncryptedData
+	if len(data) == 0 || len(data)%aes.BlockSize != 0 {
+		return nil, ErrInvalidCertificateKey
+	}
+
+	block, err := aes.NewCipher(pbkdf2.Key(passphrase, kdf.Salt, kdf.IterationCount, keySize, newHash))
+	if err != nil {
+		return nil, err
+	}
+
+	data = append([]byte{}, data...)
+	cipher.NewCBCDecrypter(block, iv).CryptBlocks(data, data)
+
+	// the padding is garbage if the passphrase is incorrect
+	padding := int(data[len(data)-1])
+	if padding == 0 || padding > aes.BlockSize ||
+		!bytes.Equal(data[len(data)-padding:], bytes.Repeat([]byte{byte(padding)}, padding)) {
+		return nil, ErrIncorrectPassphrase
+	}
+
+	return data[:len(data)-padding], nil
+}
@@ -0,0 +1,116 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestEncryptPrivateKey(t *testing.T) {
+	passphrase := []byte("secret")
+	for _, v := range []KeyType{KeyTypeRSA, KeyTypeECDSA, KeyTypeEd25519} {
+		key, err := GenerateKey(v, 0)
+		assert.Nil(t, err)
+
+		block, err := EncryptPrivateKey(key, passphrase)
+		assert.Nil(t, err)
+		assert.Equal(t, block.Type, "ENCRYPTED PRIVATE KEY")
+
+		parsed, err := ParsePrivateKeyPEM(block, passphrase)
+		assert.Nil(t, err)
+		assert.Equal(t, parsed.(interface{ Public() crypto.PublicKey }).Public(), key.Public())
+
+		_, err = ParsePrivateKeyPEM(block, []byte("incorrect"))
+		assert.Equal(t, err, ErrIncorrectPassphrase)
+
+		_, err = ParsePrivateKeyPEM(block, nil)
+		assert.Equal(t, err, ErrPassphraseRequired)
+	}
+
+	key, err := GenerateKey(KeyTypeRSA, 2048)
+	assert.Nil(t, err)
+
+	block, err := MarshalPrivateKey(key)
+	assert.Nil(t, err)
+
+	parsed, err := ParsePrivateKeyPEM(block, passphrase)
+	assert.Nil(t, err)
+	assert.True(t, key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(parsed))
+
+	legacy, err := x509.EncryptPEMBlock(rand.Reader, block.Type, block.Bytes, passphrase, //nolint:staticcheck
+		x509.PEMCipherAES256)
+	assert.Nil(t, err)
+
+	parsed, err = ParsePrivateKeyPEM(legacy, passphrase)
+	assert.Nil(t, err)
+	assert.True(t, key.(interface{ Equal(crypto.PrivateKey) bool }).Equal(parsed))
+
+	_, err = ParsePrivateKeyPEM(legacy, nil)
+	assert.Equal(t, err, ErrPassphraseRequired)
+
+	_, err = ParsePrivateKeyPEM(&pem.Block{Type: "ENCRYPTED PRIVATE KEY", Bytes: []byte("0")}, passphrase)
+	assert.Equal(t, err, ErrInvalidCertificateKey)
+}
+
+func TestReadWriteCertificateWithPassphrase(t *testing.T) {
+	passphrase := []byte("secret")
+	ca, err := Issue(Certificate{
+		IsCA:      true,
+		KeyType:   KeyTypeECDSA,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(24 * time.Hour),
+	})
+	assert.Nil(t, err)
+
+	name := t.TempDir() + "/ca"
+	err = ca.WriteWithPassphrase(name, passphrase)
+	assert.Nil(t, err)
+	assert.Equal(t, ca.KeyFile, name+".key")
+
+	data, err := os.ReadFile(name + ".key")
+	assert.Nil(t, err)
+	assert.Contains(t, string(data), "BEGIN ENCRYPTED PRIVATE KEY")
+
+	_, _, err = ReadCertificate(name)
+	assert.Equal(t, err, ErrPassphraseRequired)
+
+	_, _, err = ReadCertificateWithPassphrase(name, []byte("incorrect"))
+	assert.Equal(t, err, ErrIncorrectPassphrase)
+
+	certificates, key, err := ReadCertificateWithPassphrase(name, passphrase)
+	assert.Nil(t, err)
+	assert.Equal(t, certificates[0].Raw, ca.DER)
+	assert.Equal(t, key.Public(), ca.Key.Public())
+
+	err = WriteCertificateWithPassphrase(name, ca.DER, ca.Key, nil)
+	assert.Nil(t, err)
+
+	_, key, err = ReadCertificateWithPassphrase(name, passphrase)
+	assert.Nil(t, err)
+	assert.Equal(t, key.Public(), ca.Key.Public())
+}
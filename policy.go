@@ -0,0 +1,206 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+var (
+	// ErrWeakKeySize is weak key size error
+	ErrWeakKeySize = errors.New("selfca: the key size is weak")
+	// ErrWeakSignature is weak signature algorithm error
+	ErrWeakSignature = errors.New("selfca: the signature algorithm is weak")
+	// ErrShortValidity is too short validity error
+	ErrShortValidity = errors.New("selfca: the validity is too short")
+	// ErrNotFIPSApproved is not FIPS approved parameter error
+	ErrNotFIPSApproved = errors.New("selfca: the parameter is not FIPS approved")
+	// ErrOutlivesCA is validity outside of the ca validity error
+	ErrOutlivesCA = errors.New("selfca: the validity is outside of the ca validity")
+)
+
+// MinKeySize is the min RSA key size not considered weak
+const MinKeySize = 2048
+
+// MinValidity is the min validity not considered weak
+const MinValidity = time.Hour
+
+// weakSignatures is the signature algorithms considered weak
+var weakSignatures = map[x509.SignatureAlgorithm]bool{
+	x509.MD2WithRSA:    true,
+	x509.MD5WithRSA:    true,
+	x509.SHA1WithRSA:   true,
+	x509.DSAWithSHA1:   true,
+	x509.ECDSAWithSHA1: true,
+}
+
+// fipsKeySizes is the FIPS 186-5 approved RSA key sizes, ECDSA and EdDSA curves
+var fipsKeySizes = map[KeyType]map[int]bool{
+	KeyTypeRSA: {
+		2048: true,
+		3072: true,
+		4096: true,
+	},
+	KeyTypeECDSA: {
+		256: true,
+		384: true,
+		521: true,
+	},
+	KeyTypeEd25519: {
+		256: true,
+	},
+}
+
+// fipsSignatures is the FIPS approved signature algorithms
+var fipsSignatures = map[x509.SignatureAlgorithm]bool{
+	x509.SHA256WithRSA:    true,
+	x509.SHA384WithRSA:    true,
+	x509.SHA512WithRSA:    true,
+	x509.SHA256WithRSAPSS: true,
+	x509.SHA384WithRSAPSS: true,
+	x509.SHA512WithRSAPSS: true,
+	x509.ECDSAWithSHA256:  true,
+	x509.ECDSAWithSHA384:  true,
+	x509.ECDSAWithSHA512:  true,
+	x509.PureEd25519:      true,
+}
+
+// configKey returns the key type and size of the certificate config, of Key if set,
+// or the key to generate with the defaults applied
+func configKey(c Certificate) (KeyType, int) {
+	if c.Key != nil {
+		return KeyTypeOf(c.Key.Public())
+	}
+
+	keyType, keySize := c.KeyType, c.KeySize
+	if keyType == "" {
+		keyType = KeyTypeRSA
+	}
+
+	if keySize <= 0 {
+		keySize = DefaultKeySize(keyType)
+	}
+
+	return keyType, keySize
+}
+
+// CheckWeak checks the certificate config for weak parameters, including the existing Key,
+// returns all the weak parameters found joined as one error
+func CheckWeak(c Certificate) error {
+	var errs []error
+
+	if keyType, keySize := configKey(c); keyType == KeyTypeRSA && keySize < MinKeySize {
+		errs = append(errs, ErrWeakKeySize)
+	}
+
+	if c.CAKey != nil {
+		if keyType, keySize := KeyTypeOf(c.CAKey.Public()); keyType == KeyTypeRSA && keySize < MinKeySize {
+			errs = append(errs, fmt.Errorf("%w: CA RSA key size %d", ErrWeakKeySize, keySize))
+		}
+	}
+
+	if c.CACertificate != nil && weakSignatures[c.CACertificate.SignatureAlgorithm] {
+		errs = append(errs, ErrWeakSignature)
+	}
+
+	if c.NotAfter.Sub(c.NotBefore) < MinValidity {
+		errs = append(errs, ErrShortValidity)
+	}
+
+	return errors.Join(errs...)
+}
+
+// CheckFIPS checks the certificate config only uses FIPS approved key sizes,
+// including the existing Key, and signature algorithms, returns all the violations found
+func CheckFIPS(c Certificate) error {
+	var errs []error
+
+	keyType, keySize := configKey(c)
+	if !fipsKeySizes[keyType][keySize] {
+		errs = append(errs, fmt.Errorf("%w: %s key size %d", ErrNotFIPSApproved, strings.ToUpper(string(keyType)), keySize))
+	}
+
+	if c.CAKey != nil {
+		keyType, keySize = KeyTypeOf(c.CAKey.Public())
+		if !fipsKeySizes[keyType][keySize] {
+			errs = append(errs, fmt.Errorf("%w: CA %s key size %d", ErrNotFIPSApproved,
+				strings.ToUpper(string(keyType)), keySize))
+		}
+	}
+
+	if c.CACertificate != nil && !fipsSignatures[c.CACertificate.SignatureAlgorithm] {
+		errs = append(errs, fmt.Errorf("%w: CA signature algorithm %s",
+			ErrNotFIPSApproved, c.CACertificate.SignatureAlgorithm))
+	}
+
+	return errors.Join(errs...)
+}
+
+// issuerOf returns the certificate of the issuing ca, Parent if set, or CACertificate
+// unless the certificate is a ca, which is self-signed without Parent, nil if self-signed
+func issuerOf(c Certificate) *x509.Certificate {
+	if c.Parent != nil {
+		return c.Parent.Certificate
+	}
+
+	if c.IsCA {
+		return nil
+	}
+
+	return c.CACertificate
+}
+
+// CheckCAValidity checks the certificate validity is within the validity of its ca,
+// including the intermediate ca, the self-signed ca is not checked
+func CheckCAValidity(c Certificate) error {
+	issuer := issuerOf(c)
+	if issuer == nil {
+		return nil
+	}
+
+	if c.NotBefore.Before(issuer.NotBefore) || c.NotAfter.After(issuer.NotAfter) {
+		return ErrOutlivesCA
+	}
+
+	return nil
+}
+
+// ClampValidity returns the certificate config with validity clamped to the validity of its ca,
+// including the intermediate ca, the self-signed ca is not clamped
+func ClampValidity(c Certificate) Certificate {
+	issuer := issuerOf(c)
+	if issuer == nil {
+		return c
+	}
+
+	if c.NotBefore.Before(issuer.NotBefore) {
+		c.NotBefore = issuer.NotBefore
+	}
+
+	if c.NotAfter.After(issuer.NotAfter) {
+		c.NotAfter = issuer.NotAfter
+	}
+
+	return c
+}
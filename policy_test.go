@@ -0,0 +1,195 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestCheckWeak(t *testing.T) {
+	config := Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	}
+
+	err := CheckWeak(config)
+	assert.Nil(t, err)
+
+	config.KeySize = 1024
+	err = CheckWeak(config)
+	assert.True(t, errors.Is(err, ErrWeakKeySize))
+
+	config.KeySize = 2048
+	config.NotAfter = config.NotBefore.Add(time.Minute)
+	err = CheckWeak(config)
+	assert.True(t, errors.Is(err, ErrShortValidity))
+	assert.False(t, errors.Is(err, ErrWeakKeySize))
+
+	config.KeySize = 1024
+	err = CheckWeak(config)
+	assert.True(t, errors.Is(err, ErrWeakKeySize))
+	assert.True(t, errors.Is(err, ErrShortValidity))
+
+	config.KeySize = 1024
+	config.NotAfter = config.NotBefore.Add(time.Duration(365*24) * time.Hour)
+	certificate, key, err := GenerateCertificate(config)
+	assert.Nil(t, err)
+
+	caCertificate, err := x509.ParseCertificate(certificate)
+	assert.Nil(t, err)
+
+	config = Certificate{
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Duration(365*24) * time.Hour),
+		Hosts:         []string{"likexian.com"},
+		CAKey:         key,
+		CACertificate: caCertificate,
+	}
+
+	err = CheckWeak(config)
+	assert.True(t, errors.Is(err, ErrWeakKeySize))
+	assert.Contains(t, err.Error(), "CA RSA key size 1024")
+
+	config.KeySize = 1024
+	err = CheckWeak(config)
+	assert.Equal(t, strings.Count(err.Error(), ErrWeakKeySize.Error()), 2)
+	assert.Contains(t, err.Error(), "CA RSA key size 1024")
+
+	config.KeySize = 0
+	caCertificate.SignatureAlgorithm = x509.SHA1WithRSA
+	err = CheckWeak(config)
+	assert.True(t, errors.Is(err, ErrWeakSignature))
+
+	weakKey, err := rsa.GenerateKey(rand.Reader, 1024)
+	assert.Nil(t, err)
+
+	err = CheckWeak(Certificate{
+		KeySize:   2048,
+		Key:       weakKey,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	})
+	assert.True(t, errors.Is(err, ErrWeakKeySize))
+}
+
+func TestCheckFIPS(t *testing.T) {
+	config := Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Duration(365*24) * time.Hour),
+	}
+
+	err := CheckFIPS(config)
+	assert.Nil(t, err)
+
+	config.KeySize = 3072
+	err = CheckFIPS(config)
+	assert.Nil(t, err)
+
+	config.KeySize = 2560
+	err = CheckFIPS(config)
+	assert.True(t, errors.Is(err, ErrNotFIPSApproved))
+
+	config.KeySize = 1024
+	certificate, key, err := GenerateCertificate(config)
+	assert.Nil(t, err)
+
+	caCertificate, err := x509.ParseCertificate(certificate)
+	assert.Nil(t, err)
+
+	config = Certificate{
+		NotBefore:     time.Now(),
+		NotAfter:      time.Now().Add(time.Duration(365*24) * time.Hour),
+		Hosts:         []string{"likexian.com"},
+		CAKey:         key,
+		CACertificate: caCertificate,
+	}
+
+	err = CheckFIPS(config)
+	assert.True(t, errors.Is(err, ErrNotFIPSApproved))
+	assert.Contains(t, err.Error(), "CA RSA key size 1024")
+
+	caCertificate.SignatureAlgorithm = x509.SHA1WithRSA
+	err = CheckFIPS(config)
+	assert.Contains(t, err.Error(), "CA signature algorithm SHA1-RSA")
+
+	ecKey, err := ecdsa.GenerateKey(elliptic.P224(), rand.Reader)
+	assert.Nil(t, err)
+
+	err = CheckFIPS(Certificate{KeyType: KeyTypeECDSA, Key: ecKey})
+	assert.True(t, errors.Is(err, ErrNotFIPSApproved))
+	assert.Contains(t, err.Error(), "key size 224")
+}
+
+func TestCheckCAValidity(t *testing.T) {
+	now := time.Now()
+	caCertificate := &x509.Certificate{
+		NotBefore: now.Add(-time.Hour),
+		NotAfter:  now.Add(time.Duration(30*24) * time.Hour),
+	}
+
+	config := Certificate{
+		NotBefore:     now,
+		NotAfter:      now.Add(time.Duration(7*24) * time.Hour),
+		CACertificate: caCertificate,
+	}
+
+	err := CheckCAValidity(config)
+	assert.Nil(t, err)
+	assert.Equal(t, ClampValidity(config), config)
+
+	config.NotAfter = now.Add(time.Duration(365*24) * time.Hour)
+	err = CheckCAValidity(config)
+	assert.Equal(t, err, ErrOutlivesCA)
+
+	clamped := ClampValidity(config)
+	assert.Equal(t, clamped.NotBefore, now)
+	assert.Equal(t, clamped.NotAfter, caCertificate.NotAfter)
+	assert.Nil(t, CheckCAValidity(clamped))
+
+	config.NotBefore = now.Add(-24 * time.Hour)
+	clamped = ClampValidity(config)
+	assert.Equal(t, clamped.NotBefore, caCertificate.NotBefore)
+
+	config.IsCA = true
+	assert.Nil(t, CheckCAValidity(config))
+	assert.Equal(t, ClampValidity(config), config)
+
+	config.Parent = &CA{Certificate: caCertificate}
+	assert.Equal(t, CheckCAValidity(config), ErrOutlivesCA)
+	clamped = ClampValidity(config)
+	assert.Equal(t, clamped.NotBefore, caCertificate.NotBefore)
+	assert.Equal(t, clamped.NotAfter, caCertificate.NotAfter)
+
+	config.IsCA, config.CACertificate = false, nil
+	assert.Equal(t, CheckCAValidity(config), ErrOutlivesCA)
+	assert.Equal(t, ClampValidity(config).NotAfter, caCertificate.NotAfter)
+}
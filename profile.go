@@ -0,0 +1,231 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+)
+
+// Profile is the usage profile of certificate
+type Profile string
+
+const (
+	// ProfileServer is the profile for TLS server and client, it is the default
+	ProfileServer Profile = "server"
+	// ProfileClient is the profile for TLS client only, for mTLS client identities
+	ProfileClient Profile = "client"
+	// ProfileCodeSigning is the profile for signing code, which needs no hosts, the ca of it
+	// has the code signing extended key usage only, as the usages of ca are nested by verifiers
+	ProfileCodeSigning Profile = "codesigning"
+	// ProfileEmail is the profile for S/MIME email protection, the ca of it has the email protection
+	// extended key usage only, as the usages of ca are nested by Outlook and Thunderbird
+	ProfileEmail Profile = "email"
+	// ProfileSmartCard is the profile for Windows smart card logon
+	ProfileSmartCard Profile = "smartcard"
+	// ProfileIPsec is the profile for IPsec IKE gateways and road warrior clients
+	ProfileIPsec Profile = "ipsec"
+	// ProfileDevice is the profile for device attestation like Matter DAC and IEEE 802.1AR DevID,
+	// with digital signature usage only and no extended key usage, the ca of it has no extended key usage too
+	ProfileDevice Profile = "device"
+)
+
+// NoExpiration is the notAfter of certificate with no well-defined expiration by RFC 5280,
+// used by the device attestation certificates living as long as the devices
+var NoExpiration = time.Date(9999, 12, 31, 23, 59, 59, 0, time.UTC)
+
+var (
+	// oidExtKeyUsageIPsecIKE is the OID of id-kp-ipsecIKE extended key usage
+	oidExtKeyUsageIPsecIKE = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 3, 17}
+	// oidExtKeyUsageIKEIntermediate is the OID of IKE intermediate extended key usage required by Windows
+	oidExtKeyUsageIKEIntermediate = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 8, 2, 2}
+	// oidMatterVendorID is the OID of Matter vendor ID subject attribute
+	oidMatterVendorID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37244, 2, 1}
+	// oidMatterProductID is the OID of Matter product ID subject attribute
+	oidMatterProductID = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 37244, 2, 2}
+)
+
+var (
+	// ErrUnsupportedProfile is unsupported profile error
+	ErrUnsupportedProfile = errors.New("selfca: the certificate profile is unsupported")
+	// ErrUnsupportedUsage is unsupported key usage or extended key usage error
+	ErrUnsupportedUsage = errors.New("selfca: the key usage is unsupported")
+)
+
+// keyUsages is the key usages by lower case name
+var keyUsages = map[string]x509.KeyUsage{
+	"digitalsignature":  x509.KeyUsageDigitalSignature,
+	"contentcommitment": x509.KeyUsageContentCommitment,
+	"nonrepudiation":    x509.KeyUsageContentCommitment,
+	"keyencipherment":   x509.KeyUsageKeyEncipherment,
+	"dataencipherment":  x509.KeyUsageDataEncipherment,
+	"keyagreement":      x509.KeyUsageKeyAgreement,
+	"keycertsign":       x509.KeyUsageCertSign,
+	"crlsign":           x509.KeyUsageCRLSign,
+	"encipheronly":      x509.KeyUsageEncipherOnly,
+	"decipheronly":      x509.KeyUsageDecipherOnly,
+}
+
+// extKeyUsages is the extended key usages by lower case name
+var extKeyUsages = map[string]x509.ExtKeyUsage{
+	"any":             x509.ExtKeyUsageAny,
+	"serverauth":      x509.ExtKeyUsageServerAuth,
+	"clientauth":      x509.ExtKeyUsageClientAuth,
+	"codesigning":     x509.ExtKeyUsageCodeSigning,
+	"emailprotection": x509.ExtKeyUsageEmailProtection,
+	"ipsecendsystem":  x509.ExtKeyUsageIPSECEndSystem,
+	"ipsectunnel":     x509.ExtKeyUsageIPSECTunnel,
+	"ipsecuser":       x509.ExtKeyUsageIPSECUser,
+	"timestamping":    x509.ExtKeyUsageTimeStamping,
+	"ocspsigning":     x509.ExtKeyUsageOCSPSigning,
+}
+
+// profileUsage is the key usages of profile
+type profileUsage struct {
+	keyUsage           x509.KeyUsage
+	extKeyUsage        []x509.ExtKeyUsage
+	unknownExtKeyUsage []asn1.ObjectIdentifier
+}
+
+// profiles is the key usages of supported profiles
+var profiles = map[Profile]profileUsage{
+	ProfileServer: {
+		keyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	},
+	ProfileClient: {
+		keyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	},
+	ProfileCodeSigning: {
+		keyUsage:    x509.KeyUsageDigitalSignature,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	},
+	ProfileEmail: {
+		keyUsage:    x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment | x509.KeyUsageContentCommitment,
+		extKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	},
+	ProfileSmartCard: {
+		keyUsage:           x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		extKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		unknownExtKeyUsage: []asn1.ObjectIdentifier{oidExtKeyUsageSmartCardLogon},
+	},
+	ProfileIPsec: {
+		keyUsage:           x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment,
+		extKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		unknownExtKeyUsage: []asn1.ObjectIdentifier{oidExtKeyUsageIPsecIKE, oidExtKeyUsageIKEIntermediate},
+	},
+	ProfileDevice: {
+		keyUsage: x509.KeyUsageDigitalSignature,
+	},
+}
+
+// usageOf returns the key usages of profile, the default is ProfileServer
+func usageOf(profile Profile) (profileUsage, error) {
+	if profile == "" {
+		profile = ProfileServer
+	}
+
+	usage, ok := profiles[profile]
+	if !ok {
+		return profileUsage{}, ErrUnsupportedProfile
+	}
+
+	return usage, nil
+}
+
+// CertificateProfile returns the profile matching the key usages of certificate,
+// ProfileServer if none matches
+func CertificateProfile(c *x509.Certificate) Profile {
+	for k, v := range profiles {
+		if c.KeyUsage == v.keyUsage && slices.Equal(c.ExtKeyUsage, v.extKeyUsage) &&
+			slices.EqualFunc(c.UnknownExtKeyUsage, v.unknownExtKeyUsage, asn1.ObjectIdentifier.Equal) {
+			return k
+		}
+	}
+
+	return ProfileServer
+}
+
+// MatterAttributes returns the Matter vendor ID and product ID subject attributes for ExtraSubject,
+// encoded as UTF8String of 4 upper case hex digits like FFF1, the product ID is omitted if zero,
+// the DAC must have both and the PAI must have the vendor ID
+func MatterAttributes(vendorID, productID uint16) []pkix.AttributeTypeAndValue {
+	attrs := []pkix.AttributeTypeAndValue{matterAttribute(oidMatterVendorID, vendorID)}
+	if productID != 0 {
+		attrs = append(attrs, matterAttribute(oidMatterProductID, productID))
+	}
+
+	return attrs
+}
+
+// matterAttribute returns the Matter subject attribute of id
+func matterAttribute(oid asn1.ObjectIdentifier, id uint16) pkix.AttributeTypeAndValue {
+	return pkix.AttributeTypeAndValue{
+		Type:  oid,
+		Value: asn1.RawValue{Tag: asn1.TagUTF8String, Bytes: []byte(fmt.Sprintf("%04X", id))},
+	}
+}
+
+// AddUsage adds the key usage like digitalSignature or keyEncipherment, or the extended key usage
+// like serverAuth or codeSigning, or the dotted OID of extended key usage, to the usages of config
+// overriding the usages of profile, the names are case insensitive
+func (c *Certificate) AddUsage(name string) error {
+	if v, ok := keyUsages[strings.ToLower(name)]; ok {
+		c.KeyUsage |= v
+		return nil
+	}
+
+	if v, ok := extKeyUsages[strings.ToLower(name)]; ok {
+		c.ExtKeyUsage = append(c.ExtKeyUsage, v)
+		return nil
+	}
+
+	oid, err := parseAttributeType(name)
+	if err != nil || !strings.Contains(name, ".") {
+		return fmt.Errorf("%w: %s", ErrUnsupportedUsage, name)
+	}
+
+	c.UnknownExtKeyUsage = append(c.UnknownExtKeyUsage, oid)
+
+	return nil
+}
+
+// setUsages replaces the usages of template by the usages of config if set,
+// the ca keeps the cert signing usage
+func setUsages(template *x509.Certificate, c Certificate) {
+	if c.KeyUsage != 0 {
+		template.KeyUsage = c.KeyUsage
+		if c.IsCA {
+			template.KeyUsage |= x509.KeyUsageCertSign
+		}
+	}
+
+	if len(c.ExtKeyUsage) > 0 || len(c.UnknownExtKeyUsage) > 0 {
+		template.ExtKeyUsage = c.ExtKeyUsage
+		template.UnknownExtKeyUsage = c.UnknownExtKeyUsage
+	}
+}
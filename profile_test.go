@@ -0,0 +1,361 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/likexian/gokit/assert"
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+func TestProfile(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	server, err := ca.Issue(Certificate{
+		Hosts: []string{"likexian.com"},
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, server.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{
+		x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+	})
+
+	email, err := ca.Issue(Certificate{
+		Hosts:   []string{"i@likexian.com"},
+		Profile: ProfileEmail,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, email.Certificate.Subject.CommonName, "i@likexian.com")
+	assert.Equal(t, email.Certificate.EmailAddresses, []string{"i@likexian.com"})
+	assert.Len(t, email.Certificate.DNSNames, 0)
+	assert.Equal(t, email.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection})
+	assert.NotEqual(t, email.Certificate.KeyUsage&x509.KeyUsageContentCommitment, 0)
+
+	emailCA, err := Issue(Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+		Profile:   ProfileEmail,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, emailCA.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection})
+	email, err = emailCA.CA().Issue(Certificate{Hosts: []string{"i@likexian.com"}, Profile: ProfileEmail})
+	assert.Nil(t, err)
+	_, err = email.Certificate.Verify(x509.VerifyOptions{
+		Roots:     emailCA.CA().CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+	})
+	assert.Nil(t, err)
+
+	_, err = ca.Issue(Certificate{
+		Hosts:   []string{"likexian.com"},
+		Profile: "unknown",
+	})
+	assert.Equal(t, err, ErrUnsupportedProfile)
+}
+
+func TestIssuancePKCS12(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	email, err := ca.Issue(Certificate{
+		Hosts:   []string{"i@likexian.com"},
+		Profile: ProfileEmail,
+	})
+	assert.Nil(t, err)
+
+	err = email.WritePKCS12("not-exists/i@likexian.com", "secret")
+	assert.NotNil(t, err)
+
+	dir := t.TempDir()
+	err = email.WritePKCS12(dir+"/i@likexian.com", "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, email.PKCS12File, dir+"/i@likexian.com.p12")
+
+	data, err := os.ReadFile(email.PKCS12File)
+	assert.Nil(t, err)
+
+	key, certificate, chain, err := pkcs12.DecodeChain(data, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, key.(crypto.Signer).Public(), email.Key.Public())
+	assert.Equal(t, certificate.Raw, email.DER)
+	assert.Len(t, chain, 1)
+	assert.Equal(t, chain[0].Raw, ca.Certificate.Raw)
+
+	_, _, _, err = pkcs12.DecodeChain(data, "wrong")
+	assert.NotNil(t, err)
+
+	err = email.WriteLegacyPKCS12(dir+"/legacy", "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, email.PKCS12File, dir+"/legacy.p12")
+
+	legacy, err := os.ReadFile(email.PKCS12File)
+	assert.Nil(t, err)
+	key, certificate, chain, err = pkcs12.DecodeChain(legacy, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, key.(crypto.Signer).Public(), email.Key.Public())
+	assert.Equal(t, certificate.Raw, email.DER)
+	assert.Len(t, chain, 1)
+}
+
+func TestWritePKCS12(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	dir := t.TempDir()
+	err = WritePKCS12(dir+"/likexian.com", nil, issuance.Key, "secret")
+	assert.Equal(t, err, ErrInvalidCertificate)
+
+	certificates := []*x509.Certificate{issuance.Certificate, ca.Certificate}
+	err = WritePKCS12(dir+"/likexian.com", certificates, issuance.Key, "secret")
+	assert.Nil(t, err)
+
+	data, err := os.ReadFile(dir + "/likexian.com.p12")
+	assert.Nil(t, err)
+
+	key, certificate, chain, err := pkcs12.DecodeChain(data, "secret")
+	assert.Nil(t, err)
+	assert.Equal(t, key.(crypto.Signer).Public(), issuance.Key.Public())
+	assert.Equal(t, certificate.Raw, issuance.DER)
+	assert.Len(t, chain, 1)
+}
+
+func TestProfileSmartCard(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{
+		Hosts:   []string{"likexian.com", "127.0.0.1", "i@likexian.com"},
+		UPNs:    []string{"i@corp.likexian.com", "likexian@corp.likexian.com"},
+		Profile: ProfileSmartCard,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.Certificate.Subject.CommonName, "likexian.com")
+	assert.Equal(t, leaf.Certificate.DNSNames, []string{"likexian.com"})
+	assert.Equal(t, leaf.Certificate.EmailAddresses, []string{"i@likexian.com"})
+	assert.Equal(t, leaf.Certificate.IPAddresses[0].String(), "127.0.0.1")
+	assert.Equal(t, CertificateUPNs(leaf.Certificate), []string{"i@corp.likexian.com", "likexian@corp.likexian.com"})
+	assert.Equal(t, leaf.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+	assert.True(t, leaf.Certificate.UnknownExtKeyUsage[0].Equal(oidExtKeyUsageSmartCardLogon))
+
+	upnOnly, err := ca.Issue(Certificate{
+		UPNs:    []string{"i@corp.likexian.com"},
+		Profile: ProfileSmartCard,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, upnOnly.Certificate.Subject.CommonName, "i@corp.likexian.com")
+	assert.Equal(t, CertificateUPNs(upnOnly.Certificate), []string{"i@corp.likexian.com"})
+	assert.Len(t, CertificateUPNs(ca.Certificate), 0)
+}
+
+func TestProfileIPsec(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{
+		Hosts:   []string{"vpn.likexian.com", "192.0.2.1", "i@likexian.com"},
+		Profile: ProfileIPsec,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.Certificate.Subject.CommonName, "vpn.likexian.com")
+	assert.Equal(t, leaf.Certificate.DNSNames, []string{"vpn.likexian.com"})
+	assert.Equal(t, leaf.Certificate.IPAddresses[0].String(), "192.0.2.1")
+	assert.Equal(t, leaf.Certificate.EmailAddresses, []string{"i@likexian.com"})
+	assert.Equal(t, leaf.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{
+		x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth,
+	})
+	assert.Len(t, leaf.Certificate.UnknownExtKeyUsage, 2)
+	assert.True(t, leaf.Certificate.UnknownExtKeyUsage[0].Equal(oidExtKeyUsageIPsecIKE))
+	assert.True(t, leaf.Certificate.UnknownExtKeyUsage[1].Equal(oidExtKeyUsageIKEIntermediate))
+}
+
+func TestCertificateProfile(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	for _, v := range []Profile{ProfileServer, ProfileEmail, ProfileSmartCard, ProfileIPsec} {
+		leaf, err := ca.Issue(Certificate{
+			Hosts:   []string{"i@likexian.com"},
+			Profile: v,
+		})
+		assert.Nil(t, err)
+		assert.Equal(t, CertificateProfile(leaf.Certificate), v)
+	}
+
+	assert.Equal(t, CertificateProfile(ca.Certificate), ProfileServer)
+}
+
+func TestAddUsage(t *testing.T) {
+	c := Certificate{Hosts: []string{"likexian.com"}}
+	for _, v := range []string{"digitalSignature", "KeyEncipherment", "serverAuth", "codeSigning", "1.2.3.4"} {
+		assert.Nil(t, c.AddUsage(v))
+	}
+	assert.Equal(t, c.KeyUsage, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment)
+	assert.Equal(t, c.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageCodeSigning})
+	assert.Len(t, c.UnknownExtKeyUsage, 1)
+
+	for _, v := range []string{"invalid", "1", "1.x"} {
+		assert.True(t, errors.Is(c.AddUsage(v), ErrUnsupportedUsage))
+	}
+
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(c)
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.Certificate.KeyUsage, x509.KeyUsageDigitalSignature|x509.KeyUsageKeyEncipherment)
+	assert.Equal(t, issuance.Certificate.ExtKeyUsage, c.ExtKeyUsage)
+	assert.True(t, issuance.Certificate.UnknownExtKeyUsage[0].Equal(c.UnknownExtKeyUsage[0]))
+
+	c = Certificate{Hosts: []string{"likexian.com"}, Profile: ProfileEmail,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth}}
+	issuance, err = ca.Issue(c)
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth})
+	assert.Equal(t, issuance.Certificate.KeyUsage, profiles[ProfileEmail].keyUsage)
+
+	c = Certificate{IsCA: true, KeyUsage: x509.KeyUsageDigitalSignature, Parent: ca}
+	issuance, err = ca.Issue(c)
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.Certificate.KeyUsage, x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign)
+}
+
+func TestProfileClient(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	leaf, err := ca.Issue(Certificate{
+		Hosts:   []string{"alice@corp.likexian.com"},
+		Profile: ProfileClient,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.Certificate.Subject.CommonName, "alice@corp.likexian.com")
+	assert.Equal(t, leaf.Certificate.EmailAddresses, []string{"alice@corp.likexian.com"})
+	assert.Len(t, leaf.Certificate.DNSNames, 0)
+	assert.Equal(t, leaf.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+	assert.Equal(t, CertificateProfile(leaf.Certificate), ProfileClient)
+}
+
+func TestProfileDevice(t *testing.T) {
+	paa, err := Issue(Certificate{
+		IsCA:       true,
+		CommonName: "Matter Test PAA",
+		KeyType:    KeyTypeECDSA,
+		KeySize:    256,
+		NotBefore:  time.Now(),
+		NotAfter:   NoExpiration,
+		Profile:    ProfileDevice,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, paa.Certificate.ExtKeyUsage, 0)
+	assert.True(t, paa.Certificate.NotAfter.Equal(NoExpiration))
+
+	pai, err := paa.CA().Issue(Certificate{
+		IsCA:           true,
+		CommonName:     "Matter Test PAI",
+		ExtraSubject:   MatterAttributes(0xFFF1, 0),
+		KeyType:        KeyTypeECDSA,
+		KeySize:        256,
+		MaxPathLenZero: true,
+		NotAfter:       NoExpiration,
+		Profile:        ProfileDevice,
+	})
+	assert.Nil(t, err)
+	assert.Len(t, pai.Certificate.ExtKeyUsage, 0)
+	assert.Equal(t, pai.Certificate.KeyUsage, x509.KeyUsageDigitalSignature|x509.KeyUsageCertSign|x509.KeyUsageCRLSign)
+
+	dac, err := pai.CA().Issue(Certificate{
+		CommonName:   "Matter Test DAC",
+		ExtraSubject: MatterAttributes(0xFFF1, 0x8000),
+		KeyType:      KeyTypeECDSA,
+		KeySize:      256,
+		NotAfter:     NoExpiration,
+		Profile:      ProfileDevice,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, dac.Certificate.KeyUsage, x509.KeyUsageDigitalSignature)
+	assert.Len(t, dac.Certificate.ExtKeyUsage, 0)
+	assert.Equal(t, CertificateProfile(dac.Certificate), ProfileDevice)
+
+	var subject pkix.RDNSequence
+	_, err = asn1.Unmarshal(dac.Certificate.RawSubject, &subject)
+	assert.Nil(t, err)
+	var ids []string
+	for _, v := range subject {
+		if v[0].Type.Equal(oidMatterVendorID) || v[0].Type.Equal(oidMatterProductID) {
+			ids = append(ids, v[0].Value.(string))
+		}
+	}
+	assert.Equal(t, ids, []string{"FFF1", "8000"})
+	assert.True(t, bytes.Contains(dac.Certificate.RawSubject, []byte{asn1.TagUTF8String, 4, 'F', 'F', 'F', '1'}))
+}
+
+func TestProfileCodeSigning(t *testing.T) {
+	root, err := Issue(Certificate{
+		IsCA:      true,
+		NotBefore: time.Now(),
+		NotAfter:  time.Now().Add(time.Hour),
+		Profile:   ProfileCodeSigning,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, root.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning})
+	ca := root.CA()
+
+	leaf, err := ca.Issue(Certificate{
+		CommonName: "Acme Code Signing",
+		Profile:    ProfileCodeSigning,
+	})
+	assert.Nil(t, err)
+	assert.Equal(t, leaf.Certificate.Subject.CommonName, "Acme Code Signing")
+	assert.Equal(t, leaf.Certificate.KeyUsage, x509.KeyUsageDigitalSignature)
+	assert.Equal(t, leaf.Certificate.ExtKeyUsage, []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning})
+	assert.Equal(t, CertificateProfile(leaf.Certificate), ProfileCodeSigning)
+
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{
+		Roots:     ca.CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	assert.Nil(t, err)
+
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{Roots: ca.CertPool()})
+	assert.NotNil(t, err)
+
+	server, err := NewEphemeralCA()
+	assert.Nil(t, err)
+	leaf, err = server.Issue(Certificate{CommonName: "Acme Code Signing", Profile: ProfileCodeSigning})
+	assert.Nil(t, err)
+	_, err = leaf.Certificate.Verify(x509.VerifyOptions{
+		Roots:     server.CertPool(),
+		KeyUsages: []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	})
+	assert.NotNil(t, err)
+}
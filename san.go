@@ -0,0 +1,196 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+)
+
+var (
+	// oidExtensionSubjectAltName is the OID of subject alternative name extension
+	oidExtensionSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	// oidUPN is the OID of Microsoft user principal name otherName
+	oidUPN = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+	// oidExtKeyUsageSmartCardLogon is the OID of Microsoft smart card logon extended key usage
+	oidExtKeyUsageSmartCardLogon = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 2}
+)
+
+// ErrInvalidURI is invalid URI in hosts error
+var ErrInvalidURI = errors.New("selfca: the URI is invalid")
+
+// GeneralName tags of subject alternative name
+const (
+	sanOtherName = 0
+	sanEmail     = 1
+	sanDNS       = 2
+	sanURI       = 6
+	sanIP        = 7
+)
+
+// otherName is the otherName of subject alternative name
+type otherName struct {
+	TypeID asn1.ObjectIdentifier
+	Value  asn1.RawValue
+}
+
+// addHosts adds the hosts to subject alternative names of template,
+// classified as IP addresses, URIs like spiffe://example.org/web, email addresses or DNS names
+func addHosts(template *x509.Certificate, hosts []string) error {
+	for _, v := range hosts {
+		if ip := net.ParseIP(v); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if IsURI(v) {
+			u, err := url.Parse(v)
+			if err != nil || u.Scheme == "" || u.Host == "" && u.Opaque == "" && u.Path == "" {
+				return fmt.Errorf("%w: %s", ErrInvalidURI, v)
+			}
+			template.URIs = append(template.URIs, u)
+		} else if strings.Contains(v, "@") {
+			template.EmailAddresses = append(template.EmailAddresses, v)
+		} else {
+			template.DNSNames = append(template.DNSNames, v)
+		}
+	}
+
+	return nil
+}
+
+// IsURI returns whether the host is a URI like spiffe://example.org/web, instead of domain, IP or email
+func IsURI(host string) bool {
+	return strings.Contains(host, "://")
+}
+
+// commonNameOf returns the default common name, the first host which is not URI, or the first UPN
+func commonNameOf(hosts, upns []string) string {
+	for _, v := range hosts {
+		if !IsURI(v) {
+			return v
+		}
+	}
+
+	if len(upns) > 0 {
+		return upns[0]
+	}
+
+	return ""
+}
+
+// marshalSANs returns the subject alternative name extension of template with the
+// UPN otherNames, which are not supported by crypto/x509
+func marshalSANs(template *x509.Certificate, upns []string) (pkix.Extension, error) {
+	var names []asn1.RawValue
+	for _, v := range template.DNSNames {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: sanDNS, Bytes: []byte(v)})
+	}
+
+	for _, v := range template.EmailAddresses {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: sanEmail, Bytes: []byte(v)})
+	}
+
+	for _, v := range template.IPAddresses {
+		ip := v.To4()
+		if ip == nil {
+			ip = v
+		}
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: sanIP, Bytes: ip})
+	}
+
+	for _, v := range template.URIs {
+		names = append(names, asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: sanURI, Bytes: []byte(v.String())})
+	}
+
+	for _, v := range upns {
+		value, err := asn1.MarshalWithParams(v, "utf8")
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+
+		name, err := asn1.Marshal(otherName{
+			TypeID: oidUPN,
+			Value:  asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: value},
+		})
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+
+		var sequence asn1.RawValue
+		_, err = asn1.Unmarshal(name, &sequence)
+		if err != nil {
+			return pkix.Extension{}, err
+		}
+
+		names = append(names, asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        sanOtherName,
+			IsCompound: true,
+			Bytes:      sequence.Bytes,
+		})
+	}
+
+	value, err := asn1.Marshal(names)
+	if err != nil {
+		return pkix.Extension{}, err
+	}
+
+	return pkix.Extension{Id: oidExtensionSubjectAltName, Value: value}, nil
+}
+
+// CertificateUPNs returns the UPN otherNames in subject alternative name of certificate
+func CertificateUPNs(c *x509.Certificate) []string {
+	var upns []string
+	for _, e := range c.Extensions {
+		if !e.Id.Equal(oidExtensionSubjectAltName) {
+			continue
+		}
+
+		var names []asn1.RawValue
+		_, err := asn1.Unmarshal(e.Value, &names)
+		if err != nil {
+			return nil
+		}
+
+		for _, v := range names {
+			if v.Class != asn1.ClassContextSpecific || v.Tag != sanOtherName {
+				continue
+			}
+
+			var name otherName
+			_, err := asn1.UnmarshalWithParams(v.FullBytes, &name, "tag:0")
+			if err != nil || !name.TypeID.Equal(oidUPN) {
+				continue
+			}
+
+			var upn string
+			_, err = asn1.UnmarshalWithParams(name.Value.Bytes, &upn, "utf8")
+			if err == nil {
+				upns = append(upns, upn)
+			}
+		}
+	}
+
+	return upns
+}
@@ -20,17 +20,22 @@
 package selfca
 
 import (
+	"crypto"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"fmt"
 	"io"
+	"io/fs"
 	"math/big"
 	"net"
 	"os"
+	"path/filepath"
+	"strings"
 	"time"
 )
 
@@ -39,18 +44,74 @@ var (
 	ErrInvalidCertificate = errors.New("selfca: the certificate is invalid")
 	// ErrInvalidCertificateKey is invalid certificate key error
 	ErrInvalidCertificateKey = errors.New("selfca: the certificate key is invalid")
+	// ErrUnsupportedKeyType is unsupported key type error
+	ErrUnsupportedKeyType = errors.New("selfca: the key type is unsupported")
+	// ErrKeyMismatch is key not matching the certificate error
+	ErrKeyMismatch = errors.New("selfca: the key does not match the certificate")
 )
 
 // Certificate stors certificate information for generating
 type Certificate struct {
-	IsCA          bool
-	CommonName    string
-	KeySize       int
-	NotBefore     time.Time
-	NotAfter      time.Time
-	Hosts         []string
-	CAKey         *rsa.PrivateKey
-	CACertificate *x509.Certificate
+	IsCA       bool
+	CommonName string
+	Subject    pkix.RDNSequence
+	// Organization and the others are the subject attributes before Subject and CommonName,
+	// like pkix.Name, for the subject without parsing DN
+	Organization       []string
+	OrganizationalUnit []string
+	Country            []string
+	Locality           []string
+	Province           []string
+	StreetAddress      []string
+	PostalCode         []string
+	ExtraSubject       []pkix.AttributeTypeAndValue
+	KeySize            int
+	KeyType            KeyType
+	NotBefore          time.Time
+	NotAfter           time.Time
+	Hosts              []string
+	UPNs               []string
+	Profile            Profile
+	// KeyUsage, ExtKeyUsage and UnknownExtKeyUsage override the usages of profile if set,
+	// like x509.Certificate, AddUsage adds them by name
+	KeyUsage           x509.KeyUsage
+	ExtKeyUsage        []x509.ExtKeyUsage
+	UnknownExtKeyUsage []asn1.ObjectIdentifier
+	SerialNumber       *big.Int
+	Precertificate     bool
+	ExtraExtensions    []pkix.Extension
+	CopyExtensions     []asn1.ObjectIdentifier
+	// CSRPolicy is the policy of honoring the certificate request by SignCSR,
+	// ProfileCSRPolicy of the profile if nil
+	CSRPolicy         *CSRPolicy
+	ChallengePassword string
+	Key               crypto.Signer
+	CAKey             crypto.Signer
+	CACertificate     *x509.Certificate
+	// MaxPathLen is the max number of intermediate cas below the ca, unlimited if negative,
+	// or zero without MaxPathLenZero, like x509.Certificate
+	MaxPathLen     int
+	MaxPathLenZero bool
+	// PermittedDNSDomains and the others are the name constraints of ca, the issued names must be
+	// within the permitted and outside of the excluded, like x509.Certificate, the extension is
+	// critical if any is set, or PermittedDNSDomainsCritical
+	PermittedDNSDomainsCritical bool
+	PermittedDNSDomains         []string
+	ExcludedDNSDomains          []string
+	PermittedIPRanges           []*net.IPNet
+	ExcludedIPRanges            []*net.IPNet
+	PermittedEmailAddresses     []string
+	ExcludedEmailAddresses      []string
+	PermittedURIDomains         []string
+	ExcludedURIDomains          []string
+	// OCSPServer, IssuingCertificateURL and CRLDistributionPoints are the URLs of OCSP responder,
+	// issuer certificate and CRL embedded in the certificate, like x509.Certificate
+	OCSPServer            []string
+	IssuingCertificateURL []string
+	CRLDistributionPoints []string
+	// Parent is the issuer of intermediate ca, which is self-signed without it, the others can use it
+	// instead of CACertificate and CAKey, its certificate and chain are the issuance chain
+	Parent *CA
 }
 
 // Version returns package version
@@ -68,24 +129,91 @@ func License() string {
 	return "Licensed under the Apache License 2.0"
 }
 
-// GenerateCertificate generates X.509 certificate and key
-func GenerateCertificate(c Certificate) ([]byte, *rsa.PrivateKey, error) {
-	serialNumberMax := new(big.Int).Lsh(big.NewInt(1), 128)
-	serialNumber, err := rand.Int(rand.Reader, serialNumberMax)
+// GenerateCertificate generates X.509 certificate and key, the key is of KeyType
+// and KeySize unless Key is set
+func GenerateCertificate(c Certificate) ([]byte, crypto.Signer, error) {
+	err := c.useParent()
 	if err != nil {
 		return nil, nil, err
 	}
 
-	if c.KeySize <= 0 {
-		c.KeySize = 2048
+	template, err := certificateTemplate(c)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	key := c.Key
+	if key == nil {
+		key, err = GenerateKey(c.KeyType, c.KeySize)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	fitKeyUsage(template, key.Public())
+
+	if c.CACertificate != nil && (!c.IsCA || c.Parent != nil) {
+		err = checkNameConstraints(c.CACertificate, template)
+		if err != nil {
+			return nil, nil, err
+		}
+	}
+
+	if c.IsCA && c.Parent == nil {
+		c.CAKey = key
+		c.CACertificate = template
+	}
+
+	certificate, err := x509.CreateCertificate(rand.Reader,
+		template, c.CACertificate, key.Public(), c.CAKey)
+
+	return certificate, key, err
+}
+
+// useParent sets CACertificate and CAKey to the parent ca if any
+func (c *Certificate) useParent() error {
+	if c.Parent == nil {
+		return nil
+	}
+
+	if c.Parent.Key == nil {
+		return ErrCAClosed
+	}
+
+	if c.IsCA && c.Parent.Certificate.MaxPathLen == 0 && c.Parent.Certificate.MaxPathLenZero {
+		return ErrPathLenExceeded
+	}
+
+	c.CACertificate, c.CAKey = c.Parent.Certificate, c.Parent.Key
+
+	return nil
+}
+
+// fitKeyUsage removes the key encipherment usage for the key not RSA, which can not encipher keys
+func fitKeyUsage(template *x509.Certificate, pub crypto.PublicKey) {
+	if _, ok := pub.(*rsa.PublicKey); !ok {
+		template.KeyUsage &^= x509.KeyUsageKeyEncipherment
+	}
+}
+
+// certificateTemplate returns the certificate template of config
+func certificateTemplate(c Certificate) (*x509.Certificate, error) {
+	serialNumber := c.SerialNumber
+	if serialNumber == nil {
+		serialNumberMax := new(big.Int).Lsh(big.NewInt(1), 128)
+		sn, err := rand.Int(rand.Reader, serialNumberMax)
+		if err != nil {
+			return nil, err
+		}
+		serialNumber = sn
 	}
 
-	key, err := rsa.GenerateKey(rand.Reader, c.KeySize)
+	usage, err := usageOf(c.Profile)
 	if err != nil {
-		return nil, nil, err
+		return nil, err
 	}
 
-	template := x509.Certificate{
+	template := &x509.Certificate{
 		SerialNumber:          serialNumber,
 		Subject:               pkix.Name{},
 		NotBefore:             c.NotBefore,
@@ -96,107 +224,304 @@ func GenerateCertificate(c Certificate) ([]byte, *rsa.PrivateKey, error) {
 
 	if c.IsCA {
 		template.Subject.CommonName = "Root CA"
-		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign
+		if c.Parent != nil {
+			template.Subject.CommonName = "Intermediate CA"
+		}
+		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign | x509.KeyUsageCRLSign
+		template.MaxPathLen, template.MaxPathLenZero = c.MaxPathLen, c.MaxPathLenZero
+		setNameConstraints(template, c)
 		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
-		c.CAKey = key
-		c.CACertificate = &template
+		if c.Profile == ProfileDevice || c.Profile == ProfileCodeSigning || c.Profile == ProfileEmail {
+			template.ExtKeyUsage = usage.extKeyUsage
+		}
 	} else {
-		template.Subject.CommonName = c.Hosts[0]
-		template.KeyUsage = x509.KeyUsageDigitalSignature | x509.KeyUsageKeyEncipherment
-		template.ExtKeyUsage = []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth}
+		template.Subject.CommonName = commonNameOf(c.Hosts, c.UPNs)
+		template.KeyUsage = usage.keyUsage
+		template.ExtKeyUsage = usage.extKeyUsage
+		template.UnknownExtKeyUsage = usage.unknownExtKeyUsage
 	}
 
+	setUsages(template, c)
+
 	if c.CommonName != "" {
 		template.Subject.CommonName = c.CommonName
 	}
 
-	for _, v := range c.Hosts {
-		if ip := net.ParseIP(v); ip != nil {
-			template.IPAddresses = append(template.IPAddresses, ip)
-		} else {
-			template.DNSNames = append(template.DNSNames, v)
+	template.RawSubject, err = rawSubject(c, template.Subject.CommonName)
+	if err != nil {
+		return nil, err
+	}
+
+	err = addHosts(template, c.Hosts)
+	if err != nil {
+		return nil, err
+	}
+
+	template.OCSPServer = c.OCSPServer
+	template.IssuingCertificateURL = c.IssuingCertificateURL
+	template.CRLDistributionPoints = c.CRLDistributionPoints
+
+	template.ExtraExtensions = append(template.ExtraExtensions, c.ExtraExtensions...)
+
+	if c.Precertificate {
+		template.ExtraExtensions = append(template.ExtraExtensions, poisonExtension())
+	}
+
+	if len(c.UPNs) > 0 {
+		san, err := marshalSANs(template, c.UPNs)
+		if err != nil {
+			return nil, err
 		}
+		template.ExtraExtensions = append(template.ExtraExtensions, san)
 	}
 
-	certificate, err := x509.CreateCertificate(rand.Reader,
-		&template, c.CACertificate, &key.PublicKey, c.CAKey)
+	return template, nil
+}
 
-	return certificate, key, err
+// ReadCertificate reads certificate and key from files name.crt and name.key,
+// the certificate file can be combined PEM file also containing the key, name.pem is read
+// if name.crt does not exist, and name.der and name.key.der if neither exists
+func ReadCertificate(name string) ([]*x509.Certificate, crypto.Signer, error) {
+	return ReadCertificateWithPassphrase(name, nil)
+}
+
+// ReadCertificateWithPassphrase reads certificate and key like ReadCertificate,
+// the encrypted key is decrypted by passphrase
+func ReadCertificateWithPassphrase(name string, passphrase []byte) ([]*x509.Certificate, crypto.Signer, error) {
+	certificateName, keyName := fmt.Sprintf("%s.crt", name), fmt.Sprintf("%s.key", name)
+	if _, err := os.Stat(certificateName); errors.Is(err, fs.ErrNotExist) {
+		if _, err := os.Stat(name + ".pem"); err == nil {
+			certificateName = name + ".pem"
+		} else if _, err := os.Stat(name + ".der"); err == nil {
+			certificateName, keyName = name+".der", name+".key.der"
+		}
+	}
+
+	return ReadCertificateFileWithPassphrase(certificateName, keyName, passphrase)
+}
+
+// ReadCertificateFile reads certificate chain and key from the PEM or DER file,
+// the key is read from keyName if the file does not contain the key,
+// the key must be RSA, ECDSA or Ed25519 and match the first certificate
+func ReadCertificateFile(certificateName, keyName string) ([]*x509.Certificate, crypto.Signer, error) {
+	return ReadCertificateFileWithPassphrase(certificateName, keyName, nil)
 }
 
-// ReadCertificate reads certificate and key from files
-func ReadCertificate(name string) ([]*x509.Certificate, *rsa.PrivateKey, error) {
-	certificateName := fmt.Sprintf("%s.crt", name)
-	fd, err := os.Open(certificateName)
+// ReadCertificateFileWithPassphrase reads certificate chain and key like ReadCertificateFile,
+// the encrypted key is decrypted by passphrase
+func ReadCertificateFileWithPassphrase(certificateName, keyName string,
+	passphrase []byte) ([]*x509.Certificate, crypto.Signer, error) {
+	data, err := os.ReadFile(certificateName)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	defer fd.Close()
-	data, err := io.ReadAll(fd)
+	certificates, keyBlock, err := splitPEM(data)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	p, _ := pem.Decode(data)
-	if p == nil {
+	if len(certificates) == 0 && isDER(data) {
+		certificates, err = x509.ParseCertificates(data)
+		if err != nil {
+			return nil, nil, ErrInvalidCertificate
+		}
+	}
+
+	if len(certificates) == 0 {
 		return nil, nil, ErrInvalidCertificate
 	}
 
-	certificate, err := x509.ParseCertificates(p.Bytes)
-	if err != nil {
-		return nil, nil, err
+	if keyBlock == nil {
+		data, err = os.ReadFile(keyName)
+		if err != nil {
+			return nil, nil, err
+		}
+		defer clear(data)
+		keyBlock, _ = pem.Decode(data)
+		if keyBlock == nil && isDER(data) {
+			keyBlock = &pem.Block{Type: "PRIVATE KEY", Bytes: data}
+		}
+		if keyBlock == nil {
+			return nil, nil, ErrInvalidCertificateKey
+		}
 	}
 
-	keyName := fmt.Sprintf("%s.key", name)
-	fd, err = os.Open(keyName)
+	key, err := ParsePrivateKeyPEM(keyBlock, passphrase)
+	clear(keyBlock.Bytes)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	defer fd.Close()
-	data, err = io.ReadAll(fd)
-	if err != nil {
-		return nil, nil, err
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, nil, ErrUnsupportedKeyType
 	}
 
-	p, _ = pem.Decode(data)
-	if p == nil {
-		return nil, nil, ErrInvalidCertificateKey
+	if keyType, _ := KeyTypeOf(signer.Public()); keyType == "" {
+		return nil, nil, ErrUnsupportedKeyType
 	}
 
-	key, err := x509.ParsePKCS1PrivateKey(p.Bytes)
-	if err != nil {
-		return nil, nil, err
+	public, ok := signer.Public().(interface{ Equal(crypto.PublicKey) bool })
+	if !ok || !public.Equal(certificates[0].PublicKey) {
+		return nil, nil, ErrKeyMismatch
 	}
 
-	return certificate, key, nil
+	return certificates, signer, nil
 }
 
-// WriteCertificate writes certificate and key to files
-func WriteCertificate(name string, certificate []byte, key *rsa.PrivateKey) error {
-	certificateName := fmt.Sprintf("%s.crt", name)
-	fd, err := os.Create(certificateName)
-	if err != nil {
-		return err
+// isDER returns whether data looks like DER, starting with ASN.1 SEQUENCE
+func isDER(data []byte) bool {
+	return len(data) > 0 && data[0] == 0x30
+}
+
+// splitPEM splits the PEM data into certificates and key block, the key is nil if not found
+func splitPEM(data []byte) ([]*x509.Certificate, *pem.Block, error) {
+	var certificates []*x509.Certificate
+	var keyBlock *pem.Block
+	for {
+		var p *pem.Block
+		p, data = pem.Decode(data)
+		if p == nil {
+			break
+		}
+
+		switch {
+		case p.Type == "CERTIFICATE":
+			certificate, err := x509.ParseCertificates(p.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			certificates = append(certificates, certificate...)
+		case strings.HasSuffix(p.Type, "PRIVATE KEY"):
+			if keyBlock != nil {
+				return nil, nil, ErrInvalidCertificateKey
+			}
+			keyBlock = p
+		}
 	}
 
-	defer fd.Close()
-	err = pem.Encode(fd, &pem.Block{Type: "CERTIFICATE", Bytes: certificate})
-	if err != nil {
-		return err
+	return certificates, keyBlock, nil
+}
+
+// WriteCertificate writes certificate and key to files,
+// the existing files are replaced atomically, the key is skipped if nil
+func WriteCertificate(name string, certificate []byte, key crypto.Signer) error {
+	return WriteCertificateWithPassphrase(name, certificate, key, nil)
+}
+
+// WriteCertificateWithPassphrase writes certificate and key like WriteCertificate,
+// the key is encrypted by passphrase unless it is empty
+func WriteCertificateWithPassphrase(name string, certificate []byte, key crypto.Signer, passphrase []byte) error {
+	return writeCertificate(name, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}), key, passphrase)
+}
+
+// writeCertificate writes the PEM encoded certificate and key to files, the key is renamed
+// into place right before the certificate, so the renewed pair is swapped together
+func writeCertificate(name string, certificate []byte, key crypto.Signer, passphrase []byte) error {
+	var files []pendingFile
+	if key != nil {
+		var block *pem.Block
+		var err error
+		if len(passphrase) > 0 {
+			block, err = EncryptPrivateKey(key, passphrase)
+		} else {
+			block, err = MarshalPrivateKey(key)
+		}
+		if err != nil {
+			return err
+		}
+
+		defer clear(block.Bytes)
+
+		files = append(files, pendingFile{fmt.Sprintf("%s.key", name), func(w io.Writer) error {
+			return pem.Encode(w, block)
+		}, 0600})
 	}
 
-	keyName := fmt.Sprintf("%s.key", name)
-	fd, err = os.Create(keyName)
-	if err != nil {
+	files = append(files, pendingFile{fmt.Sprintf("%s.crt", name), func(w io.Writer) error {
+		_, err := w.Write(certificate)
 		return err
+	}, 0644})
+
+	return writeFiles(files...)
+}
+
+// WriteCertificateDER writes certificate to name.der and key to name.key.der in DER form,
+// the key is PKCS #8 and skipped if nil, the existing files are replaced atomically
+func WriteCertificateDER(name string, certificate []byte, key crypto.Signer) error {
+	var files []pendingFile
+	if key != nil {
+		der, err := x509.MarshalPKCS8PrivateKey(key)
+		if err != nil {
+			return ErrUnsupportedKeyType
+		}
+
+		defer clear(der)
+
+		files = append(files, pendingFile{fmt.Sprintf("%s.key.der", name), func(w io.Writer) error {
+			_, err := w.Write(der)
+			return err
+		}, 0600})
 	}
 
-	defer fd.Close()
-	err = pem.Encode(fd, &pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})
-	if err != nil {
+	files = append(files, pendingFile{fmt.Sprintf("%s.der", name), func(w io.Writer) error {
+		_, err := w.Write(certificate)
 		return err
+	}, 0644})
+
+	return writeFiles(files...)
+}
+
+// pendingFile is the file to write by writeFiles
+type pendingFile struct {
+	name  string
+	write func(io.Writer) error
+	perm  os.FileMode
+}
+
+// writeFile writes to a temporary file and renames it to name,
+// so readers never see a partially written file
+func writeFile(name string, write func(io.Writer) error, perm os.FileMode) error {
+	return writeFiles(pendingFile{name, write, perm})
+}
+
+// writeFiles writes all files to temporary files before renaming them in order,
+// so none is replaced if any fails to write, and the files are replaced together
+func writeFiles(files ...pendingFile) error {
+	temps := make([]string, 0, len(files))
+	defer func() {
+		for _, v := range temps {
+			os.Remove(v)
+		}
+	}()
+
+	for _, v := range files {
+		fd, err := os.CreateTemp(filepath.Dir(v.name), filepath.Base(v.name)+".*.tmp")
+		if err != nil {
+			return err
+		}
+
+		temps = append(temps, fd.Name())
+		err = v.write(fd)
+		if err == nil {
+			err = fd.Chmod(v.perm)
+		}
+
+		if e := fd.Close(); err == nil {
+			err = e
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	for i, v := range files {
+		err := os.Rename(temps[i], v.name)
+		if err != nil {
+			return err
+		}
 	}
 
 	return nil
@@ -20,7 +20,11 @@
 package selfca
 
 import (
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
 	"os"
+	"runtime"
 	"testing"
 	"time"
 
@@ -72,6 +76,13 @@ func TestGenerateCertificate(t *testing.T) {
 	assert.Nil(t, err)
 	assert.NotNil(t, key)
 	assert.NotNil(t, certificate)
+
+	config.Key = key
+	config.Hosts = []string{"likexian.com", "ssl.likexian.com"}
+	reissued, reissuedKey, err := GenerateCertificate(config)
+	assert.Nil(t, err)
+	assert.Equal(t, reissuedKey, key)
+	assert.NotEqual(t, reissued, certificate)
 }
 
 func TestReadWriteCertificate(t *testing.T) {
@@ -103,6 +114,19 @@ func TestReadWriteCertificate(t *testing.T) {
 	err = WriteCertificate(caPath, certificate, key)
 	assert.Nil(t, err)
 
+	err = WriteCertificate(caPath, certificate, key)
+	assert.Nil(t, err)
+
+	files, err := os.ReadDir(certPath)
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+
+	if runtime.GOOS != "windows" {
+		stat, err := os.Stat(caPath + ".key")
+		assert.Nil(t, err)
+		assert.Equal(t, stat.Mode().Perm(), os.FileMode(0600))
+	}
+
 	os.Remove(caPath + ".key")
 	_, _, err = ReadCertificate(caPath)
 	assert.NotNil(t, err)
@@ -111,3 +135,121 @@ func TestReadWriteCertificate(t *testing.T) {
 	_, _, err = ReadCertificate(caPath)
 	assert.NotNil(t, err)
 }
+
+func TestReadCombinedCertificate(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	var combined []byte
+	combined = append(combined, issuance.PEM...)
+	combined = append(combined, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: ca.Certificate.Raw})...)
+	combined = append(combined, issuance.KeyPEM...)
+
+	name := t.TempDir() + "/likexian.com"
+	err = os.WriteFile(name+".pem", combined, 0600)
+	assert.Nil(t, err)
+
+	certificates, key, err := ReadCertificate(name)
+	assert.Nil(t, err)
+	assert.Len(t, certificates, 2)
+	assert.Equal(t, certificates[1].Raw, ca.Certificate.Raw)
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+
+	certificates, key, err = ReadCertificateFile(name+".pem", "not-exists.key")
+	assert.Nil(t, err)
+	assert.Len(t, certificates, 2)
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+
+	err = os.WriteFile(name+".pem", append(combined, issuance.KeyPEM...), 0600)
+	assert.Nil(t, err)
+	_, _, err = ReadCertificate(name)
+	assert.Equal(t, err, ErrInvalidCertificateKey)
+
+	err = os.WriteFile(name+".pem", issuance.KeyPEM, 0600)
+	assert.Nil(t, err)
+	_, _, err = ReadCertificate(name)
+	assert.Equal(t, err, ErrInvalidCertificate)
+
+	err = os.WriteFile(name+".crt", issuance.PEM, 0644)
+	assert.Nil(t, err)
+	err = os.WriteFile(name+".key", pem.EncodeToMemory(&pem.Block{
+		Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(ca.Key.(*rsa.PrivateKey))}), 0600)
+	assert.Nil(t, err)
+	_, _, err = ReadCertificate(name)
+	assert.Equal(t, err, ErrKeyMismatch)
+}
+
+func TestWriteCertificatePair(t *testing.T) {
+	dir := t.TempDir()
+	name := dir + "/ca"
+	config := Certificate{IsCA: true, NotBefore: time.Now(), NotAfter: time.Now().Add(time.Hour)}
+
+	certificate, key, err := GenerateCertificate(config)
+	assert.Nil(t, err)
+	assert.Nil(t, WriteCertificate(name, certificate, key))
+
+	crt, err := os.ReadFile(name + ".crt")
+	assert.Nil(t, err)
+
+	// the certificate is not replaced if the key can not be
+	assert.Nil(t, os.Remove(name+".key"))
+	assert.Nil(t, os.MkdirAll(name+".key/busy", 0755))
+	renewed, renewedKey, err := GenerateCertificate(config)
+	assert.Nil(t, err)
+	assert.NotNil(t, WriteCertificate(name, renewed, renewedKey))
+
+	data, err := os.ReadFile(name + ".crt")
+	assert.Nil(t, err)
+	assert.Equal(t, data, crt)
+
+	files, err := os.ReadDir(dir)
+	assert.Nil(t, err)
+	assert.Len(t, files, 2)
+}
+
+func TestReadWriteCertificateDER(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	err = issuance.WriteDER("not-exists/likexian.com")
+	assert.NotNil(t, err)
+
+	name := t.TempDir() + "/likexian.com"
+	err = issuance.WriteDER(name)
+	assert.Nil(t, err)
+	assert.Equal(t, issuance.DERFile, name+".der")
+	assert.Equal(t, issuance.KeyDERFile, name+".key.der")
+
+	data, err := os.ReadFile(issuance.DERFile)
+	assert.Nil(t, err)
+	assert.Equal(t, data, issuance.DER)
+
+	certificates, key, err := ReadCertificate(name)
+	assert.Nil(t, err)
+	assert.Equal(t, certificates[0].Raw, issuance.DER)
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+
+	// the DER certificate with PEM key
+	err = os.WriteFile(name+".key", issuance.KeyPEM, 0600)
+	assert.Nil(t, err)
+	certificates, key, err = ReadCertificateFile(name+".der", name+".key")
+	assert.Nil(t, err)
+	assert.Equal(t, certificates[0].Raw, issuance.DER)
+	assert.Equal(t, key.Public(), issuance.Key.Public())
+
+	err = os.WriteFile(name+".key.der", []byte{0x30, 0x00}, 0600)
+	assert.Nil(t, err)
+	_, _, err = ReadCertificate(name)
+	assert.Equal(t, err, ErrInvalidCertificateKey)
+
+	err = os.WriteFile(name+".der", []byte{0x30, 0x00}, 0644)
+	assert.Nil(t, err)
+	_, _, err = ReadCertificate(name)
+	assert.Equal(t, err, ErrInvalidCertificate)
+}
@@ -0,0 +1,79 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+// Package selfcatest provides utilities for testing with selfca certificates
+package selfcatest
+
+import (
+	"context"
+	"crypto/tls"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// NewTLSServer starts a TLS server serving the certificate for hosts and 127.0.0.1,
+// returns the server and a client trusting the ca, the client connects to the server
+// for any of the hosts, the server is closed when the test finishes
+func NewTLSServer(t testing.TB, handler http.Handler, hosts ...string) (*httptest.Server, *http.Client) {
+	t.Helper()
+
+	ca := NewCA(t)
+	issuance := Issue(t, ca, append(append([]string{}, hosts...), "127.0.0.1")...)
+
+	server := httptest.NewUnstartedServer(handler)
+	server.TLS = &tls.Config{
+		Certificates: []tls.Certificate{issuance.TLSCertificate()},
+		MinVersion:   tls.VersionTLS12,
+	}
+	server.StartTLS()
+	t.Cleanup(server.Close)
+
+	address := server.Listener.Addr().String()
+	dialer := &net.Dialer{}
+	transport := &http.Transport{
+		TLSClientConfig: &tls.Config{
+			RootCAs:    ca.CertPool(),
+			MinVersion: tls.VersionTLS12,
+		},
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			host, _, err := net.SplitHostPort(addr)
+			if err == nil && containsHost(hosts, host) {
+				addr = address
+			}
+			return dialer.DialContext(ctx, network, addr)
+		},
+		ForceAttemptHTTP2: true,
+	}
+	t.Cleanup(transport.CloseIdleConnections)
+
+	return server, &http.Client{Transport: transport}
+}
+
+// containsHost returns whether host is in hosts
+func containsHost(hosts []string, host string) bool {
+	for _, v := range hosts {
+		if v == host {
+			return true
+		}
+	}
+
+	return false
+}
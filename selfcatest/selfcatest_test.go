@@ -0,0 +1,48 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfcatest
+
+import (
+	"io"
+	"net/http"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestNewTLSServer(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.WriteString(w, r.Host)
+	})
+
+	server, client := NewTLSServer(t, handler, "api.likexian.test", "10.0.0.1")
+
+	for _, v := range []string{"https://api.likexian.test/", "https://10.0.0.1/", server.URL} {
+		rsp, err := client.Get(v)
+		assert.Nil(t, err)
+		body, err := io.ReadAll(rsp.Body)
+		rsp.Body.Close()
+		assert.Nil(t, err)
+		assert.Contains(t, v, string(body))
+	}
+
+	_, err := http.Get(server.URL)
+	assert.NotNil(t, err)
+}
@@ -0,0 +1,112 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfcatest
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/likexian/selfca"
+)
+
+// Files stores the paths of certificate files written by WriteFiles
+type Files struct {
+	// Dir is the temporary folder of the files
+	Dir string
+	// CAFile is the ca certificate file path
+	CAFile string
+	// CertificateFile is the certificate file path
+	CertificateFile string
+	// KeyFile is the key file path
+	KeyFile string
+	// Issuance is the issued certificate
+	Issuance *selfca.Issuance
+}
+
+// NewCA returns an ephemeral ca, fails the test on error
+func NewCA(t testing.TB) *selfca.CA {
+	t.Helper()
+
+	ca, err := selfca.NewEphemeralCA()
+	if err != nil {
+		t.Fatalf("selfcatest: failed to generate ca: %v", err)
+	}
+
+	return ca
+}
+
+// NewFastCA returns an ephemeral ca issuing P-256 certificates valid for an hour,
+// for tests issuing many certificates, fails the test on error
+func NewFastCA(t testing.TB) *selfca.CA {
+	t.Helper()
+
+	ca, err := selfca.NewFastCA()
+	if err != nil {
+		t.Fatalf("selfcatest: failed to generate ca: %v", err)
+	}
+
+	return ca
+}
+
+// Issue issues certificate for hosts signed by the ca, fails the test on error
+func Issue(t testing.TB, ca *selfca.CA, hosts ...string) *selfca.Issuance {
+	t.Helper()
+
+	if len(hosts) == 0 {
+		t.Fatalf("selfcatest: failed to issue certificate: no hosts given")
+	}
+
+	issuance, err := ca.Issue(selfca.Certificate{
+		Hosts: hosts,
+	})
+	if err != nil {
+		t.Fatalf("selfcatest: failed to issue certificate for %v by %q: %v",
+			hosts, ca.Certificate.Subject.CommonName, err)
+	}
+
+	return issuance
+}
+
+// WriteFiles issues certificate for hosts signed by the ca, writes the ca certificate,
+// certificate and key into a temporary folder removed when the test finishes
+func WriteFiles(t testing.TB, ca *selfca.CA, hosts ...string) *Files {
+	t.Helper()
+
+	issuance := Issue(t, ca, hosts...)
+	dir := t.TempDir()
+
+	err := selfca.WriteCertificate(filepath.Join(dir, "ca"), ca.Certificate.Raw, ca.Key)
+	if err != nil {
+		t.Fatalf("selfcatest: failed to write ca certificate into %s: %v", dir, err)
+	}
+
+	err = issuance.Write(filepath.Join(dir, "cert"))
+	if err != nil {
+		t.Fatalf("selfcatest: failed to write certificate for %v into %s: %v", hosts, dir, err)
+	}
+
+	return &Files{
+		Dir:             dir,
+		CAFile:          filepath.Join(dir, "ca.crt"),
+		CertificateFile: issuance.CertificateFile,
+		KeyFile:         issuance.KeyFile,
+		Issuance:        issuance,
+	}
+}
@@ -0,0 +1,82 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfcatest
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+	"github.com/likexian/selfca"
+)
+
+type fatalTB struct {
+	testing.TB
+	message string
+}
+
+func (t *fatalTB) Helper() {}
+
+func (t *fatalTB) Fatalf(format string, args ...any) {
+	t.message = fmt.Sprintf(format, args...)
+	runtime.Goexit()
+}
+
+func TestWriteFiles(t *testing.T) {
+	ca := NewCA(t)
+	files := WriteFiles(t, ca, "likexian.com")
+
+	for _, v := range []string{files.CAFile, files.CertificateFile, files.KeyFile} {
+		_, err := os.Stat(v)
+		assert.Nil(t, err)
+	}
+
+	certificates, _, err := selfca.ReadCertificate(files.Dir + "/cert")
+	assert.Nil(t, err)
+	_, err = certificates[0].Verify(x509.VerifyOptions{
+		DNSName: "likexian.com",
+		Roots:   ca.CertPool(),
+	})
+	assert.Nil(t, err)
+}
+
+func TestNewFastCA(t *testing.T) {
+	ca := NewFastCA(t)
+	issuance := Issue(t, ca, "likexian.com")
+
+	keyType, _ := selfca.KeyTypeOf(issuance.Key.Public())
+	assert.Equal(t, keyType, selfca.KeyTypeECDSA)
+	assert.Equal(t, issuance.Certificate.NotAfter.Sub(issuance.Certificate.NotBefore), selfca.FastValidity)
+}
+
+func TestIssueFatal(t *testing.T) {
+	fake := &fatalTB{TB: t}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		Issue(fake, NewCA(t))
+	}()
+	<-done
+
+	assert.Contains(t, fake.message, "no hosts given")
+}
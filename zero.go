@@ -0,0 +1,61 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"math/big"
+)
+
+// ZeroKey overwrites the private key material in memory, the key is unusable after,
+// it is best effort as the values copied by Go runtime and crypto packages are out of reach,
+// the keys of external signers like HSM or KMS are left untouched
+func ZeroKey(key crypto.Signer) {
+	switch k := key.(type) {
+	case *rsa.PrivateKey:
+		zeroInt(k.D)
+		for _, v := range k.Primes {
+			zeroInt(v)
+		}
+		zeroInt(k.Precomputed.Dp)
+		zeroInt(k.Precomputed.Dq)
+		zeroInt(k.Precomputed.Qinv)
+		for _, v := range k.Precomputed.CRTValues {
+			zeroInt(v.Exp)
+			zeroInt(v.Coeff)
+			zeroInt(v.R)
+		}
+	case *ecdsa.PrivateKey:
+		zeroInt(k.D)
+	case ed25519.PrivateKey:
+		clear(k)
+	}
+}
+
+// zeroInt overwrites the words of x and sets it to zero
+func zeroInt(x *big.Int) {
+	if x != nil {
+		clear(x.Bits())
+		x.SetInt64(0)
+	}
+}
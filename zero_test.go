@@ -0,0 +1,79 @@
+/*
+ * Copyright 2014-2024 Li Kexian
+ *
+ * Licensed under the Apache License, Version 2.0 (the "License");
+ * you may not use this file except in compliance with the License.
+ * You may obtain a copy of the License at
+ *
+ *     http://www.apache.org/licenses/LICENSE-2.0
+ *
+ * Unless required by applicable law or agreed to in writing, software
+ * distributed under the License is distributed on an "AS IS" BASIS,
+ * WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+ * See the License for the specific language governing permissions and
+ * limitations under the License.
+ *
+ * Go module for self-signed certificate generating
+ * https://www.likexian.com/
+ */
+
+package selfca
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"testing"
+
+	"github.com/likexian/gokit/assert"
+)
+
+func TestZeroKey(t *testing.T) {
+	key, err := GenerateKey(KeyTypeRSA, 2048)
+	assert.Nil(t, err)
+	rsaKey := key.(*rsa.PrivateKey)
+	d := rsaKey.D.Bits()
+	ZeroKey(key)
+	assert.Equal(t, rsaKey.D.Sign(), 0)
+	assert.Equal(t, rsaKey.Primes[0].Sign(), 0)
+	assert.Equal(t, rsaKey.Precomputed.Dp.Sign(), 0)
+	for _, v := range d {
+		assert.Equal(t, uint(v), uint(0))
+	}
+
+	key, err = GenerateKey(KeyTypeECDSA, 256)
+	assert.Nil(t, err)
+	ZeroKey(key)
+	assert.Equal(t, key.(*ecdsa.PrivateKey).D.Sign(), 0)
+
+	key, err = GenerateKey(KeyTypeEd25519, 256)
+	assert.Nil(t, err)
+	ZeroKey(key)
+	assert.True(t, bytes.Equal(key.(ed25519.PrivateKey), make([]byte, ed25519.PrivateKeySize)))
+
+	ZeroKey(nil)
+}
+
+func TestIssuanceZero(t *testing.T) {
+	ca, err := NewEphemeralCA()
+	assert.Nil(t, err)
+
+	issuance, err := ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Nil(t, err)
+
+	keyPEM := issuance.KeyPEM
+	issuance.Zero()
+	assert.Nil(t, issuance.Key)
+	assert.Len(t, issuance.KeyPEM, 0)
+	assert.True(t, bytes.Equal(keyPEM, make([]byte, len(keyPEM))))
+
+	ca.Close()
+	assert.Nil(t, ca.Key)
+
+	_, err = ca.Issue(Certificate{Hosts: []string{"likexian.com"}})
+	assert.Equal(t, err, ErrCAClosed)
+
+	_, err = ca.CrossSign(issuance.Certificate)
+	assert.Equal(t, err, ErrCAClosed)
+}